@@ -0,0 +1,64 @@
+-- Ledger accounts are either system accounts (identified by code) or wallet accounts (identified by wallet_id)
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(50) UNIQUE,
+    wallet_id UUID UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (wallet_id) REFERENCES wallets(id) ON DELETE RESTRICT,
+    CHECK ((code IS NULL) <> (wallet_id IS NULL))
+);
+
+CREATE TABLE IF NOT EXISTS ledger_journals (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transaction_id UUID,
+    kind VARCHAR(30) NOT NULL,
+    description VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_journals_transaction_id ON ledger_journals (transaction_id);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    journal_id UUID NOT NULL,
+    account_id UUID NOT NULL,
+    direction VARCHAR(6) NOT NULL CHECK (direction IN ('debit', 'credit')),
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (journal_id) REFERENCES ledger_journals(id) ON DELETE RESTRICT,
+    FOREIGN KEY (account_id) REFERENCES ledger_accounts(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_journal_id ON ledger_postings (journal_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_account_id ON ledger_postings (account_id);
+
+-- System accounts
+INSERT INTO ledger_accounts (code, name) VALUES
+    ('transfer_clearing', 'Transfer clearing'),
+    ('fee_income', 'Fee income'),
+    ('opening_balance', 'Opening balances')
+ON CONFLICT DO NOTHING;
+
+-- Every existing wallet gets its own ledger account
+INSERT INTO ledger_accounts (wallet_id, name)
+SELECT id, 'Wallet ' || account_number FROM wallets
+ON CONFLICT DO NOTHING;
+
+-- Carry existing balances into the ledger so that wallet balances agree with their postings
+WITH journal AS (
+    INSERT INTO ledger_journals (kind, description)
+    SELECT 'opening_balance', 'Opening balances carried over from wallets'
+    WHERE EXISTS (SELECT 1 FROM wallets WHERE balance <> 0)
+    RETURNING id
+)
+INSERT INTO ledger_postings (journal_id, account_id, direction, amount)
+SELECT journal.id, la.id, CASE WHEN w.balance > 0 THEN 'credit' ELSE 'debit' END, ABS(w.balance)
+FROM journal, wallets w
+JOIN ledger_accounts la ON la.wallet_id = w.id
+WHERE w.balance <> 0
+UNION ALL
+SELECT journal.id, ob.id, CASE WHEN totals.total > 0 THEN 'debit' ELSE 'credit' END, ABS(totals.total)
+FROM journal, (SELECT SUM(balance) AS total FROM wallets) totals, ledger_accounts ob
+WHERE ob.code = 'opening_balance' AND totals.total <> 0;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS processed_messages (
+    transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    step VARCHAR(30) NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (transaction_id, step)
+);
@@ -0,0 +1,18 @@
+-- held_balance is the part of the ledger balance that has been promised to transfers that are still in flight
+ALTER TABLE wallets
+ADD COLUMN IF NOT EXISTS held_balance DECIMAL(15, 2) NOT NULL DEFAULT 0.00 CHECK (held_balance >= 0);
+
+CREATE TABLE IF NOT EXISTS wallet_holds (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    wallet_id UUID NOT NULL,
+    transaction_id UUID NOT NULL UNIQUE,
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (wallet_id) REFERENCES wallets(id) ON DELETE RESTRICT,
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_holds_active ON wallet_holds (expires_at) WHERE status = 'active';
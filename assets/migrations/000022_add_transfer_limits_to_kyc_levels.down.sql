@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_transactions_sender_created_at;
+
+ALTER TABLE kyc_levels
+DROP COLUMN IF EXISTS limit_window,
+DROP COLUMN IF EXISTS weekly_transfer_limit,
+DROP COLUMN IF EXISTS monthly_transfer_limit,
+DROP COLUMN IF EXISTS daily_transfer_count,
+DROP COLUMN IF EXISTS weekly_transfer_count,
+DROP COLUMN IF EXISTS monthly_transfer_count;
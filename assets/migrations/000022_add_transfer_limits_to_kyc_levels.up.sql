@@ -0,0 +1,14 @@
+-- limit_window decides how the daily, weekly and monthly limits are measured:
+-- 'calendar' windows start at the beginning of the day, week or month,
+-- 'rolling' windows cover the last 24 hours, 7 days or 30 days.
+-- A NULL limit means there is no limit.
+ALTER TABLE kyc_levels
+ADD COLUMN IF NOT EXISTS limit_window VARCHAR(20) NOT NULL DEFAULT 'calendar' CHECK (limit_window IN ('calendar', 'rolling')),
+ADD COLUMN IF NOT EXISTS weekly_transfer_limit DECIMAL(15, 2),
+ADD COLUMN IF NOT EXISTS monthly_transfer_limit DECIMAL(15, 2),
+ADD COLUMN IF NOT EXISTS daily_transfer_count INT,
+ADD COLUMN IF NOT EXISTS weekly_transfer_count INT,
+ADD COLUMN IF NOT EXISTS monthly_transfer_count INT;
+
+-- limits are checked against the sender's recent transfers
+CREATE INDEX IF NOT EXISTS idx_transactions_sender_created_at ON transactions (sender_wallet_id, created_at);
@@ -0,0 +1,19 @@
+-- Messages that could not be processed (malformed, or still failing after all retries).
+-- They are published to the transfer.failed topic and kept here so they can be inspected and replayed.
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    topic VARCHAR(100) NOT NULL,
+    message_key VARCHAR(255),
+    payload TEXT NOT NULL,
+    headers JSONB NOT NULL DEFAULT '{}',
+    error TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    transaction_id UUID,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    replay_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    handled_at TIMESTAMPTZ,
+    replayed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_status_created_at ON dead_letters (status, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE outbox_messages
+    DROP COLUMN IF EXISTS headers,
+    DROP COLUMN IF EXISTS message_key;
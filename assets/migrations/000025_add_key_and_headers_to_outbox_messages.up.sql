@@ -0,0 +1,4 @@
+-- Messages with the same key go to the same partition, so related events keep their order.
+ALTER TABLE outbox_messages
+    ADD COLUMN IF NOT EXISTS message_key VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}';
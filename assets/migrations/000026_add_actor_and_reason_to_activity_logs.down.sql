@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_activity_logs_actor_id;
+
+ALTER TABLE activity_logs
+DROP COLUMN IF EXISTS reason,
+DROP COLUMN IF EXISTS actor_id;
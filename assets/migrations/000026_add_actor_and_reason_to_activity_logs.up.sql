@@ -0,0 +1,7 @@
+-- Actions taken by staff on someone else's behalf (through the admin CLI, for example)
+-- record who took them and why, the user_id column stays the user the action is about.
+ALTER TABLE activity_logs
+ADD COLUMN actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+ADD COLUMN reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_activity_logs_actor_id ON activity_logs (actor_id) WHERE actor_id IS NOT NULL;
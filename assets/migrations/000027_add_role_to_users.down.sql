@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_role;
+
+ALTER TABLE users
+DROP CONSTRAINT IF EXISTS users_role_check,
+DROP COLUMN IF EXISTS role;
@@ -0,0 +1,7 @@
+-- What a role is permitted to do is decided by the application (internal/rbac),
+-- the database only knows which role each user has.
+ALTER TABLE users
+ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'customer',
+ADD CONSTRAINT users_role_check CHECK (role IN ('customer', 'support', 'compliance', 'admin'));
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role) WHERE role <> 'customer';
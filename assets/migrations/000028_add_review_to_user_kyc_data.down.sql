@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_user_kyc_data_pending;
+
+ALTER TABLE user_kyc_data
+DROP CONSTRAINT IF EXISTS user_kyc_data_status_check,
+DROP COLUMN IF EXISTS status,
+DROP COLUMN IF EXISTS submitted_at,
+DROP COLUMN IF EXISTS reviewed_by,
+DROP COLUMN IF EXISTS review_reason,
+DROP COLUMN IF EXISTS reviewed_at;
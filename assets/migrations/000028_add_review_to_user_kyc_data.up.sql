@@ -0,0 +1,16 @@
+-- KYC data is reviewed by compliance staff: it is pending until they approve or reject it, with a reason.
+-- verified stays true for approved data only, it is what decides KYC level upgrades.
+-- Rejected data can be submitted again, which sends it back to pending.
+-- Like every timestamp since 000023, they are TIMESTAMPTZ, so copying created_at keeps its instant whatever the session time zone.
+ALTER TABLE user_kyc_data
+ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'pending',
+ADD COLUMN submitted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+ADD COLUMN reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+ADD COLUMN review_reason TEXT,
+ADD COLUMN reviewed_at TIMESTAMPTZ,
+ADD CONSTRAINT user_kyc_data_status_check CHECK (status IN ('pending', 'approved', 'rejected'));
+
+UPDATE user_kyc_data SET submitted_at = created_at;
+UPDATE user_kyc_data SET status = 'approved' WHERE verified;
+
+CREATE INDEX IF NOT EXISTS idx_user_kyc_data_pending ON user_kyc_data (submitted_at) WHERE status = 'pending';
@@ -0,0 +1,7 @@
+ALTER TABLE user_kyc_data
+DROP COLUMN IF EXISTS document_key,
+DROP COLUMN IF EXISTS document_content_type,
+DROP COLUMN IF EXISTS document_size;
+
+ALTER TABLE kyc_requirements
+DROP COLUMN IF EXISTS requires_document;
@@ -0,0 +1,12 @@
+-- Some requirements are met with a document (an ID, a utility bill) rather than a value.
+-- The document itself is kept private in the document store, user_kyc_data only keeps its key,
+-- with the file name the user gave it as submission_data.
+ALTER TABLE kyc_requirements
+ADD COLUMN requires_document BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE kyc_requirements SET requires_document = TRUE WHERE requirement IN ('Government-issued ID', 'Proof of Address');
+
+ALTER TABLE user_kyc_data
+ADD COLUMN document_key TEXT,
+ADD COLUMN document_content_type VARCHAR(100),
+ADD COLUMN document_size BIGINT;
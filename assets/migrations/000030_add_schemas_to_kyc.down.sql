@@ -0,0 +1,14 @@
+ALTER TABLE user_kyc_data
+ADD COLUMN submission_data TEXT;
+
+UPDATE user_kyc_data
+SET submission_data = COALESCE(document_name, submission->>'value', submission->>'bvn', submission::TEXT);
+
+ALTER TABLE user_kyc_data
+ALTER COLUMN submission_data SET NOT NULL,
+DROP COLUMN IF EXISTS submission,
+DROP COLUMN IF EXISTS document_name;
+
+ALTER TABLE kyc_requirements
+DROP CONSTRAINT IF EXISTS kyc_requirements_schema_check,
+DROP COLUMN IF EXISTS schema;
@@ -0,0 +1,34 @@
+-- Every requirement declares the schema of the data users submit for it (see internal/kyc),
+-- and submissions are stored as the JSON object the schema describes instead of free text.
+ALTER TABLE kyc_requirements
+ADD COLUMN schema VARCHAR(30) NOT NULL DEFAULT 'text',
+ADD CONSTRAINT kyc_requirements_schema_check CHECK (schema IN ('text', 'bvn', 'nin', 'address', 'id_document', 'document', 'employment'));
+
+UPDATE kyc_requirements SET schema = 'bvn' WHERE requirement = 'BVN';
+UPDATE kyc_requirements SET schema = 'nin' WHERE requirement = 'NIN';
+UPDATE kyc_requirements SET schema = 'address' WHERE requirement = 'Address';
+UPDATE kyc_requirements SET schema = 'id_document' WHERE requirement = 'Government-issued ID';
+UPDATE kyc_requirements SET schema = 'document' WHERE requirement = 'Proof of Address';
+UPDATE kyc_requirements SET schema = 'employment' WHERE requirement = 'Occupation/Employer Information';
+
+ALTER TABLE user_kyc_data
+ADD COLUMN submission JSONB,
+ADD COLUMN document_name TEXT;
+
+-- the file name of a document was kept as its submission data
+UPDATE user_kyc_data SET document_name = submission_data WHERE document_key IS NOT NULL;
+
+-- data submitted before schemas can't be split into fields, BVNs aside it is kept as it was, under "value",
+-- for staff to review
+UPDATE user_kyc_data ukd
+SET submission = CASE
+    WHEN ukd.document_key IS NOT NULL THEN '{}'::JSONB
+    WHEN kr.schema = 'bvn' THEN jsonb_build_object('bvn', ukd.submission_data)
+    ELSE jsonb_build_object('value', ukd.submission_data)
+END
+FROM kyc_requirements kr
+WHERE kr.id = ukd.kyc_requirement_id;
+
+ALTER TABLE user_kyc_data
+ALTER COLUMN submission SET NOT NULL,
+DROP COLUMN submission_data;
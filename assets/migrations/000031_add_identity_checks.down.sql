@@ -0,0 +1,10 @@
+ALTER TABLE user_kyc_data
+DROP CONSTRAINT IF EXISTS user_kyc_data_identity_outcome_check,
+DROP COLUMN IF EXISTS identity_provider,
+DROP COLUMN IF EXISTS identity_score,
+DROP COLUMN IF EXISTS identity_outcome,
+DROP COLUMN IF EXISTS identity_response,
+DROP COLUMN IF EXISTS identity_checked_at;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS date_of_birth;
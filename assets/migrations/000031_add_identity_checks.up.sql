@@ -0,0 +1,14 @@
+-- Identity numbers (BVN, NIN) are checked with an identity provider, which matches their owner against the user.
+-- Users registered before dates of birth were asked for have none, their date of birth never matches.
+ALTER TABLE users
+ADD COLUMN date_of_birth DATE;
+
+-- The outcome of the check is kept with the KYC data: the provider, the match score (0 to 100),
+-- what was decided from it, and the provider's response as it was, for staff to review.
+ALTER TABLE user_kyc_data
+ADD COLUMN identity_provider VARCHAR(30),
+ADD COLUMN identity_score SMALLINT,
+ADD COLUMN identity_outcome VARCHAR(20),
+ADD COLUMN identity_response JSONB,
+ADD COLUMN identity_checked_at TIMESTAMPTZ,
+ADD CONSTRAINT user_kyc_data_identity_outcome_check CHECK (identity_outcome IN ('verified', 'review', 'mismatch', 'not_found'));
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_user_kyc_data_identity_fingerprint;
+
+ALTER TABLE user_kyc_data
+DROP COLUMN IF EXISTS identity_fingerprint,
+DROP COLUMN IF EXISTS duplicate_identity;
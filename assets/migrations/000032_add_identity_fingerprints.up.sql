@@ -0,0 +1,9 @@
+-- The identity number of KYC data (a BVN, a NIN, the number of an ID document) is kept as a keyed hash, its fingerprint,
+-- so the same number submitted from several accounts can be found without keeping another copy of the number.
+-- Data submitted before fingerprints is fingerprinted with morenee-admin fingerprint-kyc.
+-- duplicate_identity flags data whose number was already used by another account when it was submitted.
+ALTER TABLE user_kyc_data
+ADD COLUMN identity_fingerprint VARCHAR(64),
+ADD COLUMN duplicate_identity BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_user_kyc_data_identity_fingerprint ON user_kyc_data (identity_fingerprint) WHERE identity_fingerprint IS NOT NULL;
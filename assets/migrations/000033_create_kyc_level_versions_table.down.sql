@@ -0,0 +1,40 @@
+ALTER TABLE kyc_requirements
+DROP COLUMN IF EXISTS version,
+DROP COLUMN IF EXISTS effective_from,
+DROP COLUMN IF EXISTS retired_at;
+
+ALTER TABLE kyc_levels
+ADD COLUMN single_transfer_limit DECIMAL(15, 2),
+ADD COLUMN daily_transfer_limit DECIMAL(15, 2),
+ADD COLUMN wallet_balance_limit DECIMAL(15, 2),
+ADD COLUMN limit_window VARCHAR(20) NOT NULL DEFAULT 'calendar' CHECK (limit_window IN ('calendar', 'rolling')),
+ADD COLUMN weekly_transfer_limit DECIMAL(15, 2),
+ADD COLUMN monthly_transfer_limit DECIMAL(15, 2),
+ADD COLUMN daily_transfer_count INT,
+ADD COLUMN weekly_transfer_count INT,
+ADD COLUMN monthly_transfer_count INT;
+
+-- levels go back to the limits in effect, or to their first ones when none took effect yet
+UPDATE kyc_levels kl
+SET single_transfer_limit = v.single_transfer_limit,
+    daily_transfer_limit = v.daily_transfer_limit,
+    wallet_balance_limit = v.wallet_balance_limit,
+    limit_window = v.limit_window,
+    weekly_transfer_limit = v.weekly_transfer_limit,
+    monthly_transfer_limit = v.monthly_transfer_limit,
+    daily_transfer_count = v.daily_transfer_count,
+    weekly_transfer_count = v.weekly_transfer_count,
+    monthly_transfer_count = v.monthly_transfer_count
+FROM (
+    SELECT DISTINCT ON (kyc_level_id) *
+    FROM kyc_level_versions
+    ORDER BY kyc_level_id, (effective_from <= NOW()) DESC, effective_from DESC, version DESC
+) v
+WHERE v.kyc_level_id = kl.id;
+
+ALTER TABLE kyc_levels
+ALTER COLUMN single_transfer_limit SET NOT NULL,
+ALTER COLUMN daily_transfer_limit SET NOT NULL,
+ALTER COLUMN wallet_balance_limit SET NOT NULL;
+
+DROP TABLE IF EXISTS kyc_level_versions;
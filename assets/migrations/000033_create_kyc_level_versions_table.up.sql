@@ -0,0 +1,54 @@
+-- The limits of a KYC level are versioned: admins add a version with the new limits and the time it takes effect from,
+-- who added it and why, and the limits in effect are those of the latest version that took effect.
+-- The limits kyc_levels had become its first version.
+CREATE TABLE IF NOT EXISTS kyc_level_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    kyc_level_id INT NOT NULL REFERENCES kyc_levels(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    single_transfer_limit DECIMAL(15, 2) NOT NULL,
+    daily_transfer_limit DECIMAL(15, 2) NOT NULL,
+    wallet_balance_limit DECIMAL(15, 2) NOT NULL,
+    limit_window VARCHAR(20) NOT NULL DEFAULT 'calendar' CHECK (limit_window IN ('calendar', 'rolling')),
+    weekly_transfer_limit DECIMAL(15, 2),
+    monthly_transfer_limit DECIMAL(15, 2),
+    daily_transfer_count INT,
+    weekly_transfer_count INT,
+    monthly_transfer_count INT,
+    effective_from TIMESTAMPTZ NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (kyc_level_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_kyc_level_versions_effective_from ON kyc_level_versions (kyc_level_id, effective_from);
+
+INSERT INTO kyc_level_versions (
+    kyc_level_id, version, single_transfer_limit, daily_transfer_limit, wallet_balance_limit, limit_window,
+    weekly_transfer_limit, monthly_transfer_limit, daily_transfer_count, weekly_transfer_count, monthly_transfer_count,
+    effective_from, reason
+)
+SELECT 
+    id, 1, single_transfer_limit, daily_transfer_limit, wallet_balance_limit, limit_window,
+    weekly_transfer_limit, monthly_transfer_limit, daily_transfer_count, weekly_transfer_count, monthly_transfer_count,
+    NOW(), 'Limits before versioning'
+FROM kyc_levels;
+
+ALTER TABLE kyc_levels
+DROP COLUMN single_transfer_limit,
+DROP COLUMN daily_transfer_limit,
+DROP COLUMN wallet_balance_limit,
+DROP COLUMN limit_window,
+DROP COLUMN weekly_transfer_limit,
+DROP COLUMN monthly_transfer_limit,
+DROP COLUMN daily_transfer_count,
+DROP COLUMN weekly_transfer_count,
+DROP COLUMN monthly_transfer_count;
+
+-- Requirements count towards a level from effective_from, until they are retired.
+-- version goes up every time an admin changes a requirement, the changes are in the activity log.
+-- Retired requirements are kept, with the data users submitted for them.
+ALTER TABLE kyc_requirements
+ADD COLUMN version INT NOT NULL DEFAULT 1,
+ADD COLUMN effective_from TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+ADD COLUMN retired_at TIMESTAMPTZ;
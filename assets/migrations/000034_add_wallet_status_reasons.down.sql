@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_wallets_status_reason;
+
+ALTER TABLE wallets
+DROP CONSTRAINT IF EXISTS wallets_status_reason_on_hold_check,
+DROP CONSTRAINT IF EXISTS wallets_status_reason_check,
+DROP COLUMN IF EXISTS status_reason,
+DROP COLUMN IF EXISTS status_changed_at;
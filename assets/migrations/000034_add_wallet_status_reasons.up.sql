@@ -0,0 +1,22 @@
+-- A wallet on hold always says why (see internal/walletstatus):
+-- 'limit-exceeded' is set and lifted automatically with the balance limit of the owner's KYC level,
+-- 'security', 'compliance' and 'user-requested' are set and lifted by staff.
+ALTER TABLE wallets
+ADD COLUMN status_reason VARCHAR(30) CONSTRAINT wallets_status_reason_check CHECK (status_reason IN ('limit-exceeded', 'security', 'compliance', 'user-requested')),
+ADD COLUMN status_changed_at TIMESTAMPTZ;
+
+-- wallets staff put on hold are held for security, the others went over their balance limit
+UPDATE wallets w
+SET status_reason = CASE
+    WHEN EXISTS (
+        SELECT 1 FROM activity_logs al
+        WHERE al.entity = 'wallet' AND al.entity_id = w.id AND al.description = 'Wallet locked by staff'
+    ) THEN 'security'
+    ELSE 'limit-exceeded'
+END
+WHERE w.status = 'on-hold';
+
+ALTER TABLE wallets
+ADD CONSTRAINT wallets_status_reason_on_hold_check CHECK ((status = 'on-hold') = (status_reason IS NOT NULL));
+
+CREATE INDEX IF NOT EXISTS idx_wallets_status_reason ON wallets (status_reason) WHERE status_reason IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS suspended_credits;
+
+ALTER TABLE wallets
+DROP COLUMN IF EXISTS suspended_balance;
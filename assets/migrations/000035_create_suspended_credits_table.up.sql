@@ -0,0 +1,23 @@
+-- suspended_balance is money credited to the wallet that went over the balance limit of its owner's KYC level,
+-- it is kept in the transfer suspense account until the limit allows it into the wallet
+ALTER TABLE wallets
+ADD COLUMN IF NOT EXISTS suspended_balance DECIMAL(15, 2) NOT NULL DEFAULT 0.00 CHECK (suspended_balance >= 0);
+
+CREATE TABLE IF NOT EXISTS suspended_credits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    wallet_id UUID NOT NULL,
+    transaction_id UUID NOT NULL UNIQUE,
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    released_amount DECIMAL(15, 2) NOT NULL DEFAULT 0.00 CHECK (released_amount >= 0 AND released_amount <= amount),
+    released_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (wallet_id) REFERENCES wallets(id) ON DELETE RESTRICT,
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS idx_suspended_credits_pending ON suspended_credits (wallet_id, created_at) WHERE released_at IS NULL;
+
+INSERT INTO ledger_accounts (code, name) VALUES
+    ('transfer_suspense', 'Transfer suspense')
+ON CONFLICT DO NOTHING;
@@ -7,12 +7,12 @@ import (
 	"log/slog"
 	"os"
 	"runtime/debug"
+	"sync"
 
 	"github.com/cradoe/morenee/internal/app"
-	"github.com/cradoe/morenee/internal/repository"
 	seeders "github.com/cradoe/morenee/internal/seeder"
+	"github.com/cradoe/morenee/internal/stream"
 	"github.com/cradoe/morenee/internal/version"
-	"github.com/cradoe/morenee/internal/worker"
 )
 
 func main() {
@@ -28,6 +28,7 @@ func main() {
 
 func run(logger *slog.Logger) error {
 	showVersion := flag.Bool("version", false, "display version and exit")
+	withWorkers := flag.Bool("workers", false, "also run every worker in this process, instead of with cmd/worker")
 	flag.Parse()
 
 	if *showVersion {
@@ -42,59 +43,48 @@ func run(logger *slog.Logger) error {
 	// Let's ensure the database connection is properly closed when the application ends
 	defer application.DB.Close()
 
-	// let's create and run seeders
-	seeder := seeders.New(application.DB)
-	seeder.Run()
+	// in development, the database is migrated at startup (DB_AUTOMIGRATE), and seeded with it,
+	// elsewhere both are deployment steps, see cmd/migrate and cmd/seed
+	if application.Config.Db.Automigrate {
+		seeder := seeders.New(application.DB)
+		seeder.Run()
+	}
 
-	// These topics are required to ensure that messages for various events (e.g., transfer debit, credit, success)
-	// are properly published and consumed without errors.
-	workerTopics := []string{worker.TransferDebitTopic, worker.TransferCreditTopic, worker.TransferSuccessTopic}
-	// Ensure that the specified Kafka topics exist before producing or consuming messages.
-	// This step is important to avoid runtime errors or message loss due to missing topics.
-	err = application.Kafka.EnsureTopicsExist(workerTopics)
-	if err != nil {
-		return err
+	// The API only writes transfer events to the outbox, workers publish and consume them (see cmd/worker),
+	// so API and worker replicas can be deployed and scaled independently.
+	// The in-memory event bus only lives in this process though, its workers have to run here too.
+	if application.Config.EventBus == stream.EventBusMemory && !*withWorkers {
+		logger.Info("the in-memory event bus is used, workers run in the API process")
+		*withWorkers = true
 	}
 
 	// Create a cancellable context for managing the application lifecycle
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start workers
-	userRepo := repository.NewUserRepository(application.DB)
-	transactionRepo := repository.NewTransactionRepository(application.DB)
-	walletRepo := repository.NewWalletRepository(application.DB)
-	kycRepo := repository.NewKycRepository(application.DB)
-	activityRepo := repository.NewActivityRepository(application.DB)
-
-	wk := worker.New(&worker.Worker{
-		UserRepo:        userRepo,
-		TransactionRepo: transactionRepo,
-		WalletRepo:      walletRepo,
-		KycRepo:         kycRepo,
-		ActivityRepo:    activityRepo,
-
-		KafkaStream: application.Kafka,
-		Ctx:         ctx,
-		Helper:      application.Helper,
-		Mailer:      application.Mailer,
-	})
-
-	// In order to simplify things and reduce latency for user during transfer
-	// we have set up workers to handle every bits of the process
-	// The `HandleTransferMoney` handler function initiates the transaction and produces an event
-	// ... that would be received by our first worker `DebitWorker`.
-	// We have chosen to have different workers for each of the prcesses rather than having
-	// ... all the work done in a single worker.
-	// This approach makes it easy to keep each worker simple and focus on doing o
-	go wk.DebitWorker()
-	go wk.CreditWorker()
-	go wk.SuccessTransferWorker()
+	workers := &sync.WaitGroup{}
+	if *withWorkers {
+		err = application.EnsureTopics()
+		if err != nil {
+			return err
+		}
+
+		workers, err = application.RunWorkers(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
 
 	err = application.ServeHTTP()
 	if err != nil {
 		logger.Error("HTTP server error", "error", err)
 	}
 
+	// Consumers drain the messages they hold when ctx is cancelled, and the relay finishes its batch,
+	// we wait for them before the event bus delivers what it still has queued and exits.
+	cancel()
+	workers.Wait()
+	application.EventBus.Close()
+
 	return nil
 }
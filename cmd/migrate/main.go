@@ -0,0 +1,197 @@
+// The migrate command applies the database migrations embedded in the binary (from assets/migrations),
+// so schema changes are a deployment step of their own rather than a side effect of starting the API.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/cradoe/morenee/internal/app"
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/version"
+	"github.com/golang-migrate/migrate/v4"
+)
+
+const usage = `Usage: migrate [flags] <command>
+
+Commands:
+  up [N]      apply all pending migrations, or only the next N
+  down N|all  roll back the last N migrations, or all of them
+  status      show the current version and which migrations are applied
+  force V     set the version to V without running anything, to recover from a dirty migration
+
+Flags:
+`
+
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	err := run(logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger) error {
+	showVersion := flag.Bool("version", false, "display version and exit")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("version: %s\n", version.Get())
+		return nil
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return errors.New("missing command")
+	}
+
+	cfg, err := app.LoadConfig(logger)
+	if err != nil {
+		return err
+	}
+
+	migrator, err := repository.NewMigrator(cfg.Db.Dsn)
+	if err != nil {
+		return fmt.Errorf("failed to initialize migrator: %w", err)
+	}
+	defer migrator.Close()
+
+	// migrations can take a while, progress is reported as they run
+	migrator.Log = &migrateLogger{logger: logger}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	switch command {
+	case "up":
+		if len(args) == 0 {
+			err = migrator.Up()
+			break
+		}
+
+		steps, parseErr := parsePositive(args[0])
+		if parseErr != nil {
+			return parseErr
+		}
+		err = migrator.Steps(steps)
+
+	case "down":
+		// rolling back drops data, the number of migrations must always be given
+		if len(args) == 0 {
+			return errors.New("down needs the number of migrations to roll back, or all")
+		}
+
+		if args[0] == "all" {
+			err = migrator.Down()
+			break
+		}
+
+		steps, parseErr := parsePositive(args[0])
+		if parseErr != nil {
+			return parseErr
+		}
+		err = migrator.Steps(-steps)
+
+	case "force":
+		if len(args) == 0 {
+			return errors.New("force needs the version to set")
+		}
+
+		forced, parseErr := strconv.Atoi(args[0])
+		if parseErr != nil {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		err = migrator.Force(forced)
+
+	case "status":
+		return printStatus(migrator)
+
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		logger.Info("no migration to apply")
+	case err != nil:
+		return err
+	}
+
+	return printStatus(migrator)
+}
+
+// printStatus shows the current version, and every embedded migration with whether it has been applied
+func printStatus(migrator *migrate.Migrate) error {
+	current, dirty, err := migrator.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		fmt.Println("version: none")
+	case err != nil:
+		return err
+	case dirty:
+		fmt.Printf("version: %d (dirty, fix the schema by hand and then force a version)\n", current)
+	default:
+		fmt.Printf("version: %d\n", current)
+	}
+
+	migrations, err := repository.Migrations()
+	if err != nil {
+		return err
+	}
+	defer migrations.Close()
+
+	next, err := migrations.First()
+	for err == nil {
+		migration, identifier, readErr := migrations.ReadUp(next)
+		if readErr != nil {
+			return readErr
+		}
+		migration.Close()
+
+		state := "pending"
+		if next <= current {
+			state = "applied"
+		}
+		fmt.Printf("  %06d  %-8s %s\n", next, state, identifier)
+
+		next, err = migrations.Next(next)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func parsePositive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid number of migrations %q", value)
+	}
+
+	return n, nil
+}
+
+// migrateLogger reports the progress of golang-migrate through our logger
+type migrateLogger struct {
+	logger *slog.Logger
+}
+
+func (l *migrateLogger) Printf(format string, v ...any) {
+	l.logger.Info(fmt.Sprintf(format, v...))
+}
+
+func (l *migrateLogger) Verbose() bool {
+	return false
+}
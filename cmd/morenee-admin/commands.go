@@ -0,0 +1,317 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/cradoe/morenee/internal/handler"
+	"github.com/cradoe/morenee/internal/kyc"
+	"github.com/cradoe/morenee/internal/rbac"
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/worker"
+	"github.com/google/uuid"
+)
+
+func unlockWalletCommand(fs *flag.FlagSet) func(adm *admin) error {
+	accountNumber := fs.String("account", "", "account number of the wallet (required)")
+
+	return func(adm *admin) error {
+		if *accountNumber == "" {
+			return errors.New("-account is required")
+		}
+
+		wallet, found, err := adm.walletRepo.FindByAccountNumber(*accountNumber)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no wallet with account number %s", *accountNumber)
+		}
+
+		if wallet.Status != repository.WalletOnHoldStatus {
+			return fmt.Errorf("wallet %s is %s, only wallets on hold can be unlocked", wallet.AccountNumber, wallet.Status)
+		}
+
+		adm.printf("wallet %s (%s), on hold for %s, will go from %s to %s",
+			wallet.AccountNumber, wallet.ID, wallet.StatusReason.String, wallet.Status, repository.WalletActiveStatus)
+		if adm.dryRun {
+			return nil
+		}
+
+		unlocked, err := adm.app.WalletStatus.Unlock(wallet, "")
+		if err != nil {
+			return err
+		}
+		if !unlocked {
+			return fmt.Errorf("wallet %s is no longer on hold, nothing was done", wallet.AccountNumber)
+		}
+
+		err = adm.audit(wallet.UserID, repository.ActivityLogWalletEntity, wallet.ID, repository.AdminActivityLogWalletUnlockedDescription)
+		if err != nil {
+			return err
+		}
+
+		adm.printf("wallet %s unlocked", wallet.AccountNumber)
+		return nil
+	}
+}
+
+func unlockUserCommand(fs *flag.FlagSet) func(adm *admin) error {
+	email := fs.String("email", "", "email of the user (required)")
+
+	return func(adm *admin) error {
+		if *email == "" {
+			return errors.New("-email is required")
+		}
+
+		user, found, err := adm.userRepo.GetByEmail(*email)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no account with email %s", *email)
+		}
+
+		if user.Status != repository.UserAccountLockedStatus {
+			return fmt.Errorf("the account of %s is %s, only locked accounts can be unlocked", user.Email, user.Status)
+		}
+
+		// accounts that were never verified still have to be
+		newStatus := repository.UserAccountActiveStatus
+		if !user.VerifiedAt.Valid {
+			newStatus = repository.UserAccountActivePending
+		}
+
+		adm.printf("the account of %s (%s) will go from %s to %s", user.Email, user.ID, user.Status, newStatus)
+		if adm.dryRun {
+			return nil
+		}
+
+		unlocked, err := adm.userRepo.Unlock(user.ID)
+		if err != nil {
+			return err
+		}
+		if !unlocked {
+			return fmt.Errorf("the account of %s is no longer locked, nothing was done", user.Email)
+		}
+
+		// the log entry also ends the user's streak of failed logins, so the next failed login doesn't lock them again
+		err = adm.audit(user.ID, repository.ActivityLogUserEntity, user.ID, repository.AdminActivityLogUserUnlockedDescription)
+		if err != nil {
+			return err
+		}
+
+		adm.printf("the account of %s unlocked", user.Email)
+		return nil
+	}
+}
+
+func reverseTransferCommand(fs *flag.FlagSet) func(adm *admin) error {
+	transactionID := fs.String("transaction", "", "ID of the transaction of the transfer (required)")
+
+	return func(adm *admin) error {
+		if _, err := uuid.Parse(*transactionID); err != nil {
+			return errors.New("-transaction must be a transaction ID")
+		}
+
+		transaction, found, err := adm.worker.TransactionRepo.GetOne(*transactionID, nil)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no transaction with ID %s", *transactionID)
+		}
+
+		plan, err := adm.worker.PlanCompensation(transaction)
+		if err != nil {
+			return fmt.Errorf("transfer %s can't be undone: %w", transaction.ReferenceNumber, err)
+		}
+
+		switch plan {
+		case worker.CompensationReverse:
+			adm.printf("transfer %s of %s was debited but not credited, %s will be given back to the sender's wallet %s",
+				transaction.ReferenceNumber, transaction.Amount, transaction.Amount, transaction.SenderAccount)
+		default:
+			adm.printf("transfer %s of %s was never debited, it will be marked as failed and the funds held for it released",
+				transaction.ReferenceNumber, transaction.Amount)
+		}
+		if adm.dryRun {
+			return nil
+		}
+
+		plan, compensated, err := adm.worker.CompensateTransfer(transaction, "reversed by staff: "+adm.reason)
+		if err != nil {
+			return err
+		}
+		if !compensated {
+			return fmt.Errorf("transfer %s moved on in the meantime, nothing was done", transaction.ReferenceNumber)
+		}
+
+		err = adm.audit(transaction.SenderID, repository.ActivityLogTransactionEntity, transaction.ID, repository.AdminActivityLogTransferReversedDescription+plan)
+		if err != nil {
+			return err
+		}
+
+		adm.printf("transfer %s undone (%s), the sender is not notified by email", transaction.ReferenceNumber, plan)
+		return nil
+	}
+}
+
+func resendOTPCommand(fs *flag.FlagSet) func(adm *admin) error {
+	email := fs.String("email", "", "email of the user (required)")
+
+	return func(adm *admin) error {
+		if *email == "" {
+			return errors.New("-email is required")
+		}
+
+		user, found, err := adm.userRepo.GetByEmail(*email)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no account with email %s", *email)
+		}
+
+		if user.VerifiedAt.Valid {
+			return fmt.Errorf("the account of %s is already verified", user.Email)
+		}
+
+		adm.printf("a new verification OTP will be sent to %s, the previous one stops working", user.Email)
+		if adm.dryRun {
+			return nil
+		}
+
+		auth := handler.NewAuthHandler(&handler.AuthHandler{
+			UserRepo:     adm.userRepo,
+			ActivityRepo: adm.activityRepo,
+			Mailer:       adm.app.Mailer,
+			Helper:       adm.app.Helper,
+			Cache:        adm.app.Cache,
+		})
+
+		err = auth.SendVerificationOTP(user)
+		if err != nil {
+			return err
+		}
+
+		err = adm.audit(user.ID, repository.ActivityLogUserEntity, user.ID, repository.AdminActivityLogOTPResentDescription)
+		if err != nil {
+			return err
+		}
+
+		adm.printf("verification OTP sent to %s", user.Email)
+		return nil
+	}
+}
+
+// setRoleCommand gives a user a role. Only admins can, with one exception:
+// while nobody is an admin, any active account can make itself the first admin.
+func setRoleCommand(fs *flag.FlagSet) func(adm *admin) error {
+	email := fs.String("email", "", "email of the user (required)")
+	role := fs.String("role", "", fmt.Sprintf("the new role, one of %v (required)", rbac.Roles()))
+
+	return func(adm *admin) error {
+		if *email == "" {
+			return errors.New("-email is required")
+		}
+		if !rbac.IsRole(*role) {
+			return fmt.Errorf("-role must be one of %v", rbac.Roles())
+		}
+
+		user, found, err := adm.userRepo.GetByEmail(*email)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no account with email %s", *email)
+		}
+
+		if !rbac.Can(adm.actor.Role, rbac.PermissionManageRoles) {
+			admins, err := adm.userRepo.CountByRole(rbac.RoleAdmin)
+			if err != nil {
+				return err
+			}
+
+			bootstrap := admins == 0 && user.ID == adm.actor.ID && *role == rbac.RoleAdmin
+			if !bootstrap {
+				return fmt.Errorf("the account of %s is a %s account, only admins can give roles", adm.actor.Email, adm.actor.Role)
+			}
+		}
+
+		if user.Role == *role {
+			return fmt.Errorf("the account of %s is already a %s account", user.Email, user.Role)
+		}
+
+		adm.printf("the account of %s (%s) will go from %s to %s", user.Email, user.ID, user.Role, *role)
+		if adm.dryRun {
+			return nil
+		}
+
+		err = adm.userRepo.SetRole(user.ID, *role)
+		if err != nil {
+			return err
+		}
+
+		err = adm.audit(user.ID, repository.ActivityLogUserEntity, user.ID, repository.AdminActivityLogRoleChangedDescription+*role)
+		if err != nil {
+			return err
+		}
+
+		adm.printf("the account of %s is now a %s account", user.Email, *role)
+		return nil
+	}
+}
+
+// fingerprintKYCCommand fingerprints the identity numbers of KYC data that has none, data submitted before fingerprints.
+// With -all it fingerprints all of it again, which a new fingerprint key needs.
+func fingerprintKYCCommand(fs *flag.FlagSet) func(adm *admin) error {
+	all := fs.Bool("all", false, "fingerprint all KYC data again, not only the data without a fingerprint")
+
+	return func(adm *admin) error {
+		if !rbac.Can(adm.actor.Role, rbac.PermissionReviewKYC) {
+			return fmt.Errorf("the account of %s is a %s account, only compliance staff and admins can fingerprint KYC data", adm.actor.Email, adm.actor.Role)
+		}
+
+		kycDataList, err := adm.kycDataRepo.GetFingerprintable([]string{kyc.SchemaBVN, kyc.SchemaNIN, kyc.SchemaIDDocument}, *all)
+		if err != nil {
+			return err
+		}
+
+		adm.printf("%d KYC data will be fingerprinted", len(kycDataList))
+		if adm.dryRun {
+			return nil
+		}
+
+		fingerprinted := 0
+		for _, kycData := range kycDataList {
+			number, ok, err := kyc.IdentityNumber(kycData.Schema, kycData.Submission)
+			// data submitted before schemas has no number we can tell apart
+			if err != nil || !ok || number == "" {
+				adm.printf("KYC data %s has no identity number, skipped", kycData.ID)
+				continue
+			}
+
+			err = adm.kycDataRepo.SetFingerprint(kycData.ID, kyc.Fingerprint(adm.app.Config.IdentityFingerprints.Key, kycData.Schema, number))
+			if err != nil {
+				return err
+			}
+
+			err = adm.audit(kycData.UserID, repository.ActivityLogKYCDataEntity, kycData.ID, repository.AdminActivityLogKYCFingerprintedDescription)
+			if err != nil {
+				return err
+			}
+
+			fingerprinted++
+		}
+
+		adm.printf("%d KYC data fingerprinted, GET /admin/kyc/linked-accounts lists the numbers used by several accounts", fingerprinted)
+		return nil
+	}
+}
+
+func sqlString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
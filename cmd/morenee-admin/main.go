@@ -0,0 +1,224 @@
+// morenee-admin runs the support operations that would otherwise need hand-written SQL.
+// Every operation is audited: it is run on behalf of a member of staff (-actor, their email) and needs a reason (-reason),
+// both are written to activity_logs with the action. With -dry-run, an operation only says what it would do.
+// The actor must have a staff role, except to make the first admin with set-role, see setRoleCommand.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cradoe/morenee/internal/app"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/rbac"
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/version"
+	"github.com/cradoe/morenee/internal/worker"
+)
+
+var errUsage = errors.New("invalid usage")
+
+// command is one operation of the CLI, its flags are added to the ones every operation has
+type command struct {
+	summary string
+	flags   func(fs *flag.FlagSet) func(adm *admin) error
+}
+
+var commands = map[string]command{
+	"unlock-wallet": {
+		summary: "put a wallet that is on hold back to active",
+		flags:   unlockWalletCommand,
+	},
+	"unlock-user": {
+		summary: "unlock a user account, locked after failed logins for example",
+		flags:   unlockUserCommand,
+	},
+	"reverse-transfer": {
+		summary: "undo a transfer stuck as pending, failing or reversing it depending on how far it went",
+		flags:   reverseTransferCommand,
+	},
+	"resend-otp": {
+		summary: "email a new account verification OTP to a user",
+		flags:   resendOTPCommand,
+	},
+	"set-role": {
+		summary: "give a user a role, only admins can",
+		flags:   setRoleCommand,
+	},
+	"fingerprint-kyc": {
+		summary: "fingerprint the identity numbers of KYC data, after a change of fingerprint key for example",
+		flags:   fingerprintKYCCommand,
+	},
+}
+
+func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	err := run(logger, os.Args[1:], os.Stdout)
+	if err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger, args []string, out io.Writer) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && args[0] == "-version" {
+			fmt.Fprintf(out, "version: %s\n", version.Get())
+			return nil
+		}
+
+		printUsage(os.Stderr)
+		return errUsage
+	}
+
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	actorEmail := fs.String("actor", "", "email of the member of staff running the command (required)")
+	reason := fs.String("reason", "", "why the command is run, it is written to the activity log (required)")
+	dryRun := fs.Bool("dry-run", false, "only say what the command would do")
+	execute := cmd.flags(fs)
+
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return errUsage
+	}
+
+	*reason = strings.TrimSpace(*reason)
+	switch {
+	case *actorEmail == "":
+		err = errors.New("-actor is required")
+	case *reason == "":
+		err = errors.New("-reason is required")
+	case fs.NArg() > 0:
+		err = fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		fs.Usage()
+		return errUsage
+	}
+
+	application, err := app.NewApplication(logger)
+	if err != nil {
+		return err
+	}
+	defer application.DB.Close()
+	defer application.EventBus.Close()
+
+	adm := &admin{
+		app:          application,
+		userRepo:     repository.NewUserRepository(application.DB),
+		walletRepo:   repository.NewWalletRepository(application.DB),
+		activityRepo: repository.NewActivityRepository(application.DB),
+		kycDataRepo:  repository.NewUserKycDataRepository(application.DB),
+		// the worker is only used to undo transfers, its events go through the outbox
+		worker: application.NewWorker(context.Background()),
+		reason: *reason,
+		dryRun: *dryRun,
+		out:    out,
+	}
+
+	err = adm.setActor(*actorEmail, name == "set-role")
+	if err != nil {
+		return err
+	}
+
+	if adm.dryRun {
+		adm.printf("dry run, nothing will be changed")
+	}
+
+	return execute(adm)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: morenee-admin <command> -actor <email> -reason <text> [-dry-run] [command flags]")
+	fmt.Fprintln(w, "\nCommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-17s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintln(w, "\nRun morenee-admin <command> -h for the flags of a command.")
+}
+
+// admin carries what every command needs: the repositories, and who runs it and why
+type admin struct {
+	app          *app.Application
+	userRepo     repository.UserRepository
+	walletRepo   repository.WalletRepository
+	activityRepo repository.ActivityRepository
+	kycDataRepo  repository.UserKycDataRepository
+	worker       *worker.Worker
+
+	actor  *models.User
+	reason string
+	dryRun bool
+	out    io.Writer
+}
+
+// setActor finds the member of staff running the command, only active accounts with a staff role can run commands.
+// With anyRole, the actor's role is left for the command to check.
+func (adm *admin) setActor(email string, anyRole bool) error {
+	actor, found, err := adm.userRepo.GetByEmail(email)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no account with email %s", email)
+	}
+	if actor.Status != repository.UserAccountActiveStatus {
+		return fmt.Errorf("the account of %s is %s, it can't run commands", email, actor.Status)
+	}
+	if !anyRole && !rbac.IsStaff(actor.Role) {
+		return fmt.Errorf("the account of %s is a %s account, only staff can run commands", email, actor.Role)
+	}
+
+	adm.actor = actor
+	return nil
+}
+
+// audit writes an action the command took to the activity log, with the actor and the reason.
+// userID is the user the action was taken for.
+func (adm *admin) audit(userID, entity, entityID, description string) error {
+	_, err := adm.activityRepo.Insert(&models.ActivityLog{
+		UserID:      userID,
+		Entity:      entity,
+		EntityId:    entityID,
+		Description: description,
+		ActorID:     sqlString(adm.actor.ID),
+		Reason:      sqlString(adm.reason),
+	})
+	if err != nil {
+		// the action itself went through, whoever runs the command has to know it is not audited
+		return fmt.Errorf("the action was taken, but could not be written to the activity log: %w", err)
+	}
+
+	return nil
+}
+
+func (adm *admin) printf(format string, a ...any) {
+	fmt.Fprintf(adm.out, format+"\n", a...)
+}
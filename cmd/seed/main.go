@@ -0,0 +1,53 @@
+// The seed command fills the database with the data the application can't run without, like KYC levels.
+// Seeders skip what already exists, so it is safe to run after every deployment.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/cradoe/morenee/internal/app"
+	"github.com/cradoe/morenee/internal/repository"
+	seeders "github.com/cradoe/morenee/internal/seeder"
+	"github.com/cradoe/morenee/internal/version"
+)
+
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	err := run(logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger) error {
+	showVersion := flag.Bool("version", false, "display version and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("version: %s\n", version.Get())
+		return nil
+	}
+
+	cfg, err := app.LoadConfig(logger)
+	if err != nil {
+		return err
+	}
+
+	// the schema is expected to be up to date, cmd/migrate takes care of it
+	db, err := repository.New(cfg.Db.Dsn, false, cfg.BusinessTimezone.Name)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	seeder := seeders.New(db)
+	seeder.Run()
+
+	logger.Info("seeding done")
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
+
+	"github.com/cradoe/morenee/internal/app"
+	"github.com/cradoe/morenee/internal/stream"
+	"github.com/cradoe/morenee/internal/version"
+	"github.com/cradoe/morenee/internal/worker"
+)
+
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	err := run(logger)
+	if err != nil {
+		trace := string(debug.Stack())
+		logger.Error(err.Error(), "trace", trace)
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger) error {
+	showVersion := flag.Bool("version", false, "display version and exit")
+	runOnly := flag.String("run", "", fmt.Sprintf("comma-separated workers to run, all of them when empty (%s)", strings.Join(worker.RunnerNames(), ", ")))
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("version: %s\n", version.Get())
+		return nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(*runOnly, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	application, err := app.NewApplication(logger)
+	if err != nil {
+		return err
+	}
+	// Let's ensure the database connection is properly closed when the worker ends
+	defer application.DB.Close()
+
+	// messages published to the in-memory bus never leave the process that published them
+	if application.Config.EventBus == stream.EventBusMemory {
+		return errors.New("workers can't run on their own with the in-memory event bus, run cmd/api instead")
+	}
+
+	err = application.EnsureTopics()
+	if err != nil {
+		return err
+	}
+
+	// workers stop on SIGINT and SIGTERM, like the API does
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	workers, err := application.RunWorkers(ctx, names)
+	if err != nil {
+		return err
+	}
+
+	// the workers' counters, such as the sweeper's, can only be read from this process
+	if application.Config.Worker.MetricsAddr != "" {
+		go func() {
+			err := application.ServeWorkerMetrics(ctx)
+			if err != nil {
+				logger.Error("metrics server stopped", "error", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	logger.Info("stopping workers")
+
+	// Consumers drain the messages they hold, and the relay finishes its batch,
+	// before the event bus delivers what it still has queued.
+	workers.Wait()
+	application.EventBus.Close()
+
+	// background tasks, like alert emails, are given the chance to finish
+	application.WG.Wait()
+
+	logger.Info("stopped workers")
+	return nil
+}
@@ -1,19 +1,29 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"slices"
 	"sync"
+	"time"
+	// the time zone database is embedded, so the business time zone can be loaded in minimal containers
+	_ "time/tzdata"
 
+	"github.com/cradoe/morenee/assets"
 	"github.com/cradoe/morenee/internal/cache"
 	"github.com/cradoe/morenee/internal/config"
 	"github.com/cradoe/morenee/internal/env"
 	"github.com/cradoe/morenee/internal/errHandler"
 	"github.com/cradoe/morenee/internal/file"
 	"github.com/cradoe/morenee/internal/helper"
+	"github.com/cradoe/morenee/internal/identity"
+	"github.com/cradoe/morenee/internal/kyc"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/smtp"
 	"github.com/cradoe/morenee/internal/stream"
+	"github.com/cradoe/morenee/internal/walletstatus"
 	"github.com/joho/godotenv"
 )
 
@@ -28,11 +38,16 @@ type Application struct {
 	WG           *sync.WaitGroup
 	errorHandler *errHandler.ErrorHandler
 	Helper       *helper.Helper
-	Kafka        *stream.KafkaStream
+	EventBus     stream.EventBus
 	FileUploader *file.FileUploader
+	Documents    file.DocumentStore
+	Identities   identity.Verifier
+	WalletStatus *walletstatus.Manager
 }
 
-func NewApplication(logger *slog.Logger) (*Application, error) {
+// LoadConfig reads the configuration from the environment (and the .env file, when there is one).
+// Commands that don't need the whole application, like migrations, only load the configuration.
+func LoadConfig(logger *slog.Logger) (config.Config, error) {
 	if err := godotenv.Load(); err != nil {
 		logger.Error("Error loading .env file", "error", err)
 	}
@@ -60,14 +75,57 @@ func NewApplication(logger *slog.Logger) (*Application, error) {
 	cfg.Smtp.From = env.GetString("SMTP_FROM", "Example Name <no_reply@example.org>")
 
 	cfg.KafkaServers = env.GetString("KAFKA_SERVERS", "localhost:9092")
+	cfg.KafkaPartitions = env.GetInt("KAFKA_PARTITIONS", 1)
+	cfg.EventBus = env.GetString("EVENT_BUS", stream.EventBusKafka)
+	cfg.Worker.Concurrency = env.GetInt("WORKER_CONCURRENCY", 4)
+	cfg.Worker.MetricsAddr = env.GetString("WORKER_METRICS_ADDR", "localhost:4445")
 
 	cfg.FileUploader.ApiKey = env.GetString("CLOUDINARY_API_KEY", "")
 	cfg.FileUploader.CloudName = env.GetString("CLOUDINARY_CLOUD_NAME", "")
 	cfg.FileUploader.ApiSecret = env.GetString("CLOUDINARY_API_SECRET", "")
 
+	cfg.DocumentStore.Driver = env.GetString("DOCUMENT_STORE", file.DocumentStoreLocal)
+	cfg.DocumentStore.Dir = env.GetString("DOCUMENT_STORE_DIR", "storage/documents")
+
+	cfg.IdentityVerifier.Driver = env.GetString("IDENTITY_VERIFIER", identity.VerifierFake)
+	cfg.IdentityVerifier.Provider = env.GetString("IDENTITY_PROVIDER", "identity-provider")
+	cfg.IdentityVerifier.BaseURL = env.GetString("IDENTITY_PROVIDER_URL", "")
+	cfg.IdentityVerifier.ApiKey = env.GetString("IDENTITY_PROVIDER_API_KEY", "")
+	cfg.IdentityVerifier.Timeout = time.Duration(env.GetInt("IDENTITY_PROVIDER_TIMEOUT_SECONDS", 10)) * time.Second
+	cfg.IdentityVerifier.FixturesFile = env.GetString("IDENTITY_FIXTURES_FILE", "")
+	cfg.IdentityVerifier.AutoVerifyScore = env.GetInt("IDENTITY_AUTO_VERIFY_SCORE", 100)
+	cfg.IdentityVerifier.ReviewScore = env.GetInt("IDENTITY_REVIEW_SCORE", 50)
+
+	// there is no default key: fingerprints keyed with a key anyone can read would let them test identity numbers
+	cfg.IdentityFingerprints.Key = env.GetString("IDENTITY_FINGERPRINT_KEY", "")
+	cfg.IdentityFingerprints.OnDuplicate = env.GetString("IDENTITY_DUPLICATE_POLICY", kyc.DuplicateReview)
+	if !slices.Contains([]string{kyc.DuplicateBlock, kyc.DuplicateReview}, cfg.IdentityFingerprints.OnDuplicate) {
+		return cfg, fmt.Errorf("unknown identity duplicate policy %q, expected %q or %q", cfg.IdentityFingerprints.OnDuplicate, kyc.DuplicateBlock, kyc.DuplicateReview)
+	}
+
 	cfg.RedisServer = env.GetString("REDIS_SERVER", "localhost:6379")
 
-	db, err := repository.New(cfg.Db.Dsn, cfg.Db.Automigrate)
+	cfg.BusinessTimezone.Name = env.GetString("BUSINESS_TIMEZONE", "Africa/Lagos")
+	location, err := time.LoadLocation(cfg.BusinessTimezone.Name)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to load business timezone: %w", err)
+	}
+	cfg.BusinessTimezone.Location = location
+
+	return cfg, nil
+}
+
+func NewApplication(logger *slog.Logger) (*Application, error) {
+	cfg, err := LoadConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.IdentityFingerprints.Key == "" {
+		return nil, errors.New("IDENTITY_FINGERPRINT_KEY is not set, it keys the fingerprints of identity numbers")
+	}
+
+	db, err := repository.New(cfg.Db.Dsn, cfg.Db.Automigrate, cfg.BusinessTimezone.Name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
@@ -77,18 +135,63 @@ func NewApplication(logger *slog.Logger) (*Application, error) {
 		return nil, fmt.Errorf("failed to initialize mailer: %w", err)
 	}
 
-	cfg.KafkaServers = env.GetString("KAFKA_SERVERS", "localhost:9092")
-
 	appWaitGroup := &sync.WaitGroup{}
 
 	errorHandler := errHandler.New(cfg.Notifications.Email, mailer, logger)
 
 	helper := helper.New(&cfg.BaseURL, appWaitGroup, errorHandler)
 
-	kafkaStream := stream.New(cfg.KafkaServers)
+	var eventBus stream.EventBus
+	switch cfg.EventBus {
+	case stream.EventBusKafka:
+		eventBus, err = stream.New(cfg.KafkaServers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize kafka producer: %w", err)
+		}
+	case stream.EventBusMemory:
+		eventBus = stream.NewMemoryBus()
+	default:
+		return nil, fmt.Errorf("unknown event bus %q, expected %q or %q", cfg.EventBus, stream.EventBusKafka, stream.EventBusMemory)
+	}
 
 	fileUploader := file.New(cfg.FileUploader.CloudName, cfg.FileUploader.ApiKey, cfg.FileUploader.ApiSecret)
 
+	var documentStore file.DocumentStore
+	switch cfg.DocumentStore.Driver {
+	case file.DocumentStoreCloudinary:
+		documentStore = file.NewCloudinaryDocumentStore(cfg.FileUploader.CloudName, cfg.FileUploader.ApiKey, cfg.FileUploader.ApiSecret)
+	case file.DocumentStoreLocal:
+		documentStore, err = file.NewLocalDocumentStore(cfg.DocumentStore.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize document store: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown document store %q, expected %q or %q", cfg.DocumentStore.Driver, file.DocumentStoreCloudinary, file.DocumentStoreLocal)
+	}
+
+	var identities identity.Verifier
+	switch cfg.IdentityVerifier.Driver {
+	case identity.VerifierHTTP:
+		identities = identity.NewHTTPVerifier(cfg.IdentityVerifier.Provider, cfg.IdentityVerifier.BaseURL, cfg.IdentityVerifier.ApiKey, cfg.IdentityVerifier.Timeout)
+	case identity.VerifierFake:
+		var fixtures []byte
+		if cfg.IdentityVerifier.FixturesFile != "" {
+			fixtures, err = os.ReadFile(cfg.IdentityVerifier.FixturesFile)
+		} else {
+			fixtures, err = assets.EmbeddedFiles.ReadFile("fixtures/identities.json")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity fixtures: %w", err)
+		}
+
+		identities, err = identity.NewFakeVerifier(fixtures)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize identity verifier: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown identity verifier %q, expected %q or %q", cfg.IdentityVerifier.Driver, identity.VerifierHTTP, identity.VerifierFake)
+	}
+
 	// cache store
 	redisCache := cache.New(cfg.RedisServer, 0)
 	// defer redisCache.Close()
@@ -101,10 +204,25 @@ func NewApplication(logger *slog.Logger) (*Application, error) {
 		Mailer:       mailer,
 		errorHandler: errorHandler,
 		Helper:       helper,
-		Kafka:        kafkaStream,
+		EventBus:     eventBus,
 		FileUploader: fileUploader,
+		Documents:    documentStore,
+		Identities:   identities,
 		WG:           appWaitGroup,
 	}
 
+	// wallets are put on hold and released from the handlers, the workers and the admin CLI alike
+	app.WalletStatus = walletstatus.New(&walletstatus.Manager{
+		WalletRepo:   repository.NewWalletRepository(db),
+		UserRepo:     repository.NewUserRepository(db),
+		KycRepo:      repository.NewKycRepository(db),
+		ActivityRepo: repository.NewActivityRepository(db),
+
+		SuspendedCreditRepo: repository.NewSuspendedCreditRepository(db),
+
+		Mailer: mailer,
+		Helper: helper,
+	})
+
 	return app, nil
 }
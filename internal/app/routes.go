@@ -5,6 +5,7 @@ import (
 
 	"github.com/cradoe/morenee/internal/handler"
 	"github.com/cradoe/morenee/internal/middleware"
+	"github.com/cradoe/morenee/internal/rbac"
 	"github.com/cradoe/morenee/internal/repository"
 )
 
@@ -26,6 +27,9 @@ func (app *Application) routes() http.Handler {
 	nextOfKinRepo := repository.NewNextOfKinRepository(app.DB)
 	kycRequirementRepo := repository.NewKycRequirementRepository(app.DB)
 	userKycDataRepo := repository.NewUserKycDataRepository(app.DB)
+	outboxRepo := repository.NewOutboxRepository(app.DB)
+	holdRepo := repository.NewWalletHoldRepository(app.DB)
+	deadLetterRepo := repository.NewDeadLetterRepository(app.DB)
 
 	// middleware
 	middlewareRepo := middleware.New(app.errorHandler, app.Logger, userRepo, &app.Config)
@@ -36,6 +40,8 @@ func (app *Application) routes() http.Handler {
 	})
 	mux.HandleFunc("GET /health", routeHandler.HandleHealthCheck)
 
+	// The pending-transfer sweeper's decisions, only staff can see them
+
 	// Auth routes
 	authHandler := handler.NewAuthHandler(&handler.AuthHandler{
 		DB:           app.DB,
@@ -76,13 +82,19 @@ func (app *Application) routes() http.Handler {
 	userKycDataHandler := handler.NewUserKycDataHandler(&handler.UserKycDataHandler{
 		KycRequirementRepo: kycRequirementRepo,
 		UserKycDataRepo:    userKycDataRepo,
+		ActivityRepo:       activityRepo,
 
-		ErrHandler: app.errorHandler,
-		Helper:     app.Helper,
+		ErrHandler:   app.errorHandler,
+		Config:       &app.Config,
+		Helper:       app.Helper,
+		Documents:    app.Documents,
+		Identities:   app.Identities,
+		WalletStatus: app.WalletStatus,
 	})
 	mux.Handle("POST /account/kyc/bvn", middlewareRepo.RequireAuthenticatedUser(http.HandlerFunc(userKycDataHandler.HandleSaveUserBVN)))
 	mux.Handle("POST /account/kyc", middlewareRepo.RequireAuthenticatedUser(http.HandlerFunc(userKycDataHandler.HandleSaveKYCData)))
 	mux.Handle("GET /account/kyc", middlewareRepo.RequireAuthenticatedUser(http.HandlerFunc(userKycDataHandler.HandleGetAllUserKYCData)))
+	mux.Handle("POST /account/kyc/{id}/document", middlewareRepo.RequireAuthenticatedUser(http.HandlerFunc(userKycDataHandler.HandleUploadKYCDocument)))
 
 	// KYC routes
 	kycHandler := handler.NewKycHandler(&handler.KycHandler{
@@ -105,26 +117,103 @@ func (app *Application) routes() http.Handler {
 
 	// Transaction routes
 	transactionHandler := handler.NewTransactionHandler(&handler.TransactionHandler{
-
+		DB:              app.DB,
 		TransactionRepo: transactionRepo,
 		WalletRepo:      walletRepo,
 		ActivityRepo:    activityRepo,
 		KycRepo:         kycRepo,
+		OutboxRepo:      outboxRepo,
+		HoldRepo:        holdRepo,
 
-		ErrHandler: app.errorHandler,
-		Helper:     app.Helper,
-		Kafka:      app.Kafka,
+		ErrHandler:   app.errorHandler,
+		Helper:       app.Helper,
+		Config:       &app.Config,
+		WalletStatus: app.WalletStatus,
 	})
 	mux.Handle("POST /transactions/send-money", middlewareRepo.RequireAuthenticatedUser(http.HandlerFunc(transactionHandler.HandleTransferMoney)))
 	mux.Handle("GET /transactions/{id}", middlewareRepo.RequireAuthenticatedUser(http.HandlerFunc(transactionHandler.HandleTransactionDetails)))
 	mux.Handle("GET /transactions/wallet/{id}/transactions", middlewareRepo.RequireAuthenticatedUser(http.HandlerFunc(transactionHandler.HandleWalletTransactions)))
 
+	// Admin routes
+	// these are for staff, each route needs a permission of the user's role, see internal/rbac
+	adminHandler := handler.NewAdminHandler(&handler.AdminHandler{
+		UserRepo:        userRepo,
+		WalletRepo:      walletRepo,
+		TransactionRepo: transactionRepo,
+		ActivityRepo:    activityRepo,
+		UserKycDataRepo: userKycDataRepo,
+
+		ErrHandler:   app.errorHandler,
+		Config:       &app.Config,
+		WalletStatus: app.WalletStatus,
+	})
+	mux.Handle("GET /admin/users", middlewareRepo.RequirePermission(rbac.PermissionViewUsers, http.HandlerFunc(adminHandler.HandleSearchUsers)))
+	mux.Handle("GET /admin/users/{id}", middlewareRepo.RequirePermission(rbac.PermissionViewUsers, http.HandlerFunc(adminHandler.HandleUserDetails)))
+	mux.Handle("POST /admin/users/{id}/lock", middlewareRepo.RequirePermission(rbac.PermissionLockUsers, http.HandlerFunc(adminHandler.HandleLockUser)))
+	mux.Handle("POST /admin/users/{id}/unlock", middlewareRepo.RequirePermission(rbac.PermissionLockUsers, http.HandlerFunc(adminHandler.HandleUnlockUser)))
+	mux.Handle("PATCH /admin/users/{id}/role", middlewareRepo.RequirePermission(rbac.PermissionManageRoles, http.HandlerFunc(adminHandler.HandleChangeUserRole)))
+	mux.Handle("GET /admin/users/{id}/kyc", middlewareRepo.RequirePermission(rbac.PermissionReviewKYC, http.HandlerFunc(adminHandler.HandleUserKYCData)))
+	mux.Handle("GET /admin/wallets/{id}", middlewareRepo.RequirePermission(rbac.PermissionViewWallets, http.HandlerFunc(adminHandler.HandleWalletDetails)))
+	mux.Handle("GET /admin/wallets/{id}/transactions", middlewareRepo.RequirePermission(rbac.PermissionViewTransactions, http.HandlerFunc(adminHandler.HandleWalletTransactions)))
+	mux.Handle("POST /admin/wallets/{id}/lock", middlewareRepo.RequirePermission(rbac.PermissionLockWallets, http.HandlerFunc(adminHandler.HandleLockWallet)))
+	mux.Handle("POST /admin/wallets/{id}/unlock", middlewareRepo.RequirePermission(rbac.PermissionLockWallets, http.HandlerFunc(adminHandler.HandleUnlockWallet)))
+	mux.Handle("GET /admin/transactions/{id}", middlewareRepo.RequirePermission(rbac.PermissionViewTransactions, http.HandlerFunc(adminHandler.HandleTransactionDetails)))
+
+	kycReviewHandler := handler.NewKycReviewHandler(&handler.KycReviewHandler{
+		UserRepo:        userRepo,
+		UserKycDataRepo: userKycDataRepo,
+		KycRepo:         kycRepo,
+		ActivityRepo:    activityRepo,
+
+		ErrHandler:   app.errorHandler,
+		Config:       &app.Config,
+		Mailer:       app.Mailer,
+		Helper:       app.Helper,
+		Documents:    app.Documents,
+		WalletStatus: app.WalletStatus,
+	})
+	mux.Handle("GET /admin/kyc/submissions", middlewareRepo.RequirePermission(rbac.PermissionReviewKYC, http.HandlerFunc(kycReviewHandler.HandlePendingSubmissions)))
+	mux.Handle("GET /admin/kyc/submissions/{id}", middlewareRepo.RequirePermission(rbac.PermissionReviewKYC, http.HandlerFunc(kycReviewHandler.HandleSubmissionDetails)))
+	mux.Handle("GET /admin/kyc/submissions/{id}/document", middlewareRepo.RequirePermission(rbac.PermissionReviewKYC, http.HandlerFunc(kycReviewHandler.HandleSubmissionDocument)))
+	mux.Handle("POST /admin/kyc/submissions/{id}/approve", middlewareRepo.RequirePermission(rbac.PermissionReviewKYC, http.HandlerFunc(kycReviewHandler.HandleApproveSubmission)))
+	mux.Handle("POST /admin/kyc/submissions/{id}/reject", middlewareRepo.RequirePermission(rbac.PermissionReviewKYC, http.HandlerFunc(kycReviewHandler.HandleRejectSubmission)))
+	mux.Handle("GET /admin/kyc/linked-accounts", middlewareRepo.RequirePermission(rbac.PermissionReviewKYC, http.HandlerFunc(kycReviewHandler.HandleLinkedAccounts)))
+
+	kycAdminHandler := handler.NewKycAdminHandler(&handler.KycAdminHandler{
+		KycRepo:            kycRepo,
+		KycRequirementRepo: kycRequirementRepo,
+		ActivityRepo:       activityRepo,
+
+		ErrHandler: app.errorHandler,
+	})
+	mux.Handle("GET /admin/kyc/levels", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleLevels)))
+	mux.Handle("POST /admin/kyc/levels", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleCreateLevel)))
+	mux.Handle("PATCH /admin/kyc/levels/{id}", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleRenameLevel)))
+	mux.Handle("GET /admin/kyc/levels/{id}/versions", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleLevelVersions)))
+	mux.Handle("POST /admin/kyc/levels/{id}/versions", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleAddLevelVersion)))
+	mux.Handle("GET /admin/kyc/levels/{id}/requirements", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleLevelRequirements)))
+	mux.Handle("POST /admin/kyc/levels/{id}/requirements", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleAddRequirement)))
+	mux.Handle("PATCH /admin/kyc/requirements/{id}", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleUpdateRequirement)))
+	mux.Handle("POST /admin/kyc/requirements/{id}/retire", middlewareRepo.RequirePermission(rbac.PermissionManageKYC, http.HandlerFunc(kycAdminHandler.HandleRetireRequirement)))
+
+	deadLetterHandler := handler.NewDeadLetterHandler(&handler.DeadLetterHandler{
+		DB:             app.DB,
+		DeadLetterRepo: deadLetterRepo,
+		OutboxRepo:     outboxRepo,
+		ActivityRepo:   activityRepo,
+
+		ErrHandler: app.errorHandler,
+	})
+	mux.Handle("GET /admin/dead-letters", middlewareRepo.RequirePermission(rbac.PermissionManageDeadLetter, http.HandlerFunc(deadLetterHandler.HandleDeadLetters)))
+	mux.Handle("GET /admin/dead-letters/{id}", middlewareRepo.RequirePermission(rbac.PermissionManageDeadLetter, http.HandlerFunc(deadLetterHandler.HandleDeadLetterDetails)))
+	mux.Handle("POST /admin/dead-letters/{id}/replay", middlewareRepo.RequirePermission(rbac.PermissionManageDeadLetter, http.HandlerFunc(deadLetterHandler.HandleReplayDeadLetter)))
+
 	// utility routes
 	utilityHandler := handler.NewUtilityHandler(&handler.UtilityHandler{
 		FileUploader: app.FileUploader,
 		ErrHandler:   app.errorHandler,
 	})
-	mux.HandleFunc("POST /utility/upload-file", utilityHandler.HandleUploadFile)
+	mux.Handle("POST /utility/upload-file", middlewareRepo.RequireAuthenticatedUser(http.HandlerFunc(utilityHandler.HandleUploadFile)))
 
 	// we need to handle all other routes that are not defined in the mux.
 	// This is when user tries to access a route that does not exist
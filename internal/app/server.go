@@ -10,6 +10,9 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/cradoe/morenee/internal/handler"
+	"github.com/cradoe/morenee/internal/worker"
 )
 
 const (
@@ -60,3 +63,52 @@ func (app *Application) ServeHTTP() error {
 	app.WG.Wait()
 	return nil
 }
+
+// ServeWorkerMetrics serves the health and the metrics of the workers of this process on the internal address
+// Config.Worker.MetricsAddr until ctx is cancelled. It is meant for the process running the workers on their own,
+// the counters of a worker are only kept by the process it runs in. Nothing on it is authenticated,
+// so the address must not be reachable from outside.
+func (app *Application) ServeWorkerMetrics(ctx context.Context) error {
+	mux := http.NewServeMux()
+
+	routeHandler := handler.NewRouteHandler(&handler.RouteHandler{
+		ErrHandler: app.errorHandler,
+	})
+	mux.HandleFunc("GET /health", routeHandler.HandleHealthCheck)
+	mux.Handle("GET /metrics", worker.MetricsHandler())
+
+	srv := &http.Server{
+		Addr:         app.Config.Worker.MetricsAddr,
+		Handler:      mux,
+		ErrorLog:     slog.NewLogLogger(app.Logger.Handler(), slog.LevelWarn),
+		IdleTimeout:  defaultIdleTimeout,
+		ReadTimeout:  defaultReadTimeout,
+		WriteTimeout: defaultWriteTimeout,
+	}
+
+	shutdownErrorChan := make(chan error)
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownPeriod)
+		defer cancel()
+
+		shutdownErrorChan <- srv.Shutdown(shutdownCtx)
+	}()
+
+	app.Logger.Info("starting metrics server", slog.Group("server", "addr", srv.Addr))
+
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownErrorChan
+	if err != nil {
+		return err
+	}
+
+	app.Logger.Info("stopped metrics server", slog.Group("server", "addr", srv.Addr))
+	return nil
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/stream"
+	"github.com/cradoe/morenee/internal/worker"
+)
+
+// EnsureTopics creates the topics the workers need on the event bus.
+// This step is important to avoid runtime errors or message loss due to missing topics.
+func (app *Application) EnsureTopics() error {
+	topics := []stream.Topic{}
+	for _, topic := range worker.Topics() {
+		topics = append(topics, stream.Topic{Name: topic, Partitions: app.Config.KafkaPartitions})
+	}
+
+	return app.EventBus.EnsureTopicsExist(topics)
+}
+
+// NewWorker gives the workers the application's database, event bus and mailer, they stop when ctx is cancelled
+func (app *Application) NewWorker(ctx context.Context) *worker.Worker {
+	return worker.New(&worker.Worker{
+		UserRepo:        repository.NewUserRepository(app.DB),
+		TransactionRepo: repository.NewTransactionRepository(app.DB),
+		WalletRepo:      repository.NewWalletRepository(app.DB),
+		KycRepo:         repository.NewKycRepository(app.DB),
+		ActivityRepo:    repository.NewActivityRepository(app.DB),
+		OutboxRepo:      repository.NewOutboxRepository(app.DB),
+		ProcessedRepo:   repository.NewProcessedMessageRepository(app.DB),
+		HoldRepo:        repository.NewWalletHoldRepository(app.DB),
+		LedgerRepo:      repository.NewLedgerRepository(app.DB),
+		DeadLetterRepo:  repository.NewDeadLetterRepository(app.DB),
+
+		DB:       app.DB,
+		EventBus: app.EventBus,
+		Ctx:      ctx,
+		Helper:   app.Helper,
+		Mailer:   app.Mailer,
+
+		WalletStatus: app.WalletStatus,
+
+		Concurrency: app.Config.Worker.Concurrency,
+	})
+}
+
+// RunWorkers starts the named workers (all of them when names is empty) until ctx is cancelled.
+// The returned wait group is done once every worker has stopped, consumers drain the messages they hold first.
+func (app *Application) RunWorkers(ctx context.Context, names []string) (*sync.WaitGroup, error) {
+	wk := app.NewWorker(ctx)
+
+	runners, err := wk.SelectRunners(names)
+	if err != nil {
+		return nil, err
+	}
+
+	var workers sync.WaitGroup
+	for name, run := range runners {
+		app.Logger.Info("starting worker", slog.String("worker", name))
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run()
+		}()
+	}
+
+	return &workers, nil
+}
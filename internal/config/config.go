@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type Config struct {
 	BaseURL  string
 	HttpPort int
@@ -26,5 +28,51 @@ type Config struct {
 		ApiKey    string
 		ApiSecret string
 	}
+	// DocumentStore keeps the documents users send, see file.DocumentStore
+	DocumentStore struct {
+		// Driver is either "cloudinary" or "local", local keeps them in Dir and is meant for development
+		Driver string
+		Dir    string
+	}
+	// IdentityVerifier checks BVNs and NINs with an identity provider, see identity.Verifier
+	IdentityVerifier struct {
+		// Driver is either "http" or "fake", fake looks identities up in fixture data and is meant for development
+		Driver string
+		// Provider is the name checks with the HTTP provider are recorded with
+		Provider string
+		BaseURL  string
+		ApiKey   string
+		Timeout  time.Duration
+		// FixturesFile is the fixture data of the fake provider, the built-in fixtures are used when it is empty
+		FixturesFile string
+		// Submissions scoring AutoVerifyScore (0 to 100) or more are verified without a review,
+		// those scoring less than ReviewScore are flagged as a mismatch for staff
+		AutoVerifyScore int
+		ReviewScore     int
+	}
+	// IdentityFingerprints find identity numbers used by several accounts, see kyc.Fingerprint
+	IdentityFingerprints struct {
+		// Key keys the fingerprints, after changing it existing data must be fingerprinted again (morenee-admin fingerprint-kyc -all)
+		Key string
+		// OnDuplicate is either "block", which refuses a number another account uses,
+		// or "review", which keeps it flagged for compliance staff
+		OnDuplicate string
+	}
 	KafkaServers string
+	// KafkaPartitions is the number of partitions of the topics we create
+	KafkaPartitions int
+	// EventBus is either "kafka" or "memory", the in-memory bus runs the workers without a broker
+	EventBus string
+	Worker   struct {
+		// Concurrency is the number of messages each consumer processes at the same time
+		Concurrency int
+		// MetricsAddr is the internal address cmd/worker serves its health and metrics on, nothing is served when it is empty
+		MetricsAddr string
+	}
+	// BusinessTimezone decides where a day, week or month starts,
+	// for transfer limit windows and for the date filters on transaction history
+	BusinessTimezone struct {
+		Name     string
+		Location *time.Location
+	}
 }
@@ -164,3 +164,14 @@ func (e *ErrorHandler) AuthenticationRequired(w http.ResponseWriter, r *http.Req
 		headers: nil,
 	})
 }
+
+func (e *ErrorHandler) NotPermitted(w http.ResponseWriter, r *http.Request) {
+	message := "You do not have permission to access this resource"
+	e.ErrorMessage(&Error{
+		w:       w,
+		r:       r,
+		status:  http.StatusForbidden,
+		message: message,
+		headers: nil,
+	})
+}
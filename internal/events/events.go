@@ -0,0 +1,135 @@
+// Events are what the parts of the application tell each other through the event bus.
+// Every event travels in an Envelope, which says what the event is (its type and schema version),
+// when it happened, and which flow it belongs to (the correlation ID), next to the payload itself.
+// ...
+// A payload's schema can change over time. When it does, its schema version is bumped,
+// and an upcaster is registered to turn the previous version into the new one,
+// so events written by an older release (still sitting in the outbox or on a topic) can still be read.
+// Decode always returns events at their current schema version.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnknownEventType   = errors.New("unknown event type")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+)
+
+// Envelope is an event as it is published, its payload is read with DecodePayload
+type Envelope struct {
+	EventID       string          `json:"event_id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	CorrelationID string          `json:"correlation_id"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Upcaster turns the payload of an event at one schema version into the next version
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+// schemas holds the current schema version of every event type,
+// and upcasters the upcasters of each type by the version they upgrade from
+var (
+	schemas   = map[string]int{}
+	upcasters = map[string]map[int]Upcaster{}
+)
+
+// register declares an event type and its current schema version
+func register(eventType string, version int) {
+	schemas[eventType] = version
+	upcasters[eventType] = map[int]Upcaster{}
+}
+
+// registerUpcaster adds the upcaster from fromVersion to fromVersion+1 of an event type
+func registerUpcaster(eventType string, fromVersion int, upcaster Upcaster) {
+	upcasters[eventType][fromVersion] = upcaster
+}
+
+// New wraps a payload in an envelope, at the current schema version of its type
+func New(eventType string, correlationID string, payload any) (*Envelope, error) {
+	version, ok := schemas[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEventType, eventType)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		EventID:       uuid.NewString(),
+		Type:          eventType,
+		SchemaVersion: version,
+		OccurredAt:    time.Now(),
+		CorrelationID: correlationID,
+		Payload:       data,
+	}, nil
+}
+
+// Decode reads an event and upcasts it to the current schema version of its type.
+// Messages written before events had envelopes are read as version 0 of legacyType.
+func Decode(data []byte, legacyType string) (*Envelope, error) {
+	var envelope Envelope
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	if envelope.Type == "" && envelope.SchemaVersion == 0 {
+		envelope = Envelope{
+			Type:          legacyType,
+			SchemaVersion: 0,
+			Payload:       data,
+		}
+	}
+
+	err = upcast(&envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelope, nil
+}
+
+// DecodePayload reads the payload of an event into v
+func (e *Envelope) DecodePayload(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+func upcast(envelope *Envelope) error {
+	version, ok := schemas[envelope.Type]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEventType, envelope.Type)
+	}
+
+	// an event from a newer release can't be read safely
+	if envelope.SchemaVersion > version {
+		return fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, envelope.Type, envelope.SchemaVersion)
+	}
+
+	for envelope.SchemaVersion < version {
+		upcaster, ok := upcasters[envelope.Type][envelope.SchemaVersion]
+		if !ok {
+			return fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, envelope.Type, envelope.SchemaVersion)
+		}
+
+		payload, err := upcaster(envelope.Payload)
+		if err != nil {
+			return fmt.Errorf("upcasting %s from version %d: %w", envelope.Type, envelope.SchemaVersion, err)
+		}
+
+		envelope.Payload = payload
+		envelope.SchemaVersion++
+	}
+
+	return nil
+}
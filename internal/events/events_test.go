@@ -0,0 +1,165 @@
+package events_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cradoe/morenee/internal/events"
+	"github.com/cradoe/morenee/internal/handler"
+	"github.com/cradoe/morenee/internal/models"
+)
+
+func TestDecodeUpcastsLegacyTransfer(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	// before events had envelopes, the transaction was published as the API returned it
+	legacy, err := json.Marshal(&handler.TransactionResponseData{
+		ID:              "transaction",
+		ReferenceNumber: "MRN-123",
+		Amount:          models.NewMoney(150050, ""),
+		Description:     "rent",
+		Status:          "pending",
+		CreatedAt:       createdAt,
+		Sender: handler.MiniUserWithWallet{
+			ID:        "sender",
+			FirstName: "Ada",
+			LastName:  "Obi",
+			Wallet:    handler.WalletMiniData{ID: "sender-wallet", AccountNumber: "0123456789", BankName: models.BankName},
+		},
+		Recipient: handler.MiniUserWithWallet{
+			ID:        "recipient",
+			FirstName: "Tunde",
+			LastName:  "Bello",
+			Wallet:    handler.WalletMiniData{ID: "recipient-wallet", AccountNumber: "9876543210", BankName: models.BankName},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, eventType := range []string{events.TypeTransferInitiated, events.TypeTransferDebited, events.TypeTransferCredited} {
+		t.Run(eventType, func(t *testing.T) {
+			envelope, err := events.Decode(legacy, eventType)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if envelope.Type != eventType || envelope.SchemaVersion != 1 {
+				t.Errorf("Decode() = %s version %d, want %s version 1", envelope.Type, envelope.SchemaVersion, eventType)
+			}
+
+			var transfer events.Transfer
+			err = envelope.DecodePayload(&transfer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := events.Transfer{
+				TransactionID:   "transaction",
+				ReferenceNumber: "MRN-123",
+				Amount:          models.NewMoney(150050, ""),
+				Description:     "rent",
+				InitiatedAt:     createdAt,
+				Sender: events.Party{
+					UserID: "sender", FirstName: "Ada", LastName: "Obi",
+					WalletID: "sender-wallet", AccountNumber: "0123456789", BankName: models.BankName,
+				},
+				Recipient: events.Party{
+					UserID: "recipient", FirstName: "Tunde", LastName: "Bello",
+					WalletID: "recipient-wallet", AccountNumber: "9876543210", BankName: models.BankName,
+				},
+			}
+			if !transfer.InitiatedAt.Equal(want.InitiatedAt) {
+				t.Errorf("InitiatedAt = %v, want %v", transfer.InitiatedAt, want.InitiatedAt)
+			}
+			transfer.InitiatedAt = want.InitiatedAt
+			if transfer != want {
+				t.Errorf("DecodePayload() = %+v, want %+v", transfer, want)
+			}
+		})
+	}
+}
+
+func TestDecodeCurrentEnvelope(t *testing.T) {
+	transfer := events.Transfer{TransactionID: "transaction", Amount: models.NewMoney(500, "")}
+	debitedAt := time.Date(2024, 3, 1, 9, 31, 0, 0, time.UTC)
+
+	envelope, err := events.New(events.TypeTransferDebited, "transaction", &events.TransferDebited{Transfer: transfer, DebitedAt: debitedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the legacy type only applies to messages without an envelope
+	decoded, err := events.Decode(data, events.TypeTransferInitiated)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if decoded.EventID != envelope.EventID || decoded.Type != events.TypeTransferDebited || decoded.SchemaVersion != 1 || decoded.CorrelationID != "transaction" {
+		t.Errorf("Decode() = %+v, want %+v", decoded, envelope)
+	}
+
+	var payload events.TransferDebited
+	err = decoded.DecodePayload(&payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.TransactionID != "transaction" || payload.Amount != transfer.Amount || !payload.DebitedAt.Equal(debitedAt) {
+		t.Errorf("DecodePayload() = %+v", payload)
+	}
+}
+
+func TestDecodeRejectsUnknownEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		legacy  string
+		wantErr error
+	}{
+		{
+			name:    "unknown type",
+			data:    `{"event_id": "e", "type": "transfer.teleported", "schema_version": 1, "payload": {}}`,
+			legacy:  events.TypeTransferInitiated,
+			wantErr: events.ErrUnknownEventType,
+		},
+		{
+			name:    "version from a newer release",
+			data:    `{"event_id": "e", "type": "transfer.debited", "schema_version": 2, "payload": {}}`,
+			legacy:  events.TypeTransferInitiated,
+			wantErr: events.ErrUnsupportedVersion,
+		},
+		{
+			name:    "legacy message of a type that never had one",
+			data:    `{"id": "transaction"}`,
+			legacy:  events.TypeTransferCompleted,
+			wantErr: events.ErrUnsupportedVersion,
+		},
+		{
+			name:    "legacy message of an unknown type",
+			data:    `{"id": "transaction"}`,
+			legacy:  "transfer.teleported",
+			wantErr: events.ErrUnknownEventType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := events.Decode([]byte(tt.data), tt.legacy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err := events.New("transfer.teleported", "transaction", struct{}{})
+	if !errors.Is(err, events.ErrUnknownEventType) {
+		t.Errorf("New() error = %v, want %v", err, events.ErrUnknownEventType)
+	}
+}
@@ -0,0 +1,178 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/cradoe/morenee/internal/models"
+)
+
+const (
+	// TypeTransferInitiated is published when a transfer has been created and its funds are held, the sender is debited next
+	TypeTransferInitiated = "transfer.initiated"
+
+	// TypeTransferDebited is published when the sender has been debited, the recipient is credited next
+	TypeTransferDebited = "transfer.debited"
+
+	// TypeTransferCredited is published when the recipient has been credited, the transfer is completed next
+	TypeTransferCredited = "transfer.credited"
+
+	// TypeTransferCompleted is published when a transfer went through
+	TypeTransferCompleted = "transfer.completed"
+
+	// TypeTransferFailed is published when a transfer was given up on before the sender was debited
+	TypeTransferFailed = "transfer.failed"
+
+	// TypeTransferReversed is published when the money of a transfer went back to the sender
+	TypeTransferReversed = "transfer.reversed"
+)
+
+func init() {
+	register(TypeTransferInitiated, 1)
+	register(TypeTransferDebited, 1)
+	register(TypeTransferCredited, 1)
+	register(TypeTransferCompleted, 1)
+	register(TypeTransferFailed, 1)
+	register(TypeTransferReversed, 1)
+
+	// version 0 is the transaction as the API returned it, which is what was published before events had envelopes
+	registerUpcaster(TypeTransferInitiated, 0, upcastLegacyTransfer)
+	registerUpcaster(TypeTransferDebited, 0, upcastLegacyTransfer)
+	registerUpcaster(TypeTransferCredited, 0, upcastLegacyTransfer)
+}
+
+// Party is one side of a transfer
+type Party struct {
+	UserID        string `json:"user_id"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	WalletID      string `json:"wallet_id"`
+	AccountNumber string `json:"account_number"`
+	BankName      string `json:"bank_name"`
+}
+
+// Transfer is what every step of a transfer needs to know about it
+type Transfer struct {
+	TransactionID   string       `json:"transaction_id"`
+	ReferenceNumber string       `json:"reference_number"`
+	Amount          models.Money `json:"amount"`
+	Description     string       `json:"description"`
+	Sender          Party        `json:"sender"`
+	Recipient       Party        `json:"recipient"`
+	InitiatedAt     time.Time    `json:"initiated_at"`
+}
+
+// TransferInitiated is the payload of TypeTransferInitiated
+type TransferInitiated struct {
+	Transfer
+}
+
+// TransferDebited is the payload of TypeTransferDebited
+type TransferDebited struct {
+	Transfer
+	DebitedAt time.Time `json:"debited_at"`
+}
+
+// TransferCredited is the payload of TypeTransferCredited
+type TransferCredited struct {
+	Transfer
+	CreditedAt time.Time `json:"credited_at"`
+}
+
+// TransferCompleted is the payload of TypeTransferCompleted
+type TransferCompleted struct {
+	TransactionID string    `json:"transaction_id"`
+	CompletedAt   time.Time `json:"completed_at"`
+}
+
+// TransferFailed is the payload of TypeTransferFailed
+type TransferFailed struct {
+	TransactionID string    `json:"transaction_id"`
+	Reason        string    `json:"reason"`
+	FailedAt      time.Time `json:"failed_at"`
+}
+
+// TransferReversed is the payload of TypeTransferReversed, ReversalTransactionID is the transaction that gave the money back
+type TransferReversed struct {
+	TransactionID         string    `json:"transaction_id"`
+	ReversalTransactionID string    `json:"reversal_transaction_id"`
+	ReversedAt            time.Time `json:"reversed_at"`
+}
+
+// TransferFromDetails builds the transfer of a stored transaction
+func TransferFromDetails(transaction *models.TransactionDetails) *Transfer {
+	return &Transfer{
+		TransactionID:   transaction.ID,
+		ReferenceNumber: transaction.ReferenceNumber,
+		Amount:          transaction.Amount,
+		Description:     transaction.Description,
+		InitiatedAt:     transaction.CreatedAt.Time,
+		Sender: Party{
+			UserID:        transaction.SenderID,
+			FirstName:     transaction.SenderFirstName,
+			LastName:      transaction.SenderLastName,
+			WalletID:      transaction.SenderWalletID,
+			AccountNumber: transaction.SenderAccount,
+			BankName:      models.BankName,
+		},
+		Recipient: Party{
+			UserID:        transaction.RecipientID,
+			FirstName:     transaction.RecipientFirstName,
+			LastName:      transaction.RecipientLastName,
+			WalletID:      transaction.RecipientWalletID,
+			AccountNumber: transaction.RecipientAccount,
+			BankName:      models.BankName,
+		},
+	}
+}
+
+type legacyParty struct {
+	ID        string `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Wallet    struct {
+		ID            string `json:"id"`
+		AccountNumber string `json:"account_number"`
+		BankName      string `json:"bank_name"`
+	} `json:"wallet"`
+}
+
+type legacyTransfer struct {
+	ID              string       `json:"id"`
+	ReferenceNumber string       `json:"reference_number"`
+	Amount          models.Money `json:"amount"`
+	Description     string       `json:"description"`
+	CreatedAt       time.Time    `json:"created_at"`
+	Sender          legacyParty  `json:"sender"`
+	Recipient       legacyParty  `json:"recipient"`
+}
+
+func (p legacyParty) party() Party {
+	return Party{
+		UserID:        p.ID,
+		FirstName:     p.FirstName,
+		LastName:      p.LastName,
+		WalletID:      p.Wallet.ID,
+		AccountNumber: p.Wallet.AccountNumber,
+		BankName:      p.Wallet.BankName,
+	}
+}
+
+// upcastLegacyTransfer turns a transaction, as the API returned it, into the first version of a transfer event
+func upcastLegacyTransfer(payload json.RawMessage) (json.RawMessage, error) {
+	var legacy legacyTransfer
+	err := json.Unmarshal(payload, &legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&Transfer{
+		TransactionID:   legacy.ID,
+		ReferenceNumber: legacy.ReferenceNumber,
+		Amount:          legacy.Amount,
+		Description:     legacy.Description,
+		InitiatedAt:     legacy.CreatedAt,
+		Sender:          legacy.Sender.party(),
+		Recipient:       legacy.Recipient.party(),
+	})
+}
@@ -0,0 +1,225 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+const (
+	// DocumentStoreCloudinary selects the Cloudinary document store
+	DocumentStoreCloudinary = "cloudinary"
+
+	// DocumentStoreLocal selects the document store on the local disk
+	DocumentStoreLocal = "local"
+)
+
+var ErrDocumentNotFound = errors.New("document not found")
+
+// DocumentStore keeps the documents users send us, such as KYC documents.
+// Unlike FileUploader, which gives back public URLs, documents are private:
+// nothing links to them, they are only read through Open, by routes that check who is asking.
+type DocumentStore interface {
+	// Save stores content under key, a path such as "kyc/<user id>/<document id>.pdf"
+	Save(ctx context.Context, key string, content io.Reader, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// CloudinaryDocumentStore keeps documents as private Cloudinary assets,
+// which can only be downloaded with a signed URL that expires shortly after we make it.
+type CloudinaryDocumentStore struct {
+	cloud_name string
+	api_key    string
+	api_secret string
+	client     *http.Client
+}
+
+func NewCloudinaryDocumentStore(cloud_name, api_key, api_secret string) *CloudinaryDocumentStore {
+	return &CloudinaryDocumentStore{
+		cloud_name: cloud_name,
+		api_key:    api_key,
+		api_secret: api_secret,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *CloudinaryDocumentStore) Save(ctx context.Context, key string, content io.Reader, contentType string) error {
+	cld, err := cloudinary.NewFromParams(s.cloud_name, s.api_key, s.api_secret)
+	if err != nil {
+		return err
+	}
+
+	overwrite := false
+	// raw assets keep their extension in their public ID and are never transformed
+	res, err := cld.Upload.Upload(ctx, content, uploader.UploadParams{
+		PublicID:     key,
+		ResourceType: string(api.File),
+		Type:         api.Private,
+		Overwrite:    &overwrite,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the SDK reports errors returned by the API in the result, not as an error
+	if res.Error.Message != "" {
+		return fmt.Errorf("uploading document: %s", res.Error.Message)
+	}
+
+	return nil
+}
+
+func (s *CloudinaryDocumentStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	cld, err := cloudinary.NewFromParams(s.cloud_name, s.api_key, s.api_secret)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(time.Minute)
+	downloadURL, err := cld.Upload.PrivateDownloadURL(uploader.PrivateDownloadURLParams{
+		PublicID:     key,
+		DeliveryType: api.Private,
+		ResourceType: api.File,
+		ExpiresAt:    &expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return nil, ErrDocumentNotFound
+	case res.StatusCode != http.StatusOK:
+		res.Body.Close()
+		return nil, fmt.Errorf("downloading document: unexpected status %s", res.Status)
+	}
+
+	return res.Body, nil
+}
+
+func (s *CloudinaryDocumentStore) Delete(ctx context.Context, key string) error {
+	cld, err := cloudinary.NewFromParams(s.cloud_name, s.api_key, s.api_secret)
+	if err != nil {
+		return err
+	}
+
+	res, err := cld.Upload.Destroy(ctx, uploader.DestroyParams{
+		PublicID:     key,
+		Type:         api.Private,
+		ResourceType: string(api.File),
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.Error.Message != "" {
+		return fmt.Errorf("deleting document: %s", res.Error.Message)
+	}
+
+	// deleting a document that is already gone is not a failure
+	if res.Result != "ok" && res.Result != "not found" {
+		return fmt.Errorf("deleting document: unexpected result %q", res.Result)
+	}
+
+	return nil
+}
+
+// LocalDocumentStore keeps documents in a directory of the local disk, it is meant for development.
+// Files can only be read by the user running the application.
+type LocalDocumentStore struct {
+	dir string
+}
+
+func NewLocalDocumentStore(dir string) (*LocalDocumentStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalDocumentStore{dir: dir}, nil
+}
+
+func (s *LocalDocumentStore) Save(ctx context.Context, key string, content io.Reader, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, content)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *LocalDocumentStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrDocumentNotFound
+	}
+
+	return f, err
+}
+
+func (s *LocalDocumentStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path is where the document with key is kept, keys can't point outside of the store's directory
+func (s *LocalDocumentStore) path(key string) (string, error) {
+	if !filepath.IsLocal(key) || strings.Contains(key, `\`) {
+		return "", fmt.Errorf("invalid document key %q", key)
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
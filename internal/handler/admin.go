@@ -0,0 +1,525 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cradoe/morenee/internal/config"
+	"github.com/cradoe/morenee/internal/context"
+	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/rbac"
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/request"
+	"github.com/cradoe/morenee/internal/response"
+	"github.com/cradoe/morenee/internal/validator"
+	"github.com/cradoe/morenee/internal/walletstatus"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrCannotActOnSelf = errors.New("staff can't take this action on their own account")
+)
+
+// AdminUserResponseData is a user as staff see them, with their status and role
+type AdminUserResponseData struct {
+	UserResponseData
+	Status string `json:"status"`
+	Role   string `json:"role"`
+}
+
+type AdminUserDetailsResponseData struct {
+	AdminUserResponseData
+	Wallets []*WalletResponseData `json:"wallets"`
+}
+
+// AdminHandler serves the routes staff use to look after customers, across users.
+// Every route is behind a permission (see internal/rbac), and every action is written to the activity log
+// with the ID of the member of staff who took it, and their reason.
+type AdminHandler struct {
+	UserRepo        repository.UserRepository
+	WalletRepo      repository.WalletRepository
+	TransactionRepo repository.TransactionRepository
+	ActivityRepo    repository.ActivityRepository
+	UserKycDataRepo repository.UserKycDataRepository
+
+	ErrHandler   *errHandler.ErrorHandler
+	Config       *config.Config
+	WalletStatus *walletstatus.Manager
+}
+
+func NewAdminHandler(handler *AdminHandler) *AdminHandler {
+	return &AdminHandler{
+		UserRepo:        handler.UserRepo,
+		WalletRepo:      handler.WalletRepo,
+		TransactionRepo: handler.TransactionRepo,
+		ActivityRepo:    handler.ActivityRepo,
+		UserKycDataRepo: handler.UserKycDataRepo,
+		ErrHandler:      handler.ErrHandler,
+		Config:          handler.Config,
+		WalletStatus:    handler.WalletStatus,
+	}
+}
+
+func (h *AdminHandler) HandleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	queryValues := retrieveUrlQueryValues(r, h.Config.BusinessTimezone.Location)
+
+	users, err := h.UserRepo.Search(queryValues.Search, queryValues.Limit, queryValues.Offset)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := make([]*AdminUserResponseData, len(users))
+	for i, user := range users {
+		data[i] = formAdminUserResponseData(&user)
+	}
+
+	message := "Users retrieved successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *AdminHandler) HandleUserDetails(w http.ResponseWriter, r *http.Request) {
+	user, found := h.findUser(w, r)
+	if !found {
+		return
+	}
+
+	wallets, _, err := h.WalletRepo.GetAllByUserId(user.ID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := &AdminUserDetailsResponseData{
+		AdminUserResponseData: *formAdminUserResponseData(user),
+		Wallets:               make([]*WalletResponseData, len(wallets)),
+	}
+	for i, wallet := range wallets {
+		data.Wallets[i] = formWalletResponseData(&wallet)
+	}
+
+	message := "User details fetched successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *AdminHandler) HandleLockUser(w http.ResponseWriter, r *http.Request) {
+	reason, ok := readReason(w, r, h.ErrHandler)
+	if !ok {
+		return
+	}
+
+	user, found := h.findUser(w, r)
+	if !found {
+		return
+	}
+
+	if user.ID == context.ContextGetAuthenticatedUser(r).ID {
+		h.ErrHandler.BadRequest(w, r, ErrCannotActOnSelf)
+		return
+	}
+
+	if user.Status == repository.UserAccountLockedStatus {
+		response.JSONErrorResponse(w, nil, "Account is already locked", http.StatusConflict, nil)
+		return
+	}
+
+	err := h.UserRepo.Lock(user.ID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	err = h.audit(r, user.ID, repository.ActivityLogUserEntity, user.ID, repository.AdminActivityLogUserLockedDescription, reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "Account locked successfully"
+	err = response.JSONOkResponse(w, nil, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *AdminHandler) HandleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	reason, ok := readReason(w, r, h.ErrHandler)
+	if !ok {
+		return
+	}
+
+	user, found := h.findUser(w, r)
+	if !found {
+		return
+	}
+
+	unlocked, err := h.UserRepo.Unlock(user.ID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !unlocked {
+		response.JSONErrorResponse(w, nil, "Account is not locked", http.StatusConflict, nil)
+		return
+	}
+
+	// the log entry also ends the user's streak of failed logins, so the next failed login doesn't lock them again
+	err = h.audit(r, user.ID, repository.ActivityLogUserEntity, user.ID, repository.AdminActivityLogUserUnlockedDescription, reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "Account unlocked successfully"
+	err = response.JSONOkResponse(w, nil, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *AdminHandler) HandleChangeUserRole(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Role      string              `json:"role"`
+		Reason    string              `json:"reason"`
+		Validator validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, err)
+		return
+	}
+
+	input.Validator.Check(rbac.IsRole(input.Role), fmt.Sprintf("Role must be one of %v", rbac.Roles()))
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
+		return
+	}
+
+	user, found := h.findUser(w, r)
+	if !found {
+		return
+	}
+
+	// an admin can't take their own rights away, someone always keeps them
+	if user.ID == context.ContextGetAuthenticatedUser(r).ID {
+		h.ErrHandler.BadRequest(w, r, ErrCannotActOnSelf)
+		return
+	}
+
+	err = h.UserRepo.SetRole(user.ID, input.Role)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	err = h.audit(r, user.ID, repository.ActivityLogUserEntity, user.ID, repository.AdminActivityLogRoleChangedDescription+input.Role, input.Reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "Role changed successfully"
+	err = response.JSONOkResponse(w, nil, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *AdminHandler) HandleUserKYCData(w http.ResponseWriter, r *http.Request) {
+	user, found := h.findUser(w, r)
+	if !found {
+		return
+	}
+
+	kycDataList, err := h.UserKycDataRepo.GetAll(user.ID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := make([]*UserKYCDataResponse, len(kycDataList))
+	for i, kycData := range kycDataList {
+		data[i] = formUserKYCDataResponse(&kycData)
+	}
+
+	message := "KYC data retrieved successfully."
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *AdminHandler) HandleWalletDetails(w http.ResponseWriter, r *http.Request) {
+	wallet, found := h.findWallet(w, r)
+	if !found {
+		return
+	}
+
+	message := "Wallet details fetched successfully"
+	err := response.JSONOkResponse(w, formWalletResponseData(wallet), message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *AdminHandler) HandleWalletTransactions(w http.ResponseWriter, r *http.Request) {
+	wallet, found := h.findWallet(w, r)
+	if !found {
+		return
+	}
+
+	filterOptions := retrieveUrlQueryValues(r, h.Config.BusinessTimezone.Location)
+
+	transactions, _, err := h.TransactionRepo.FindAllByWalletId(wallet.ID, &repository.FilterTransactionsOptions{
+		StartDate:   filterOptions.StartDate,
+		EndDate:     filterOptions.EndDate,
+		SearchQuery: filterOptions.Search,
+		Limit:       filterOptions.Limit,
+		Offset:      filterOptions.Offset,
+	})
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := make([]*TransactionResponseData, len(transactions))
+	for i, t := range transactions {
+		data[i] = formTransactionResponseData(t)
+	}
+
+	message := "Transactions fetched successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleLockWallet puts a wallet on hold, status_reason says why: security, compliance or user-requested.
+// A wallet on hold for going over its balance limit can be held again for one of these, it is then no longer released automatically.
+func (h *AdminHandler) HandleLockWallet(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		StatusReason string              `json:"status_reason"`
+		Reason       string              `json:"reason"`
+		Validator    validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, err)
+		return
+	}
+
+	input.Validator.Check(validator.In(input.StatusReason, repository.WalletStaffReasons()...), fmt.Sprintf("Status reason must be one of %v", repository.WalletStaffReasons()))
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
+		return
+	}
+
+	wallet, found := h.findWallet(w, r)
+	if !found {
+		return
+	}
+
+	locked, err := h.WalletStatus.Lock(wallet, input.StatusReason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !locked {
+		response.JSONErrorResponse(w, nil, "Wallet is already on hold", http.StatusConflict, nil)
+		return
+	}
+
+	err = h.audit(r, wallet.UserID, repository.ActivityLogWalletEntity, wallet.ID, repository.AdminActivityLogWalletLockedDescription+input.StatusReason, input.Reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "Wallet locked successfully"
+	err = response.JSONOkResponse(w, nil, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleUnlockWallet puts a wallet on hold back to active, whatever the reason of the hold
+func (h *AdminHandler) HandleUnlockWallet(w http.ResponseWriter, r *http.Request) {
+	reason, ok := readReason(w, r, h.ErrHandler)
+	if !ok {
+		return
+	}
+
+	wallet, found := h.findWallet(w, r)
+	if !found {
+		return
+	}
+
+	unlocked, err := h.WalletStatus.Unlock(wallet, "")
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !unlocked {
+		response.JSONErrorResponse(w, nil, "Wallet is not on hold", http.StatusConflict, nil)
+		return
+	}
+
+	err = h.audit(r, wallet.UserID, repository.ActivityLogWalletEntity, wallet.ID, repository.AdminActivityLogWalletUnlockedDescription, reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "Wallet unlocked successfully"
+	err = response.JSONOkResponse(w, nil, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *AdminHandler) HandleTransactionDetails(w http.ResponseWriter, r *http.Request) {
+	transactionID := r.PathValue("id")
+	if _, err := uuid.Parse(transactionID); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+
+	transaction, found, err := h.TransactionRepo.GetOne(transactionID, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+
+	message := "Transaction details fetched successfully"
+	err = response.JSONOkResponse(w, formTransactionResponseData(transaction), message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// readReason reads the reason staff give for an action, it writes the error response when there is none
+func readReason(w http.ResponseWriter, r *http.Request, errorHandler *errHandler.ErrorHandler) (string, bool) {
+	var input struct {
+		Reason    string              `json:"reason"`
+		Validator validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		errorHandler.BadRequest(w, r, err)
+		return "", false
+	}
+
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		errorHandler.FailedValidation(w, r, input.Validator.Errors)
+		return "", false
+	}
+
+	return input.Reason, true
+}
+
+// audit writes an action staff took to the activity log, with the member of staff and their reason.
+// userID is the user the action was taken for.
+func (h *AdminHandler) audit(r *http.Request, userID, entity, entityID, description, reason string) error {
+	actor := context.ContextGetAuthenticatedUser(r)
+
+	_, err := h.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      userID,
+		Entity:      entity,
+		EntityId:    entityID,
+		Description: description,
+		ActorID:     sql.NullString{String: actor.ID, Valid: true},
+		Reason:      sql.NullString{String: reason, Valid: reason != ""},
+	})
+
+	return err
+}
+
+// findUser loads the user in the path, it writes the error response when it can't
+func (h *AdminHandler) findUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	user, found, err := h.UserRepo.GetOne(id)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return nil, false
+	}
+
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// findWallet loads the wallet in the path, it writes the error response when it can't
+func (h *AdminHandler) findWallet(w http.ResponseWriter, r *http.Request) (*models.Wallet, bool) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	wallet, found, err := h.WalletRepo.GetOne(id)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return nil, false
+	}
+
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	return wallet, true
+}
+
+func formAdminUserResponseData(user *models.User) *AdminUserResponseData {
+	data := &AdminUserResponseData{
+		UserResponseData: UserResponseData{
+			ID:          user.ID,
+			FirstName:   user.FirstName,
+			LastName:    user.LastName,
+			Email:       user.Email,
+			Image:       user.Image.String,
+			PhoneNumber: user.PhoneNumber,
+			Gender:      user.Gender,
+			DateOfBirth: dateOfBirthPointer(user.DateOfBirth),
+			CreatedAt:   user.CreatedAt,
+		},
+		Status: user.Status,
+		Role:   user.Role,
+	}
+
+	if user.VerifiedAt.Valid {
+		data.VerifiedAt = &user.VerifiedAt.Time
+	}
+
+	return data
+}
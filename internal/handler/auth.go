@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
 	"time"
@@ -58,6 +59,7 @@ func (h *AuthHandler) HandleAuthRegister(w http.ResponseWriter, r *http.Request)
 		LastName    string              `json:"last_name"`
 		PhoneNumber string              `json:"phone_number"`
 		Gender      string              `json:"gender"`
+		DateOfBirth string              `json:"date_of_birth"`
 		Validator   validator.Validator `json:"-"`
 	}
 
@@ -98,6 +100,11 @@ func (h *AuthHandler) HandleAuthRegister(w http.ResponseWriter, r *http.Request)
 
 	input.Validator.Check(validator.NotBlank(input.Gender), "Gender is required")
 
+	// the date of birth is matched against the owner of the BVN or NIN the user submits for KYC
+	dateOfBirth, dateOfBirthErr := time.Parse(time.DateOnly, input.DateOfBirth)
+	input.Validator.Check(dateOfBirthErr == nil, "Date of birth should be a date, YYYY-MM-DD")
+	input.Validator.Check(dateOfBirthErr != nil || dateOfBirth.Before(time.Now()), "Date of birth must be in the past")
+
 	input.Validator.Check(validator.NotBlank(input.PhoneNumber), "Phone number is required")
 	input.Validator.Check(validator.Matches(input.PhoneNumber, validator.RgxPhoneNumber), "Phone number must be in international format")
 
@@ -143,6 +150,7 @@ func (h *AuthHandler) HandleAuthRegister(w http.ResponseWriter, r *http.Request)
 		Email:          input.Email,
 		PhoneNumber:    input.PhoneNumber,
 		Gender:         input.Gender,
+		DateOfBirth:    sql.NullTime{Time: dateOfBirth, Valid: true},
 		HashedPassword: hashedPassword,
 	}
 
@@ -160,7 +168,7 @@ func (h *AuthHandler) HandleAuthRegister(w http.ResponseWriter, r *http.Request)
 	// send verification OTP
 	h.Helper.BackgroundTask(r, func() error {
 		createdUser.ID = userID
-		localErr := h.generateAndSendVerificationOTP(createdUser)
+		localErr := h.SendVerificationOTP(createdUser)
 
 		if localErr != nil {
 			log.Printf("Error sending verification email: %v", localErr)
@@ -195,7 +203,9 @@ func (h *AuthHandler) HandleAuthRegister(w http.ResponseWriter, r *http.Request)
 
 }
 
-func (h *AuthHandler) generateAndSendVerificationOTP(user *models.User) error {
+// SendVerificationOTP emails a new account verification OTP to the user, it replaces any OTP sent before.
+// Staff also use it, through the admin CLI, for users whose OTP never arrived.
+func (h *AuthHandler) SendVerificationOTP(user *models.User) error {
 
 	otp, err := gopass.GenerateOTP(5)
 
@@ -571,7 +581,7 @@ func (h *AuthHandler) HandleResendVerificationOTP(w http.ResponseWriter, r *http
 		return
 	}
 
-	err = h.generateAndSendVerificationOTP(user)
+	err = h.SendVerificationOTP(user)
 
 	if err != nil {
 		log.Printf("Error sending verification email: %v", err)
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/cradoe/morenee/internal/context"
+	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/response"
+	"github.com/cradoe/morenee/internal/validator"
+	"github.com/google/uuid"
+)
+
+type DeadLetterResponseData struct {
+	ID            string            `json:"id"`
+	Topic         string            `json:"topic"`
+	Key           *string           `json:"key"`
+	Payload       string            `json:"payload"`
+	Headers       map[string]string `json:"headers"`
+	Error         string            `json:"error"`
+	Attempts      int               `json:"attempts"`
+	TransactionID *string           `json:"transaction_id"`
+	Status        string            `json:"status"`
+	ReplayCount   int               `json:"replay_count"`
+	CreatedAt     time.Time         `json:"created_at"`
+	HandledAt     *time.Time        `json:"handled_at"`
+	ReplayedAt    *time.Time        `json:"replayed_at"`
+}
+
+type DeadLetterHandler struct {
+	DB             *repository.DB
+	DeadLetterRepo repository.DeadLetterRepository
+	OutboxRepo     repository.OutboxRepository
+	ActivityRepo   repository.ActivityRepository
+
+	ErrHandler *errHandler.ErrorHandler
+}
+
+func NewDeadLetterHandler(handler *DeadLetterHandler) *DeadLetterHandler {
+	return &DeadLetterHandler{
+		DB:             handler.DB,
+		DeadLetterRepo: handler.DeadLetterRepo,
+		OutboxRepo:     handler.OutboxRepo,
+		ActivityRepo:   handler.ActivityRepo,
+		ErrHandler:     handler.ErrHandler,
+	}
+}
+
+func (h *DeadLetterHandler) HandleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	var v validator.Validator
+	v.Check(status == "" || validator.In(status, repository.DeadLetterStatusPending, repository.DeadLetterStatusHandled, repository.DeadLetterStatusReplayed), "status must be one of pending, handled or replayed")
+	if v.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, v.Errors)
+		return
+	}
+
+	// dead letters have no date filters, only pagination is used
+	queryValues := retrieveUrlQueryValues(r, time.UTC)
+
+	deadLetters, err := h.DeadLetterRepo.GetAll(status, queryValues.Limit, queryValues.Offset)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := make([]*DeadLetterResponseData, len(deadLetters))
+	for i, deadLetter := range deadLetters {
+		data[i] = formDeadLetterResponseData(&deadLetter)
+	}
+
+	message := "Data retrieved successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *DeadLetterHandler) HandleDeadLetterDetails(w http.ResponseWriter, r *http.Request) {
+	deadLetter, found := h.findDeadLetter(w, r)
+	if !found {
+		return
+	}
+
+	message := "Details fetched successfully"
+	err := response.JSONOkResponse(w, formDeadLetterResponseData(deadLetter), message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleReplayDeadLetter sends a dead letter's original payload back to the topic it came from.
+// Transfer workers are idempotent, so replaying a message whose transfer has moved on does nothing.
+func (h *DeadLetterHandler) HandleReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	deadLetter, found := h.findDeadLetter(w, r)
+	if !found {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// a dead letter that was handled or replayed already must not be published again
+	replayed, err := h.DeadLetterRepo.MarkReplayed(deadLetter.ID, tx)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !replayed {
+		response.JSONErrorResponse(w, nil, "Only pending dead letters can be replayed", http.StatusConflict, nil)
+		return
+	}
+
+	_, err = h.OutboxRepo.Insert(&models.OutboxMessage{
+		Topic:   deadLetter.Topic,
+		Key:     deadLetter.MessageKey,
+		Payload: deadLetter.Payload,
+		Headers: deadLetter.Headers,
+	}, tx)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	// dead letters belong to no user, the replay is logged against the member of staff who did it
+	actor := context.ContextGetAuthenticatedUser(r)
+	_, err = h.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      actor.ID,
+		Entity:      repository.ActivityLogDeadLetterEntity,
+		EntityId:    deadLetter.ID,
+		Description: repository.AdminActivityLogDeadLetterReplayedDescription,
+		ActorID:     sql.NullString{String: actor.ID, Valid: true},
+	})
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "Message queued for replay"
+	err = response.JSONOkResponse(w, nil, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// findDeadLetter loads the dead letter in the path, it writes the error response when it can't
+func (h *DeadLetterHandler) findDeadLetter(w http.ResponseWriter, r *http.Request) (*models.DeadLetter, bool) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	deadLetter, found, err := h.DeadLetterRepo.GetOne(id)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return nil, false
+	}
+
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	return deadLetter, true
+}
+
+func formDeadLetterResponseData(deadLetter *models.DeadLetter) *DeadLetterResponseData {
+	data := &DeadLetterResponseData{
+		ID:          deadLetter.ID,
+		Topic:       deadLetter.Topic,
+		Payload:     deadLetter.Payload,
+		Headers:     deadLetter.Headers,
+		Error:       deadLetter.Error,
+		Attempts:    deadLetter.Attempts,
+		Status:      deadLetter.Status,
+		ReplayCount: deadLetter.ReplayCount,
+		CreatedAt:   deadLetter.CreatedAt,
+	}
+
+	if deadLetter.MessageKey.Valid {
+		data.Key = &deadLetter.MessageKey.String
+	}
+	if deadLetter.TransactionID.Valid {
+		data.TransactionID = &deadLetter.TransactionID.String
+	}
+	if deadLetter.HandledAt.Valid {
+		data.HandledAt = &deadLetter.HandledAt.Time
+	}
+	if deadLetter.ReplayedAt.Valid {
+		data.ReplayedAt = &deadLetter.ReplayedAt.Time
+	}
+
+	return data
+}
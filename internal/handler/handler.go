@@ -26,13 +26,17 @@ type queryStringValues struct {
 	Offset    int
 }
 
-func retrieveUrlQueryValues(r *http.Request) *queryStringValues {
+// retrieveUrlQueryValues reads the filter and pagination values of a list request.
+// Dates are calendar days in the business time zone (location):
+// StartDate is the start of start_date, and EndDate is the start of the day after end_date,
+// so that the whole of end_date is included when filtering with created_at < EndDate.
+func retrieveUrlQueryValues(r *http.Request, location *time.Location) *queryStringValues {
 	var queryValues = &queryStringValues{}
 
 	// Parse start_date if provided
 	startDateStr := r.URL.Query().Get("start_date")
 	if startDateStr != "" {
-		parsedStart, err := time.Parse("2006-01-02", startDateStr)
+		parsedStart, err := time.ParseInLocation("2006-01-02", startDateStr, location)
 		if err == nil {
 			queryValues.StartDate = &parsedStart
 		}
@@ -41,8 +45,9 @@ func retrieveUrlQueryValues(r *http.Request) *queryStringValues {
 	// Parse end_date if provided
 	endDateStr := r.URL.Query().Get("end_date")
 	if endDateStr != "" {
-		parsedEnd, err := time.Parse("2006-01-02", endDateStr)
+		parsedEnd, err := time.ParseInLocation("2006-01-02", endDateStr, location)
 		if err == nil {
+			parsedEnd = parsedEnd.AddDate(0, 0, 1)
 			queryValues.EndDate = &parsedEnd
 		}
 	}
@@ -1,25 +1,38 @@
 package handler
 
 import (
+	"database/sql"
 	"net/http"
+	"time"
 
 	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/models"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/response"
 )
 
 type KYCResponseData struct {
-	ID                  string                       `json:"id"`
-	LevelName           string                       `json:"level_name"`
-	DailyTransferLimit  float64                      `json:"daily_transfer_limit"`
-	WalletBalanceLimit  float64                      `json:"wallet_balance_limit"`
-	SingleTransferLimit float64                      `json:"single_transfer_limit"`
-	Requirements        []KYCRequirementResponseData `json:"requirements"`
+	ID                   string                       `json:"id"`
+	LevelName            string                       `json:"level_name"`
+	Version              int                          `json:"version"`
+	EffectiveFrom        time.Time                    `json:"effective_from"`
+	DailyTransferLimit   models.Money                 `json:"daily_transfer_limit"`
+	WalletBalanceLimit   models.Money                 `json:"wallet_balance_limit"`
+	SingleTransferLimit  models.Money                 `json:"single_transfer_limit"`
+	LimitWindow          string                       `json:"limit_window"`
+	WeeklyTransferLimit  models.NullMoney             `json:"weekly_transfer_limit"`
+	MonthlyTransferLimit models.NullMoney             `json:"monthly_transfer_limit"`
+	DailyTransferCount   *int32                       `json:"daily_transfer_count"`
+	WeeklyTransferCount  *int32                       `json:"weekly_transfer_count"`
+	MonthlyTransferCount *int32                       `json:"monthly_transfer_count"`
+	Requirements         []KYCRequirementResponseData `json:"requirements"`
 }
 
 type KYCRequirementResponseData struct {
-	ID          string `json:"id"`
-	Requirement string `json:"requirement"`
+	ID               string `json:"id"`
+	Requirement      string `json:"requirement"`
+	Schema           string `json:"schema"`
+	RequiresDocument bool   `json:"requires_document"`
 }
 
 type KycHandler struct {
@@ -59,19 +72,15 @@ func (h *KycHandler) HandleKYCs(w http.ResponseWriter, r *http.Request) {
 		requirements := make([]KYCRequirementResponseData, len(kyc.Requirements))
 		for j, req := range kyc.Requirements {
 			requirements[j] = KYCRequirementResponseData{
-				ID:          req.ID,
-				Requirement: req.Requirement,
+				ID:               req.ID,
+				Requirement:      req.Requirement,
+				Schema:           req.Schema,
+				RequiresDocument: req.RequiresDocument,
 			}
 		}
 
-		data[i] = &KYCResponseData{
-			ID:                  kyc.ID,
-			LevelName:           kyc.LevelName,
-			DailyTransferLimit:  kyc.DailyTransferLimit,
-			WalletBalanceLimit:  kyc.WalletBalanceLimit,
-			SingleTransferLimit: kyc.SingleTransferLimit,
-			Requirements:        requirements,
-		}
+		data[i] = formKYCResponseData(&kyc)
+		data[i].Requirements = requirements
 	}
 
 	err = response.JSONOkResponse(w, data, message, nil)
@@ -96,19 +105,15 @@ func (h *KycHandler) HandleSingleYC(w http.ResponseWriter, r *http.Request) {
 
 	message := "Data retrieved successfully"
 
-	kyc := &KYCResponseData{
-		ID:                  result.ID,
-		LevelName:           result.LevelName,
-		DailyTransferLimit:  result.DailyTransferLimit,
-		SingleTransferLimit: result.SingleTransferLimit,
-		WalletBalanceLimit:  result.WalletBalanceLimit,
-	}
+	kyc := formKYCResponseData(result)
 
 	requirements := make([]KYCRequirementResponseData, len(result.Requirements))
 	for j, req := range result.Requirements {
 		requirements[j] = KYCRequirementResponseData{
-			ID:          req.ID,
-			Requirement: req.Requirement,
+			ID:               req.ID,
+			Requirement:      req.Requirement,
+			Schema:           req.Schema,
+			RequiresDocument: req.RequiresDocument,
 		}
 	}
 
@@ -119,3 +124,30 @@ func (h *KycHandler) HandleSingleYC(w http.ResponseWriter, r *http.Request) {
 		h.ErrHandler.ServerError(w, r, err)
 	}
 }
+
+func formKYCResponseData(kyc *models.KYCLevel) *KYCResponseData {
+	return &KYCResponseData{
+		ID:                   kyc.ID,
+		LevelName:            kyc.LevelName,
+		Version:              kyc.Version,
+		EffectiveFrom:        kyc.EffectiveFrom,
+		DailyTransferLimit:   kyc.DailyTransferLimit,
+		WalletBalanceLimit:   kyc.WalletBalanceLimit,
+		SingleTransferLimit:  kyc.SingleTransferLimit,
+		LimitWindow:          kyc.LimitWindow,
+		WeeklyTransferLimit:  kyc.WeeklyTransferLimit,
+		MonthlyTransferLimit: kyc.MonthlyTransferLimit,
+		DailyTransferCount:   nullInt32Pointer(kyc.DailyTransferCount),
+		WeeklyTransferCount:  nullInt32Pointer(kyc.WeeklyTransferCount),
+		MonthlyTransferCount: nullInt32Pointer(kyc.MonthlyTransferCount),
+	}
+}
+
+// nullInt32Pointer turns an optional count into a pointer, so it is written as null in JSON when not set
+func nullInt32Pointer(value sql.NullInt32) *int32 {
+	if !value.Valid {
+		return nil
+	}
+
+	return &value.Int32
+}
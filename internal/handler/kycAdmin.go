@@ -0,0 +1,748 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cradoe/morenee/internal/context"
+	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/kyc"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/request"
+	"github.com/cradoe/morenee/internal/response"
+	"github.com/cradoe/morenee/internal/validator"
+	"github.com/google/uuid"
+)
+
+// KYCLevelVersionResponseData is a version of the limits of a level, with who added it and why
+type KYCLevelVersionResponseData struct {
+	ID                   string           `json:"id"`
+	KYCLevelID           string           `json:"kyc_level_id"`
+	Version              int              `json:"version"`
+	SingleTransferLimit  models.Money     `json:"single_transfer_limit"`
+	DailyTransferLimit   models.Money     `json:"daily_transfer_limit"`
+	WalletBalanceLimit   models.Money     `json:"wallet_balance_limit"`
+	LimitWindow          string           `json:"limit_window"`
+	WeeklyTransferLimit  models.NullMoney `json:"weekly_transfer_limit"`
+	MonthlyTransferLimit models.NullMoney `json:"monthly_transfer_limit"`
+	DailyTransferCount   *int32           `json:"daily_transfer_count"`
+	WeeklyTransferCount  *int32           `json:"weekly_transfer_count"`
+	MonthlyTransferCount *int32           `json:"monthly_transfer_count"`
+	EffectiveFrom        time.Time        `json:"effective_from"`
+	CreatedBy            *string          `json:"created_by"`
+	Reason               *string          `json:"reason"`
+	CreatedAt            time.Time        `json:"created_at"`
+}
+
+// KYCAdminRequirementResponseData is a requirement as admins see it, with when it counts towards its level
+type KYCAdminRequirementResponseData struct {
+	KYCRequirementResponseData
+	KYCLevelID    string     `json:"kyc_level_id"`
+	Version       int        `json:"version"`
+	EffectiveFrom time.Time  `json:"effective_from"`
+	RetiredAt     *time.Time `json:"retired_at"`
+}
+
+// KYCAdminLevelResponseData is a level as admins see it: the limits in effect,
+// with every version of its limits and every requirement, in place of the requirements in effect
+type KYCAdminLevelResponseData struct {
+	*KYCResponseData
+	Versions     []*KYCLevelVersionResponseData     `json:"versions"`
+	Requirements []*KYCAdminRequirementResponseData `json:"requirements"`
+}
+
+// kycLimitsInput is the limits of a level as admins send them, nil weekly/monthly limits and counts mean there is no such limit
+type kycLimitsInput struct {
+	SingleTransferLimit  models.Money     `json:"single_transfer_limit"`
+	DailyTransferLimit   models.Money     `json:"daily_transfer_limit"`
+	WalletBalanceLimit   models.Money     `json:"wallet_balance_limit"`
+	LimitWindow          string           `json:"limit_window"`
+	WeeklyTransferLimit  models.NullMoney `json:"weekly_transfer_limit"`
+	MonthlyTransferLimit models.NullMoney `json:"monthly_transfer_limit"`
+	DailyTransferCount   *int32           `json:"daily_transfer_count"`
+	WeeklyTransferCount  *int32           `json:"weekly_transfer_count"`
+	MonthlyTransferCount *int32           `json:"monthly_transfer_count"`
+}
+
+// KycAdminHandler lets admins manage the KYC levels, their limits and their requirements.
+// Limits are never changed in place: every change is a new version of the level's limits, which takes effect from a set time,
+// and requirements are retired rather than deleted, so the data users submitted for them stays.
+// Every change is written to the activity log with the admin who made it, and their reason.
+type KycAdminHandler struct {
+	KycRepo            repository.KycRepository
+	KycRequirementRepo repository.KycRequirementRepository
+	ActivityRepo       repository.ActivityRepository
+
+	ErrHandler *errHandler.ErrorHandler
+}
+
+func NewKycAdminHandler(handler *KycAdminHandler) *KycAdminHandler {
+	return &KycAdminHandler{
+		KycRepo:            handler.KycRepo,
+		KycRequirementRepo: handler.KycRequirementRepo,
+		ActivityRepo:       handler.ActivityRepo,
+		ErrHandler:         handler.ErrHandler,
+	}
+}
+
+// HandleLevels lists the levels with the limits in effect, every version of their limits and every requirement,
+// including those that are yet to take effect and those that were retired
+func (h *KycAdminHandler) HandleLevels(w http.ResponseWriter, r *http.Request) {
+	levels, err := h.KycRepo.GetAll()
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	versions, err := h.KycRepo.GetAllVersions()
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	requirements, err := h.KycRequirementRepo.GetAll()
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := make([]*KYCAdminLevelResponseData, len(levels))
+	index := make(map[string]*KYCAdminLevelResponseData, len(levels))
+	for i, level := range levels {
+		data[i] = &KYCAdminLevelResponseData{
+			KYCResponseData: formKYCResponseData(&level),
+			Versions:        []*KYCLevelVersionResponseData{},
+			Requirements:    []*KYCAdminRequirementResponseData{},
+		}
+		index[level.ID] = data[i]
+	}
+
+	for _, version := range versions {
+		if level, ok := index[version.KYCLevelID]; ok {
+			level.Versions = append(level.Versions, formKYCLevelVersionResponseData(&version))
+		}
+	}
+
+	for _, requirement := range requirements {
+		if level, ok := index[requirement.KYCLevelID]; ok {
+			level.Requirements = append(level.Requirements, formKYCAdminRequirementResponseData(&requirement))
+		}
+	}
+
+	message := "Data retrieved successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleCreateLevel adds a level above the existing ones, its limits take effect at once.
+// Users can't reach it before it has a requirement in effect.
+func (h *KycAdminHandler) HandleCreateLevel(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		LevelName string `json:"level_name"`
+		kycLimitsInput
+		Reason    string              `json:"reason"`
+		Validator validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, err)
+		return
+	}
+
+	input.Validator.Check(validator.NotBlank(input.LevelName), "Level name is required")
+	input.Validator.Check(validator.MaxRunes(input.LevelName, 50), "Level name must not be more than 50 characters")
+	input.kycLimitsInput.validate(&input.Validator)
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
+		return
+	}
+
+	actor := context.ContextGetAuthenticatedUser(r)
+	limits := input.kycLimitsInput.version(actor.ID, input.Reason)
+
+	levelID, created, err := h.KycRepo.Create(input.LevelName, limits)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !created {
+		response.JSONErrorResponse(w, nil, "A KYC level already has this name", http.StatusConflict, nil)
+		return
+	}
+
+	err = h.audit(r, repository.ActivityLogKYCLevelEntity, limits.ID, repository.AdminActivityLogKYCLevelCreatedDescription, input.Reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	level, found, err := h.KycRepo.GetOne(levelID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if !found {
+		h.ErrHandler.ServerError(w, r, fmt.Errorf("kyc level %s not found after it was created", levelID))
+		return
+	}
+
+	message := "KYC level created successfully"
+	err = response.JSONCreatedResponse(w, formKYCResponseData(level), message)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleRenameLevel changes the name of a level, its limits and requirements stay as they are
+func (h *KycAdminHandler) HandleRenameLevel(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		LevelName string              `json:"level_name"`
+		Reason    string              `json:"reason"`
+		Validator validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, err)
+		return
+	}
+
+	input.Validator.Check(validator.NotBlank(input.LevelName), "Level name is required")
+	input.Validator.Check(validator.MaxRunes(input.LevelName, 50), "Level name must not be more than 50 characters")
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
+		return
+	}
+
+	level, found := h.findLevel(w, r)
+	if !found {
+		return
+	}
+
+	renamed, err := h.KycRepo.Rename(level.ID, input.LevelName)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !renamed {
+		response.JSONErrorResponse(w, nil, "A KYC level already has this name", http.StatusConflict, nil)
+		return
+	}
+
+	err = h.audit(r, repository.ActivityLogKYCLevelEntity, level.VersionID, repository.AdminActivityLogKYCLevelRenamedDescription, input.Reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	level.LevelName = input.LevelName
+
+	message := "KYC level renamed successfully"
+	err = response.JSONOkResponse(w, formKYCResponseData(level), message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleLevelVersions lists the versions of the limits of a level, the latest first
+func (h *KycAdminHandler) HandleLevelVersions(w http.ResponseWriter, r *http.Request) {
+	level, found := h.findLevel(w, r)
+	if !found {
+		return
+	}
+
+	versions, err := h.KycRepo.GetVersions(level.ID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := make([]*KYCLevelVersionResponseData, len(versions))
+	for i, version := range versions {
+		data[i] = formKYCLevelVersionResponseData(&version)
+	}
+
+	message := "Data retrieved successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleAddLevelVersion changes the limits of a level from effective_from, at once when it is not given.
+// The limits in effect until then stay as they are, limits can't be changed in the past.
+func (h *KycAdminHandler) HandleAddLevelVersion(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		kycLimitsInput
+		EffectiveFrom *time.Time          `json:"effective_from"`
+		Reason        string              `json:"reason"`
+		Validator     validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, err)
+		return
+	}
+
+	effectiveFrom := effectiveTime(input.EffectiveFrom)
+
+	input.kycLimitsInput.validate(&input.Validator)
+	input.Validator.Check(!effectiveFrom.Before(time.Now().Add(-time.Minute)), "Effective from must not be in the past")
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
+		return
+	}
+
+	level, found := h.findLevel(w, r)
+	if !found {
+		return
+	}
+
+	limits := input.kycLimitsInput.version(context.ContextGetAuthenticatedUser(r).ID, input.Reason)
+	limits.EffectiveFrom = effectiveFrom
+
+	version, found, err := h.KycRepo.AddVersion(level.ID, limits)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+
+	err = h.audit(r, repository.ActivityLogKYCLevelEntity, version.ID, repository.AdminActivityLogKYCLimitsChangedDescription, input.Reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "KYC limits changed successfully"
+	err = response.JSONCreatedResponse(w, formKYCLevelVersionResponseData(version), message)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleLevelRequirements lists every requirement of a level, including those that are yet to take effect and those that were retired
+func (h *KycAdminHandler) HandleLevelRequirements(w http.ResponseWriter, r *http.Request) {
+	level, found := h.findLevel(w, r)
+	if !found {
+		return
+	}
+
+	requirements, err := h.KycRequirementRepo.GetByLevel(level.ID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := make([]*KYCAdminRequirementResponseData, len(requirements))
+	for i, requirement := range requirements {
+		data[i] = formKYCAdminRequirementResponseData(&requirement)
+	}
+
+	message := "Data retrieved successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleAddRequirement adds a requirement to a level from effective_from, at once when it is not given.
+// Users already on the level or above keep their level, the requirement only counts for those still to reach it.
+func (h *KycAdminHandler) HandleAddRequirement(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Requirement      string              `json:"requirement"`
+		Schema           string              `json:"schema"`
+		RequiresDocument bool                `json:"requires_document"`
+		EffectiveFrom    *time.Time          `json:"effective_from"`
+		Reason           string              `json:"reason"`
+		Validator        validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, err)
+		return
+	}
+
+	effectiveFrom := effectiveTime(input.EffectiveFrom)
+
+	validateRequirement(&input.Validator, input.Requirement, input.Schema, input.RequiresDocument)
+	input.Validator.Check(!effectiveFrom.Before(time.Now().Add(-time.Minute)), "Effective from must not be in the past")
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
+		return
+	}
+
+	level, found := h.findLevel(w, r)
+	if !found {
+		return
+	}
+
+	requirement := &models.KYCLevelRequirement{
+		KYCLevelID:       level.ID,
+		Requirement:      input.Requirement,
+		Schema:           input.Schema,
+		RequiresDocument: input.RequiresDocument,
+		EffectiveFrom:    effectiveFrom,
+	}
+
+	created, err := h.KycRequirementRepo.Insert(requirement)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !created {
+		response.JSONErrorResponse(w, nil, "A KYC requirement already has this name", http.StatusConflict, nil)
+		return
+	}
+
+	err = h.audit(r, repository.ActivityLogKYCRequirementEntity, requirement.ID, repository.AdminActivityLogKYCRequirementAddedDescription, input.Reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "KYC requirement added successfully"
+	err = response.JSONCreatedResponse(w, formKYCAdminRequirementResponseData(requirement), message)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleUpdateRequirement renames a requirement, or changes its schema while nobody submitted data for it.
+// Fields that are not given keep their value.
+func (h *KycAdminHandler) HandleUpdateRequirement(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Requirement      *string             `json:"requirement"`
+		Schema           *string             `json:"schema"`
+		RequiresDocument *bool               `json:"requires_document"`
+		Reason           string              `json:"reason"`
+		Validator        validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, err)
+		return
+	}
+
+	requirement, found := h.findRequirement(w, r)
+	if !found {
+		return
+	}
+
+	if input.Requirement != nil {
+		requirement.Requirement = *input.Requirement
+	}
+	if input.Schema != nil {
+		requirement.Schema = *input.Schema
+	}
+	if input.RequiresDocument != nil {
+		requirement.RequiresDocument = *input.RequiresDocument
+	}
+
+	validateRequirement(&input.Validator, requirement.Requirement, requirement.Schema, requirement.RequiresDocument)
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
+		return
+	}
+
+	existing, found, err := h.KycRequirementRepo.FindByName(requirement.Requirement)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if found && existing.ID != requirement.ID {
+		response.JSONErrorResponse(w, nil, "A KYC requirement already has this name", http.StatusConflict, nil)
+		return
+	}
+
+	updated, err := h.KycRequirementRepo.Update(requirement)
+	if errors.Is(err, repository.ErrKYCRequirementInUse) {
+		response.JSONErrorResponse(w, nil, "Users already submitted data for this requirement, retire it and add a new one instead", http.StatusConflict, nil)
+		return
+	}
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !updated {
+		response.JSONErrorResponse(w, nil, "Requirement is retired", http.StatusConflict, nil)
+		return
+	}
+
+	err = h.audit(r, repository.ActivityLogKYCRequirementEntity, requirement.ID, repository.AdminActivityLogKYCRequirementChangedDescription, input.Reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "KYC requirement updated successfully"
+	err = response.JSONOkResponse(w, formKYCAdminRequirementResponseData(requirement), message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleRetireRequirement stops a requirement from counting towards its level from retire_at, at once when it is not given.
+// Users are not moved up a level by it, that happens the next time data of theirs is approved.
+func (h *KycAdminHandler) HandleRetireRequirement(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RetireAt  *time.Time          `json:"retire_at"`
+		Reason    string              `json:"reason"`
+		Validator validator.Validator `json:"-"`
+	}
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, err)
+		return
+	}
+
+	retireAt := effectiveTime(input.RetireAt)
+
+	input.Validator.Check(!retireAt.Before(time.Now().Add(-time.Minute)), "Retire at must not be in the past")
+	input.Validator.Check(validator.NotBlank(input.Reason), "Reason is required")
+	if input.Validator.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
+		return
+	}
+
+	requirement, found := h.findRequirement(w, r)
+	if !found {
+		return
+	}
+
+	retired, err := h.KycRequirementRepo.Retire(requirement.ID, retireAt)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if !retired {
+		response.JSONErrorResponse(w, nil, "Requirement is already retired", http.StatusConflict, nil)
+		return
+	}
+
+	err = h.audit(r, repository.ActivityLogKYCRequirementEntity, requirement.ID, repository.AdminActivityLogKYCRequirementRetiredDescription, input.Reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "KYC requirement retired successfully"
+	err = response.JSONOkResponse(w, map[string]any{"retired_at": retireAt}, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// audit writes a change the admin made to the activity log, with their reason.
+// Changes to levels are no user's own, they are logged under the admin.
+func (h *KycAdminHandler) audit(r *http.Request, entity, entityID, description, reason string) error {
+	actor := context.ContextGetAuthenticatedUser(r)
+
+	_, err := h.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      actor.ID,
+		Entity:      entity,
+		EntityId:    entityID,
+		Description: description,
+		ActorID:     sql.NullString{String: actor.ID, Valid: true},
+		Reason:      sql.NullString{String: reason, Valid: true},
+	})
+
+	return err
+}
+
+// findLevel loads the level in the path, it writes the error response when it can't
+func (h *KycAdminHandler) findLevel(w http.ResponseWriter, r *http.Request) (*models.KYCLevel, bool) {
+	id := r.PathValue("id")
+	if _, err := strconv.Atoi(id); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	level, found, err := h.KycRepo.GetOne(id)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return nil, false
+	}
+
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	return level, true
+}
+
+// findRequirement loads the requirement in the path, it writes the error response when it can't
+func (h *KycAdminHandler) findRequirement(w http.ResponseWriter, r *http.Request) (*models.KYCLevelRequirement, bool) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	requirement, found, err := h.KycRequirementRepo.GetOne(id)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return nil, false
+	}
+
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	return requirement, true
+}
+
+// validate checks the limits make sense together: every limit is positive,
+// and a limit over a longer time is never below one over a shorter time
+func (input *kycLimitsInput) validate(v *validator.Validator) {
+	if input.LimitWindow == "" {
+		input.LimitWindow = repository.TransferLimitWindowCalendar
+	}
+
+	v.Check(input.SingleTransferLimit.IsPositive(), "Single transfer limit must be more than zero")
+	v.Check(input.DailyTransferLimit.IsPositive(), "Daily transfer limit must be more than zero")
+	v.Check(input.WalletBalanceLimit.IsPositive(), "Wallet balance limit must be more than zero")
+	v.Check(validator.In(input.LimitWindow, repository.TransferLimitWindowCalendar, repository.TransferLimitWindowRolling), "Limit window must be calendar or rolling")
+	v.Check(!input.WeeklyTransferLimit.Valid || input.WeeklyTransferLimit.Money.IsPositive(), "Weekly transfer limit must be more than zero")
+	v.Check(!input.MonthlyTransferLimit.Valid || input.MonthlyTransferLimit.Money.IsPositive(), "Monthly transfer limit must be more than zero")
+
+	v.Check(notAbove(input.SingleTransferLimit, input.DailyTransferLimit), "Single transfer limit must not be more than the daily transfer limit")
+	if input.WeeklyTransferLimit.Valid {
+		v.Check(notAbove(input.DailyTransferLimit, input.WeeklyTransferLimit.Money), "Daily transfer limit must not be more than the weekly transfer limit")
+	}
+	if input.MonthlyTransferLimit.Valid {
+		v.Check(notAbove(input.DailyTransferLimit, input.MonthlyTransferLimit.Money), "Daily transfer limit must not be more than the monthly transfer limit")
+	}
+	if input.WeeklyTransferLimit.Valid && input.MonthlyTransferLimit.Valid {
+		v.Check(notAbove(input.WeeklyTransferLimit.Money, input.MonthlyTransferLimit.Money), "Weekly transfer limit must not be more than the monthly transfer limit")
+	}
+
+	v.Check(input.DailyTransferCount == nil || *input.DailyTransferCount > 0, "Daily transfer count must be more than zero")
+	v.Check(input.WeeklyTransferCount == nil || *input.WeeklyTransferCount > 0, "Weekly transfer count must be more than zero")
+	v.Check(input.MonthlyTransferCount == nil || *input.MonthlyTransferCount > 0, "Monthly transfer count must be more than zero")
+}
+
+// version turns the limits into a version of a level, added by actorID for reason
+func (input *kycLimitsInput) version(actorID, reason string) *models.KYCLevelVersion {
+	return &models.KYCLevelVersion{
+		SingleTransferLimit:  input.SingleTransferLimit,
+		DailyTransferLimit:   input.DailyTransferLimit,
+		WalletBalanceLimit:   input.WalletBalanceLimit,
+		LimitWindow:          input.LimitWindow,
+		WeeklyTransferLimit:  input.WeeklyTransferLimit,
+		MonthlyTransferLimit: input.MonthlyTransferLimit,
+		DailyTransferCount:   int32PointerNull(input.DailyTransferCount),
+		WeeklyTransferCount:  int32PointerNull(input.WeeklyTransferCount),
+		MonthlyTransferCount: int32PointerNull(input.MonthlyTransferCount),
+		CreatedBy:            sql.NullString{String: actorID, Valid: true},
+		Reason:               sql.NullString{String: reason, Valid: true},
+	}
+}
+
+// validateRequirement checks the name and schema of a requirement,
+// data of the document schema is the document alone, so it can't go without one
+func validateRequirement(v *validator.Validator, requirement, schema string, requiresDocument bool) {
+	v.Check(validator.NotBlank(requirement), "Requirement is required")
+	v.Check(validator.MaxRunes(requirement, 100), "Requirement must not be more than 100 characters")
+	v.Check(kyc.IsSchema(schema), fmt.Sprintf("Schema must be one of %v", kyc.Schemas()))
+	v.Check(schema != kyc.SchemaDocument || requiresDocument, "Requirements of the document schema require a document")
+}
+
+// effectiveTime is when a change takes effect, at once when no time is given
+func effectiveTime(at *time.Time) time.Time {
+	if at == nil {
+		return time.Now()
+	}
+
+	return *at
+}
+
+// notAbove reports whether a is not more than b
+func notAbove(a, b models.Money) bool {
+	cmp, err := a.Cmp(b)
+	return err == nil && cmp <= 0
+}
+
+// int32PointerNull turns an optional count from a request into a nullable column
+func int32PointerNull(value *int32) sql.NullInt32 {
+	if value == nil {
+		return sql.NullInt32{}
+	}
+
+	return sql.NullInt32{Int32: *value, Valid: true}
+}
+
+func formKYCLevelVersionResponseData(version *models.KYCLevelVersion) *KYCLevelVersionResponseData {
+	data := &KYCLevelVersionResponseData{
+		ID:                   version.ID,
+		KYCLevelID:           version.KYCLevelID,
+		Version:              version.Version,
+		SingleTransferLimit:  version.SingleTransferLimit,
+		DailyTransferLimit:   version.DailyTransferLimit,
+		WalletBalanceLimit:   version.WalletBalanceLimit,
+		LimitWindow:          version.LimitWindow,
+		WeeklyTransferLimit:  version.WeeklyTransferLimit,
+		MonthlyTransferLimit: version.MonthlyTransferLimit,
+		DailyTransferCount:   nullInt32Pointer(version.DailyTransferCount),
+		WeeklyTransferCount:  nullInt32Pointer(version.WeeklyTransferCount),
+		MonthlyTransferCount: nullInt32Pointer(version.MonthlyTransferCount),
+		EffectiveFrom:        version.EffectiveFrom,
+		CreatedAt:            version.CreatedAt,
+	}
+
+	if version.CreatedBy.Valid {
+		data.CreatedBy = &version.CreatedBy.String
+	}
+	if version.Reason.Valid {
+		data.Reason = &version.Reason.String
+	}
+
+	return data
+}
+
+func formKYCAdminRequirementResponseData(requirement *models.KYCLevelRequirement) *KYCAdminRequirementResponseData {
+	data := &KYCAdminRequirementResponseData{
+		KYCRequirementResponseData: KYCRequirementResponseData{
+			ID:               requirement.ID,
+			Requirement:      requirement.Requirement,
+			Schema:           requirement.Schema,
+			RequiresDocument: requirement.RequiresDocument,
+		},
+		KYCLevelID:    requirement.KYCLevelID,
+		Version:       requirement.Version,
+		EffectiveFrom: requirement.EffectiveFrom,
+	}
+
+	if requirement.RetiredAt.Valid {
+		data.RetiredAt = &requirement.RetiredAt.Time
+	}
+
+	return data
+}
@@ -1,49 +1,107 @@
 package handler
 
 import (
+	dctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"path/filepath"
 	"time"
 
+	"github.com/cradoe/morenee/internal/config"
 	"github.com/cradoe/morenee/internal/context"
 	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/file"
 	"github.com/cradoe/morenee/internal/helper"
+	"github.com/cradoe/morenee/internal/identity"
+	"github.com/cradoe/morenee/internal/kyc"
+	"github.com/cradoe/morenee/internal/models"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/request"
 	"github.com/cradoe/morenee/internal/response"
 	"github.com/cradoe/morenee/internal/validator"
+	"github.com/cradoe/morenee/internal/walletstatus"
+	"github.com/google/uuid"
 )
 
+var (
+	ErrKYCDataAlreadySet        = errors.New("data has already been set, only rejected data can be submitted again")
+	ErrKYCRequirementNotFound   = errors.New("KYC requirement not found")
+	ErrKYCRequirementNeedsFile  = errors.New("this requirement is met with a document, upload it instead")
+	ErrKYCRequirementNeedsValue = errors.New("this requirement is met with data, not a document")
+	ErrKYCIdentityLinked        = errors.New("this identity is linked to another account, please contact support")
+)
+
+// maxKYCDocumentSize is the size of the largest document users can send for a KYC requirement
+const maxKYCDocumentSize = 5 << 20 // 5 MB
+
+// kycDocumentTypes are the types of documents users can send, with the extension they are stored with.
+// The type is sniffed from the document itself, not taken from what the client says it is.
+var kycDocumentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+// identityKinds are the schemas whose submissions are checked with the identity provider, with the kind of number they carry
+var identityKinds = map[string]string{
+	kyc.SchemaBVN: identity.KindBVN,
+	kyc.SchemaNIN: identity.KindNIN,
+}
+
 type UserKYCDataResponse struct {
-	ID          string                     `json:"id"`
-	Value       string                     `json:"value"`
-	Verified    bool                       `json:"verified"`
-	CreatedAt   time.Time                  `json:"created_at"`
-	Requirement KYCRequirementResponseData `json:"requirement"`
+	ID           string                     `json:"id"`
+	Data         models.KYCSubmission       `json:"data"`
+	Verified     bool                       `json:"verified"`
+	Status       string                     `json:"status"`
+	ReviewReason *string                    `json:"review_reason"`
+	SubmittedAt  time.Time                  `json:"submitted_at"`
+	ReviewedAt   *time.Time                 `json:"reviewed_at"`
+	CreatedAt    time.Time                  `json:"created_at"`
+	Document     *KYCDocumentResponseData   `json:"document"`
+	Requirement  KYCRequirementResponseData `json:"requirement"`
+}
+
+// KYCDocumentResponseData describes a KYC document, the document itself is private and never linked to
+type KYCDocumentResponseData struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
 }
 
 type UserKycDataHandler struct {
 	UserKycDataRepo    repository.UserKycDataRepository
 	KycRequirementRepo repository.KycRequirementRepository
-
-	ErrHandler *errHandler.ErrorHandler
-	Helper     *helper.Helper
+	ActivityRepo       repository.ActivityRepository
+
+	ErrHandler   *errHandler.ErrorHandler
+	Config       *config.Config
+	Helper       *helper.Helper
+	Documents    file.DocumentStore
+	Identities   identity.Verifier
+	WalletStatus *walletstatus.Manager
 }
 
 func NewUserKycDataHandler(handler *UserKycDataHandler) *UserKycDataHandler {
 	return &UserKycDataHandler{
 		UserKycDataRepo:    handler.UserKycDataRepo,
 		KycRequirementRepo: handler.KycRequirementRepo,
+		ActivityRepo:       handler.ActivityRepo,
 		ErrHandler:         handler.ErrHandler,
+		Config:             handler.Config,
 		Helper:             handler.Helper,
+		Documents:          handler.Documents,
+		Identities:         handler.Identities,
+		WalletStatus:       handler.WalletStatus,
 	}
 }
 
+// HandleSaveUserBVN saves the BVN, the body is the data of the bvn schema: {"bvn": "..."}
 func (h *UserKycDataHandler) HandleSaveUserBVN(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		BVN       string              `json:"bvn"`
-		Validator validator.Validator `json:"-"`
-	}
+	var input json.RawMessage
 
 	err := request.DecodeJSON(w, r, &input)
 	if err != nil {
@@ -51,14 +109,6 @@ func (h *UserKycDataHandler) HandleSaveUserBVN(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	input.Validator.Check(validator.NotBlank(input.BVN), "Value is required")
-	input.Validator.Check(len(input.BVN) == 10, "BVN should be 10 digits")
-
-	if input.Validator.HasErrors() {
-		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
-		return
-	}
-
 	user := context.ContextGetAuthenticatedUser((r))
 
 	requirement, found, err := h.KycRequirementRepo.FindByName("BVN")
@@ -67,42 +117,42 @@ func (h *UserKycDataHandler) HandleSaveUserBVN(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if !found {
-		h.ErrHandler.ServerError(w, r, err)
+	// admins may have retired the BVN requirement
+	if !found || !requirement.Active(time.Now()) {
+		response.JSONErrorResponse(w, nil, ErrKYCRequirementNotFound.Error(), http.StatusUnprocessableEntity, nil)
 		return
 	}
 
-	// check that record has not been set
-	_, found, err = h.UserKycDataRepo.GetByRequirementId(user.ID, requirement.ID)
-	if found {
-		message := "Data has already been set"
-		response.JSONErrorResponse(w, nil, message, http.StatusForbidden, nil)
-		if err != nil {
-			h.ErrHandler.ServerError(w, r, err)
-		}
+	submission, ok := h.parseSubmission(w, r, requirement, input)
+	if !ok {
 		return
 	}
 
-	err = h.UserKycDataRepo.Insert(user.ID, input.BVN, requirement.ID)
+	id, duplicate, err := h.submit(user.ID, requirement, submission, nil)
+	if errors.Is(err, ErrKYCDataAlreadySet) {
+		response.JSONErrorResponse(w, nil, err.Error(), http.StatusForbidden, nil)
+		return
+	}
+	if errors.Is(err, ErrKYCIdentityLinked) {
+		response.JSONErrorResponse(w, nil, err.Error(), http.StatusConflict, nil)
+		return
+	}
 	if err != nil {
 		h.ErrHandler.ServerError(w, r, err)
 		return
 	}
 
-	// make attempt to upgrade user kyc level in background
-	h.Helper.BackgroundTask(r, func() error {
-		_, err = h.UserKycDataRepo.UpgradeLevel(user.ID)
-
-		if err != nil {
-			log.Printf("Error upgrading user kyc: %v", err)
-			return err
-		}
-
-		return nil
-	})
+	verified, err := h.checkIdentity(r, user, id, requirement, submission, duplicate)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
 
-	message := "BVN saved successfully."
-	err = response.JSONCreatedResponse(w, nil, message)
+	message := "BVN saved successfully, it will be reviewed shortly."
+	if verified {
+		message = "BVN verified successfully."
+	}
+	err = response.JSONCreatedResponse(w, map[string]any{"verified": verified}, message)
 	if err != nil {
 		h.ErrHandler.ServerError(w, r, err)
 	}
@@ -118,18 +168,9 @@ func (h *UserKycDataHandler) HandleGetAllUserKYCData(w http.ResponseWriter, r *h
 		return
 	}
 
-	formattedResponse := make([]UserKYCDataResponse, len(kycDataList))
+	formattedResponse := make([]*UserKYCDataResponse, len(kycDataList))
 	for i, data := range kycDataList {
-		formattedResponse[i] = UserKYCDataResponse{
-			ID:        data.ID,
-			Value:     data.SubmissionData,
-			Verified:  data.Verified,
-			CreatedAt: data.CreatedAt,
-			Requirement: KYCRequirementResponseData{
-				ID:          data.RequirementID,
-				Requirement: data.Requirement,
-			},
-		}
+		formattedResponse[i] = formUserKYCDataResponse(&data)
 	}
 
 	message := "KYC data retrieved successfully."
@@ -139,11 +180,11 @@ func (h *UserKycDataHandler) HandleGetAllUserKYCData(w http.ResponseWriter, r *h
 	}
 }
 
-// general purpse handler for setting kyc data
+// general purpse handler for setting kyc data, data is shaped by the schema of the requirement
 func (h *UserKycDataHandler) HandleSaveKYCData(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		RequirementID string              `json:"requirement_id"`
-		Value         string              `json:"value"`
+		Data          json.RawMessage     `json:"data"`
 		Validator     validator.Validator `json:"-"`
 	}
 
@@ -154,7 +195,7 @@ func (h *UserKycDataHandler) HandleSaveKYCData(w http.ResponseWriter, r *http.Re
 	}
 
 	input.Validator.Check(validator.NotBlank(input.RequirementID), "Requirement ID is required")
-	input.Validator.Check(validator.NotBlank(input.Value), "Value is required")
+	input.Validator.Check(len(input.Data) != 0, "Data is required")
 
 	if input.Validator.HasErrors() {
 		h.ErrHandler.FailedValidation(w, r, input.Validator.Errors)
@@ -163,38 +204,388 @@ func (h *UserKycDataHandler) HandleSaveKYCData(w http.ResponseWriter, r *http.Re
 
 	user := context.ContextGetAuthenticatedUser((r))
 
-	// check that record has not been set
-	_, found, err := h.UserKycDataRepo.GetByRequirementId(user.ID, input.RequirementID)
-	if found {
-		message := "Data has already been set"
-		response.JSONErrorResponse(w, nil, message, http.StatusForbidden, nil)
-		if err != nil {
-			h.ErrHandler.ServerError(w, r, err)
+	requirement, found, err := h.findRequirement(input.RequirementID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if !found {
+		response.JSONErrorResponse(w, nil, ErrKYCRequirementNotFound.Error(), http.StatusUnprocessableEntity, nil)
+		return
+	}
+	if requirement.RequiresDocument {
+		response.JSONErrorResponse(w, nil, ErrKYCRequirementNeedsFile.Error(), http.StatusUnprocessableEntity, nil)
+		return
+	}
+
+	submission, ok := h.parseSubmission(w, r, requirement, input.Data)
+	if !ok {
+		return
+	}
+
+	id, duplicate, err := h.submit(user.ID, requirement, submission, nil)
+	if errors.Is(err, ErrKYCDataAlreadySet) {
+		response.JSONErrorResponse(w, nil, err.Error(), http.StatusForbidden, nil)
+		return
+	}
+	if errors.Is(err, ErrKYCIdentityLinked) {
+		response.JSONErrorResponse(w, nil, err.Error(), http.StatusConflict, nil)
+		return
+	}
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	verified, err := h.checkIdentity(r, user, id, requirement, submission, duplicate)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	message := "KYC data saved successfully."
+	if verified {
+		message = "KYC data verified successfully."
+	}
+	err = response.JSONCreatedResponse(w, map[string]any{"verified": verified}, message)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleUploadKYCDocument attaches a document (multipart field "file") to the requirement in the path,
+// with the data of the requirement's schema as JSON in the multipart field "data", if it has any.
+// The document is kept private in the document store, only its key is saved with the user's KYC data.
+func (h *UserKycDataHandler) HandleUploadKYCDocument(w http.ResponseWriter, r *http.Request) {
+	user := context.ContextGetAuthenticatedUser(r)
+
+	requirement, found, err := h.findRequirement(r.PathValue("id"))
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+	if !requirement.RequiresDocument {
+		response.JSONErrorResponse(w, nil, ErrKYCRequirementNeedsValue.Error(), http.StatusUnprocessableEntity, nil)
+		return
+	}
+
+	// check before storing anything, submit checks again in case of concurrent requests
+	existing, found, err := h.UserKycDataRepo.GetByRequirementId(user.ID, requirement.ID)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if found && existing.Status != repository.KYCDataRejectedStatus {
+		response.JSONErrorResponse(w, nil, ErrKYCDataAlreadySet.Error(), http.StatusForbidden, nil)
+		return
+	}
+
+	// leave some room for the rest of the multipart body
+	r.Body = http.MaxBytesReader(w, r.Body, maxKYCDocumentSize+(1<<20))
+	err = r.ParseMultipartForm(maxKYCDocumentSize)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			h.ErrHandler.FailedValidation(w, r, []string{fmt.Sprintf("File must not be larger than %d MB", maxKYCDocumentSize>>20)})
+			return
 		}
+		h.ErrHandler.BadRequest(w, r, errors.New("invalid request data"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		h.ErrHandler.BadRequest(w, r, errors.New("error retrieving the file"))
+		return
+	}
+	defer upload.Close()
+
+	// the data is checked before the document is stored
+	submission, ok := h.parseSubmission(w, r, requirement, []byte(r.FormValue("data")))
+	if !ok {
+		return
+	}
+
+	var v validator.Validator
+
+	sniffed := make([]byte, 512)
+	n, err := io.ReadFull(upload, sniffed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	contentType := http.DetectContentType(sniffed[:n])
+	extension, allowed := kycDocumentTypes[contentType]
+
+	v.Check(header.Size > 0, "File is empty")
+	v.Check(header.Size <= maxKYCDocumentSize, fmt.Sprintf("File must not be larger than %d MB", maxKYCDocumentSize>>20))
+	v.Check(allowed, "File must be a JPEG or PNG image, or a PDF document")
+	if v.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, v.Errors)
 		return
 	}
 
-	err = h.UserKycDataRepo.Insert(user.ID, input.Value, input.RequirementID)
+	_, err = upload.Seek(0, io.SeekStart)
 	if err != nil {
 		h.ErrHandler.ServerError(w, r, err)
 		return
 	}
 
-	// make attempt to upgrade user kyc level in background
-	h.Helper.BackgroundTask(r, func() error {
-		_, err = h.UserKycDataRepo.UpgradeLevel(user.ID)
+	document := &models.KYCDocument{
+		Key:         fmt.Sprintf("kyc/%s/%s%s", user.ID, uuid.NewString(), extension),
+		FileName:    kycDocumentFileName(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+	}
 
-		if err != nil {
-			log.Printf("Error upgrading user kyc: %v", err)
-			return err
+	err = h.Documents.Save(r.Context(), document.Key, upload, document.ContentType)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	_, _, err = h.submit(user.ID, requirement, submission, document)
+	if err != nil {
+		// nothing links to the document, it must not stay in the store
+		deleteErr := h.Documents.Delete(dctx.Background(), document.Key)
+		if deleteErr != nil {
+			log.Printf("Error deleting unused kyc document %s: %v", document.Key, deleteErr)
 		}
 
-		return nil
-	})
+		if errors.Is(err, ErrKYCDataAlreadySet) {
+			response.JSONErrorResponse(w, nil, err.Error(), http.StatusForbidden, nil)
+			return
+		}
+		if errors.Is(err, ErrKYCIdentityLinked) {
+			response.JSONErrorResponse(w, nil, err.Error(), http.StatusConflict, nil)
+			return
+		}
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
 
-	message := "KYC data saved successfully."
+	message := "Document uploaded successfully."
 	err = response.JSONCreatedResponse(w, nil, message)
 	if err != nil {
 		h.ErrHandler.ServerError(w, r, err)
 	}
 }
+
+// findRequirement loads a requirement by its ID, IDs that are not UUIDs are not found
+func (h *UserKycDataHandler) findRequirement(id string) (*models.KYCLevelRequirement, bool, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, false, nil
+	}
+
+	requirement, found, err := h.KycRequirementRepo.GetOne(id)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	// requirements that are yet to take effect, or were retired, take no data
+	if !requirement.Active(time.Now()) {
+		return nil, false, nil
+	}
+
+	return requirement, true, nil
+}
+
+// parseSubmission checks data against the schema of the requirement and returns the data to store.
+// It writes the error response when the data is not valid.
+func (h *UserKycDataHandler) parseSubmission(w http.ResponseWriter, r *http.Request, requirement *models.KYCLevelRequirement, data []byte) (models.KYCSubmission, bool) {
+	submission, v, err := kyc.Parse(requirement.Schema, data)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return nil, false
+	}
+
+	if v.HasErrors() {
+		h.ErrHandler.FailedValidation(w, r, v.Errors)
+		return nil, false
+	}
+
+	return models.KYCSubmission(submission), true
+}
+
+// submit saves the user's data for a requirement, the data then waits for compliance staff to review it.
+// Data that was rejected can be submitted again, any other data is ErrKYCDataAlreadySet.
+// An identity number another account already submitted is ErrKYCIdentityLinked, or is kept flagged as a duplicate,
+// depending on the duplicate policy.
+// It returns the ID of the user's data, and whether it is a duplicate.
+func (h *UserKycDataHandler) submit(userID string, requirement *models.KYCLevelRequirement, submission models.KYCSubmission, document *models.KYCDocument) (string, bool, error) {
+	kycData, found, err := h.UserKycDataRepo.GetByRequirementId(userID, requirement.ID)
+	if err != nil {
+		return "", false, err
+	}
+
+	if found && kycData.Status != repository.KYCDataRejectedStatus {
+		return "", false, ErrKYCDataAlreadySet
+	}
+
+	fingerprint, err := h.fingerprint(requirement, submission)
+	if err != nil {
+		return "", false, err
+	}
+
+	id := ""
+	if !found {
+		id, err = h.UserKycDataRepo.Insert(userID, requirement.ID, submission, document, fingerprint)
+	} else {
+		var resubmitted bool
+		resubmitted, err = h.UserKycDataRepo.Resubmit(kycData.ID, submission, document, fingerprint)
+		// another request submitted it again in the meantime
+		if err == nil && !resubmitted {
+			err = ErrKYCDataAlreadySet
+		}
+		id = kycData.ID
+	}
+	if errors.Is(err, repository.ErrIdentityLinked) {
+		return "", false, ErrKYCIdentityLinked
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return id, fingerprint != nil && fingerprint.Duplicate, nil
+}
+
+// fingerprint is the fingerprint of the identity number of the submission, nil when it has none.
+// It blocks numbers another account already submitted when the duplicate policy says so, the repository checks them as it saves the data.
+func (h *UserKycDataHandler) fingerprint(requirement *models.KYCLevelRequirement, submission models.KYCSubmission) (*models.KYCFingerprint, error) {
+	number, ok, err := kyc.IdentityNumber(requirement.Schema, submission)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return &models.KYCFingerprint{
+		Value: kyc.Fingerprint(h.Config.IdentityFingerprints.Key, requirement.Schema, number),
+		Block: h.Config.IdentityFingerprints.OnDuplicate == kyc.DuplicateBlock,
+	}, nil
+}
+
+// checkIdentity checks the identity number of the submission id with the identity provider, for BVNs and NINs,
+// and keeps the check with it. A good enough match verifies the submission, which can move the user up a KYC level,
+// anything else leaves it to compliance staff. So does a provider that can't be reached, the submission is kept all the same,
+// and so does a duplicate, however well it matched. It returns whether the submission was verified.
+func (h *UserKycDataHandler) checkIdentity(r *http.Request, user *models.User, id string, requirement *models.KYCLevelRequirement, submission models.KYCSubmission, duplicate bool) (bool, error) {
+	kind, ok := identityKinds[requirement.Schema]
+	if !ok {
+		return false, nil
+	}
+
+	number, _, err := kyc.IdentityNumber(requirement.Schema, submission)
+	if err != nil {
+		return false, err
+	}
+
+	check := &models.IdentityCheck{Provider: h.Identities.Name()}
+
+	owner, err := h.Identities.Lookup(r.Context(), kind, number)
+	switch {
+	case errors.Is(err, identity.ErrIdentityNotFound):
+		check.Outcome = identity.OutcomeNotFound
+	case err != nil:
+		log.Printf("Error checking %s of kyc data %s with %s: %v", kind, id, check.Provider, err)
+		return false, nil
+	default:
+		thresholds := identity.Thresholds{
+			AutoVerifyScore: h.Config.IdentityVerifier.AutoVerifyScore,
+			ReviewScore:     h.Config.IdentityVerifier.ReviewScore,
+		}
+
+		check.Score = identity.Match(owner, user).Score
+		check.Outcome = thresholds.Outcome(check.Score)
+		check.Response = owner.Response
+
+		if duplicate && check.Outcome == identity.OutcomeVerified {
+			check.Outcome = identity.OutcomeReview
+		}
+	}
+
+	verified := check.Outcome == identity.OutcomeVerified
+	recorded, err := h.UserKycDataRepo.RecordIdentityCheck(id, check, verified)
+	if err != nil {
+		return false, err
+	}
+
+	// staff reviewed it in the meantime
+	if !recorded || !verified {
+		return false, nil
+	}
+
+	_, err = h.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      user.ID,
+		Entity:      repository.ActivityLogKYCDataEntity,
+		EntityId:    id,
+		Description: UserActivityLogKYCVerifiedDescription + requirement.Requirement,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	upgraded, err := h.UserKycDataRepo.UpgradeLevel(user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// the new level may lift the balance limit the user's wallets were held for
+	if upgraded {
+		h.Helper.BackgroundTask(nil, func() error {
+			return h.WalletStatus.EnforceUserLimits(user.ID)
+		})
+	}
+
+	return true, nil
+}
+
+func formUserKYCDataResponse(kycData *models.KYCData) *UserKYCDataResponse {
+	data := &UserKYCDataResponse{
+		ID:          kycData.ID,
+		Data:        kycData.Submission,
+		Verified:    kycData.Verified,
+		Status:      kycData.Status,
+		SubmittedAt: kycData.SubmittedAt,
+		CreatedAt:   kycData.CreatedAt,
+		Requirement: KYCRequirementResponseData{
+			ID:               kycData.RequirementID,
+			Requirement:      kycData.Requirement,
+			Schema:           kycData.Schema,
+			RequiresDocument: kycData.RequiresDocument,
+		},
+	}
+
+	if kycData.DocumentKey.Valid {
+		data.Document = &KYCDocumentResponseData{
+			FileName:    kycData.DocumentName.String,
+			ContentType: kycData.DocumentContentType.String,
+			Size:        kycData.DocumentSize.Int64,
+		}
+	}
+	if kycData.ReviewReason.Valid {
+		data.ReviewReason = &kycData.ReviewReason.String
+	}
+	if kycData.ReviewedAt.Valid {
+		data.ReviewedAt = &kycData.ReviewedAt.Time
+	}
+
+	return data
+}
+
+// kycDocumentFileName is the name of an uploaded document as we keep it: without a path, and not too long
+func kycDocumentFileName(name string) string {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." {
+		return "document"
+	}
+
+	if len(name) > 255 {
+		name = name[len(name)-255:]
+	}
+
+	return name
+}
@@ -0,0 +1,369 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/cradoe/morenee/internal/config"
+	"github.com/cradoe/morenee/internal/context"
+	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/file"
+	"github.com/cradoe/morenee/internal/helper"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/response"
+	"github.com/cradoe/morenee/internal/smtp"
+	"github.com/cradoe/morenee/internal/walletstatus"
+	"github.com/google/uuid"
+)
+
+type KYCSubmissionResponseData struct {
+	UserKYCDataResponse
+	UserID            string                     `json:"user_id"`
+	IdentityCheck     *IdentityCheckResponseData `json:"identity_check"`
+	DuplicateIdentity bool                       `json:"duplicate_identity"`
+}
+
+// IdentityCheckResponseData is the check of an identity number with the identity provider, with the provider's response
+type IdentityCheckResponseData struct {
+	Provider  string          `json:"provider"`
+	Score     int16           `json:"score"`
+	Outcome   string          `json:"outcome"`
+	CheckedAt time.Time       `json:"checked_at"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// LinkedAccountsResponseData is an identity number submitted from several accounts, with the accounts.
+// The number itself is never shown, only the requirement it was submitted for.
+type LinkedAccountsResponseData struct {
+	Requirement string                      `json:"requirement"`
+	Accounts    []LinkedAccountResponseData `json:"accounts"`
+}
+
+type LinkedAccountResponseData struct {
+	UserID      string    `json:"user_id"`
+	FirstName   string    `json:"first_name"`
+	LastName    string    `json:"last_name"`
+	Email       string    `json:"email"`
+	KYCDataID   string    `json:"kyc_data_id"`
+	Status      string    `json:"status"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// KycReviewHandler serves the review queue of compliance staff.
+// Users' KYC data waits there until staff approve or reject it with a reason,
+// approved data can move the user up a KYC level, and the user is emailed the outcome either way.
+type KycReviewHandler struct {
+	UserRepo        repository.UserRepository
+	UserKycDataRepo repository.UserKycDataRepository
+	KycRepo         repository.KycRepository
+	ActivityRepo    repository.ActivityRepository
+
+	ErrHandler   *errHandler.ErrorHandler
+	Config       *config.Config
+	Mailer       *smtp.Mailer
+	Helper       *helper.Helper
+	Documents    file.DocumentStore
+	WalletStatus *walletstatus.Manager
+}
+
+func NewKycReviewHandler(handler *KycReviewHandler) *KycReviewHandler {
+	return &KycReviewHandler{
+		UserRepo:        handler.UserRepo,
+		UserKycDataRepo: handler.UserKycDataRepo,
+		KycRepo:         handler.KycRepo,
+		ActivityRepo:    handler.ActivityRepo,
+		ErrHandler:      handler.ErrHandler,
+		Config:          handler.Config,
+		Mailer:          handler.Mailer,
+		Helper:          handler.Helper,
+		Documents:       handler.Documents,
+		WalletStatus:    handler.WalletStatus,
+	}
+}
+
+func (h *KycReviewHandler) HandlePendingSubmissions(w http.ResponseWriter, r *http.Request) {
+	queryValues := retrieveUrlQueryValues(r, h.Config.BusinessTimezone.Location)
+
+	kycDataList, err := h.UserKycDataRepo.GetPending(queryValues.Limit, queryValues.Offset)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := make([]*KYCSubmissionResponseData, len(kycDataList))
+	for i, kycData := range kycDataList {
+		data[i] = formKYCSubmissionResponseData(&kycData)
+	}
+
+	message := "Pending submissions retrieved successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleLinkedAccounts reports the identity numbers (BVN, NIN, ID document number) submitted from more than one account
+func (h *KycReviewHandler) HandleLinkedAccounts(w http.ResponseWriter, r *http.Request) {
+	queryValues := retrieveUrlQueryValues(r, h.Config.BusinessTimezone.Location)
+
+	// a page is limit numbers, with all of their accounts
+	identities, err := h.UserKycDataRepo.GetLinkedAccounts(queryValues.Limit, queryValues.Offset)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	data := []*LinkedAccountsResponseData{}
+	for _, identity := range identities {
+		group := &LinkedAccountsResponseData{Requirement: identity.Accounts[0].Requirement}
+		for _, account := range identity.Accounts {
+			group.Accounts = append(group.Accounts, LinkedAccountResponseData{
+				UserID:      account.UserID,
+				FirstName:   account.FirstName,
+				LastName:    account.LastName,
+				Email:       account.Email,
+				KYCDataID:   account.KYCDataID,
+				Status:      account.Status,
+				SubmittedAt: account.SubmittedAt,
+			})
+		}
+
+		data = append(data, group)
+	}
+
+	message := "Linked accounts retrieved successfully"
+	err = response.JSONOkResponse(w, data, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+func (h *KycReviewHandler) HandleSubmissionDetails(w http.ResponseWriter, r *http.Request) {
+	kycData, found := h.findSubmission(w, r)
+	if !found {
+		return
+	}
+
+	message := "Submission fetched successfully"
+	err := response.JSONOkResponse(w, formKYCSubmissionResponseData(kycData), message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// HandleSubmissionDocument sends the document of a submission, straight from the document store
+func (h *KycReviewHandler) HandleSubmissionDocument(w http.ResponseWriter, r *http.Request) {
+	kycData, found := h.findSubmission(w, r)
+	if !found {
+		return
+	}
+
+	if !kycData.DocumentKey.Valid {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+
+	document, err := h.Documents.Open(r.Context(), kycData.DocumentKey.String)
+	if errors.Is(err, file.ErrDocumentNotFound) {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	defer document.Close()
+
+	// the document is only ever downloaded, browsers must not guess its type or keep a copy
+	w.Header().Set("Content-Type", kycData.DocumentContentType.String)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": kycData.DocumentName.String}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	if kycData.DocumentSize.Valid {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", kycData.DocumentSize.Int64))
+	}
+
+	_, err = io.Copy(w, document)
+	if err != nil {
+		// the response has started, all we can do is log it
+		log.Printf("Error sending kyc document %s: %v", kycData.DocumentKey.String, err)
+	}
+}
+
+func (h *KycReviewHandler) HandleApproveSubmission(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, repository.KYCDataApprovedStatus)
+}
+
+func (h *KycReviewHandler) HandleRejectSubmission(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, repository.KYCDataRejectedStatus)
+}
+
+// review approves or rejects the submission in the path, status is the outcome
+func (h *KycReviewHandler) review(w http.ResponseWriter, r *http.Request, status string) {
+	reason, ok := readReason(w, r, h.ErrHandler)
+	if !ok {
+		return
+	}
+
+	kycData, found := h.findSubmission(w, r)
+	if !found {
+		return
+	}
+
+	reviewer := context.ContextGetAuthenticatedUser(r)
+
+	// staff don't review their own documents
+	if kycData.UserID == reviewer.ID {
+		h.ErrHandler.BadRequest(w, r, ErrCannotActOnSelf)
+		return
+	}
+
+	if kycData.Status != repository.KYCDataPendingStatus {
+		response.JSONErrorResponse(w, nil, fmt.Sprintf("Submission is already %s", kycData.Status), http.StatusConflict, nil)
+		return
+	}
+
+	reviewed, err := h.UserKycDataRepo.Review(kycData.ID, status, reviewer.ID, reason)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	// another member of staff reviewed it in the meantime
+	if !reviewed {
+		response.JSONErrorResponse(w, nil, "Submission has already been reviewed", http.StatusConflict, nil)
+		return
+	}
+
+	description := repository.AdminActivityLogKYCRejectedDescription + kycData.Requirement
+	if status == repository.KYCDataApprovedStatus {
+		description = repository.AdminActivityLogKYCApprovedDescription + kycData.Requirement
+	}
+
+	_, err = h.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      kycData.UserID,
+		Entity:      repository.ActivityLogKYCDataEntity,
+		EntityId:    kycData.ID,
+		Description: description,
+		ActorID:     sql.NullString{String: reviewer.ID, Valid: true},
+		Reason:      sql.NullString{String: reason, Valid: true},
+	})
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	// only verified data counts towards KYC levels, so an approval is the only time the user can move up
+	upgraded := false
+	if status == repository.KYCDataApprovedStatus {
+		upgraded, err = h.UserKycDataRepo.UpgradeLevel(kycData.UserID)
+		if err != nil {
+			h.ErrHandler.ServerError(w, r, err)
+			return
+		}
+	}
+
+	// the new level may lift the balance limit the user's wallets were held for
+	if upgraded {
+		h.Helper.BackgroundTask(r, func() error {
+			return h.WalletStatus.EnforceUserLimits(kycData.UserID)
+		})
+	}
+
+	h.Helper.BackgroundTask(r, func() error {
+		err := h.sendReviewEmail(kycData, status, reason, upgraded)
+		if err != nil {
+			log.Printf("sending kyc review email: %v", err)
+			return err
+		}
+
+		return nil
+	})
+
+	message := fmt.Sprintf("Submission %s successfully", status)
+	err = response.JSONOkResponse(w, map[string]any{"kyc_level_upgraded": upgraded}, message, nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+	}
+}
+
+// sendReviewEmail tells the user the outcome of the review of their submission
+func (h *KycReviewHandler) sendReviewEmail(kycData *models.KYCData, status, reason string, upgraded bool) error {
+	user, found, err := h.UserRepo.GetOne(kycData.UserID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	emailData := h.Helper.NewEmailData()
+	emailData["Name"] = user.FirstName + " " + user.LastName
+	emailData["BankName"] = BankName
+	emailData["Requirement"] = kycData.Requirement
+	emailData["Approved"] = status == repository.KYCDataApprovedStatus
+	emailData["Reason"] = reason
+	emailData["LevelName"] = ""
+
+	if upgraded && user.KYCLevelID.Valid {
+		level, found, err := h.KycRepo.GetOne(fmt.Sprintf("%d", user.KYCLevelID.Int16))
+		if err != nil {
+			return err
+		}
+		if found {
+			emailData["LevelName"] = level.LevelName
+		}
+	}
+
+	return h.Mailer.Send(user.Email, emailData, "kyc-review.tmpl")
+}
+
+// findSubmission loads the submission in the path, it writes the error response when it can't
+func (h *KycReviewHandler) findSubmission(w http.ResponseWriter, r *http.Request) (*models.KYCData, bool) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	kycData, found, err := h.UserKycDataRepo.GetOne(id)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return nil, false
+	}
+
+	if !found {
+		h.ErrHandler.NotFound(w, r)
+		return nil, false
+	}
+
+	return kycData, true
+}
+
+func formKYCSubmissionResponseData(kycData *models.KYCData) *KYCSubmissionResponseData {
+	data := &KYCSubmissionResponseData{
+		UserKYCDataResponse: *formUserKYCDataResponse(kycData),
+		UserID:              kycData.UserID,
+		DuplicateIdentity:   kycData.DuplicateIdentity,
+	}
+
+	if kycData.IdentityCheckedAt.Valid {
+		data.IdentityCheck = &IdentityCheckResponseData{
+			Provider:  kycData.IdentityProvider.String,
+			Score:     kycData.IdentityScore.Int16,
+			Outcome:   kycData.IdentityOutcome.String,
+			CheckedAt: kycData.IdentityCheckedAt.Time,
+			Response:  kycData.IdentityResponse,
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cradoe/morenee/internal/config"
+	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/repository"
+)
+
+// stubLinkedKycDataRepo pages through identities like the repository does, and remembers the page it was asked for
+type stubLinkedKycDataRepo struct {
+	repository.UserKycDataRepository
+	identities []models.LinkedIdentity
+	limit      int
+	offset     int
+}
+
+func (s *stubLinkedKycDataRepo) GetLinkedAccounts(limit, offset int) ([]models.LinkedIdentity, error) {
+	s.limit, s.offset = limit, offset
+
+	page := []models.LinkedIdentity{}
+	for i := offset; i < len(s.identities) && i < offset+limit; i++ {
+		page = append(page, s.identities[i])
+	}
+
+	return page, nil
+}
+
+func TestLinkedAccountsPagesThroughIdentityNumbers(t *testing.T) {
+	accounts := func(fingerprint, requirement string, n int) []models.LinkedKYCAccount {
+		list := []models.LinkedKYCAccount{}
+		for i := 0; i < n; i++ {
+			list = append(list, models.LinkedKYCAccount{Fingerprint: fingerprint, Requirement: requirement, UserID: string(rune('a' + i))})
+		}
+		return list
+	}
+
+	repo := &stubLinkedKycDataRepo{identities: []models.LinkedIdentity{
+		{Fingerprint: "first", Accounts: accounts("first", "bvn", 3)},
+		{Fingerprint: "second", Accounts: accounts("second", "nin", 4)},
+		{Fingerprint: "third", Accounts: accounts("third", "bvn", 2)},
+	}}
+
+	cfg := &config.Config{}
+	cfg.BusinessTimezone.Location = time.UTC
+
+	h := NewKycReviewHandler(&KycReviewHandler{
+		UserKycDataRepo: repo,
+		ErrHandler:      errHandler.New("", nil, slog.New(slog.NewTextHandler(io.Discard, nil))),
+		Config:          cfg,
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/kyc/linked-accounts?limit=1&page=2", nil)
+	w := httptest.NewRecorder()
+	h.HandleLinkedAccounts(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if repo.limit != 1 || repo.offset != 1 {
+		t.Errorf("got limit %d and offset %d, want limit 1 and offset 1", repo.limit, repo.offset)
+	}
+
+	var body struct {
+		Data []LinkedAccountsResponseData `json:"data"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Data) != 1 {
+		t.Fatalf("got %d identity numbers, want 1", len(body.Data))
+	}
+	if body.Data[0].Requirement != "nin" {
+		t.Errorf("got requirement %q, want %q", body.Data[0].Requirement, "nin")
+	}
+	if len(body.Data[0].Accounts) != 4 {
+		t.Errorf("got %d accounts, want all 4 accounts of the number", len(body.Data[0].Accounts))
+	}
+}
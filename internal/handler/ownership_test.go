@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cradoe/morenee/internal/config"
+	"github.com/cradoe/morenee/internal/context"
+	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/repository"
+)
+
+const (
+	ownerWalletID   = "6f1c2b0e-8a4d-4c53-9a59-2f6f1d0b7a11"
+	otherWalletID   = "0b9e7c55-3d2a-4e8f-b1c6-7a2d9e4f5c22"
+	transactionID   = "a3d5e7f9-1b2c-4d6e-8f0a-1c3e5a7b9d33"
+	unknownID       = "c8e1a2b3-4d5f-4a6b-9c7d-8e9f0a1b2c44"
+	ownerUserID     = "owner"
+	otherUserID     = "other"
+	recipientUserID = "recipient"
+)
+
+// the repositories only implement what the read routes use, anything else panics
+type stubWalletRepo struct {
+	repository.WalletRepository
+	wallets map[string]*models.Wallet
+}
+
+func (s *stubWalletRepo) GetOne(id string) (*models.Wallet, bool, error) {
+	wallet, ok := s.wallets[id]
+	return wallet, ok, nil
+}
+
+func (s *stubWalletRepo) Balance(id string) (*models.Wallet, error) {
+	return s.wallets[id], nil
+}
+
+type stubTransactionRepo struct {
+	repository.TransactionRepository
+	transactions map[string]*models.TransactionDetails
+}
+
+func (s *stubTransactionRepo) GetOne(id string, _ *sql.Tx) (*models.TransactionDetails, bool, error) {
+	transaction, ok := s.transactions[id]
+	return transaction, ok, nil
+}
+
+func (s *stubTransactionRepo) FindAllByWalletId(walletID string, _ *repository.FilterTransactionsOptions) ([]*models.TransactionDetails, bool, error) {
+	found := []*models.TransactionDetails{}
+	for _, transaction := range s.transactions {
+		if transaction.SenderWalletID == walletID || transaction.RecipientWalletID == walletID {
+			found = append(found, transaction)
+		}
+	}
+
+	return found, len(found) > 0, nil
+}
+
+func newOwnershipMux() *http.ServeMux {
+	walletRepo := &stubWalletRepo{wallets: map[string]*models.Wallet{
+		ownerWalletID: {ID: ownerWalletID, UserID: ownerUserID, Status: repository.WalletActiveStatus},
+		otherWalletID: {ID: otherWalletID, UserID: otherUserID, Status: repository.WalletActiveStatus},
+	}}
+	transactionRepo := &stubTransactionRepo{transactions: map[string]*models.TransactionDetails{
+		transactionID: {
+			ID:                transactionID,
+			SenderID:          ownerUserID,
+			SenderWalletID:    ownerWalletID,
+			RecipientID:       recipientUserID,
+			RecipientWalletID: "recipient-wallet",
+		},
+	}}
+
+	errorHandler := errHandler.New("", nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cfg := &config.Config{}
+	cfg.BusinessTimezone.Location = time.UTC
+
+	walletHandler := NewWalletHandler(&WalletHandler{
+		WalletRepo: walletRepo,
+		ErrHandler: errorHandler,
+	})
+	transactionHandler := NewTransactionHandler(&TransactionHandler{
+		WalletRepo:      walletRepo,
+		TransactionRepo: transactionRepo,
+		ErrHandler:      errorHandler,
+		Config:          cfg,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /wallets/{id}/details", walletHandler.HandleWalletDetails)
+	mux.HandleFunc("GET /wallets/{id}/balance", walletHandler.HandleWalletBalance)
+	mux.HandleFunc("GET /transactions/{id}", transactionHandler.HandleTransactionDetails)
+	mux.HandleFunc("GET /transactions/wallet/{id}/transactions", transactionHandler.HandleWalletTransactions)
+
+	return mux
+}
+
+func TestReadRoutesOnlyServeTheOwner(t *testing.T) {
+	mux := newOwnershipMux()
+
+	tests := []struct {
+		name   string
+		path   string
+		userID string
+		want   int
+	}{
+		{name: "wallet details of the owner", path: "/wallets/" + ownerWalletID + "/details", userID: ownerUserID, want: http.StatusOK},
+		{name: "wallet details of another user", path: "/wallets/" + otherWalletID + "/details", userID: ownerUserID, want: http.StatusNotFound},
+		{name: "wallet details of a missing wallet", path: "/wallets/" + unknownID + "/details", userID: ownerUserID, want: http.StatusNotFound},
+
+		{name: "wallet balance of the owner", path: "/wallets/" + ownerWalletID + "/balance", userID: ownerUserID, want: http.StatusOK},
+		{name: "wallet balance of another user", path: "/wallets/" + otherWalletID + "/balance", userID: ownerUserID, want: http.StatusNotFound},
+		{name: "wallet balance of a missing wallet", path: "/wallets/" + unknownID + "/balance", userID: ownerUserID, want: http.StatusNotFound},
+
+		{name: "wallet transactions of the owner", path: "/transactions/wallet/" + ownerWalletID + "/transactions", userID: ownerUserID, want: http.StatusOK},
+		{name: "wallet transactions of another user", path: "/transactions/wallet/" + otherWalletID + "/transactions", userID: ownerUserID, want: http.StatusNotFound},
+
+		{name: "transaction of the sender", path: "/transactions/" + transactionID, userID: ownerUserID, want: http.StatusOK},
+		{name: "transaction of the recipient", path: "/transactions/" + transactionID, userID: recipientUserID, want: http.StatusOK},
+		{name: "transaction of another user", path: "/transactions/" + transactionID, userID: otherUserID, want: http.StatusNotFound},
+		{name: "missing transaction", path: "/transactions/" + unknownID, userID: ownerUserID, want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r = context.ContextSetAuthenticatedUser(r, &models.User{ID: tt.userID})
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("GET %s as %s = %d, want %d: %s", tt.path, tt.userID, w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
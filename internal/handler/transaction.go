@@ -13,15 +13,19 @@ import (
 	"time"
 
 	"github.com/cradoe/morenee/internal/cache"
+	"github.com/cradoe/morenee/internal/config"
 	"github.com/cradoe/morenee/internal/context"
 	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/events"
 	"github.com/cradoe/morenee/internal/helper"
 	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/policy"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/request"
 	"github.com/cradoe/morenee/internal/response"
-	"github.com/cradoe/morenee/internal/stream"
 	"github.com/cradoe/morenee/internal/validator"
+	"github.com/cradoe/morenee/internal/walletstatus"
+	"github.com/google/uuid"
 )
 
 var (
@@ -32,9 +36,13 @@ var (
 	ErrInActiveSenderAccount       = errors.New("your account cannot process transaction at this time")
 	ErrInsufficientBalance         = errors.New("insufficient balance")
 	ErrDailyLimitExceeded          = errors.New("daily limit exceeded, upgrade your account")
+	ErrWeeklyLimitExceeded         = errors.New("weekly limit exceeded, upgrade your account")
+	ErrMonthlyLimitExceeded        = errors.New("monthly limit exceeded, upgrade your account")
+	ErrTransferCountLimitExceeded  = errors.New("you have reached the number of transfers allowed for now, upgrade your account")
 	ErrSingleTransferLimitExceeded = errors.New("transfer limit exceeded, upgrade your account")
 	ErrCompleteProfileSetup        = errors.New("setup your bvn and address")
 	ErrRecipientNotFound           = errors.New("recipient not found")
+	ErrRecipientBalanceLimit       = errors.New("the recipient can't receive this amount, it would take their balance over the limit of their account")
 	ErrNoAccountPin                = errors.New("you need to set PIN for your account")
 	ErrDuplicateTransfer           = errors.New("this appears to be a duplicate transaction")
 	ErrInvalidPin                  = errors.New("invalid pin")
@@ -43,37 +51,18 @@ var (
 	ErrInvalidEndDate              = errors.New("invalid end_date format. Use YYYY-MM-DD")
 )
 
-const (
-	// TransactionActivityLogInitiatedDescription is used when a transaction is created and pending completion.
-	TransactionActivityLogInitiatedDescription = "Transaction initiated"
-
-	// TransactionActivityLogDebitDescription is used to log when a sender's wallet is debited successfully.
-	TransactionActivityLogDebitDescription = "Transaction debit"
-
-	// TransactionActivityLogCreditDescription is used to log when a recipient's wallet is credited successfully.
-	TransactionActivityLogCreditDescription = "Transaction credit"
-
-	// TransactionActivityLogFailedDebitDescription is used when a debit operation fails, potentially due to insufficient funds or errors.
-	TransactionActivityLogFailedDebitDescription = "Transaction debit failed"
-
-	// TransactionActivityLogFailedCreditDescription is used to log a failure to credit the recipient’s wallet or account.
-	TransactionActivityLogFailedCreditDescription = "Transaction could not credit recipient"
-
-	// TransactionActivityLogRevertedDescription is used when a previously failed transaction is reversed and the money is credited back to the sender.
-	TransactionActivityLogRevertedDescription = "Transaction reverted"
-
-	// TransactionActivityLogSuccessDescription is used to log the successful completion of a transaction.
-	TransactionActivityLogSuccessDescription = "Transaction success"
-)
-
 const (
 	transferDebitTopic = "transfer.debit"
+
+	// transferHoldDuration is how long funds stay reserved for a transfer before the hold expires,
+	// it comfortably covers the debit worker's retries
+	transferHoldDuration = 30 * time.Minute
 )
 
 type TransactionResponseData struct {
 	ID              string             `json:"id"`
 	ReferenceNumber string             `json:"reference_number"`
-	Amount          float64            `json:"amount"`
+	Amount          models.Money       `json:"amount"`
 	Description     string             `json:"description"`
 	Status          string             `json:"status"`
 	CreatedAt       time.Time          `json:"created_at"`
@@ -82,28 +71,36 @@ type TransactionResponseData struct {
 }
 
 type TransactionHandler struct {
+	DB              *repository.DB
 	TransactionRepo repository.TransactionRepository
 	WalletRepo      repository.WalletRepository
 	KycRepo         repository.KycRepository
 	ActivityRepo    repository.ActivityRepository
-
-	ErrHandler *errHandler.ErrorHandler
-	Cache      *cache.Cache
-	Helper     *helper.Helper
-	Kafka      *stream.KafkaStream
+	OutboxRepo      repository.OutboxRepository
+	HoldRepo        repository.WalletHoldRepository
+
+	ErrHandler   *errHandler.ErrorHandler
+	Cache        *cache.Cache
+	Helper       *helper.Helper
+	Config       *config.Config
+	WalletStatus *walletstatus.Manager
 }
 
 func NewTransactionHandler(handler *TransactionHandler) *TransactionHandler {
 	return &TransactionHandler{
+		DB:              handler.DB,
 		TransactionRepo: handler.TransactionRepo,
 		WalletRepo:      handler.WalletRepo,
 		KycRepo:         handler.KycRepo,
 		ActivityRepo:    handler.ActivityRepo,
+		OutboxRepo:      handler.OutboxRepo,
+		HoldRepo:        handler.HoldRepo,
 
-		ErrHandler: handler.ErrHandler,
-		Cache:      handler.Cache,
-		Helper:     handler.Helper,
-		Kafka:      handler.Kafka,
+		ErrHandler:   handler.ErrHandler,
+		Cache:        handler.Cache,
+		Helper:       handler.Helper,
+		Config:       handler.Config,
+		WalletStatus: handler.WalletStatus,
 	}
 }
 
@@ -119,7 +116,7 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 	type TransferFundsInput struct {
 		SenderWalletID string              `json:"sender_wallet_id"`
 		AccountNumber  string              `json:"account_number"`
-		Amount         float64             `json:"amount"`
+		Amount         models.Money        `json:"amount"`
 		Description    string              `json:"description"`
 		Pin            int                 `json:"pin"`
 		Validator      validator.Validator `json:"-"`
@@ -204,7 +201,7 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 	}
 
 	// Step 2: Validate other input items
-	input.Validator.Check(input.Amount > 0, "Amount is required")
+	input.Validator.Check(input.Amount.IsPositive(), "Amount is required")
 
 	input.Validator.Check(validator.NotBlank(input.SenderWalletID), "Sender wallet id is required")
 	input.Validator.Check(validator.NotBlank(input.AccountNumber), "Recipient account number is required")
@@ -304,8 +301,8 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Check sender wallet status
-	if senderWallet.Status != repository.WalletActiveStatus {
+	// Check sender wallet status, a wallet held for going over its balance limit can still send
+	if !walletstatus.CanSend(senderWallet) {
 		response.JSONErrorResponse(w, nil, ErrInActiveSenderAccount.Error(), http.StatusUnprocessableEntity, nil)
 		return
 	}
@@ -316,8 +313,35 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Check if sender has enough balance
-	if senderWallet.Balance < input.Amount {
+	// the amount is always in the currency of the wallets involved
+	amount := input.Amount.WithCurrency(senderWallet.Currency)
+
+	// Check the recipient can take the amount without going over the balance limit of their KYC level
+	// the credit checks it again, and suspends what would go over when transfers to the recipient got past this check together
+	recipientRoom, err := h.WalletStatus.Room(recipientWallet)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if exceeded, err := amount.GreaterThan(recipientRoom); err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	} else if exceeded {
+		response.JSONErrorResponse(w, nil, ErrRecipientBalanceLimit.Error(), http.StatusUnprocessableEntity, nil)
+		return
+	}
+
+	// Check if sender has enough balance, funds held for other transfers don't count
+	// this is a quick check, the hold placed below is what actually reserves the funds
+	availableBalance, err := senderWallet.AvailableBalance()
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if insufficient, err := availableBalance.LessThan(amount); err != nil {
+		response.JSONErrorResponse(w, nil, ErrIncompatibleWalletCurrency.Error(), http.StatusUnprocessableEntity, nil)
+		return
+	} else if insufficient {
 		response.JSONErrorResponse(w, nil, ErrInsufficientBalance.Error(), http.StatusUnprocessableEntity, nil)
 		return
 	}
@@ -335,6 +359,7 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 	level, kycLevelExists, err := h.KycRepo.GetOne(kycLevelIDStr)
 	if err != nil {
 		h.ErrHandler.ServerError(w, r, err)
+		return
 	}
 	if !kycLevelExists {
 		response.JSONErrorResponse(w, nil, ErrCompleteProfileSetup.Error(), http.StatusUnprocessableEntity, nil)
@@ -344,17 +369,11 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 
 	// check sender kyc to be sure they can transfer this amount
 	// check for single transfer limit
-	if senderKycLevel.SingleTransferLimit < input.Amount {
-		response.JSONErrorResponse(w, nil, ErrSingleTransferLimitExceeded.Error(), http.StatusUnprocessableEntity, nil)
-		return
-	}
-
-	// Check for daily limit
-	if exceeded, err := h.TransactionRepo.HasExceededDailyLimit(senderWallet.ID, input.Amount, senderKycLevel.DailyTransferLimit); err != nil {
+	if exceeded, err := amount.GreaterThan(senderKycLevel.SingleTransferLimit); err != nil {
 		h.ErrHandler.ServerError(w, r, err)
 		return
 	} else if exceeded {
-		response.JSONErrorResponse(w, nil, ErrDailyLimitExceeded.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONErrorResponse(w, nil, ErrSingleTransferLimitExceeded.Error(), http.StatusUnprocessableEntity, nil)
 		return
 	}
 
@@ -364,24 +383,67 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 	// ...
 
 	// Step 5: create a pending transaction and initialize a background worker to handle the rest
+	// The transaction and the event for the debit worker are written in the same database transaction (outbox),
+	// so a transfer can never be left pending without its event, the outbox relay takes it from there.
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	// rolling back after a successful commit is a no-op
+	defer tx.Rollback()
+
+	// Check the daily, weekly and monthly limits
+	// this locks the sender's wallet until the transaction is committed,
+	// so parallel transfers are checked one after the other and can't go over the limits together
+	exceededLimit, err := h.TransactionRepo.ExceededTransferLimit(senderWallet.ID, amount, senderKycLevel, tx)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if exceededLimit != "" {
+		response.JSONErrorResponse(w, nil, transferLimitError(exceededLimit).Error(), http.StatusUnprocessableEntity, nil)
+		return
+	}
+
 	newTrans := &models.Transaction{
 		SenderWalletID:    senderWallet.ID,
 		RecipientWalletID: recipientWallet.ID,
-		Amount:            input.Amount,
+		Amount:            amount,
 		ReferenceNumber:   generateTransactionRef(),
 		Description:       sql.NullString{String: input.Description, Valid: input.Description != ""},
 	}
-	transactionId, err := h.TransactionRepo.Insert(newTrans, nil)
+	transactionId, err := h.TransactionRepo.Insert(newTrans, tx)
 	if err != nil {
 		h.ErrHandler.ServerError(w, r, err)
 		return
 	}
 
-	transactionData, found, err := h.TransactionRepo.GetOne(transactionId)
-	if !found {
+	// reserve the funds until the debit worker captures them
+	held, err := h.HoldRepo.Place(&models.WalletHold{
+		WalletID:      senderWallet.ID,
+		TransactionID: transactionId,
+		Amount:        amount,
+		ExpiresAt:     time.Now().Add(transferHoldDuration),
+	}, tx)
+	if err != nil {
 		h.ErrHandler.ServerError(w, r, err)
 		return
 	}
+	if !held {
+		response.JSONErrorResponse(w, nil, ErrInsufficientBalance.Error(), http.StatusUnprocessableEntity, nil)
+		return
+	}
+
+	transactionData, found, err := h.TransactionRepo.GetOne(transactionId, tx)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+	if !found {
+		h.ErrHandler.ServerError(w, r, errors.New("transaction not found after insert"))
+		return
+	}
 
 	transferRes := formTransactionResponseData(transactionData)
 
@@ -390,6 +452,39 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 		h.ErrHandler.ServerError(w, r, err)
 		return
 	}
+
+	// every event of the transfer is correlated by its transaction
+	event, err := events.New(events.TypeTransferInitiated, transactionId, &events.TransferInitiated{
+		Transfer: *events.TransferFromDetails(transactionData),
+	})
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	eventMessage, err := json.Marshal(event)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	// the debit worker picks this up once the relay has published it
+	// debits are keyed by the sender's wallet, so the wallet's transfers are debited in the order they were made
+	_, err = h.OutboxRepo.Insert(&models.OutboxMessage{
+		Topic:   transferDebitTopic,
+		Key:     sql.NullString{String: senderWallet.ID, Valid: true},
+		Payload: string(eventMessage),
+	}, tx)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
+
 	// save idempotency key to cache for 10 minutes to prevent duplicate retries
 	cacheKey := idempotencyKey
 	cacheExpiration := time.Minute * 10
@@ -400,23 +495,12 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Produce message so that the debit worker can debit the sender
-	h.Helper.BackgroundTask(r, func() error {
-		err := h.Kafka.ProduceMessage(transferDebitTopic, string(jsonMessage))
-		if err != nil {
-			log.Printf("Error producing message: %v", err)
-			return err
-		}
-
-		return nil
-	})
-
 	h.Helper.BackgroundTask(r, func() error {
 		_, err = h.ActivityRepo.Insert(&models.ActivityLog{
 			UserID:      transferRes.Sender.ID,
 			Entity:      repository.ActivityLogTransactionEntity,
 			EntityId:    transferRes.ID,
-			Description: TransactionActivityLogInitiatedDescription,
+			Description: repository.TransactionActivityLogInitiatedDescription,
 		})
 
 		if err != nil {
@@ -435,11 +519,29 @@ func (h *TransactionHandler) HandleTransferMoney(w http.ResponseWriter, r *http.
 }
 
 func (h *TransactionHandler) HandleWalletTransactions(w http.ResponseWriter, r *http.Request) {
+	user := context.ContextGetAuthenticatedUser(r)
+
 	walletId := r.PathValue("id")
+	if _, err := uuid.Parse(walletId); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
 
-	var filterOptions = retrieveUrlQueryValues(r)
+	wallet, found, err := h.WalletRepo.GetOne(walletId)
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
+		return
+	}
 
-	transactions, found, err := h.TransactionRepo.FindAllByWalletId(walletId, &repository.FilterTransactionsOptions{
+	// someone else's wallet is answered as a wallet that doesn't exist
+	if !found || !policy.CanViewWallet(user, wallet) {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+
+	var filterOptions = retrieveUrlQueryValues(r, h.Config.BusinessTimezone.Location)
+
+	transactions, found, err := h.TransactionRepo.FindAllByWalletId(wallet.ID, &repository.FilterTransactionsOptions{
 		StartDate:   filterOptions.StartDate,
 		EndDate:     filterOptions.EndDate,
 		SearchQuery: filterOptions.Search,
@@ -473,19 +575,26 @@ func (h *TransactionHandler) HandleWalletTransactions(w http.ResponseWriter, r *
 }
 
 func (h *TransactionHandler) HandleTransactionDetails(w http.ResponseWriter, r *http.Request) {
-	transactionId := r.PathValue("id")
+	user := context.ContextGetAuthenticatedUser(r)
 
-	transaction, found, err := h.TransactionRepo.GetOne(transactionId)
-	if !found {
+	transactionId := r.PathValue("id")
+	if _, err := uuid.Parse(transactionId); err != nil {
 		h.ErrHandler.NotFound(w, r)
 		return
 	}
 
+	transaction, found, err := h.TransactionRepo.GetOne(transactionId, nil)
 	if err != nil {
 		h.ErrHandler.ServerError(w, r, err)
 		return
 	}
 
+	// a transaction the user is not a party to is answered as a transaction that doesn't exist
+	if !found || !policy.CanViewTransaction(user, transaction) {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+
 	result := formTransactionResponseData(transaction)
 
 	message := "Details fetched successfully"
@@ -528,6 +637,20 @@ func formTransactionResponseData(transaction *models.TransactionDetails) *Transa
 	}
 }
 
+// transferLimitError maps a limit reported by TransactionRepository.ExceededTransferLimit to the error shown to the user
+func transferLimitError(limit string) error {
+	switch limit {
+	case repository.TransferLimitWeeklyAmount:
+		return ErrWeeklyLimitExceeded
+	case repository.TransferLimitMonthlyAmount:
+		return ErrMonthlyLimitExceeded
+	case repository.TransferLimitDailyCount, repository.TransferLimitWeeklyCount, repository.TransferLimitMonthlyCount:
+		return ErrTransferCountLimitExceeded
+	default:
+		return ErrDailyLimitExceeded
+	}
+}
+
 // GenerateTransactionRef generates a unique transaction reference
 // Format: TX-{timestamp}-{randomHex}
 func generateTransactionRef() string {
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
@@ -41,6 +42,10 @@ const (
 	// UserActivityLogLockedAccountDescription is used to log an activity where a user's account has been locked.
 	// This log entry can be triggered due to multiple failed login attempts, security concerns, or manual actions by administrators.
 	UserActivityLogLockedAccountDescription = "Locked account"
+
+	// UserActivityLogKYCVerifiedDescription is used when the identity provider vouched for KYC data, which verified it without a review.
+	// It is followed by the requirement.
+	UserActivityLogKYCVerifiedDescription = "KYC data verified by identity provider: "
 )
 
 type UserResponseData struct {
@@ -51,6 +56,7 @@ type UserResponseData struct {
 	Image       string           `json:"image"`
 	PhoneNumber string           `json:"phone_number"`
 	Gender      string           `json:"gender"`
+	DateOfBirth *string          `json:"date_of_birth"`
 	CreatedAt   time.Time        `json:"created_at"`
 	VerifiedAt  *time.Time       `json:"verified_at"`
 	KYCLevel    *KYCResponseData `json:"kyc_level"`
@@ -185,6 +191,7 @@ func (h *UserHandler) HandleUserProfile(w http.ResponseWriter, r *http.Request)
 		Image:       user.Image.String,
 		PhoneNumber: user.PhoneNumber,
 		Gender:      user.Gender,
+		DateOfBirth: dateOfBirthPointer(user.DateOfBirth),
 		CreatedAt:   user.CreatedAt,
 		VerifiedAt:  verifiedAt,
 	}
@@ -356,3 +363,13 @@ func (h *UserHandler) HandleAddNextOfKin(w http.ResponseWriter, r *http.Request)
 		h.ErrHandler.ServerError(w, r, err)
 	}
 }
+
+// dateOfBirthPointer formats a date of birth as YYYY-MM-DD, users registered before it was asked for have none
+func dateOfBirthPointer(dateOfBirth sql.NullTime) *string {
+	if !dateOfBirth.Valid {
+		return nil
+	}
+
+	formatted := dateOfBirth.Time.Format(time.DateOnly)
+	return &formatted
+}
@@ -8,11 +8,13 @@ import (
 	"github.com/cradoe/morenee/internal/context"
 	"github.com/cradoe/morenee/internal/errHandler"
 	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/policy"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/response"
+	"github.com/google/uuid"
 )
 
-const BankName = "Mornee"
+const BankName = models.BankName
 
 type WalletMiniData struct {
 	ID            string `json:"id"`
@@ -20,13 +22,17 @@ type WalletMiniData struct {
 	BankName      string `json:"bank_name"`
 }
 type WalletResponseData struct {
-	ID            string    `json:"id"`
-	AccountNumber string    `json:"account_number"`
-	BankName      string    `json:"bank_name"`
-	Balance       float64   `json:"balance"`
-	Currency      string    `json:"currency"`
-	Status        string    `json:"status"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            string       `json:"id"`
+	AccountNumber string       `json:"account_number"`
+	BankName      string       `json:"bank_name"`
+	Balance       models.Money `json:"balance"`
+	Currency      string       `json:"currency"`
+	Status        string       `json:"status"`
+	StatusReason  *string      `json:"status_reason"`
+	CreatedAt     time.Time    `json:"created_at"`
+
+	// SuspendedBalance is money sent to the wallet that waits for its balance limit to allow it
+	SuspendedBalance models.Money `json:"suspended_balance"`
 }
 
 type WalletHandler struct {
@@ -70,6 +76,10 @@ func (h *WalletHandler) HandleWalletBalance(w http.ResponseWriter, r *http.Reque
 	user := context.ContextGetAuthenticatedUser((r))
 
 	walletID := r.PathValue("id")
+	if _, err := uuid.Parse(walletID); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
 
 	wallet, err := h.WalletRepo.Balance(walletID)
 	if err != nil {
@@ -77,18 +87,30 @@ func (h *WalletHandler) HandleWalletBalance(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// check if logged in user is the owner of the wallet
-	if user.ID != wallet.UserID {
-		message := "Access denied"
-		response.JSONErrorResponse(w, nil, message, http.StatusForbidden, nil)
+	// someone else's wallet is answered as a wallet that doesn't exist
+	if !policy.CanViewWallet(user, wallet) {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
+
+	availableBalance, err := wallet.AvailableBalance()
+	if err != nil {
+		h.ErrHandler.ServerError(w, r, err)
 		return
 	}
 
 	message := "Balance fetched successfully"
 
+	// the ledger balance is what the wallet holds,
+	// the available balance excludes funds held for transfers that are still being processed,
+	// and the suspended balance is money sent to the wallet that waits for its balance limit to allow it
 	data := map[string]any{
-		"balance":  wallet.Balance,
-		"currency": wallet.Currency,
+		"balance":           wallet.Balance,
+		"ledger_balance":    wallet.Balance,
+		"available_balance": availableBalance,
+		"held_balance":      wallet.HeldBalance,
+		"suspended_balance": wallet.SuspendedBalance,
+		"currency":          wallet.Currency,
 	}
 	err = response.JSONOkResponse(w, data, message, nil)
 
@@ -101,6 +123,10 @@ func (h *WalletHandler) HandleWalletDetails(w http.ResponseWriter, r *http.Reque
 	user := context.ContextGetAuthenticatedUser((r))
 
 	walletID := r.PathValue("id")
+	if _, err := uuid.Parse(walletID); err != nil {
+		h.ErrHandler.NotFound(w, r)
+		return
+	}
 
 	wallet, found, err := h.WalletRepo.GetOne(walletID)
 
@@ -109,29 +135,15 @@ func (h *WalletHandler) HandleWalletDetails(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if !found {
-		response.JSONErrorResponse(w, nil, ErrWalletNotFound.Error(), http.StatusUnprocessableEntity, nil)
-		return
-	}
-
-	// check if logged in user is the owner of the wallet
-	if user.ID != wallet.UserID {
-		message := "Access denied"
-		response.JSONErrorResponse(w, nil, message, http.StatusForbidden, nil)
+	// someone else's wallet is answered as a wallet that doesn't exist
+	if !found || !policy.CanViewWallet(user, wallet) {
+		h.ErrHandler.NotFound(w, r)
 		return
 	}
 
 	message := "Wallet details fetched successfully"
 
-	data := &WalletResponseData{
-		ID:            wallet.ID,
-		Balance:       wallet.Balance,
-		BankName:      BankName,
-		Currency:      wallet.Currency,
-		AccountNumber: wallet.AccountNumber,
-		Status:        wallet.Status,
-		CreatedAt:     wallet.CreatedAt,
-	}
+	data := formWalletResponseData(wallet)
 	err = response.JSONOkResponse(w, data, message, nil)
 
 	if err != nil {
@@ -161,15 +173,7 @@ func (h *WalletHandler) HandleUserWallets(w http.ResponseWriter, r *http.Request
 
 	data := make([]*WalletResponseData, len(wallets))
 	for i, wallet := range wallets {
-		data[i] = &WalletResponseData{
-			ID:            wallet.ID,
-			Balance:       wallet.Balance,
-			BankName:      BankName,
-			Currency:      wallet.Currency,
-			AccountNumber: wallet.AccountNumber,
-			Status:        wallet.Status,
-			CreatedAt:     wallet.CreatedAt,
-		}
+		data[i] = formWalletResponseData(&wallet)
 	}
 
 	err = response.JSONOkResponse(w, data, message, nil)
@@ -177,3 +181,24 @@ func (h *WalletHandler) HandleUserWallets(w http.ResponseWriter, r *http.Request
 		h.ErrHandler.ServerError(w, r, err)
 	}
 }
+
+func formWalletResponseData(wallet *models.Wallet) *WalletResponseData {
+	data := &WalletResponseData{
+		ID:            wallet.ID,
+		Balance:       wallet.Balance,
+		BankName:      BankName,
+		Currency:      wallet.Currency,
+		AccountNumber: wallet.AccountNumber,
+		Status:        wallet.Status,
+		CreatedAt:     wallet.CreatedAt,
+
+		SuspendedBalance: wallet.SuspendedBalance,
+	}
+
+	// why the wallet is on hold
+	if wallet.StatusReason.Valid {
+		data.StatusReason = &wallet.StatusReason.String
+	}
+
+	return data
+}
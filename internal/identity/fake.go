@@ -0,0 +1,59 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// FakeVerifier looks identities up in fixture data, a JSON array of identities with the kind and number they are found by:
+//
+//	[{"kind": "bvn", "number": "22222222222", "first_name": "Ada", "last_name": "Obi", "date_of_birth": "1990-01-31", "phone_number": "08012345678"}]
+//
+// It is meant for development, numbers that are not in the fixtures are not found.
+type FakeVerifier struct {
+	identities map[string]json.RawMessage
+}
+
+func NewFakeVerifier(fixtures []byte) (*FakeVerifier, error) {
+	var entries []json.RawMessage
+	err := json.Unmarshal(fixtures, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity fixtures: %w", err)
+	}
+
+	identities := make(map[string]json.RawMessage, len(entries))
+	for _, entry := range entries {
+		var key struct {
+			Kind   string `json:"kind"`
+			Number string `json:"number"`
+		}
+		err = json.Unmarshal(entry, &key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity fixtures: %w", err)
+		}
+
+		identities[key.Kind+":"+key.Number] = entry
+	}
+
+	return &FakeVerifier{identities: identities}, nil
+}
+
+func (v *FakeVerifier) Name() string {
+	return VerifierFake
+}
+
+func (v *FakeVerifier) Lookup(ctx context.Context, kind, number string) (*Identity, error) {
+	entry, ok := v.identities[kind+":"+number]
+	if !ok {
+		return nil, ErrIdentityNotFound
+	}
+
+	var r record
+	err := json.Unmarshal(entry, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.identity(entry)
+}
@@ -0,0 +1,106 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxResponseSize is the size of the largest response we read from the provider
+const maxResponseSize = 1 << 20 // 1 MB
+
+// record is an identity as providers send it, and as fixtures keep it
+type record struct {
+	FirstName   string `json:"first_name"`
+	MiddleName  string `json:"middle_name"`
+	LastName    string `json:"last_name"`
+	DateOfBirth string `json:"date_of_birth"`
+	PhoneNumber string `json:"phone_number"`
+}
+
+// identity turns the record into an Identity, response is where the record came from
+func (r *record) identity(response json.RawMessage) (*Identity, error) {
+	identity := &Identity{
+		FirstName:   r.FirstName,
+		MiddleName:  r.MiddleName,
+		LastName:    r.LastName,
+		PhoneNumber: r.PhoneNumber,
+		Response:    response,
+	}
+
+	// a missing date of birth doesn't match, rather than failing the whole check
+	if r.DateOfBirth != "" {
+		dateOfBirth, err := time.Parse(time.DateOnly, r.DateOfBirth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date of birth %q: %w", r.DateOfBirth, err)
+		}
+		identity.DateOfBirth = dateOfBirth
+	}
+
+	return identity, nil
+}
+
+// HTTPVerifier calls the identity provider's API: GET <base URL>/<kind>/<number>, authenticated with the API key.
+// The provider answers with the identity as JSON (first_name, middle_name, last_name, date_of_birth as YYYY-MM-DD
+// and phone_number), or 404 when it doesn't know the number.
+type HTTPVerifier struct {
+	name    string
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func NewHTTPVerifier(name, baseURL, apiKey string, timeout time.Duration) *HTTPVerifier {
+	return &HTTPVerifier{
+		name:    name,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (v *HTTPVerifier) Name() string {
+	return v.name
+}
+
+func (v *HTTPVerifier) Lookup(ctx context.Context, kind, number string) (*Identity, error) {
+	endpoint := fmt.Sprintf("%s/%s/%s", v.baseURL, url.PathEscape(kind), url.PathEscape(number))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrIdentityNotFound
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("identity lookup: unexpected status %s", res.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var r record
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		return nil, fmt.Errorf("identity lookup: invalid response: %w", err)
+	}
+
+	return r.identity(body)
+}
@@ -0,0 +1,197 @@
+// The identity verifier looks identity numbers (BVN, NIN) up with an identity provider,
+// and Match scores how well their owner matches the user who submitted them.
+// Two implementations are available, chosen with the IDENTITY_VERIFIER setting:
+//   - HTTPVerifier, which calls the identity provider's API, and is what runs in production
+//   - FakeVerifier, which looks identities up in fixture data, for development
+//
+// Submissions are verified without a review when their score reaches the auto-verify threshold,
+// anything else goes to compliance staff, flagged as a mismatch when its score is below the review threshold.
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cradoe/morenee/internal/models"
+)
+
+const (
+	// VerifierHTTP selects the identity provider's API
+	VerifierHTTP = "http"
+
+	// VerifierFake selects the fake provider backed by fixture data
+	VerifierFake = "fake"
+)
+
+const (
+	// KindBVN is a Bank Verification Number
+	KindBVN = "bvn"
+
+	// KindNIN is a National Identification Number
+	KindNIN = "nin"
+)
+
+const (
+	// OutcomeVerified is the outcome of a check that matched well enough to verify the submission without a review
+	OutcomeVerified = "verified"
+
+	// OutcomeReview is the outcome of a check that partly matched, staff decide
+	OutcomeReview = "review"
+
+	// OutcomeMismatch is the outcome of a check that matched poorly, staff decide, but should look twice
+	OutcomeMismatch = "mismatch"
+
+	// OutcomeNotFound is the outcome of a check of a number the provider doesn't know
+	OutcomeNotFound = "not_found"
+)
+
+// Weight of each field in the score, they add up to 100
+const (
+	nameWeight        = 50
+	dateOfBirthWeight = 25
+	phoneWeight       = 25
+)
+
+var ErrIdentityNotFound = errors.New("identity not found")
+
+// Verifier looks identity numbers up with an identity provider
+type Verifier interface {
+	// Name is the name the provider is recorded with
+	Name() string
+	// Lookup returns the owner of the number, kind is KindBVN or KindNIN.
+	// It returns ErrIdentityNotFound when the provider doesn't know the number.
+	Lookup(ctx context.Context, kind, number string) (*Identity, error)
+}
+
+// Identity is the owner of an identity number, as the provider knows them
+type Identity struct {
+	FirstName   string
+	MiddleName  string
+	LastName    string
+	DateOfBirth time.Time
+	PhoneNumber string
+
+	// Response is the provider's response as it was
+	Response json.RawMessage
+}
+
+// Thresholds decide what is done with a check from its score
+type Thresholds struct {
+	// AutoVerifyScore is the lowest score that verifies a submission without a review
+	AutoVerifyScore int
+	// ReviewScore is the lowest score that is not flagged as a mismatch
+	ReviewScore int
+}
+
+// Outcome is what is done with a check that scored score
+func (t Thresholds) Outcome(score int) string {
+	switch {
+	case score >= t.AutoVerifyScore:
+		return OutcomeVerified
+	case score >= t.ReviewScore:
+		return OutcomeReview
+	default:
+		return OutcomeMismatch
+	}
+}
+
+// Result is how well the owner of an identity number matches a user
+type Result struct {
+	// Score goes from 0, nothing matched, to 100, everything did
+	Score       int
+	Name        bool
+	DateOfBirth bool
+	PhoneNumber bool
+}
+
+// Match scores how well the identity matches the user.
+// The user's first and last names must both be among the identity's names, in any order, for the name to match,
+// half of the name's weight is given when only one of them is.
+func Match(identity *Identity, user *models.User) Result {
+	var result Result
+
+	names := map[string]bool{}
+	for _, name := range []string{identity.FirstName, identity.MiddleName, identity.LastName} {
+		for _, part := range strings.Fields(normalizeName(name)) {
+			names[part] = true
+		}
+	}
+
+	firstName := hasName(names, user.FirstName)
+	lastName := hasName(names, user.LastName)
+	switch {
+	case firstName && lastName:
+		result.Name = true
+		result.Score += nameWeight
+	case firstName || lastName:
+		result.Score += nameWeight / 2
+	}
+
+	if user.DateOfBirth.Valid && !identity.DateOfBirth.IsZero() {
+		y1, m1, d1 := user.DateOfBirth.Time.Date()
+		y2, m2, d2 := identity.DateOfBirth.Date()
+		if y1 == y2 && m1 == m2 && d1 == d2 {
+			result.DateOfBirth = true
+			result.Score += dateOfBirthWeight
+		}
+	}
+
+	userPhone := nationalNumber(user.PhoneNumber)
+	if userPhone != "" && userPhone == nationalNumber(identity.PhoneNumber) {
+		result.PhoneNumber = true
+		result.Score += phoneWeight
+	}
+
+	return result
+}
+
+// hasName reports whether every part of name is among names
+func hasName(names map[string]bool, name string) bool {
+	parts := strings.Fields(normalizeName(name))
+	if len(parts) == 0 {
+		return false
+	}
+
+	for _, part := range parts {
+		if !names[part] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeName lower-cases a name and drops what isn't a letter or a space, "O'Neil-Ade" is "oneilade"
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r):
+			return unicode.ToLower(r)
+		case unicode.IsSpace(r):
+			return ' '
+		default:
+			return -1
+		}
+	}, strings.TrimSpace(name))
+}
+
+// nationalNumber is the last 10 digits of a phone number,
+// so "+2348012345678" and "08012345678" are the same number
+func nationalNumber(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	if len(digits) < 10 {
+		return ""
+	}
+
+	return digits[len(digits)-10:]
+}
@@ -0,0 +1,286 @@
+// Every KYC requirement declares a schema, the shape of the data users submit for it:
+// a BVN, an address, the details of an ID document, and so on.
+// Submissions are checked against their requirement's schema, and stored as the JSON object the schema describes,
+// with their values cleaned up (trimmed, upper-cased where it matters), so staff review data that is already consistent.
+// The identity numbers they carry are fingerprinted, so a number used by several accounts can be found.
+package kyc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cradoe/morenee/internal/validator"
+)
+
+const (
+	// SchemaText is a single free-text value, for requirements nothing more specific fits
+	SchemaText = "text"
+
+	// SchemaBVN is a Bank Verification Number
+	SchemaBVN = "bvn"
+
+	// SchemaNIN is a National Identification Number
+	SchemaNIN = "nin"
+
+	// SchemaAddress is a postal address
+	SchemaAddress = "address"
+
+	// SchemaIDDocument is the type, number and expiry date of an ID document, which is uploaded with them
+	SchemaIDDocument = "id_document"
+
+	// SchemaDocument is an uploaded document and nothing else, a utility bill for example
+	SchemaDocument = "document"
+
+	// SchemaEmployment is what the user does for a living, and who for
+	SchemaEmployment = "employment"
+)
+
+const (
+	// DuplicateBlock refuses submissions whose identity number is already used by another account
+	DuplicateBlock = "block"
+
+	// DuplicateReview keeps submissions whose identity number is already used by another account,
+	// flagged for compliance staff, and never verified without a review
+	DuplicateReview = "review"
+)
+
+// Date format of the dates in submissions
+const DateFormat = time.DateOnly
+
+var (
+	ErrUnknownSchema = errors.New("unknown KYC schema")
+
+	rgxElevenDigits   = regexp.MustCompile(`^\d{11}$`)
+	rgxPostcode       = regexp.MustCompile(`^\d{6}$`)
+	rgxDocumentNumber = regexp.MustCompile(`^[A-Z0-9]{5,20}$`)
+)
+
+// IDDocumentTypes are the ID documents users can submit
+var IDDocumentTypes = []string{"passport", "drivers_license", "national_id"}
+
+// EmploymentStatuses are the employment statuses users can choose from
+var EmploymentStatuses = []string{"employed", "self_employed", "unemployed", "student", "retired"}
+
+// submission is the data of a schema. clean tidies the values up, then validate checks them.
+type submission interface {
+	clean()
+	validate(v *validator.Validator)
+}
+
+var schemas = map[string]func() submission{
+	SchemaText:       func() submission { return &Text{} },
+	SchemaBVN:        func() submission { return &BVN{} },
+	SchemaNIN:        func() submission { return &NIN{} },
+	SchemaAddress:    func() submission { return &Address{} },
+	SchemaIDDocument: func() submission { return &IDDocument{} },
+	SchemaDocument:   func() submission { return &Document{} },
+	SchemaEmployment: func() submission { return &Employment{} },
+}
+
+// Schemas lists every schema
+func Schemas() []string {
+	return []string{SchemaText, SchemaBVN, SchemaNIN, SchemaAddress, SchemaIDDocument, SchemaDocument, SchemaEmployment}
+}
+
+// IsSchema reports whether schema is a known schema
+func IsSchema(schema string) bool {
+	_, ok := schemas[schema]
+	return ok
+}
+
+// Parse checks data against schema. It returns the data to store when it is valid,
+// and what is wrong with it in the validator when it isn't.
+// An empty data is an empty object, which is what documents without fields are submitted with.
+func Parse(schema string, data []byte) (json.RawMessage, validator.Validator, error) {
+	var v validator.Validator
+
+	newSubmission, ok := schemas[schema]
+	if !ok {
+		return nil, v, fmt.Errorf("%w: %q", ErrUnknownSchema, schema)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("{}")
+	}
+
+	s := newSubmission()
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(s)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("data must only contain a single JSON object")
+	}
+	if err != nil {
+		v.AddError(fmt.Sprintf("Data is not a valid %s submission: %v", schema, err))
+		return nil, v, nil
+	}
+
+	s.clean()
+	s.validate(&v)
+	if v.HasErrors() {
+		return nil, v, nil
+	}
+
+	cleaned, err := json.Marshal(s)
+	if err != nil {
+		return nil, v, err
+	}
+
+	return cleaned, v, nil
+}
+
+type Text struct {
+	Value string `json:"value"`
+}
+
+func (s *Text) clean() {
+	s.Value = strings.TrimSpace(s.Value)
+}
+
+func (s *Text) validate(v *validator.Validator) {
+	v.Check(validator.NotBlank(s.Value), "Value is required")
+	v.Check(validator.MaxRunes(s.Value, 500), "Value must not be more than 500 characters")
+}
+
+type BVN struct {
+	BVN string `json:"bvn"`
+}
+
+func (s *BVN) clean() {
+	s.BVN = strings.TrimSpace(s.BVN)
+}
+
+func (s *BVN) validate(v *validator.Validator) {
+	v.Check(validator.Matches(s.BVN, rgxElevenDigits), "BVN should be 11 digits")
+}
+
+type NIN struct {
+	NIN string `json:"nin"`
+}
+
+func (s *NIN) clean() {
+	s.NIN = strings.TrimSpace(s.NIN)
+}
+
+func (s *NIN) validate(v *validator.Validator) {
+	v.Check(validator.Matches(s.NIN, rgxElevenDigits), "NIN should be 11 digits")
+}
+
+type Address struct {
+	Street   string `json:"street"`
+	City     string `json:"city"`
+	State    string `json:"state"`
+	Postcode string `json:"postcode"`
+}
+
+func (s *Address) clean() {
+	s.Street = strings.TrimSpace(s.Street)
+	s.City = strings.TrimSpace(s.City)
+	s.State = strings.TrimSpace(s.State)
+	s.Postcode = strings.TrimSpace(s.Postcode)
+}
+
+func (s *Address) validate(v *validator.Validator) {
+	v.Check(validator.NotBlank(s.Street), "Street is required")
+	v.Check(validator.MaxRunes(s.Street, 200), "Street must not be more than 200 characters")
+	v.Check(validator.NotBlank(s.City), "City is required")
+	v.Check(validator.MaxRunes(s.City, 100), "City must not be more than 100 characters")
+	v.Check(validator.NotBlank(s.State), "State is required")
+	v.Check(validator.MaxRunes(s.State, 100), "State must not be more than 100 characters")
+	v.Check(validator.Matches(s.Postcode, rgxPostcode), "Postcode should be 6 digits")
+}
+
+type IDDocument struct {
+	DocumentType   string `json:"document_type"`
+	DocumentNumber string `json:"document_number"`
+	ExpiryDate     string `json:"expiry_date"`
+}
+
+func (s *IDDocument) clean() {
+	s.DocumentType = strings.ToLower(strings.TrimSpace(s.DocumentType))
+	s.DocumentNumber = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(s.DocumentNumber), " ", ""))
+	s.ExpiryDate = strings.TrimSpace(s.ExpiryDate)
+}
+
+func (s *IDDocument) validate(v *validator.Validator) {
+	v.Check(validator.In(s.DocumentType, IDDocumentTypes...), fmt.Sprintf("Document type must be one of %s", strings.Join(IDDocumentTypes, ", ")))
+	v.Check(validator.Matches(s.DocumentNumber, rgxDocumentNumber), "Document number should be 5 to 20 letters or digits")
+
+	expiry, err := time.Parse(DateFormat, s.ExpiryDate)
+	if err != nil {
+		v.AddError("Expiry date should be a date, YYYY-MM-DD")
+		return
+	}
+	v.Check(expiry.After(time.Now()), "Document has expired")
+}
+
+// Document has no fields, the document is the submission
+type Document struct{}
+
+func (s *Document) clean() {}
+
+func (s *Document) validate(v *validator.Validator) {}
+
+type Employment struct {
+	EmploymentStatus string `json:"employment_status"`
+	Occupation       string `json:"occupation"`
+	EmployerName     string `json:"employer_name,omitempty"`
+	EmployerAddress  string `json:"employer_address,omitempty"`
+}
+
+func (s *Employment) clean() {
+	s.EmploymentStatus = strings.ToLower(strings.TrimSpace(s.EmploymentStatus))
+	s.Occupation = strings.TrimSpace(s.Occupation)
+	s.EmployerName = strings.TrimSpace(s.EmployerName)
+	s.EmployerAddress = strings.TrimSpace(s.EmployerAddress)
+}
+
+func (s *Employment) validate(v *validator.Validator) {
+	v.Check(validator.In(s.EmploymentStatus, EmploymentStatuses...), fmt.Sprintf("Employment status must be one of %s", strings.Join(EmploymentStatuses, ", ")))
+	v.Check(validator.NotBlank(s.Occupation), "Occupation is required")
+	v.Check(validator.MaxRunes(s.Occupation, 100), "Occupation must not be more than 100 characters")
+	v.Check(s.EmploymentStatus != "employed" || validator.NotBlank(s.EmployerName), "Employer name is required when employed")
+	v.Check(validator.MaxRunes(s.EmployerName, 200), "Employer name must not be more than 200 characters")
+	v.Check(validator.MaxRunes(s.EmployerAddress, 200), "Employer address must not be more than 200 characters")
+}
+
+// IdentityNumber is the number that identifies the person a submission is about: a BVN, a NIN, or the number of an ID document.
+// ok is false for schemas that don't carry one. data is a submission Parse returned.
+func IdentityNumber(schema string, data []byte) (number string, ok bool, err error) {
+	switch schema {
+	case SchemaBVN:
+		var s BVN
+		err = json.Unmarshal(data, &s)
+		return s.BVN, err == nil, err
+	case SchemaNIN:
+		var s NIN
+		err = json.Unmarshal(data, &s)
+		return s.NIN, err == nil, err
+	case SchemaIDDocument:
+		var s IDDocument
+		err = json.Unmarshal(data, &s)
+		return s.DocumentNumber, err == nil, err
+	default:
+		return "", false, nil
+	}
+}
+
+// Fingerprint is a keyed hash (HMAC-SHA256) of the identity number of a submission of schema, see IdentityNumber.
+// The same number always has the same fingerprint, so numbers used by several accounts can be found,
+// but the number can't be found from its fingerprint without the key.
+func Fingerprint(key, schema, number string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(schema + ":" + number))
+	return hex.EncodeToString(mac.Sum(nil))
+}
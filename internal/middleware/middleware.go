@@ -10,6 +10,7 @@ import (
 	"github.com/cradoe/morenee/internal/config"
 	"github.com/cradoe/morenee/internal/context"
 	"github.com/cradoe/morenee/internal/errHandler"
+	"github.com/cradoe/morenee/internal/rbac"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/response"
 
@@ -129,3 +130,18 @@ func (mid *Middleware) RequireAuthenticatedUser(next http.Handler) http.Handler
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequirePermission only lets authenticated users whose role has the permission through,
+// everyone else gets 403 Forbidden (or 401 Unauthorized when not authenticated)
+func (mid *Middleware) RequirePermission(permission rbac.Permission, next http.Handler) http.Handler {
+	return mid.RequireAuthenticatedUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticatedUser := context.ContextGetAuthenticatedUser(r)
+
+		if !rbac.Can(authenticatedUser.Role, permission) {
+			mid.errHandler.NotPermitted(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
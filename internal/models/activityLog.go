@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
 
 type ActivityLog struct {
 	ID          string    `db:"id"`
@@ -9,4 +12,8 @@ type ActivityLog struct {
 	EntityId    string    `db:"entity_id"`
 	Description string    `db:"description"`
 	CreatedAt   time.Time `db:"created_at"`
+
+	// ActorID and Reason are set when a member of staff took the action, the user is who it was taken for
+	ActorID sql.NullString `db:"actor_id"`
+	Reason  sql.NullString `db:"reason"`
 }
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type DeadLetter struct {
+	ID            string         `db:"id"`
+	Topic         string         `db:"topic"`
+	MessageKey    sql.NullString `db:"message_key"`
+	Payload       string         `db:"payload"`
+	Headers       MessageHeaders `db:"headers"`
+	Error         string         `db:"error"`
+	Attempts      int            `db:"attempts"`
+	TransactionID sql.NullString `db:"transaction_id"`
+	Status        string         `db:"status"`
+	ReplayCount   int            `db:"replay_count"`
+	CreatedAt     time.Time      `db:"created_at"`
+	HandledAt     sql.NullTime   `db:"handled_at"`
+	ReplayedAt    sql.NullTime   `db:"replayed_at"`
+}
+
+// MessageHeaders are the headers of a Kafka message, stored as a JSON object
+type MessageHeaders map[string]string
+
+func (h MessageHeaders) Value() (driver.Value, error) {
+	if h == nil {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (h *MessageHeaders) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		*h = MessageHeaders{}
+		return nil
+	case []byte:
+		return json.Unmarshal(value, h)
+	case string:
+		return json.Unmarshal([]byte(value), h)
+	default:
+		return fmt.Errorf("cannot scan %T into MessageHeaders", src)
+	}
+}
@@ -1,30 +1,180 @@
 package models
 
-import "time"
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
+// KYCLevel is a level with the limits in effect, those of its version that took effect last (see KYCLevelVersion)
 type KYCLevel struct {
-	ID                  string                `db:"id"`
-	LevelName           string                `db:"level_name"`
-	DailyTransferLimit  float64               `db:"daily_transfer_limit"`
-	WalletBalanceLimit  float64               `db:"wallet_balance_limit"`
-	SingleTransferLimit float64               `db:"single_transfer_limit"`
-	RequirementID       string                `db:"requirement_id"`
-	Requirement         string                `db:"requirement"`
-	Requirements        []KYCLevelRequirement `db:"requirements"`
+	ID                   string                `db:"id"`
+	LevelName            string                `db:"level_name"`
+	VersionID            string                `db:"version_id"`
+	Version              int                   `db:"version"`
+	EffectiveFrom        time.Time             `db:"effective_from"`
+	DailyTransferLimit   Money                 `db:"daily_transfer_limit"`
+	WalletBalanceLimit   Money                 `db:"wallet_balance_limit"`
+	SingleTransferLimit  Money                 `db:"single_transfer_limit"`
+	LimitWindow          string                `db:"limit_window"`
+	WeeklyTransferLimit  NullMoney             `db:"weekly_transfer_limit"`
+	MonthlyTransferLimit NullMoney             `db:"monthly_transfer_limit"`
+	DailyTransferCount   sql.NullInt32         `db:"daily_transfer_count"`
+	WeeklyTransferCount  sql.NullInt32         `db:"weekly_transfer_count"`
+	MonthlyTransferCount sql.NullInt32         `db:"monthly_transfer_count"`
+	RequirementID        string                `db:"requirement_id"`
+	Requirement          string                `db:"requirement"`
+	Requirements         []KYCLevelRequirement `db:"requirements"`
 }
 
+// KYCLevelVersion is a set of limits of a level, in effect from EffectiveFrom until a later version takes effect
+type KYCLevelVersion struct {
+	ID                   string         `db:"id"`
+	KYCLevelID           string         `db:"kyc_level_id"`
+	Version              int            `db:"version"`
+	SingleTransferLimit  Money          `db:"single_transfer_limit"`
+	DailyTransferLimit   Money          `db:"daily_transfer_limit"`
+	WalletBalanceLimit   Money          `db:"wallet_balance_limit"`
+	LimitWindow          string         `db:"limit_window"`
+	WeeklyTransferLimit  NullMoney      `db:"weekly_transfer_limit"`
+	MonthlyTransferLimit NullMoney      `db:"monthly_transfer_limit"`
+	DailyTransferCount   sql.NullInt32  `db:"daily_transfer_count"`
+	WeeklyTransferCount  sql.NullInt32  `db:"weekly_transfer_count"`
+	MonthlyTransferCount sql.NullInt32  `db:"monthly_transfer_count"`
+	EffectiveFrom        time.Time      `db:"effective_from"`
+	CreatedBy            sql.NullString `db:"created_by"`
+	Reason               sql.NullString `db:"reason"`
+	CreatedAt            time.Time      `db:"created_at"`
+}
+
+// KYCLevelRequirement is a requirement of a level, it counts towards the level from EffectiveFrom until it is retired
 type KYCLevelRequirement struct {
-	ID          string `db:"id"`
-	Requirement string `db:"requirement"`
+	ID               string       `db:"id"`
+	KYCLevelID       string       `db:"kyc_level_id"`
+	Requirement      string       `db:"requirement"`
+	Schema           string       `db:"schema"`
+	RequiresDocument bool         `db:"requires_document"`
+	Version          int          `db:"version"`
+	EffectiveFrom    time.Time    `db:"effective_from"`
+	RetiredAt        sql.NullTime `db:"retired_at"`
+}
+
+// Active reports whether the requirement counts towards its level at t
+func (r *KYCLevelRequirement) Active(t time.Time) bool {
+	return !r.EffectiveFrom.After(t) && (!r.RetiredAt.Valid || r.RetiredAt.Time.After(t))
+}
+
+// KYCDocument is a document a user sent for a requirement, Key is where the document store keeps it
+type KYCDocument struct {
+	Key         string
+	FileName    string
+	ContentType string
+	Size        int64
 }
 
 type KYCData struct {
-	ID             string    `db:"id"`
-	UserID         string    `db:"user_id"`
-	SubmissionData string    `db:"submission_data"`
-	Verified       bool      `db:"verified"`
-	CreatedAt      time.Time `db:"created_at"`
-	RequirementID  string    `db:"kyc_requirement_id"`
-
-	Requirement string `db:"requirement"`
+	ID            string         `db:"id"`
+	UserID        string         `db:"user_id"`
+	Submission    KYCSubmission  `db:"submission"`
+	Verified      bool           `db:"verified"`
+	Status        string         `db:"status"`
+	SubmittedAt   time.Time      `db:"submitted_at"`
+	ReviewedBy    sql.NullString `db:"reviewed_by"`
+	ReviewReason  sql.NullString `db:"review_reason"`
+	ReviewedAt    sql.NullTime   `db:"reviewed_at"`
+	CreatedAt     time.Time      `db:"created_at"`
+	RequirementID string         `db:"kyc_requirement_id"`
+
+	DocumentKey         sql.NullString `db:"document_key"`
+	DocumentName        sql.NullString `db:"document_name"`
+	DocumentContentType sql.NullString `db:"document_content_type"`
+	DocumentSize        sql.NullInt64  `db:"document_size"`
+
+	// the check of an identity number with the identity provider, see internal/identity
+	IdentityProvider  sql.NullString `db:"identity_provider"`
+	IdentityScore     sql.NullInt16  `db:"identity_score"`
+	IdentityOutcome   sql.NullString `db:"identity_outcome"`
+	IdentityResponse  []byte         `db:"identity_response"`
+	IdentityCheckedAt sql.NullTime   `db:"identity_checked_at"`
+
+	IdentityFingerprint sql.NullString `db:"identity_fingerprint"`
+	DuplicateIdentity   bool           `db:"duplicate_identity"`
+
+	Requirement      string `db:"requirement"`
+	Schema           string `db:"schema"`
+	RequiresDocument bool   `db:"requires_document"`
+}
+
+// IdentityCheck is the outcome of the check of an identity number, Response is the provider's response as it was
+type IdentityCheck struct {
+	Provider string
+	Score    int
+	Outcome  string
+	Response []byte
+}
+
+// KYCFingerprint is the fingerprint of the identity number of KYC data (see kyc.Fingerprint),
+// Block refuses the number when another account already used it, otherwise Duplicate is set to true once saved
+type KYCFingerprint struct {
+	Value     string
+	Block     bool
+	Duplicate bool
+}
+
+// LinkedIdentity is an identity number, by its fingerprint, submitted from more than one account, with every account that submitted it
+type LinkedIdentity struct {
+	Fingerprint string
+	Accounts    []LinkedKYCAccount
+}
+
+// LinkedKYCAccount is an account that submitted an identity number another account submitted too
+type LinkedKYCAccount struct {
+	Fingerprint string    `db:"identity_fingerprint"`
+	Requirement string    `db:"requirement"`
+	KYCDataID   string    `db:"kyc_data_id"`
+	Status      string    `db:"status"`
+	SubmittedAt time.Time `db:"submitted_at"`
+	UserID      string    `db:"user_id"`
+	FirstName   string    `db:"first_name"`
+	LastName    string    `db:"last_name"`
+	Email       string    `db:"email"`
+}
+
+// KYCSubmission is the data a user submitted for a requirement,
+// a JSON object shaped by the requirement's schema (see internal/kyc)
+type KYCSubmission json.RawMessage
+
+func (s KYCSubmission) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return "{}", nil
+	}
+
+	return string(s), nil
+}
+
+func (s *KYCSubmission) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		*s = KYCSubmission("{}")
+		return nil
+	case []byte:
+		// the driver may reuse the bytes, keep a copy
+		*s = append(KYCSubmission(nil), value...)
+		return nil
+	case string:
+		*s = KYCSubmission(value)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into KYCSubmission", src)
+	}
+}
+
+func (s KYCSubmission) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return []byte("{}"), nil
+	}
+
+	return s, nil
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Journal struct {
+	ID            string         `db:"id"`
+	TransactionID sql.NullString `db:"transaction_id"`
+	Kind          string         `db:"kind"`
+	Description   string         `db:"description"`
+	CreatedAt     time.Time      `db:"created_at"`
+
+	Postings []Posting `db:"-"`
+}
+
+// Posting is a single debit or credit leg of a journal.
+// A leg targets either a wallet account (WalletID) or a system account (AccountCode).
+type Posting struct {
+	ID          string    `db:"id"`
+	JournalID   string    `db:"journal_id"`
+	AccountID   string    `db:"account_id"`
+	WalletID    string    `db:"wallet_id"`
+	AccountCode string    `db:"code"`
+	Direction   string    `db:"direction"`
+	Amount      Money     `db:"amount"`
+	CreatedAt   time.Time `db:"created_at"`
+}
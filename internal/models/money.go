@@ -0,0 +1,342 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency assumed for amounts that don't carry one,
+// such as the DECIMAL(15,2) amount columns in the database.
+const DefaultCurrency = "NGN"
+
+var (
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+	ErrMoneyOverflow    = errors.New("amount is too large")
+	ErrInvalidAmount    = errors.New("invalid amount, use a number with at most two decimal places")
+)
+
+// Money is an amount held as an integer number of minor units (kobo for NGN) plus its currency code.
+// Amounts are never stored as floats, so rounding drift can't leak into balances or limit checks.
+//
+// In JSON, Money is written as a plain number in major units (e.g. 1500.50), which keeps the API unchanged.
+// In SQL, it is read from and written to DECIMAL(15,2) columns exactly, via its decimal text.
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+func NewMoney(minor int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	return Money{Minor: minor, Currency: currency}
+}
+
+// ParseMoney parses a decimal string such as "1500", "1500.5" or "-20.05" without going through float64
+func ParseMoney(value string, currency string) (Money, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Money{}, ErrInvalidAmount
+	}
+
+	negative := false
+	if value[0] == '-' || value[0] == '+' {
+		negative = value[0] == '-'
+		value = value[1:]
+	}
+
+	whole, fraction, hasFraction := strings.Cut(value, ".")
+	if whole == "" && (!hasFraction || fraction == "") {
+		return Money{}, ErrInvalidAmount
+	}
+	if len(fraction) > 2 {
+		// DECIMAL columns may come back padded with zeros, those are safe to drop
+		if strings.Trim(fraction[2:], "0") != "" {
+			return Money{}, ErrInvalidAmount
+		}
+		fraction = fraction[:2]
+	}
+
+	for _, part := range []string{whole, fraction} {
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return Money{}, ErrInvalidAmount
+			}
+		}
+	}
+
+	var units int64
+	if whole != "" {
+		parsed, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil {
+			return Money{}, ErrMoneyOverflow
+		}
+		units = parsed
+	}
+
+	var cents int64
+	if fraction != "" {
+		fraction += strings.Repeat("0", 2-len(fraction))
+		parsed, _ := strconv.ParseInt(fraction, 10, 64)
+		cents = parsed
+	}
+
+	if units > (math.MaxInt64-cents)/100 {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	minor := units*100 + cents
+	if negative {
+		minor = -minor
+	}
+
+	return NewMoney(minor, currency), nil
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+
+	return m.Currency
+}
+
+func (m Money) sameCurrency(other Money) error {
+	if m.currency() != other.currency() {
+		return ErrCurrencyMismatch
+	}
+
+	return nil
+}
+
+// WithCurrency returns the same amount expressed in the given currency
+func (m Money) WithCurrency(currency string) Money {
+	return NewMoney(m.Minor, currency)
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+
+	if (other.Minor > 0 && m.Minor > math.MaxInt64-other.Minor) ||
+		(other.Minor < 0 && m.Minor < math.MinInt64-other.Minor) {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	return NewMoney(m.Minor+other.Minor, m.currency()), nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Minor == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	return m.Add(other.Neg())
+}
+
+func (m Money) Neg() Money {
+	return NewMoney(-m.Minor, m.currency())
+}
+
+// Cmp returns -1, 0 or +1 depending on whether m is less than, equal to or greater than other
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case m.Minor < other.Minor:
+		return -1, nil
+	case m.Minor > other.Minor:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (m Money) LessThan(other Money) (bool, error) {
+	c, err := m.Cmp(other)
+	return c < 0, err
+}
+
+func (m Money) GreaterThan(other Money) (bool, error) {
+	c, err := m.Cmp(other)
+	return c > 0, err
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Minor > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Minor < 0
+}
+
+// Decimal formats the amount in major units with exactly two decimal places, e.g. "1500.50"
+func (m Money) Decimal() string {
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign = "-"
+	}
+
+	units := minor / 100
+	cents := minor % 100
+	if units < 0 {
+		units = -units
+	}
+	if cents < 0 {
+		cents = -cents
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+}
+
+// String formats the amount for people, e.g. "NGN 1,500.50". It is what email templates render.
+func (m Money) String() string {
+	decimal := m.Decimal()
+
+	sign := ""
+	if strings.HasPrefix(decimal, "-") {
+		sign = "-"
+		decimal = decimal[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(decimal, ".")
+
+	var grouped strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(r)
+	}
+
+	return fmt.Sprintf("%s %s%s.%s", m.currency(), sign, grouped.String(), fraction)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.Decimal()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string.
+// The currency is left untouched, or set to DefaultCurrency when there is none.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := strings.TrimSpace(string(data))
+	if value == "null" {
+		return nil
+	}
+
+	// a string must be quoted on both ends, and only once
+	if strings.HasPrefix(value, `"`) || strings.HasSuffix(value, `"`) {
+		if len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+			return ErrInvalidAmount
+		}
+		value = value[1 : len(value)-1]
+	}
+
+	// exponent notation is valid JSON but has no place in an amount
+	if strings.ContainsAny(value, "eE") {
+		return ErrInvalidAmount
+	}
+
+	parsed, err := ParseMoney(value, m.currency())
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
+
+func (m *Money) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		*m = NewMoney(0, m.Currency)
+		return nil
+	case []byte:
+		parsed, err := ParseMoney(string(value), m.Currency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case string:
+		parsed, err := ParseMoney(value, m.Currency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case int64:
+		if value > math.MaxInt64/100 || value < math.MinInt64/100 {
+			return ErrMoneyOverflow
+		}
+		*m = NewMoney(value*100, m.Currency)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+}
+
+// NullMoney is a Money that may be NULL, in the same spirit as sql.NullString.
+// It is used for optional amounts such as limits that are not set.
+type NullMoney struct {
+	Money Money
+	Valid bool
+}
+
+func (n NullMoney) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+
+	return n.Money.MarshalJSON()
+}
+
+func (n *NullMoney) UnmarshalJSON(data []byte) error {
+	if strings.TrimSpace(string(data)) == "null" {
+		n.Money, n.Valid = NewMoney(0, n.Money.Currency), false
+		return nil
+	}
+
+	err := n.Money.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+
+	n.Valid = true
+	return nil
+}
+
+func (n NullMoney) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+
+	return n.Money.Value()
+}
+
+func (n *NullMoney) Scan(src any) error {
+	if src == nil {
+		n.Money, n.Valid = NewMoney(0, n.Money.Currency), false
+		return nil
+	}
+
+	n.Valid = true
+	return n.Money.Scan(src)
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int64
+		wantErr error
+	}{
+		{name: "whole", value: "1500", want: 150000},
+		{name: "one decimal", value: "1500.5", want: 150050},
+		{name: "two decimals", value: "1500.55", want: 150055},
+		{name: "no whole part", value: ".5", want: 50},
+		{name: "trailing dot", value: "12.", want: 1200},
+		{name: "spaces", value: " 12.30 ", want: 1230},
+		{name: "plus sign", value: "+12.30", want: 1230},
+		{name: "negative", value: "-20.05", want: -2005},
+		{name: "negative below one", value: "-0.5", want: -50},
+		{name: "negative zero", value: "-0", want: 0},
+		{name: "zeros padded by DECIMAL columns", value: "12.3000", want: 1230},
+		{name: "largest amount", value: "92233720368547758.07", want: math.MaxInt64},
+
+		{name: "too many decimals are never rounded", value: "12.345", wantErr: ErrInvalidAmount},
+		{name: "half a kobo is never rounded", value: "12.005", wantErr: ErrInvalidAmount},
+		{name: "empty", value: "", wantErr: ErrInvalidAmount},
+		{name: "sign only", value: "-", wantErr: ErrInvalidAmount},
+		{name: "dot only", value: ".", wantErr: ErrInvalidAmount},
+		{name: "two signs", value: "--12", wantErr: ErrInvalidAmount},
+		{name: "letters", value: "12a", wantErr: ErrInvalidAmount},
+		{name: "two dots", value: "1.2.3", wantErr: ErrInvalidAmount},
+		{name: "exponent", value: "1e3", wantErr: ErrInvalidAmount},
+		{name: "too large", value: "92233720368547758.08", wantErr: ErrMoneyOverflow},
+		{name: "too large for int64", value: "99999999999999999999", wantErr: ErrMoneyOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.value, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseMoney(%q) error = %v, want %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got.Minor != tt.want {
+				t.Errorf("ParseMoney(%q) = %d, want %d", tt.value, got.Minor, tt.want)
+			}
+			if got.Currency != DefaultCurrency {
+				t.Errorf("ParseMoney(%q) currency = %q, want %q", tt.value, got.Currency, DefaultCurrency)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int64
+		wantErr bool
+	}{
+		{name: "number", data: `1500.50`, want: 150050},
+		{name: "negative number", data: `-3`, want: -300},
+		{name: "string", data: `"1500.50"`, want: 150050},
+		{name: "null leaves the amount", data: `null`, want: 700},
+
+		{name: "unbalanced opening quote", data: `"12`, wantErr: true},
+		{name: "unbalanced closing quote", data: `12"`, wantErr: true},
+		{name: "lone quote", data: `"`, wantErr: true},
+		{name: "empty string", data: `""`, wantErr: true},
+		{name: "quoted twice", data: `""12""`, wantErr: true},
+		{name: "exponent", data: `1.5e3`, wantErr: true},
+		{name: "too many decimals", data: `1.001`, wantErr: true},
+		{name: "not a number", data: `"abc"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMoney(700, "USD")
+			err := m.UnmarshalJSON([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalJSON(%s) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if m.Minor != tt.want {
+				t.Errorf("UnmarshalJSON(%s) = %d, want %d", tt.data, m.Minor, tt.want)
+			}
+			if m.Currency != "USD" {
+				t.Errorf("UnmarshalJSON(%s) currency = %q, want the currency to be kept", tt.data, m.Currency)
+			}
+		})
+	}
+}
+
+func TestMoneyJSONInStruct(t *testing.T) {
+	var input struct {
+		Amount Money     `json:"amount"`
+		Limit  NullMoney `json:"limit"`
+	}
+
+	err := json.Unmarshal([]byte(`{"amount": 12.5, "limit": null}`), &input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.Amount.Minor != 1250 || input.Amount.Currency != DefaultCurrency {
+		t.Errorf("amount = %+v, want 1250 %s", input.Amount, DefaultCurrency)
+	}
+	if input.Limit.Valid {
+		t.Errorf("limit = %+v, want it not to be set", input.Limit)
+	}
+
+	out, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"amount":12.50,"limit":null}`; string(out) != want {
+		t.Errorf("json.Marshal() = %s, want %s", out, want)
+	}
+}
+
+func TestMoneyAddNearLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    int64
+		want    int64
+		wantErr error
+	}{
+		{name: "up to the largest amount", a: math.MaxInt64 - 1, b: 1, want: math.MaxInt64},
+		{name: "down to the smallest amount", a: math.MinInt64 + 1, b: -1, want: math.MinInt64},
+		{name: "largest and smallest", a: math.MaxInt64, b: math.MinInt64, want: -1},
+		{name: "over the largest amount", a: math.MaxInt64, b: 1, wantErr: ErrMoneyOverflow},
+		{name: "under the smallest amount", a: math.MinInt64, b: -1, wantErr: ErrMoneyOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMoney(tt.a, "").Add(NewMoney(tt.b, ""))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Add() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.Minor != tt.want {
+				t.Errorf("Add() = %d, want %d", got.Minor, tt.want)
+			}
+		})
+	}
+
+	_, err := NewMoney(1, "NGN").Add(NewMoney(1, "USD"))
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add() of different currencies error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+}
+
+func TestMoneyNegNearLimits(t *testing.T) {
+	if got := NewMoney(math.MaxInt64, "").Neg(); got.Minor != -math.MaxInt64 {
+		t.Errorf("Neg() of the largest amount = %d, want %d", got.Minor, int64(-math.MaxInt64))
+	}
+	if got := NewMoney(-math.MaxInt64, "").Neg(); got.Minor != math.MaxInt64 {
+		t.Errorf("Neg() = %d, want %d", got.Minor, int64(math.MaxInt64))
+	}
+
+	// the smallest amount has no opposite, Sub must refuse it rather than wrap around
+	_, err := NewMoney(0, "").Sub(NewMoney(math.MinInt64, ""))
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Sub() of the smallest amount error = %v, want %v", err, ErrMoneyOverflow)
+	}
+
+	got, err := NewMoney(-1, "").Sub(NewMoney(math.MaxInt64, ""))
+	if err != nil || got.Minor != math.MinInt64 {
+		t.Errorf("Sub() = %d, %v, want %d", got.Minor, err, int64(math.MinInt64))
+	}
+}
+
+func TestMoneyValueScanRoundTrip(t *testing.T) {
+	amounts := []int64{0, 1, 50, 100, 150050, -1, -2005, math.MaxInt64, -math.MaxInt64}
+
+	for _, minor := range amounts {
+		value, err := NewMoney(minor, "").Value()
+		if err != nil {
+			t.Fatalf("Value() of %d error = %v", minor, err)
+		}
+
+		// drivers hand DECIMAL columns back as text or bytes
+		for _, src := range []any{value, []byte(value.(string))} {
+			got := Money{Currency: "USD"}
+			err = got.Scan(src)
+			if err != nil {
+				t.Fatalf("Scan(%v) error = %v", src, err)
+			}
+			if got.Minor != minor || got.Currency != "USD" {
+				t.Errorf("Scan(Value(%d)) = %+v, want %d USD", minor, got, minor)
+			}
+		}
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     any
+		want    int64
+		wantErr bool
+	}{
+		{name: "NULL", src: nil, want: 0},
+		{name: "integer", src: int64(12), want: 1200},
+		{name: "integer too large", src: int64(math.MaxInt64 / 10), wantErr: true},
+		{name: "padded decimal", src: "12.3400", want: 1234},
+		{name: "float", src: 12.34, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := got.Scan(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan(%v) error = %v, want error %v", tt.src, err, tt.wantErr)
+			}
+			if err == nil && got.Minor != tt.want {
+				t.Errorf("Scan(%v) = %d, want %d", tt.src, got.Minor, tt.want)
+			}
+		})
+	}
+}
+
+func TestNullMoneyValueScanRoundTrip(t *testing.T) {
+	for _, in := range []NullMoney{{}, {Money: NewMoney(1250, ""), Valid: true}} {
+		value, err := in.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got NullMoney
+		err = got.Scan(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Valid != in.Valid || got.Money.Minor != in.Money.Minor {
+			t.Errorf("Scan(Value(%+v)) = %+v", in, got)
+		}
+	}
+}
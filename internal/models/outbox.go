@@ -0,0 +1,20 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type OutboxMessage struct {
+	ID          string         `db:"id"`
+	Topic       string         `db:"topic"`
+	Key         sql.NullString `db:"message_key"`
+	Payload     string         `db:"payload"`
+	Headers     MessageHeaders `db:"headers"`
+	Status      string         `db:"status"`
+	Attempts    int            `db:"attempts"`
+	LastError   sql.NullString `db:"last_error"`
+	AvailableAt time.Time      `db:"available_at"`
+	CreatedAt   time.Time      `db:"created_at"`
+	SentAt      sql.NullTime   `db:"sent_at"`
+}
@@ -10,7 +10,7 @@ type Transaction struct {
 	SenderWalletID    string         `db:"sender_wallet_id"`
 	RecipientWalletID string         `db:"recipient_wallet_id"`
 	ReferenceNumber   string         `db:"reference_number"`
-	Amount            float64        `db:"amount"`
+	Amount            Money          `db:"amount"`
 	Description       sql.NullString `db:"description"`
 	Status            string         `db:"status"`
 	CreatedAt         time.Time      `db:"created_at"`
@@ -22,7 +22,7 @@ type Transaction struct {
 type TransactionDetails struct {
 	ID              string       `db:"id"`
 	ReferenceNumber string       `db:"reference_number"`
-	Amount          float64      `db:"amount"`
+	Amount          Money        `db:"amount"`
 	Status          string       `db:"status"`
 	Description     string       `db:"description"`
 	CreatedAt       sql.NullTime `db:"created_at"`
@@ -13,8 +13,10 @@ type User struct {
 	PhoneNumber    string         `db:"phone_number"`
 	Image          sql.NullString `db:"image"`
 	Gender         string         `db:"gender"`
+	DateOfBirth    sql.NullTime   `db:"date_of_birth"`
 	Email          string         `db:"email"`
 	Status         string         `db:"status"`
+	Role           string         `db:"role"`
 	Pin            sql.NullInt32  `db:"pin"`
 	CreatedAt      time.Time      `db:"created_at"`
 	DeletedAt      sql.NullTime   `db:"deleted_at"`
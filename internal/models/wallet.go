@@ -5,14 +5,52 @@ import (
 	"time"
 )
 
+// BankName is the name our wallets are held under
+const BankName = "Mornee"
+
 type Wallet struct {
 	ID            string       `db:"id"`
 	UserID        string       `db:"user_id"`
-	Balance       float64      `db:"balance"`
+	Balance       Money        `db:"balance"`
+	HeldBalance   Money        `db:"held_balance"`
 	AccountNumber string       `db:"account_number"`
 	Currency      string       `db:"currency"`
 	Status        string       `db:"status"`
 	CreatedAt     time.Time    `db:"created_at"`
 	DeletedAt     sql.NullTime `db:"deleted_at"`
 	UpdatedAt     sql.NullTime `db:"updated_at"`
+
+	// StatusReason is why the wallet is on hold, it is not set for active wallets
+	StatusReason    sql.NullString `db:"status_reason"`
+	StatusChangedAt sql.NullTime   `db:"status_changed_at"`
+
+	// SuspendedBalance is money credited to the wallet that is waiting in suspense for its balance limit to allow it
+	SuspendedBalance Money `db:"suspended_balance"`
+}
+
+// AvailableBalance is the part of the ledger balance that is not held for transfers in flight
+func (w *Wallet) AvailableBalance() (Money, error) {
+	return w.Balance.Sub(w.HeldBalance.WithCurrency(w.Balance.Currency))
+}
+
+type WalletHold struct {
+	ID            string    `db:"id"`
+	WalletID      string    `db:"wallet_id"`
+	TransactionID string    `db:"transaction_id"`
+	Amount        Money     `db:"amount"`
+	Status        string    `db:"status"`
+	ExpiresAt     time.Time `db:"expires_at"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
+}
+
+type SuspendedCredit struct {
+	ID             string       `db:"id"`
+	WalletID       string       `db:"wallet_id"`
+	TransactionID  string       `db:"transaction_id"`
+	Amount         Money        `db:"amount"`
+	ReleasedAmount Money        `db:"released_amount"`
+	ReleasedAt     sql.NullTime `db:"released_at"`
+	CreatedAt      time.Time    `db:"created_at"`
+	UpdatedAt      time.Time    `db:"updated_at"`
 }
@@ -0,0 +1,25 @@
+// Policies decide whether a user may see a record loaded by the ID in a request path.
+// Handlers load the record, then ask the policy; when it says no, they answer as if the record did not exist (404 Not Found),
+// so users can't find out which IDs belong to somebody else.
+// Staff see other users' records through the admin routes, which are guarded by permissions (see internal/rbac), not by these policies.
+package policy
+
+import "github.com/cradoe/morenee/internal/models"
+
+// CanViewWallet reports whether user owns wallet
+func CanViewWallet(user *models.User, wallet *models.Wallet) bool {
+	if user == nil || wallet == nil {
+		return false
+	}
+
+	return wallet.UserID == user.ID
+}
+
+// CanViewTransaction reports whether user is the sender or the recipient of transaction
+func CanViewTransaction(user *models.User, transaction *models.TransactionDetails) bool {
+	if user == nil || transaction == nil {
+		return false
+	}
+
+	return transaction.SenderID == user.ID || transaction.RecipientID == user.ID
+}
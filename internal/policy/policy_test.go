@@ -0,0 +1,61 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/cradoe/morenee/internal/models"
+)
+
+func TestCanViewWallet(t *testing.T) {
+	owner := &models.User{ID: "owner"}
+	other := &models.User{ID: "other"}
+	wallet := &models.Wallet{ID: "wallet", UserID: owner.ID}
+
+	tests := []struct {
+		name   string
+		user   *models.User
+		wallet *models.Wallet
+		want   bool
+	}{
+		{name: "owner", user: owner, wallet: wallet, want: true},
+		{name: "other user", user: other, wallet: wallet, want: false},
+		{name: "no user", user: nil, wallet: wallet, want: false},
+		{name: "no wallet", user: owner, wallet: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanViewWallet(tt.user, tt.wallet); got != tt.want {
+				t.Errorf("CanViewWallet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanViewTransaction(t *testing.T) {
+	sender := &models.User{ID: "sender"}
+	recipient := &models.User{ID: "recipient"}
+	other := &models.User{ID: "other"}
+	transaction := &models.TransactionDetails{ID: "transaction", SenderID: sender.ID, RecipientID: recipient.ID}
+
+	tests := []struct {
+		name        string
+		user        *models.User
+		transaction *models.TransactionDetails
+		want        bool
+	}{
+		{name: "sender", user: sender, transaction: transaction, want: true},
+		{name: "recipient", user: recipient, transaction: transaction, want: true},
+		{name: "other user", user: other, transaction: transaction, want: false},
+		{name: "no user", user: nil, transaction: transaction, want: false},
+		{name: "no transaction", user: sender, transaction: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanViewTransaction(tt.user, tt.transaction); got != tt.want {
+				t.Errorf("CanViewTransaction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
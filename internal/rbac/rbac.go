@@ -0,0 +1,86 @@
+// Every user has a role, which decides what they are permitted to do beyond their own account.
+// Customers can only act on their own account, staff roles (support, compliance and admin) get the permissions of the admin routes.
+// Handlers and middlewares only ask about permissions, never about roles,
+// so what a role can do is decided here and nowhere else.
+package rbac
+
+import "slices"
+
+const (
+	// RoleCustomer is the default role, it has no permission beyond the user's own account
+	RoleCustomer = "customer"
+
+	// RoleSupport looks after customers: it sees their accounts, and can lock and unlock them
+	RoleSupport = "support"
+
+	// RoleCompliance reviews KYC submissions, and can lock accounts for compliance reasons
+	RoleCompliance = "compliance"
+
+	// RoleAdmin can do everything, including granting roles and managing KYC levels
+	RoleAdmin = "admin"
+)
+
+type Permission string
+
+const (
+	PermissionViewUsers        Permission = "users:view"
+	PermissionLockUsers        Permission = "users:lock"
+	PermissionViewWallets      Permission = "wallets:view"
+	PermissionLockWallets      Permission = "wallets:lock"
+	PermissionViewTransactions Permission = "transactions:view"
+	PermissionReviewKYC        Permission = "kyc:review"
+	PermissionManageDeadLetter Permission = "dead-letters:manage"
+	PermissionManageRoles      Permission = "roles:manage"
+	PermissionManageKYC        Permission = "kyc:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleCustomer: {},
+	RoleSupport: {
+		PermissionViewUsers,
+		PermissionLockUsers,
+		PermissionViewWallets,
+		PermissionLockWallets,
+		PermissionViewTransactions,
+	},
+	RoleCompliance: {
+		PermissionViewUsers,
+		PermissionLockUsers,
+		PermissionViewWallets,
+		PermissionLockWallets,
+		PermissionViewTransactions,
+		PermissionReviewKYC,
+	},
+	RoleAdmin: {
+		PermissionViewUsers,
+		PermissionLockUsers,
+		PermissionViewWallets,
+		PermissionLockWallets,
+		PermissionViewTransactions,
+		PermissionReviewKYC,
+		PermissionManageDeadLetter,
+		PermissionManageRoles,
+		PermissionManageKYC,
+	},
+}
+
+// Roles lists every role
+func Roles() []string {
+	return []string{RoleCustomer, RoleSupport, RoleCompliance, RoleAdmin}
+}
+
+// IsRole reports whether role is a known role
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// IsStaff reports whether role belongs to a member of staff
+func IsStaff(role string) bool {
+	return IsRole(role) && role != RoleCustomer
+}
+
+// Can reports whether role has permission, unknown roles have none
+func Can(role string, permission Permission) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
@@ -18,6 +18,7 @@ import (
 type ActivityRepository interface {
 	CountConsecutiveFailedLoginAttempts(userID, action_desc string) int
 	Insert(log *models.ActivityLog) (*models.ActivityLog, error)
+	Exists(entity, entityID, description string) (bool, error)
 }
 
 const (
@@ -29,6 +30,112 @@ const (
 
 	// ActivityLogUserEntity is used in activites that has to do with user account and the users table
 	ActivityLogUserEntity = "user"
+
+	// ActivityLogDeadLetterEntity is used in activities that has to do with dead-lettered messages and the dead_letters table
+	ActivityLogDeadLetterEntity = "dead_letter"
+
+	// ActivityLogKYCDataEntity is used in activities that has to do with the KYC data users submit and the user_kyc_data table
+	ActivityLogKYCDataEntity = "kyc_data"
+
+	// ActivityLogKYCLevelEntity is used in activities that has to do with KYC levels and their limits, its ID is the ID of a version of the limits
+	// (the version in effect for changes to the level itself)
+	ActivityLogKYCLevelEntity = "kyc_level"
+
+	// ActivityLogKYCRequirementEntity is used in activities that has to do with the requirements of KYC levels and the kyc_requirements table
+	ActivityLogKYCRequirementEntity = "kyc_requirement"
+)
+
+const (
+	// TransactionActivityLogInitiatedDescription is used when a transaction is created and pending completion.
+	TransactionActivityLogInitiatedDescription = "Transaction initiated"
+
+	// TransactionActivityLogDebitDescription is used to log when a sender's wallet is debited successfully.
+	TransactionActivityLogDebitDescription = "Transaction debit"
+
+	// TransactionActivityLogCreditDescription is used to log when a recipient's wallet is credited successfully.
+	TransactionActivityLogCreditDescription = "Transaction credit"
+
+	// TransactionActivityLogFailedDebitDescription is used when a debit operation fails, potentially due to insufficient funds or errors.
+	TransactionActivityLogFailedDebitDescription = "Transaction debit failed"
+
+	// TransactionActivityLogFailedCreditDescription is used to log a failure to credit the recipient’s wallet or account.
+	TransactionActivityLogFailedCreditDescription = "Transaction could not credit recipient"
+
+	// TransactionActivityLogRevertedDescription is used when a previously failed transaction is reversed and the money is credited back to the sender.
+	TransactionActivityLogRevertedDescription = "Transaction reverted"
+
+	// TransactionActivityLogSuccessDescription is used to log the successful completion of a transaction.
+	TransactionActivityLogSuccessDescription = "Transaction success"
+
+	// TransactionActivityLogCreditSuspendedDescription is used when part of a credit would have taken the recipient's wallet over its balance limit and was suspended.
+	TransactionActivityLogCreditSuspendedDescription = "Transaction credit suspended: balance over limit"
+
+	// TransactionActivityLogRecoveryDescription is used by the pending-transfer sweeper, followed by the decision it made about a stuck transaction.
+	TransactionActivityLogRecoveryDescription = "Transaction recovery: "
+)
+
+const (
+	// WalletActivityLogLimitLockedDescription is used when a wallet is put on hold for going over the balance limit of its owner's KYC level.
+	WalletActivityLogLimitLockedDescription = "Wallet locked: balance over limit"
+
+	// WalletActivityLogLimitReleasedDescription is used when a wallet on hold for going over its balance limit is back within it and released.
+	WalletActivityLogLimitReleasedDescription = "Wallet unlocked: balance within limit"
+
+	// WalletActivityLogSuspenseReleasedDescription is used when suspended credits of a wallet are released into it, as far as its balance limit allows.
+	WalletActivityLogSuspenseReleasedDescription = "Suspended credits released"
+)
+
+const (
+	// AdminActivityLogWalletUnlockedDescription is used when staff put a wallet that was on hold back to active.
+	AdminActivityLogWalletUnlockedDescription = "Wallet unlocked by staff"
+
+	// AdminActivityLogUserUnlockedDescription is used when staff unlock a user account, it also ends the user's streak of failed logins.
+	AdminActivityLogUserUnlockedDescription = "User unlocked by staff"
+
+	// AdminActivityLogTransferReversedDescription is used when staff undo a stuck transfer, it is followed by what was done.
+	AdminActivityLogTransferReversedDescription = "Transfer force-reversed by staff: "
+
+	// AdminActivityLogOTPResentDescription is used when staff send a new account verification OTP to a user.
+	AdminActivityLogOTPResentDescription = "Verification OTP resent by staff"
+
+	// AdminActivityLogUserLockedDescription is used when staff lock a user account.
+	AdminActivityLogUserLockedDescription = "User locked by staff"
+
+	// AdminActivityLogWalletLockedDescription is used when staff put a wallet on hold, it is followed by the reason of the hold.
+	AdminActivityLogWalletLockedDescription = "Wallet locked by staff: "
+
+	// AdminActivityLogRoleChangedDescription is used when a user is given a new role, it is followed by the role.
+	AdminActivityLogRoleChangedDescription = "Role changed by staff: "
+
+	// AdminActivityLogDeadLetterReplayedDescription is used when staff replay a dead-lettered transfer message.
+	AdminActivityLogDeadLetterReplayedDescription = "Dead letter replayed by staff"
+
+	// AdminActivityLogKYCApprovedDescription is used when compliance staff approve KYC data, it is followed by the requirement.
+	AdminActivityLogKYCApprovedDescription = "KYC data approved by staff: "
+
+	// AdminActivityLogKYCRejectedDescription is used when compliance staff reject KYC data, it is followed by the requirement.
+	AdminActivityLogKYCRejectedDescription = "KYC data rejected by staff: "
+
+	// AdminActivityLogKYCFingerprintedDescription is used when staff fingerprint the identity number of KYC data submitted before fingerprints.
+	AdminActivityLogKYCFingerprintedDescription = "KYC identity fingerprinted by staff"
+
+	// AdminActivityLogKYCLevelCreatedDescription is used when an admin adds a KYC level, it is logged with the first version of its limits.
+	AdminActivityLogKYCLevelCreatedDescription = "KYC level created by staff"
+
+	// AdminActivityLogKYCLevelRenamedDescription is used when an admin renames a KYC level, it is logged with the version of its limits in effect.
+	AdminActivityLogKYCLevelRenamedDescription = "KYC level renamed by staff"
+
+	// AdminActivityLogKYCLimitsChangedDescription is used when an admin adds a version of the limits of a KYC level.
+	AdminActivityLogKYCLimitsChangedDescription = "KYC level limits changed by staff"
+
+	// AdminActivityLogKYCRequirementAddedDescription is used when an admin adds a requirement to a KYC level.
+	AdminActivityLogKYCRequirementAddedDescription = "KYC requirement added by staff"
+
+	// AdminActivityLogKYCRequirementChangedDescription is used when an admin renames a KYC requirement or changes its schema.
+	AdminActivityLogKYCRequirementChangedDescription = "KYC requirement changed by staff"
+
+	// AdminActivityLogKYCRequirementRetiredDescription is used when an admin retires a KYC requirement.
+	AdminActivityLogKYCRequirementRetiredDescription = "KYC requirement retired by staff"
 )
 
 type ActivityRepositoryImpl struct {
@@ -46,8 +153,8 @@ func (repo *ActivityRepositoryImpl) Insert(log *models.ActivityLog) (*models.Act
 	var trans models.ActivityLog
 
 	query := `
-		INSERT INTO activity_logs (user_id, entity, entity_id, description)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO activity_logs (user_id, entity, entity_id, description, actor_id, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id`
 
 	err := repo.db.GetContext(ctx, &trans, query,
@@ -55,6 +162,8 @@ func (repo *ActivityRepositoryImpl) Insert(log *models.ActivityLog) (*models.Act
 		log.Entity,
 		log.EntityId,
 		log.Description,
+		log.ActorID,
+		log.Reason,
 	)
 
 	if err != nil {
@@ -101,3 +210,25 @@ func (repo *ActivityRepositoryImpl) CountConsecutiveFailedLoginAttempts(userID,
 
 	return count
 }
+
+// Exists reports whether an activity with the given description has been logged for an entity.
+// It lets background processes use the activity log as evidence of what already happened.
+func (repo *ActivityRepositoryImpl) Exists(entity, entityID, description string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var exists bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM activity_logs
+			WHERE entity = $1 AND entity_id = $2 AND description = $3
+		)`
+
+	err := repo.db.GetContext(ctx, &exists, query, entity, entityID, description)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
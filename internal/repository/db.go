@@ -2,16 +2,15 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/cradoe/morenee/assets"
-
 	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/jmoiron/sqlx"
 
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/lib/pq"
 )
 
@@ -21,10 +20,18 @@ type DB struct {
 	*sqlx.DB
 }
 
-func New(dsn string, automigrate bool) (*DB, error) {
+// New connects to the database with its session time zone set to timezone,
+// so that NOW(), date_trunc and friends work with the business's days, weeks and months,
+// and TIMESTAMPTZ values are read back in that zone.
+func New(dsn string, automigrate bool, timezone string) (*DB, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
+	dsn, err := withTimezone(dsn, timezone)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := sqlx.ConnectContext(ctx, "postgres", "postgres://"+dsn)
 	if err != nil {
 		return nil, err
@@ -36,18 +43,13 @@ func New(dsn string, automigrate bool) (*DB, error) {
 	db.SetConnMaxLifetime(2 * time.Hour)
 
 	// automigration is only encouraged in development mode
-	// set AUTO_MIGRATE to false in your .env file
+	// set DB_AUTOMIGRATE to false in your .env file, and run migrations with cmd/migrate instead
 	if automigrate {
-		iofsDriver, err := iofs.New(assets.EmbeddedFiles, "migrations")
-		if err != nil {
-			return nil, err
-		}
-
-		migrator, err := migrate.NewWithSourceInstance("iofs", iofsDriver, "postgres://"+dsn)
-
+		migrator, err := NewMigrator(dsn)
 		if err != nil {
 			return nil, err
 		}
+		defer migrator.Close()
 
 		err = migrator.Up()
 
@@ -61,3 +63,52 @@ func New(dsn string, automigrate bool) (*DB, error) {
 
 	return &DB{db}, nil
 }
+
+// withTimezone adds the timezone run-time parameter to the connection string
+func withTimezone(dsn string, timezone string) (string, error) {
+	if timezone == "" {
+		return dsn, nil
+	}
+
+	parsed, err := url.Parse("postgres://" + dsn)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsed.Query()
+	query.Set("timezone", timezone)
+	parsed.RawQuery = query.Encode()
+
+	return strings.TrimPrefix(parsed.String(), "postgres://"), nil
+}
+
+// withTx runs fn inside the caller's transaction when one is given,
+// otherwise it starts (and commits) a transaction of its own.
+// This lets repository methods take an optional *sql.Tx, the same way Insert methods do.
+func (db *DB) withTx(ctx context.Context, tx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if tx != nil {
+		return fn(tx)
+	}
+
+	ownTx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer ownTx.Rollback()
+
+	if err := fn(ownTx); err != nil {
+		return err
+	}
+
+	return ownTx.Commit()
+}
+
+// queryer lets sqlx scan into structs inside the caller's transaction when one is given,
+// and falls back to the database itself otherwise.
+func (db *DB) queryer(tx *sql.Tx) sqlx.QueryerContext {
+	if tx != nil {
+		return &sqlx.Tx{Tx: tx, Mapper: db.Mapper}
+	}
+
+	return db.DB
+}
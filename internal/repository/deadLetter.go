@@ -0,0 +1,162 @@
+// Dead letters are messages our workers gave up on: either they could not be read at all (poison messages),
+// or processing them kept failing after all retries.
+// Each one is stored here with the error, the number of attempts and the original key and headers,
+// and published to the transfer.failed topic, where the failure worker compensates for it.
+// ...
+// Admins can list and inspect dead letters, and replay them onto their original topic once the cause has been fixed.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cradoe/morenee/internal/models"
+)
+
+const (
+	// DeadLetterStatusPending is used for dead letters the failure worker has not handled yet
+	DeadLetterStatusPending = "pending"
+
+	// DeadLetterStatusHandled is used for dead letters the failure worker has compensated for
+	DeadLetterStatusHandled = "handled"
+
+	// DeadLetterStatusReplayed is used for dead letters that were sent back to their original topic
+	DeadLetterStatusReplayed = "replayed"
+)
+
+type DeadLetterRepository interface {
+	Insert(deadLetter *models.DeadLetter, tx *sql.Tx) (string, error)
+	GetAll(status string, limit int, offset int) ([]models.DeadLetter, error)
+	GetOne(id string) (*models.DeadLetter, bool, error)
+	MarkHandled(id string) error
+	MarkReplayed(id string, tx *sql.Tx) (bool, error)
+}
+
+type DeadLetterRepositoryImpl struct {
+	db *DB
+}
+
+func NewDeadLetterRepository(db *DB) DeadLetterRepository {
+	return &DeadLetterRepositoryImpl{db: db}
+}
+
+const getDeadLetterBasicQuery = `
+	SELECT id, topic, message_key, payload, headers, error, attempts, transaction_id,
+		status, replay_count, created_at, handled_at, replayed_at
+	FROM dead_letters`
+
+func (repo *DeadLetterRepositoryImpl) Insert(deadLetter *models.DeadLetter, tx *sql.Tx) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var id string
+
+	query := `
+		INSERT INTO dead_letters (topic, message_key, payload, headers, error, attempts, transaction_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+
+	args := []any{
+		deadLetter.Topic,
+		deadLetter.MessageKey,
+		deadLetter.Payload,
+		deadLetter.Headers,
+		deadLetter.Error,
+		deadLetter.Attempts,
+		deadLetter.TransactionID,
+	}
+
+	if tx != nil {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&id)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		err := repo.db.GetContext(ctx, &id, query, args...)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// GetAll lists dead letters, newest first. An empty status lists all of them.
+func (repo *DeadLetterRepositoryImpl) GetAll(status string, limit int, offset int) ([]models.DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := getDeadLetterBasicQuery + `
+		WHERE ($1::text = '' OR status = $1)
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3`
+
+	var deadLetters []models.DeadLetter
+	err := repo.db.SelectContext(ctx, &deadLetters, query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return deadLetters, nil
+}
+
+func (repo *DeadLetterRepositoryImpl) GetOne(id string) (*models.DeadLetter, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var deadLetter models.DeadLetter
+
+	query := getDeadLetterBasicQuery + ` WHERE id = $1`
+
+	err := repo.db.GetContext(ctx, &deadLetter, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &deadLetter, true, nil
+}
+
+func (repo *DeadLetterRepositoryImpl) MarkHandled(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `UPDATE dead_letters SET status = $1, handled_at = $2 WHERE id = $3 AND status = $4`
+
+	_, err := repo.db.ExecContext(ctx, query, DeadLetterStatusHandled, time.Now(), id, DeadLetterStatusPending)
+	return err
+}
+
+// MarkReplayed marks a pending dead letter as replayed.
+// It returns false when the dead letter is not pending (anymore), it was handled or replayed already.
+func (repo *DeadLetterRepositoryImpl) MarkReplayed(id string, tx *sql.Tx) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE dead_letters
+		SET status = $1, replay_count = replay_count + 1, replayed_at = $2
+		WHERE id = $3 AND status = $4`
+
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, query, DeadLetterStatusReplayed, time.Now(), id, DeadLetterStatusPending)
+	} else {
+		result, err = repo.db.ExecContext(ctx, query, DeadLetterStatusReplayed, time.Now(), id, DeadLetterStatusPending)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/cradoe/morenee/internal/models"
 )
@@ -9,6 +11,11 @@ import (
 type KycRepository interface {
 	GetAll() ([]models.KYCLevel, error)
 	GetOne(id string) (*models.KYCLevel, bool, error)
+	Create(levelName string, limits *models.KYCLevelVersion) (string, bool, error)
+	AddVersion(levelID string, limits *models.KYCLevelVersion) (*models.KYCLevelVersion, bool, error)
+	GetVersions(levelID string) ([]models.KYCLevelVersion, error)
+	GetAllVersions() ([]models.KYCLevelVersion, error)
+	Rename(id, levelName string) (bool, error)
 }
 
 type KycRepositoryImpl struct {
@@ -19,112 +26,140 @@ func NewKycRepository(db *DB) KycRepository {
 	return &KycRepositoryImpl{db: db}
 }
 
-func (repo *KycRepositoryImpl) GetAll() ([]models.KYCLevel, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
-
-	query := `
+// effectiveLevelsQuery selects the levels with the limits of their version that took effect last,
+// and the requirements in effect. Levels are filtered by the condition appended to it.
+const effectiveLevelsQuery = `
 		SELECT 
 			kl.id, 
 			kl.level_name, 
-			kl.daily_transfer_limit, 
-			kl.wallet_balance_limit, 
-			kl.single_transfer_limit, 
+			lv.id AS version_id,
+			lv.version,
+			lv.effective_from,
+			lv.daily_transfer_limit, 
+			lv.wallet_balance_limit, 
+			lv.single_transfer_limit, 
+			lv.limit_window,
+			lv.weekly_transfer_limit,
+			lv.monthly_transfer_limit,
+			lv.daily_transfer_count,
+			lv.weekly_transfer_count,
+			lv.monthly_transfer_count,
 			kr.id as requirement_id,
-			kr.requirement
+			kr.requirement,
+			kr.schema,
+			kr.requires_document,
+			kr.version,
+			kr.effective_from
 		FROM 
 			kyc_levels kl
+		JOIN LATERAL (
+			SELECT * 
+			FROM kyc_level_versions v 
+			WHERE v.kyc_level_id = kl.id AND v.effective_from <= NOW()
+			ORDER BY v.effective_from DESC, v.version DESC
+			LIMIT 1
+		) lv ON TRUE
 		LEFT JOIN 
 			kyc_requirements kr 
 		ON 
 			kl.id = kr.kyc_level_id
-		ORDER BY 
-			kl.level_name;
-	`
-
-	rows, err := repo.db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+			AND kr.effective_from <= NOW()
+			AND (kr.retired_at IS NULL OR kr.retired_at > NOW())
+`
 
-	kycMap := make(map[string]*models.KYCLevel)
+// scanLevels reads the rows of effectiveLevelsQuery, one level per ID with its requirements, in the order they come
+func scanLevels(rows *sql.Rows) ([]models.KYCLevel, error) {
+	kycLevels := []models.KYCLevel{}
+	index := make(map[string]int)
 
 	for rows.Next() {
 		var (
-			levelID             string
-			levelName           string
-			dailyTransferLimit  float64
-			walletBalanceLimit  float64
-			singleTransferLimit float64
-			requirementID       *string
-			requirementValue    *string
+			requirementID            *string
+			requirementValue         *string
+			schema                   *string
+			requiresDocument         *bool
+			requirementVersion       *int
+			requirementEffectiveFrom *time.Time
+			tempKYC                  models.KYCLevel
 		)
 
 		if err := rows.Scan(
-			&levelID,
-			&levelName,
-			&dailyTransferLimit,
-			&walletBalanceLimit,
-			&singleTransferLimit,
+			&tempKYC.ID,
+			&tempKYC.LevelName,
+			&tempKYC.VersionID,
+			&tempKYC.Version,
+			&tempKYC.EffectiveFrom,
+			&tempKYC.DailyTransferLimit,
+			&tempKYC.WalletBalanceLimit,
+			&tempKYC.SingleTransferLimit,
+			&tempKYC.LimitWindow,
+			&tempKYC.WeeklyTransferLimit,
+			&tempKYC.MonthlyTransferLimit,
+			&tempKYC.DailyTransferCount,
+			&tempKYC.WeeklyTransferCount,
+			&tempKYC.MonthlyTransferCount,
 			&requirementID,
 			&requirementValue,
+			&schema,
+			&requiresDocument,
+			&requirementVersion,
+			&requirementEffectiveFrom,
 		); err != nil {
 			return nil, err
 		}
 
-		// Check if models.KYCLevel already exists in the map
-		kyc, exists := kycMap[levelID]
+		i, exists := index[tempKYC.ID]
 		if !exists {
-			// Create a new models.KYCLevel if it doesn't exist
-			kyc = &models.KYCLevel{
-				ID:                  levelID,
-				LevelName:           levelName,
-				DailyTransferLimit:  dailyTransferLimit,
-				WalletBalanceLimit:  walletBalanceLimit,
-				SingleTransferLimit: singleTransferLimit,
-				Requirements:        []models.KYCLevelRequirement{},
-			}
-			kycMap[levelID] = kyc
+			tempKYC.Requirements = []models.KYCLevelRequirement{}
+			kycLevels = append(kycLevels, tempKYC)
+			i = len(kycLevels) - 1
+			index[tempKYC.ID] = i
 		}
 
 		// If a requirement is present, add it to the models.KYCLevel
 		if requirementID != nil && requirementValue != nil {
-			kyc.Requirements = append(kyc.Requirements, models.KYCLevelRequirement{
-				ID:          *requirementID,
-				Requirement: *requirementValue,
+			kycLevels[i].Requirements = append(kycLevels[i].Requirements, models.KYCLevelRequirement{
+				ID:               *requirementID,
+				KYCLevelID:       tempKYC.ID,
+				Requirement:      *requirementValue,
+				Schema:           *schema,
+				RequiresDocument: requiresDocument != nil && *requiresDocument,
+				Version:          *requirementVersion,
+				EffectiveFrom:    *requirementEffectiveFrom,
 			})
 		}
 	}
 
-	var kycLevels []models.KYCLevel
-	for _, kyc := range kycMap {
-		kycLevels = append(kycLevels, *kyc)
+	return kycLevels, rows.Err()
+}
+
+func (repo *KycRepositoryImpl) GetAll() ([]models.KYCLevel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := effectiveLevelsQuery + `
+		ORDER BY 
+			kl.id, kr.requirement;
+	`
+
+	rows, err := repo.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return kycLevels, nil
+	return scanLevels(rows)
 }
+
 func (repo *KycRepositoryImpl) GetOne(id string) (*models.KYCLevel, bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	query := `
-		SELECT 
-			kl.id, 
-			kl.level_name, 
-			kl.daily_transfer_limit, 
-			kl.wallet_balance_limit, 
-			kl.single_transfer_limit, 
-			kr.id as requirement_id,
-			kr.requirement
-		FROM 
-			kyc_levels kl
-		LEFT JOIN 
-			kyc_requirements kr 
-		ON 
-			kl.id = kr.kyc_level_id
+	query := effectiveLevelsQuery + `
 		WHERE 
-			kl.id = $1;
+			kl.id::TEXT = $1
+		ORDER BY 
+			kr.requirement;
 	`
 
 	rows, err := repo.db.QueryContext(ctx, query, id)
@@ -133,46 +168,183 @@ func (repo *KycRepositoryImpl) GetOne(id string) (*models.KYCLevel, bool, error)
 	}
 	defer rows.Close()
 
-	var kyc *models.KYCLevel
-	kycRequirements := []models.KYCLevelRequirement{}
+	kycLevels, err := scanLevels(rows)
+	if err != nil {
+		return nil, false, err
+	}
 
-	for rows.Next() {
-		var (
-			requirementID    *string
-			requirementValue *string
-			tempKYC          models.KYCLevel
-		)
+	if len(kycLevels) == 0 {
+		return nil, false, nil
+	}
 
-		if err := rows.Scan(
-			&tempKYC.ID,
-			&tempKYC.LevelName,
-			&tempKYC.DailyTransferLimit,
-			&tempKYC.WalletBalanceLimit,
-			&tempKYC.SingleTransferLimit,
-			&requirementID,
-			&requirementValue,
-		); err != nil {
-			return nil, false, err
+	return &kycLevels[0], true, nil
+}
+
+// Create adds a level, its first version takes effect at once, limits.EffectiveFrom is ignored.
+// It returns the ID of the level, and false when a level already has the name.
+func (repo *KycRepositoryImpl) Create(levelName string, limits *models.KYCLevelVersion) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var levelID string
+	err := repo.db.withTx(ctx, nil, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO kyc_levels (level_name) 
+			VALUES ($1) 
+			ON CONFLICT (level_name) DO NOTHING 
+			RETURNING id`, levelName,
+		).Scan(&levelID)
+		if err != nil {
+			return err
 		}
 
-		if kyc == nil {
-			kyc = &tempKYC
+		limits.Version = 1
+		limits.EffectiveFrom = time.Now()
+		return insertLevelVersion(ctx, tx, levelID, limits)
+	})
+
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return levelID, true, nil
+}
+
+// AddVersion adds a version with new limits to a level, numbered after the level's last version.
+// The limits take effect at limits.EffectiveFrom, the limits in effect until then stay as they are.
+// It returns false when there is no such level.
+func (repo *KycRepositoryImpl) AddVersion(levelID string, limits *models.KYCLevelVersion) (*models.KYCLevelVersion, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := repo.db.withTx(ctx, nil, func(tx *sql.Tx) error {
+		// the level's row is locked, so two versions added at the same time are numbered one after the other
+		var locked string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM kyc_levels WHERE id::TEXT = $1 FOR UPDATE`, levelID).Scan(&locked)
+		if err != nil {
+			return err
 		}
 
-		// Append requirement if it exists
-		if requirementID != nil && requirementValue != nil {
-			kycRequirements = append(kycRequirements, models.KYCLevelRequirement{
-				ID:          *requirementID,
-				Requirement: *requirementValue,
-			})
+		err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) + 1 FROM kyc_level_versions WHERE kyc_level_id = $1`, locked).Scan(&limits.Version)
+		if err != nil {
+			return err
 		}
-	}
 
-	if kyc == nil {
+		return insertLevelVersion(ctx, tx, locked, limits)
+	})
+
+	if err == sql.ErrNoRows {
 		return nil, false, nil
 	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return limits, true, nil
+}
+
+// insertLevelVersion inserts limits as a version of the level, it sets their ID, level and creation time
+func insertLevelVersion(ctx context.Context, tx *sql.Tx, levelID string, limits *models.KYCLevelVersion) error {
+	query := `
+		INSERT INTO kyc_level_versions (
+			kyc_level_id, version, single_transfer_limit, daily_transfer_limit, wallet_balance_limit, limit_window,
+			weekly_transfer_limit, monthly_transfer_limit, daily_transfer_count, weekly_transfer_count, monthly_transfer_count,
+			effective_from, created_by, reason
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id, kyc_level_id, created_at
+	`
+
+	return tx.QueryRowContext(ctx, query,
+		levelID,
+		limits.Version,
+		limits.SingleTransferLimit,
+		limits.DailyTransferLimit,
+		limits.WalletBalanceLimit,
+		limits.LimitWindow,
+		limits.WeeklyTransferLimit,
+		limits.MonthlyTransferLimit,
+		limits.DailyTransferCount,
+		limits.WeeklyTransferCount,
+		limits.MonthlyTransferCount,
+		limits.EffectiveFrom,
+		limits.CreatedBy,
+		limits.Reason,
+	).Scan(&limits.ID, &limits.KYCLevelID, &limits.CreatedAt)
+}
+
+// GetVersions lists the versions of a level, the latest first, including those that are yet to take effect
+func (repo *KycRepositoryImpl) GetVersions(levelID string) ([]models.KYCLevelVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		SELECT 
+			id, kyc_level_id, version, single_transfer_limit, daily_transfer_limit, wallet_balance_limit, limit_window,
+			weekly_transfer_limit, monthly_transfer_limit, daily_transfer_count, weekly_transfer_count, monthly_transfer_count,
+			effective_from, created_by, reason, created_at
+		FROM kyc_level_versions
+		WHERE kyc_level_id::TEXT = $1
+		ORDER BY version DESC
+	`
+
+	versions := []models.KYCLevelVersion{}
+	err := repo.db.SelectContext(ctx, &versions, query, levelID)
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// GetAllVersions lists the versions of every level, by level and the latest first, including those that are yet to take effect
+func (repo *KycRepositoryImpl) GetAllVersions() ([]models.KYCLevelVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		SELECT 
+			id, kyc_level_id, version, single_transfer_limit, daily_transfer_limit, wallet_balance_limit, limit_window,
+			weekly_transfer_limit, monthly_transfer_limit, daily_transfer_count, weekly_transfer_count, monthly_transfer_count,
+			effective_from, created_by, reason, created_at
+		FROM kyc_level_versions
+		ORDER BY kyc_level_id, version DESC
+	`
+
+	versions := []models.KYCLevelVersion{}
+	err := repo.db.SelectContext(ctx, &versions, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// Rename changes the name of a level, its limits and requirements stay as they are.
+// It returns false when another level already has the name.
+func (repo *KycRepositoryImpl) Rename(id, levelName string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE kyc_levels
+		SET level_name = $1
+		WHERE id::TEXT = $2
+			AND NOT EXISTS (SELECT 1 FROM kyc_levels WHERE level_name = $1 AND id::TEXT <> $2)
+	`
+
+	result, err := repo.db.ExecContext(ctx, query, levelName, id)
+	if err != nil {
+		return false, err
+	}
 
-	kyc.Requirements = kycRequirements
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
 
-	return kyc, true, nil
+	return rowsAffected > 0, nil
 }
@@ -3,12 +3,24 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"time"
 
 	"github.com/cradoe/morenee/internal/models"
 )
 
+// ErrKYCRequirementInUse is returned when the schema of a requirement users already submitted data for is changed,
+// their data would no longer match it
+var ErrKYCRequirementInUse = errors.New("users already submitted data for the requirement")
+
 type KycRequirementRepository interface {
 	FindByName(name string) (*models.KYCLevelRequirement, bool, error)
+	GetOne(id string) (*models.KYCLevelRequirement, bool, error)
+	GetByLevel(levelID string) ([]models.KYCLevelRequirement, error)
+	GetAll() ([]models.KYCLevelRequirement, error)
+	Insert(requirement *models.KYCLevelRequirement) (bool, error)
+	Update(requirement *models.KYCLevelRequirement) (bool, error)
+	Retire(id string, at time.Time) (bool, error)
 }
 
 type KycRequirementRepositoryImpl struct {
@@ -19,18 +31,182 @@ func NewKycRequirementRepository(db *DB) KycRequirementRepository {
 	return &KycRequirementRepositoryImpl{db: db}
 }
 
+const requirementColumns = `id, kyc_level_id, requirement, schema, requires_document, version, effective_from, retired_at`
+
 func (repo *KycRequirementRepositoryImpl) FindByName(name string) (*models.KYCLevelRequirement, bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
 	var requirement models.KYCLevelRequirement
-	query := `SELECT  id, requirement FROM kyc_requirements WHERE requirement = $1 LIMIT 1;`
+	query := `SELECT ` + requirementColumns + ` FROM kyc_requirements WHERE requirement = $1 LIMIT 1;`
 
 	err := repo.db.GetContext(ctx, &requirement, query, name)
 
 	if err == sql.ErrNoRows {
 		return nil, false, nil
 	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &requirement, true, nil
+}
+
+func (repo *KycRequirementRepositoryImpl) GetOne(id string) (*models.KYCLevelRequirement, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var requirement models.KYCLevelRequirement
+	query := `SELECT ` + requirementColumns + ` FROM kyc_requirements WHERE id = $1`
+
+	err := repo.db.GetContext(ctx, &requirement, query, id)
+
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
 
 	return &requirement, true, nil
 }
+
+// GetByLevel lists every requirement of a level, including those that are yet to take effect and those that were retired
+func (repo *KycRequirementRepositoryImpl) GetByLevel(levelID string) ([]models.KYCLevelRequirement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	requirements := []models.KYCLevelRequirement{}
+	query := `SELECT ` + requirementColumns + ` FROM kyc_requirements WHERE kyc_level_id::TEXT = $1 ORDER BY effective_from, requirement`
+
+	err := repo.db.SelectContext(ctx, &requirements, query, levelID)
+	if err != nil {
+		return nil, err
+	}
+
+	return requirements, nil
+}
+
+// GetAll lists every requirement of every level, by level, including those that are yet to take effect and those that were retired
+func (repo *KycRequirementRepositoryImpl) GetAll() ([]models.KYCLevelRequirement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	requirements := []models.KYCLevelRequirement{}
+	query := `SELECT ` + requirementColumns + ` FROM kyc_requirements ORDER BY kyc_level_id, effective_from, requirement`
+
+	err := repo.db.SelectContext(ctx, &requirements, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return requirements, nil
+}
+
+// Insert adds a requirement to its level, it counts towards the level from requirement.EffectiveFrom.
+// It returns false when a requirement already has the name.
+func (repo *KycRequirementRepositoryImpl) Insert(requirement *models.KYCLevelRequirement) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO kyc_requirements (kyc_level_id, requirement, schema, requires_document, effective_from)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (requirement) DO NOTHING
+		RETURNING ` + requirementColumns
+
+	err := repo.db.GetContext(ctx, requirement, query,
+		requirement.KYCLevelID,
+		requirement.Requirement,
+		requirement.Schema,
+		requirement.RequiresDocument,
+		requirement.EffectiveFrom,
+	)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Update changes the name, schema and document requirement of a requirement, and moves it to its next version.
+// The schema can only change while nobody submitted data for the requirement, ErrKYCRequirementInUse is returned otherwise.
+// It returns false when the requirement is retired.
+func (repo *KycRequirementRepositoryImpl) Update(requirement *models.KYCLevelRequirement) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var updated bool
+	err := repo.db.withTx(ctx, nil, func(tx *sql.Tx) error {
+		var current models.KYCLevelRequirement
+		err := repo.db.queryer(tx).QueryRowxContext(ctx, `
+			SELECT `+requirementColumns+` 
+			FROM kyc_requirements 
+			WHERE id = $1 AND (retired_at IS NULL OR retired_at > NOW()) 
+			FOR UPDATE`, requirement.ID,
+		).StructScan(&current)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if current.Schema != requirement.Schema || current.RequiresDocument != requirement.RequiresDocument {
+			var inUse bool
+			err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM user_kyc_data WHERE kyc_requirement_id = $1)`, requirement.ID).Scan(&inUse)
+			if err != nil {
+				return err
+			}
+			if inUse {
+				return ErrKYCRequirementInUse
+			}
+		}
+
+		err = repo.db.queryer(tx).QueryRowxContext(ctx, `
+			UPDATE kyc_requirements
+			SET requirement = $1, schema = $2, requires_document = $3, version = version + 1
+			WHERE id = $4
+			RETURNING `+requirementColumns,
+			requirement.Requirement, requirement.Schema, requirement.RequiresDocument, requirement.ID,
+		).StructScan(requirement)
+		if err != nil {
+			return err
+		}
+
+		updated = true
+		return nil
+	})
+
+	return updated, err
+}
+
+// Retire stops a requirement from counting towards its level from at, the data users submitted for it is kept.
+// A requirement whose retirement is still to come can be retired again, at another time.
+// It returns false when the requirement is already retired.
+func (repo *KycRequirementRepositoryImpl) Retire(id string, at time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE kyc_requirements
+		SET retired_at = $1
+		WHERE id = $2 AND (retired_at IS NULL OR retired_at > NOW())
+	`
+
+	result, err := repo.db.ExecContext(ctx, query, at, id)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,316 @@
+// The ledger is the source of truth for money movement.
+// Every transfer, reversal and fee is recorded as a journal made up of
+// balanced debit and credit postings, so the sum of debits always equals the sum of credits.
+// ...
+// Wallet balances are kept on the wallets table for fast reads, but they are only ever
+// changed in the same database transaction that writes the postings.
+// This means a wallet's balance can always be proven (or checked) against its postings.
+// ...
+// Wallets are customer liability accounts: a credit increases the balance and a debit decreases it.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/cradoe/morenee/internal/models"
+)
+
+const (
+	// LedgerDebit and LedgerCredit are the two sides of a posting
+	LedgerDebit  = "debit"
+	LedgerCredit = "credit"
+
+	// JournalKindTransferDebit moves money out of the sender's wallet into the transfer clearing account
+	JournalKindTransferDebit = "transfer_debit"
+
+	// JournalKindTransferCredit moves money out of the transfer clearing account into the recipient's wallet
+	JournalKindTransferCredit = "transfer_credit"
+
+	// JournalKindReversal returns money held in the transfer clearing account back to the sender
+	JournalKindReversal = "reversal"
+
+	// JournalKindSuspenseRelease moves money a wallet could not take when it was credited out of the transfer suspense account into the wallet
+	JournalKindSuspenseRelease = "suspense_release"
+
+	// JournalKindFee moves a charge from a wallet into the fee income account
+	JournalKindFee = "fee"
+
+	// LedgerTransferClearingAccount holds money in flight between the debit and credit steps of a transfer
+	LedgerTransferClearingAccount = "transfer_clearing"
+
+	// LedgerTransferSuspenseAccount holds the part of credits that would have taken wallets over their balance limit
+	LedgerTransferSuspenseAccount = "transfer_suspense"
+
+	// LedgerFeeIncomeAccount collects fees charged on wallets
+	LedgerFeeIncomeAccount = "fee_income"
+)
+
+var (
+	ErrUnbalancedJournal = errors.New("journal debits and credits do not balance")
+	ErrInvalidPosting    = errors.New("journal contains an invalid posting")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+type LedgerRepository interface {
+	Post(journal *models.Journal, tx *sql.Tx) (string, error)
+	GetByTransactionId(transactionID string) ([]models.Journal, error)
+	WalletBalance(walletID string) (models.Money, error)
+	VerifyWalletBalance(walletID string) (bool, error)
+}
+
+type LedgerRepositoryImpl struct {
+	db *DB
+}
+
+func NewLedgerRepository(db *DB) LedgerRepository {
+	return &LedgerRepositoryImpl{db: db}
+}
+
+// Post writes a balanced journal and applies its wallet legs to the wallet balances.
+// It runs inside tx when one is given, so callers can combine it with other writes.
+func (repo *LedgerRepositoryImpl) Post(journal *models.Journal, tx *sql.Tx) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var id string
+	err := repo.db.withTx(ctx, tx, func(tx *sql.Tx) error {
+		var err error
+		id, err = postJournal(ctx, tx, journal)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (repo *LedgerRepositoryImpl) GetByTransactionId(transactionID string) ([]models.Journal, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var journals []models.Journal
+
+	query := `
+		SELECT id, transaction_id, kind, COALESCE(description, '') AS description, created_at
+		FROM ledger_journals
+		WHERE transaction_id = $1
+		ORDER BY created_at ASC`
+
+	err := repo.db.SelectContext(ctx, &journals, query, transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT
+			p.id,
+			p.journal_id,
+			p.account_id,
+			COALESCE(a.wallet_id::text, '') AS wallet_id,
+			COALESCE(a.code, '') AS code,
+			p.direction,
+			p.amount,
+			p.created_at
+		FROM ledger_postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE p.journal_id = $1`
+
+	for i := range journals {
+		err := repo.db.SelectContext(ctx, &journals[i].Postings, query, journals[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return journals, nil
+}
+
+// WalletBalance computes the balance of a wallet purely from its ledger postings
+func (repo *LedgerRepositoryImpl) WalletBalance(walletID string) (models.Money, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var balance models.Money
+
+	query := `
+		SELECT COALESCE(SUM(CASE WHEN p.direction = $2 THEN p.amount ELSE -p.amount END), 0)
+		FROM ledger_postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.wallet_id = $1`
+
+	err := repo.db.GetContext(ctx, &balance, query, walletID, LedgerCredit)
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	return balance, nil
+}
+
+// VerifyWalletBalance checks that the balance stored on the wallet agrees with its postings
+func (repo *LedgerRepositoryImpl) VerifyWalletBalance(walletID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var stored models.Money
+	err := repo.db.GetContext(ctx, &stored, `SELECT balance FROM wallets WHERE id = $1`, walletID)
+	if err != nil {
+		return false, err
+	}
+
+	computed, err := repo.WalletBalance(walletID)
+	if err != nil {
+		return false, err
+	}
+
+	return stored.Minor == computed.Minor, nil
+}
+
+// postJournal validates the journal, locks the wallets it touches (in a stable order, to avoid deadlocks),
+// writes the journal with its postings and moves the wallet balances, all within tx.
+// A wallet leg can never take the wallet below its held funds; ErrInsufficientFunds is returned instead.
+func postJournal(ctx context.Context, tx *sql.Tx, journal *models.Journal) (string, error) {
+	if len(journal.Postings) < 2 {
+		return "", ErrUnbalancedJournal
+	}
+
+	// a journal is always in a single currency, that of its first leg
+	currency := journal.Postings[0].Amount.Currency
+	debits := models.NewMoney(0, currency)
+	credits := models.NewMoney(0, currency)
+	walletDeltas := map[string]models.Money{}
+
+	for _, posting := range journal.Postings {
+		amount := posting.Amount
+		if !amount.IsPositive() || (posting.WalletID == "") == (posting.AccountCode == "") {
+			return "", ErrInvalidPosting
+		}
+
+		var err error
+		delta := amount
+
+		switch posting.Direction {
+		case LedgerDebit:
+			debits, err = debits.Add(amount)
+			delta = amount.Neg()
+		case LedgerCredit:
+			credits, err = credits.Add(amount)
+		default:
+			return "", ErrInvalidPosting
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if posting.WalletID != "" {
+			current, ok := walletDeltas[posting.WalletID]
+			if !ok {
+				current = models.NewMoney(0, currency)
+			}
+			walletDeltas[posting.WalletID], err = current.Add(delta)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if balanced, err := debits.Cmp(credits); err != nil || balanced != 0 {
+		return "", ErrUnbalancedJournal
+	}
+
+	walletIDs := make([]string, 0, len(walletDeltas))
+	for walletID := range walletDeltas {
+		walletIDs = append(walletIDs, walletID)
+	}
+	sort.Strings(walletIDs)
+
+	for _, walletID := range walletIDs {
+		wallet := models.Wallet{
+			Balance:     models.NewMoney(0, currency),
+			HeldBalance: models.NewMoney(0, currency),
+		}
+		err := tx.QueryRowContext(ctx,
+			`SELECT balance, held_balance FROM wallets WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+			walletID,
+		).Scan(&wallet.Balance, &wallet.HeldBalance)
+		if err != nil {
+			return "", err
+		}
+
+		delta := walletDeltas[walletID]
+		if !delta.IsNegative() {
+			continue
+		}
+
+		// money taken out of a wallet can only come from its available balance,
+		// funds held for other transfers are not touched
+		available, err := wallet.AvailableBalance()
+		if err != nil {
+			return "", err
+		}
+
+		newBalance, err := available.Add(delta)
+		if err != nil {
+			return "", err
+		}
+
+		if newBalance.IsNegative() {
+			return "", ErrInsufficientFunds
+		}
+	}
+
+	var journalID string
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO ledger_journals (transaction_id, kind, description)
+		VALUES ($1, $2, $3)
+		RETURNING id`,
+		journal.TransactionID,
+		journal.Kind,
+		journal.Description,
+	).Scan(&journalID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, posting := range journal.Postings {
+		var accountQuery, accountKey string
+		if posting.WalletID != "" {
+			accountQuery = `SELECT id FROM ledger_accounts WHERE wallet_id = $1`
+			accountKey = posting.WalletID
+		} else {
+			accountQuery = `SELECT id FROM ledger_accounts WHERE code = $1`
+			accountKey = posting.AccountCode
+		}
+
+		var accountID string
+		err := tx.QueryRowContext(ctx, accountQuery, accountKey).Scan(&accountID)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO ledger_postings (journal_id, account_id, direction, amount)
+			VALUES ($1, $2, $3, $4)`,
+			journalID, accountID, posting.Direction, posting.Amount,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, walletID := range walletIDs {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE wallets SET balance = balance + $1, updated_at = NOW() WHERE id = $2`,
+			walletDeltas[walletID], walletID,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	journal.ID = journalID
+	return journalID, nil
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"github.com/cradoe/morenee/assets"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+)
+
+// Migrations reads the migrations embedded in the binary, from assets/migrations
+func Migrations() (source.Driver, error) {
+	return iofs.New(assets.EmbeddedFiles, "migrations")
+}
+
+// NewMigrator applies the embedded migrations to the database at dsn.
+// Migrations take a lock on the database, so only one migrator runs them at a time.
+func NewMigrator(dsn string) (*migrate.Migrate, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.NewWithSourceInstance("iofs", migrations, "postgres://"+dsn)
+}
@@ -0,0 +1,163 @@
+// The outbox makes event publishing part of the database transaction that causes it.
+// Instead of producing to Kafka directly (and hoping it works), a message is written to
+// outbox_messages in the same transaction as the change it describes, with its key and headers.
+// A relay then publishes pending messages to Kafka, retries failures and marks them sent.
+// ...
+// Publishing is at-least-once: a message can be published more than once if the relay
+// crashes after producing but before marking it as sent, so consumers must be idempotent.
+// Messages with the same key are published in the order they were written, one relay at a time,
+// only a message parked as failed lets the later messages of its key go ahead without it.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// OutboxStatusPending is used for messages waiting to be published (or retried)
+	OutboxStatusPending = "pending"
+
+	// OutboxStatusSent is used for messages that have been delivered to Kafka
+	OutboxStatusSent = "sent"
+
+	// OutboxStatusFailed is used for messages that could not be delivered after the maximum number of attempts
+	OutboxStatusFailed = "failed"
+
+	// outboxClaimLock is the advisory lock relays take to claim messages, one after the other.
+	// It is outside of the range of hashtext, so it can't be taken for a KYC fingerprint.
+	outboxClaimLock int64 = 0x6f7574626f78
+)
+
+type OutboxRepository interface {
+	Insert(message *models.OutboxMessage, tx *sql.Tx) (string, error)
+	ClaimPending(limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkSent(id string) error
+	MarkFailed(id string, lastError string, retryAt time.Time, maxAttempts int) error
+	Release(id string) error
+}
+
+type OutboxRepositoryImpl struct {
+	db *DB
+}
+
+func NewOutboxRepository(db *DB) OutboxRepository {
+	return &OutboxRepositoryImpl{db: db}
+}
+
+func (repo *OutboxRepositoryImpl) Insert(message *models.OutboxMessage, tx *sql.Tx) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var id string
+
+	query := `
+		INSERT INTO outbox_messages (topic, message_key, payload, headers)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`
+
+	args := []any{message.Topic, message.Key, message.Payload, message.Headers}
+
+	if tx != nil {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&id)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		err := repo.db.GetContext(ctx, &id, query, args...)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// ClaimPending picks the oldest messages that are due for publishing and leases them to the caller.
+// Leased messages are hidden from other relays until the lease expires,
+// so a crashed relay's messages are picked up again later.
+// A message is not claimed while an older message with the same key is leased or waiting for a retry,
+// so messages of a key are never published by two relays at once, nor out of order.
+// Relays claim one after the other, the claim only takes as long as the query.
+func (repo *OutboxRepositoryImpl) ClaimPending(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	messages := []models.OutboxMessage{}
+
+	query := `
+		UPDATE outbox_messages
+		SET available_at = NOW() + $3 * INTERVAL '1 millisecond', attempts = attempts + 1
+		WHERE id IN (
+			SELECT m.id FROM outbox_messages m
+			WHERE m.status = $1 AND m.available_at <= NOW()
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox_messages o
+					WHERE o.message_key = m.message_key
+						AND o.status = $1
+						AND o.available_at > NOW()
+						AND o.created_at <= m.created_at
+						AND o.id <> m.id
+				)
+			ORDER BY m.created_at ASC
+			LIMIT $2
+		)
+		RETURNING id, topic, message_key, payload, headers, status, attempts, last_error, available_at, created_at, sent_at`
+
+	err := repo.db.withTx(ctx, nil, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, outboxClaimLock)
+		if err != nil {
+			return err
+		}
+
+		return sqlx.SelectContext(ctx, repo.db.queryer(tx), &messages, query, OutboxStatusPending, limit, lease.Milliseconds())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (repo *OutboxRepositoryImpl) MarkSent(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `UPDATE outbox_messages SET status = $1, sent_at = NOW(), last_error = NULL WHERE id = $2`
+
+	_, err := repo.db.ExecContext(ctx, query, OutboxStatusSent, id)
+	return err
+}
+
+// MarkFailed records a failed publish attempt and schedules the next one.
+// Once a message has used up maxAttempts it is parked as failed and no longer retried.
+func (repo *OutboxRepositoryImpl) MarkFailed(id string, lastError string, retryAt time.Time, maxAttempts int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE outbox_messages
+		SET last_error = $1,
+			available_at = $2,
+			status = CASE WHEN attempts >= $3 THEN $4 ELSE status END
+		WHERE id = $5`
+
+	_, err := repo.db.ExecContext(ctx, query, lastError, retryAt, maxAttempts, OutboxStatusFailed, id)
+	return err
+}
+
+// Release gives a claimed message back without publishing it, it is due again at once and the claim is not counted as an attempt.
+// The relay releases the messages that come after one of their key that failed, they are claimed again once it is sent.
+func (repo *OutboxRepositoryImpl) Release(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `UPDATE outbox_messages SET available_at = NOW(), attempts = GREATEST(attempts - 1, 0) WHERE id = $1 AND status = $2`
+
+	_, err := repo.db.ExecContext(ctx, query, id, OutboxStatusPending)
+	return err
+}
@@ -0,0 +1,69 @@
+// Kafka delivers messages at least once, so a worker can receive the same transfer event more than once
+// (after a rebalance, a crash before committing the offset, or an outbox message published twice).
+// processed_messages records every transfer step that has been applied, keyed by transaction ID and step.
+// ...
+// Workers record the step in the same database transaction as the change it makes,
+// so either both happen or neither does, and a redelivered message becomes a no-op.
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+const (
+	// ProcessedStepDebit is recorded when the sender's wallet has been debited for a transfer
+	ProcessedStepDebit = "debit"
+
+	// ProcessedStepCredit is recorded when the recipient's wallet has been credited for a transfer
+	ProcessedStepCredit = "credit"
+
+	// ProcessedStepReversal is recorded when a failed transfer has been reversed to the sender
+	ProcessedStepReversal = "reversal"
+
+	// ProcessedStepSuccess is recorded when a transfer has been marked as completed
+	ProcessedStepSuccess = "success"
+)
+
+type ProcessedMessageRepository interface {
+	MarkProcessed(transactionID string, step string, tx *sql.Tx) (bool, error)
+}
+
+type ProcessedMessageRepositoryImpl struct {
+	db *DB
+}
+
+func NewProcessedMessageRepository(db *DB) ProcessedMessageRepository {
+	return &ProcessedMessageRepositoryImpl{db: db}
+}
+
+// MarkProcessed records that a step of a transaction has been applied.
+// It returns false when the step had already been recorded, which means the message is a replay.
+// When two deliveries race, the second one waits on the first transaction's row and then returns false.
+func (repo *ProcessedMessageRepositoryImpl) MarkProcessed(transactionID string, step string, tx *sql.Tx) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO processed_messages (transaction_id, step)
+		VALUES ($1, $2)
+		ON CONFLICT (transaction_id, step) DO NOTHING`
+
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, query, transactionID, step)
+	} else {
+		result, err = repo.db.ExecContext(ctx, query, transactionID, step)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
@@ -0,0 +1,248 @@
+// A credit that would take a wallet over the balance limit of its owner's KYC level is only credited up to the limit,
+// the rest is parked in the transfer suspense account as a suspended credit of the wallet.
+// The wallet's suspended balance is the sum of its suspended credits, so owners see the money waiting for them.
+// ...
+// Suspended credits are released into the wallet, oldest first, as far as the limit allows:
+// once the owner moves up a level, spends some of their balance, or admins raise the limit.
+// Both moves are ledger journals, so the suspense account always holds the sum of the suspended balances.
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type SuspendedCreditRepository interface {
+	Credit(walletID string, amount models.Money, limit models.Money, transactionID string, tx *sql.Tx) (models.Money, error)
+	Release(walletID string, limit models.Money) (models.Money, error)
+}
+
+type SuspendedCreditRepositoryImpl struct {
+	db *DB
+}
+
+func NewSuspendedCreditRepository(db *DB) SuspendedCreditRepository {
+	return &SuspendedCreditRepositoryImpl{db: db}
+}
+
+// Credit moves money held in the transfer clearing account into the wallet, up to the balance limit,
+// and suspends the rest. Money already in suspense counts against the limit, so it is released before newer credits.
+// The wallet row is locked until tx is committed, so concurrent credits are checked one after the other.
+// It returns the amount that was suspended, zero when the whole amount was credited.
+func (repo *SuspendedCreditRepositoryImpl) Credit(walletID string, amount models.Money, limit models.Money, transactionID string, tx *sql.Tx) (models.Money, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	if !amount.IsPositive() {
+		return models.Money{}, ErrInvalidPosting
+	}
+
+	suspended := models.NewMoney(0, amount.Currency)
+	err := repo.db.withTx(ctx, tx, func(tx *sql.Tx) error {
+		wallet := models.Wallet{
+			Balance:          models.NewMoney(0, amount.Currency),
+			SuspendedBalance: models.NewMoney(0, amount.Currency),
+		}
+		err := tx.QueryRowContext(ctx,
+			`SELECT balance, suspended_balance FROM wallets WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+			walletID,
+		).Scan(&wallet.Balance, &wallet.SuspendedBalance)
+		if err != nil {
+			return err
+		}
+
+		taken, err := wallet.Balance.Add(wallet.SuspendedBalance)
+		if err != nil {
+			return err
+		}
+
+		room, err := limitRoom(limit.WithCurrency(amount.Currency), taken)
+		if err != nil {
+			return err
+		}
+
+		credited, err := minMoney(amount, room)
+		if err != nil {
+			return err
+		}
+
+		suspended, err = amount.Sub(credited)
+		if err != nil {
+			return err
+		}
+
+		postings := []models.Posting{
+			{AccountCode: LedgerTransferClearingAccount, Direction: LedgerDebit, Amount: amount},
+		}
+		if credited.IsPositive() {
+			postings = append(postings, models.Posting{WalletID: walletID, Direction: LedgerCredit, Amount: credited})
+		}
+		if suspended.IsPositive() {
+			postings = append(postings, models.Posting{AccountCode: LedgerTransferSuspenseAccount, Direction: LedgerCredit, Amount: suspended})
+		}
+
+		_, err = postJournal(ctx, tx, &models.Journal{
+			TransactionID: sql.NullString{String: transactionID, Valid: transactionID != ""},
+			Kind:          JournalKindTransferCredit,
+			Description:   "Transfer credit",
+			Postings:      postings,
+		})
+		if err != nil || !suspended.IsPositive() {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO suspended_credits (wallet_id, transaction_id, amount)
+			VALUES ($1, $2, $3)`,
+			walletID, transactionID, suspended,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE wallets SET suspended_balance = suspended_balance + $1, updated_at = NOW() WHERE id = $2`,
+			suspended, walletID,
+		)
+		return err
+	})
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	return suspended, nil
+}
+
+// Release moves the suspended credits of the wallet into it, oldest first, as far as the balance limit allows.
+// It returns the amount released, zero when the limit leaves no room or nothing is suspended.
+func (repo *SuspendedCreditRepositoryImpl) Release(walletID string, limit models.Money) (models.Money, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var released models.Money
+	err := repo.db.withTx(ctx, nil, func(tx *sql.Tx) error {
+		// every change to suspended credits locks their wallet first
+		var wallet models.Wallet
+		err := tx.QueryRowContext(ctx,
+			`SELECT balance, suspended_balance, currency FROM wallets WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+			walletID,
+		).Scan(&wallet.Balance, &wallet.SuspendedBalance, &wallet.Currency)
+		if err != nil {
+			return err
+		}
+
+		released = models.NewMoney(0, wallet.Currency)
+		if !wallet.SuspendedBalance.IsPositive() {
+			return nil
+		}
+
+		room, err := limitRoom(limit.WithCurrency(wallet.Currency), wallet.Balance.WithCurrency(wallet.Currency))
+		if err != nil || !room.IsPositive() {
+			return err
+		}
+
+		credits := []models.SuspendedCredit{}
+		err = sqlx.SelectContext(ctx, repo.db.queryer(tx), &credits, `
+			SELECT id, wallet_id, transaction_id, amount, released_amount, released_at, created_at, updated_at
+			FROM suspended_credits
+			WHERE wallet_id = $1 AND released_at IS NULL
+			ORDER BY created_at ASC, id ASC`,
+			walletID,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, credit := range credits {
+			left, err := credit.Amount.WithCurrency(wallet.Currency).Sub(credit.ReleasedAmount.WithCurrency(wallet.Currency))
+			if err != nil {
+				return err
+			}
+
+			amount, err := minMoney(left, room)
+			if err != nil {
+				return err
+			}
+			if !amount.IsPositive() {
+				break
+			}
+
+			_, err = postJournal(ctx, tx, &models.Journal{
+				TransactionID: sql.NullString{String: credit.TransactionID, Valid: true},
+				Kind:          JournalKindSuspenseRelease,
+				Description:   "Suspended credit released",
+				Postings: []models.Posting{
+					{AccountCode: LedgerTransferSuspenseAccount, Direction: LedgerDebit, Amount: amount},
+					{WalletID: walletID, Direction: LedgerCredit, Amount: amount},
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				UPDATE suspended_credits
+				SET released_amount = released_amount + $1,
+					released_at = CASE WHEN released_amount + $1 = amount THEN NOW() END,
+					updated_at = NOW()
+				WHERE id = $2`,
+				amount, credit.ID,
+			)
+			if err != nil {
+				return err
+			}
+
+			if released, err = released.Add(amount); err != nil {
+				return err
+			}
+			if room, err = room.Sub(amount); err != nil {
+				return err
+			}
+		}
+
+		if !released.IsPositive() {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE wallets SET suspended_balance = suspended_balance - $1, updated_at = NOW() WHERE id = $2`,
+			released, walletID,
+		)
+		return err
+	})
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	return released, nil
+}
+
+// limitRoom is how much more than taken the limit allows, zero when taken is already at or over it
+func limitRoom(limit models.Money, taken models.Money) (models.Money, error) {
+	room, err := limit.Sub(taken)
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	if room.IsNegative() {
+		return models.NewMoney(0, limit.Currency), nil
+	}
+
+	return room, nil
+}
+
+func minMoney(a models.Money, b models.Money) (models.Money, error) {
+	less, err := b.LessThan(a)
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	if less {
+		return b, nil
+	}
+
+	return a, nil
+}
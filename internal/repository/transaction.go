@@ -9,14 +9,17 @@ import (
 	"time"
 
 	"github.com/cradoe/morenee/internal/models"
+	"github.com/jmoiron/sqlx"
 )
 
 type TransactionRepository interface {
 	Insert(transaction *models.Transaction, tx *sql.Tx) (string, error)
-	UpdateStatus(transactionID string, status string) (bool, error)
-	GetOne(id string) (*models.TransactionDetails, bool, error)
+	UpdateStatus(transactionID string, status string, tx *sql.Tx) (bool, error)
+	GetOne(id string, tx *sql.Tx) (*models.TransactionDetails, bool, error)
+	LockStatus(id string, tx *sql.Tx) (string, bool, error)
+	FindStuckPending(createdBefore time.Time, after *models.TransactionDetails, limit int) ([]*models.TransactionDetails, error)
 	FindAllByWalletId(walletId string, option *FilterTransactionsOptions) ([]*models.TransactionDetails, bool, error)
-	HasExceededDailyLimit(walletID string, intending_amount float64, dailyLimit float64) (bool, error)
+	ExceededTransferLimit(walletID string, amount models.Money, level *models.KYCLevel, tx *sql.Tx) (string, error)
 }
 
 type TransactionRepositoryImpl struct {
@@ -114,14 +117,20 @@ func (repo *TransactionRepositoryImpl) Insert(transaction *models.Transaction, t
 	return id, nil
 }
 
-func (repo *TransactionRepositoryImpl) UpdateStatus(transactionID string, status string) (bool, error) {
+func (repo *TransactionRepositoryImpl) UpdateStatus(transactionID string, status string, tx *sql.Tx) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
 	query := `
         UPDATE transactions SET status=$1 WHERE id=$2`
 
-	result, err := repo.db.ExecContext(ctx, query, status, transactionID)
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, query, status, transactionID)
+	} else {
+		result, err = repo.db.ExecContext(ctx, query, status, transactionID)
+	}
 	if err != nil {
 		return false, err
 	}
@@ -139,7 +148,7 @@ func (repo *TransactionRepositoryImpl) UpdateStatus(transactionID string, status
 	return true, nil
 }
 
-func (repo *TransactionRepositoryImpl) GetOne(id string) (*models.TransactionDetails, bool, error) {
+func (repo *TransactionRepositoryImpl) GetOne(id string, tx *sql.Tx) (*models.TransactionDetails, bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
@@ -150,7 +159,7 @@ func (repo *TransactionRepositoryImpl) GetOne(id string) (*models.TransactionDet
 		WHERE t.id = $1
 	`
 
-	err := repo.db.GetContext(ctx, &transaction, query, id)
+	err := sqlx.GetContext(ctx, repo.db.queryer(tx), &transaction, query, id)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, false, nil
@@ -163,6 +172,62 @@ func (repo *TransactionRepositoryImpl) GetOne(id string) (*models.TransactionDet
 	return &transaction, true, nil
 }
 
+// LockStatus locks the transaction row until tx ends and returns its status.
+// Workers use it to serialise the steps of a transfer with each other and with the recovery sweeper,
+// and to skip work for transactions that have already been completed, failed or reversed.
+func (repo *TransactionRepositoryImpl) LockStatus(id string, tx *sql.Tx) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	if tx == nil {
+		return "", false, errors.New("a transaction can only be locked inside a database transaction")
+	}
+
+	var status string
+	err := tx.QueryRowContext(ctx, `SELECT status FROM transactions WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return status, true, nil
+}
+
+// FindStuckPending returns the oldest transfers that are still pending although they were created before createdBefore,
+// after the transfer after when it is given, so callers page past the transfers they already handled.
+// Reversals are recorded as transfers to self and are never picked.
+func (repo *TransactionRepositoryImpl) FindStuckPending(createdBefore time.Time, after *models.TransactionDetails, limit int) ([]*models.TransactionDetails, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := getTransactionBasicQuery + `
+		WHERE t.status = $1
+		AND t.created_at < $2
+		AND t.sender_wallet_id <> t.recipient_wallet_id
+		AND ($3::TIMESTAMPTZ IS NULL OR (t.created_at, t.id) > ($3, $4::UUID))
+		ORDER BY t.created_at ASC, t.id ASC
+		LIMIT $5`
+
+	var afterCreatedAt sql.NullTime
+	var afterID sql.NullString
+	if after != nil {
+		afterCreatedAt = after.CreatedAt
+		afterID = sql.NullString{String: after.ID, Valid: true}
+	}
+
+	var transactions []*models.TransactionDetails
+	err := repo.db.SelectContext(ctx, &transactions, query, TransactionStatusPending, createdBefore, afterCreatedAt, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// FilterTransactionsOptions narrows down a transaction list,
+// transactions are included from StartDate (inclusive) up to EndDate (exclusive)
 type FilterTransactionsOptions struct {
 	StartDate   *time.Time
 	EndDate     *time.Time
@@ -189,9 +254,9 @@ func (repo *TransactionRepositoryImpl) FindAllByWalletId(walletId string, option
 		placeholderIdx++
 	}
 
-	// Filter by end date if provided
+	// Filter by end date if provided, it is exclusive
 	if option.EndDate != nil {
-		query += " AND t.created_at <= $" + strconv.Itoa(placeholderIdx)
+		query += " AND t.created_at < $" + strconv.Itoa(placeholderIdx)
 		args = append(args, option.EndDate)
 		placeholderIdx++
 	}
@@ -259,33 +324,133 @@ func (repo *TransactionRepositoryImpl) FindAllByWalletId(walletId string, option
 	return transactions, true, nil
 }
 
-// HasExceededDailyLimit checks whether a user has exceeded their daily debit limit based on their transaction history.
-// It sums all transactions initiated by the user for the current day with statuses "completed" or "pending".
-// The function then compares the total debit amount with the provided daily limit. If the total amount (including the current transaction) exceeds the limit, it returns true; otherwise, false.
-func (repo *TransactionRepositoryImpl) HasExceededDailyLimit(walletID string, intending_amount float64, dailyLimit float64) (bool, error) {
+const (
+	// TransferLimitWindowCalendar measures limits from the start of the current day, week (Monday) or month,
+	// in the business time zone the database connection was opened with
+	TransferLimitWindowCalendar = "calendar"
+
+	// TransferLimitWindowRolling measures limits over the last 24 hours, 7 days or 30 days
+	TransferLimitWindowRolling = "rolling"
+)
+
+const (
+	// The limits a transfer can exceed, as reported by ExceededTransferLimit
+	TransferLimitDailyAmount   = "daily_amount"
+	TransferLimitWeeklyAmount  = "weekly_amount"
+	TransferLimitMonthlyAmount = "monthly_amount"
+	TransferLimitDailyCount    = "daily_count"
+	TransferLimitWeeklyCount   = "weekly_count"
+	TransferLimitMonthlyCount  = "monthly_count"
+)
+
+type transferUsage struct {
+	DailyAmount   models.Money `db:"daily_amount"`
+	DailyCount    int32        `db:"daily_count"`
+	WeeklyAmount  models.Money `db:"weekly_amount"`
+	WeeklyCount   int32        `db:"weekly_count"`
+	MonthlyAmount models.Money `db:"monthly_amount"`
+	MonthlyCount  int32        `db:"monthly_count"`
+}
+
+// ExceededTransferLimit checks the sender's daily, weekly and monthly amount and count limits for their KYC level,
+// counting all "completed" or "pending" transfers in each window plus the one being attempted.
+// It returns the first limit that would be exceeded, or an empty string when the transfer is within all of them.
+// ...
+// The sender's wallet row is locked (FOR UPDATE) before the usage is summed,
+// so it must run in the same database transaction that inserts the new transaction.
+// Concurrent transfers from the same wallet then wait for each other,
+// and can't all pass the check and together go over the limit.
+func (repo *TransactionRepositoryImpl) ExceededTransferLimit(walletID string, amount models.Money, level *models.KYCLevel, tx *sql.Tx) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	var totalDebit float64
+	if tx == nil {
+		return "", errors.New("transfer limits must be checked inside a database transaction")
+	}
 
-	// Query to sum the amount of all "completed" or "pending" transactions for the current day
-	query := `
-		SELECT COALESCE(SUM(amount), 0)
-		FROM transactions
-		WHERE sender_wallet_id = $1 
-		AND status IN ($2, $3)
-		AND DATE(created_at) = CURRENT_DATE
-	`
+	_, err := tx.ExecContext(ctx, `SELECT id FROM wallets WHERE id = $1 FOR UPDATE`, walletID)
+	if err != nil {
+		return "", err
+	}
 
-	err := repo.db.GetContext(ctx, &totalDebit, query, walletID, TransactionStatusCompleted, TransactionStatusPending)
+	// reversals are recorded as transfers to self, they don't use up any limit
+	query := `
+		WITH windows AS (
+			SELECT
+				CASE WHEN $2::text = $3::text THEN NOW() - INTERVAL '24 hours' ELSE date_trunc('day', NOW()) END AS day_start,
+				CASE WHEN $2::text = $3::text THEN NOW() - INTERVAL '7 days' ELSE date_trunc('week', NOW()) END AS week_start,
+				CASE WHEN $2::text = $3::text THEN NOW() - INTERVAL '30 days' ELSE date_trunc('month', NOW()) END AS month_start
+		)
+		SELECT
+			COALESCE(SUM(t.amount) FILTER (WHERE t.created_at >= w.day_start), 0) AS daily_amount,
+			COUNT(t.id) FILTER (WHERE t.created_at >= w.day_start) AS daily_count,
+			COALESCE(SUM(t.amount) FILTER (WHERE t.created_at >= w.week_start), 0) AS weekly_amount,
+			COUNT(t.id) FILTER (WHERE t.created_at >= w.week_start) AS weekly_count,
+			COALESCE(SUM(t.amount) FILTER (WHERE t.created_at >= w.month_start), 0) AS monthly_amount,
+			COUNT(t.id) FILTER (WHERE t.created_at >= w.month_start) AS monthly_count
+		FROM windows w
+		LEFT JOIN transactions t
+			ON t.sender_wallet_id = $1
+			AND t.recipient_wallet_id <> t.sender_wallet_id
+			AND t.status IN ($4, $5)
+			AND t.created_at >= LEAST(w.day_start, w.week_start, w.month_start)`
+
+	var usage transferUsage
+	err = sqlx.GetContext(ctx, repo.db.queryer(tx), &usage, query,
+		walletID,
+		level.LimitWindow,
+		TransferLimitWindowRolling,
+		TransactionStatusCompleted,
+		TransactionStatusPending,
+	)
 	if err != nil {
-		return false, err
+		return "", err
+	}
+
+	amountLimits := []struct {
+		name  string
+		used  models.Money
+		limit models.NullMoney
+	}{
+		{TransferLimitDailyAmount, usage.DailyAmount, models.NullMoney{Money: level.DailyTransferLimit, Valid: true}},
+		{TransferLimitWeeklyAmount, usage.WeeklyAmount, level.WeeklyTransferLimit},
+		{TransferLimitMonthlyAmount, usage.MonthlyAmount, level.MonthlyTransferLimit},
 	}
 
-	// Check if the total debit (including the new debit attempt) exceeds the daily limit
-	if totalDebit+intending_amount > dailyLimit {
-		return true, nil
+	for _, l := range amountLimits {
+		if !l.limit.Valid {
+			continue
+		}
+
+		total, err := l.used.WithCurrency(amount.Currency).Add(amount)
+		if err != nil {
+			return "", err
+		}
+
+		exceeded, err := total.GreaterThan(l.limit.Money.WithCurrency(amount.Currency))
+		if err != nil {
+			return "", err
+		}
+		if exceeded {
+			return l.name, nil
+		}
+	}
+
+	countLimits := []struct {
+		name  string
+		used  int32
+		limit sql.NullInt32
+	}{
+		{TransferLimitDailyCount, usage.DailyCount, level.DailyTransferCount},
+		{TransferLimitWeeklyCount, usage.WeeklyCount, level.WeeklyTransferCount},
+		{TransferLimitMonthlyCount, usage.MonthlyCount, level.MonthlyTransferCount},
+	}
+
+	for _, l := range countLimits {
+		if l.limit.Valid && l.used+1 > l.limit.Int32 {
+			return l.name, nil
+		}
 	}
 
-	return false, nil
+	return "", nil
 }
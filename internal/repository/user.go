@@ -19,6 +19,10 @@ type UserRepository interface {
 	ChangePin(id string, pin string) error
 	ChangeProfilePicture(id string, image string) error
 	Lock(id string) error
+	Unlock(id string) (bool, error)
+	Search(search string, limit, offset int) ([]models.User, error)
+	SetRole(id string, role string) error
+	CountByRole(role string) (int, error)
 }
 
 const (
@@ -50,8 +54,8 @@ func (repo *UserRepositoryImpl) Insert(user *models.User, tx *sql.Tx) (string, e
 
 	var id string
 	query := `
-		INSERT INTO users (first_name, last_name, phone_number, gender, email, hashed_password)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (first_name, last_name, phone_number, gender, date_of_birth, email, hashed_password)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id`
 
 	if tx != nil {
@@ -60,6 +64,7 @@ func (repo *UserRepositoryImpl) Insert(user *models.User, tx *sql.Tx) (string, e
 			user.LastName,
 			user.PhoneNumber,
 			user.Gender,
+			user.DateOfBirth,
 			user.Email,
 			user.HashedPassword,
 		).Scan(&id)
@@ -72,6 +77,7 @@ func (repo *UserRepositoryImpl) Insert(user *models.User, tx *sql.Tx) (string, e
 			user.LastName,
 			user.PhoneNumber,
 			user.Gender,
+			user.DateOfBirth,
 			user.Email,
 			user.HashedPassword,
 		)
@@ -198,3 +204,79 @@ func (repo *UserRepositoryImpl) Lock(id string) error {
 	_, err := repo.db.ExecContext(ctx, query, UserAccountLockedStatus, id)
 	return err
 }
+
+// Unlock reopens a locked account, accounts that were never verified go back to pending.
+// It reports false when the account was not locked.
+func (repo *UserRepositoryImpl) Unlock(id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET status = CASE WHEN verified_at IS NULL THEN $1 ELSE $2 END
+		WHERE id = $3 AND status = $4`
+
+	result, err := repo.db.ExecContext(ctx, query, UserAccountActivePending, UserAccountActiveStatus, id, UserAccountLockedStatus)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// Search finds users by name, email, phone number or the account number of one of their wallets,
+// newest first. An empty search returns every user.
+func (repo *UserRepositoryImpl) Search(search string, limit, offset int) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	users := []models.User{}
+
+	query := `
+		SELECT * FROM users
+		WHERE $1 = ''
+			OR email ILIKE '%' || $1 || '%'
+			OR (first_name || ' ' || last_name) ILIKE '%' || $1 || '%'
+			OR phone_number LIKE '%' || $1 || '%'
+			OR id IN (SELECT user_id FROM wallets WHERE account_number = $1)
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3`
+
+	err := repo.db.SelectContext(ctx, &users, query, search, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (repo *UserRepositoryImpl) SetRole(id string, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `UPDATE users SET role = $1 WHERE id = $2`
+
+	_, err := repo.db.ExecContext(ctx, query, role, id)
+	return err
+}
+
+func (repo *UserRepositoryImpl) CountByRole(role string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var count int
+
+	query := `SELECT COUNT(*) FROM users WHERE role = $1`
+
+	err := repo.db.GetContext(ctx, &count, query, role)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -3,17 +3,41 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 
 	"github.com/cradoe/morenee/internal/models"
+	"github.com/lib/pq"
 )
 
 type UserKycDataRepository interface {
-	Insert(userID, submissionData, requirementID string) error
+	Insert(userID, requirementID string, submission models.KYCSubmission, document *models.KYCDocument, fingerprint *models.KYCFingerprint) (string, error)
 	GetAll(userID string) ([]models.KYCData, error)
+	GetOne(id string) (*models.KYCData, bool, error)
 	GetByRequirementId(userID, kycRequirementID string) (*models.KYCData, bool, error)
+	GetPending(limit, offset int) ([]models.KYCData, error)
+	Resubmit(id string, submission models.KYCSubmission, document *models.KYCDocument, fingerprint *models.KYCFingerprint) (bool, error)
+	Review(id, status, reviewerID, reason string) (bool, error)
+	RecordIdentityCheck(id string, check *models.IdentityCheck, approve bool) (bool, error)
 	UpgradeLevel(userID string) (bool, error)
+	GetLinkedAccounts(limit, offset int) ([]models.LinkedIdentity, error)
+	GetFingerprintable(schemas []string, all bool) ([]models.KYCData, error)
+	SetFingerprint(id, fingerprint string) error
 }
 
+const (
+	// KYCDataPendingStatus is the status of KYC data waiting for compliance staff to review it
+	KYCDataPendingStatus = "pending"
+
+	// KYCDataApprovedStatus is the status of KYC data staff verified, only approved data counts towards KYC levels
+	KYCDataApprovedStatus = "approved"
+
+	// KYCDataRejectedStatus is the status of KYC data staff turned down, the user can submit it again
+	KYCDataRejectedStatus = "rejected"
+)
+
+// ErrIdentityLinked is returned when data is saved with a fingerprint that blocks identity numbers another account used
+var ErrIdentityLinked = errors.New("identity number linked to another account")
+
 type UserKycDataRepositoryImpl struct {
 	db *DB
 }
@@ -22,21 +46,35 @@ func NewUserKycDataRepository(db *DB) UserKycDataRepository {
 	return &UserKycDataRepositoryImpl{db: db}
 }
 
-func (repo *UserKycDataRepositoryImpl) Insert(userID, submissionData, requirementID string) error {
+// Insert saves the user's data for a requirement, document is nil for requirements met without a document,
+// and fingerprint is nil for data without an identity number.
+// A fingerprint another account already saved is ErrIdentityLinked when it blocks duplicates, or is saved as a duplicate.
+func (repo *UserKycDataRepositoryImpl) Insert(userID, requirementID string, submission models.KYCSubmission, document *models.KYCDocument, fingerprint *models.KYCFingerprint) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
 	query := `
-		INSERT INTO user_kyc_data (user_id, submission_data, kyc_requirement_id)
-		VALUES ($1, $2, $3)
+		INSERT INTO user_kyc_data (user_id, kyc_requirement_id, submission, document_key, document_name, document_content_type, document_size, identity_fingerprint, duplicate_identity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
 	`
 
-	_, err := repo.db.ExecContext(ctx, query, userID, submissionData, requirementID)
+	var id string
+	err := repo.db.withTx(ctx, nil, func(tx *sql.Tx) error {
+		err := checkFingerprint(ctx, tx, fingerprint, userID)
+		if err != nil {
+			return err
+		}
+
+		documentKey, documentName, documentContentType, documentSize := documentColumns(document)
+		fingerprintValue, duplicate := fingerprintColumns(fingerprint)
+		return tx.QueryRowContext(ctx, query, userID, requirementID, submission, documentKey, documentName, documentContentType, documentSize, fingerprintValue, duplicate).Scan(&id)
+	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return id, nil
 }
 
 func (repo *UserKycDataRepositoryImpl) GetAll(userID string) ([]models.KYCData, error) {
@@ -47,11 +85,21 @@ func (repo *UserKycDataRepositoryImpl) GetAll(userID string) ([]models.KYCData,
 		SELECT 
 			ukd.id, 
 			ukd.user_id, 
-			ukd.submission_data, 
+			ukd.submission, 
 			ukd.kyc_requirement_id,
 			ukd.created_at, 
 			ukd.verified, 
-			kr.requirement
+			ukd.status,
+			ukd.submitted_at,
+			ukd.review_reason,
+			ukd.reviewed_at,
+			ukd.document_key,
+			ukd.document_name,
+			ukd.document_content_type,
+			ukd.document_size,
+			kr.requirement,
+			kr.schema,
+			kr.requires_document
 		FROM 
 			user_kyc_data ukd
 		LEFT JOIN 
@@ -74,11 +122,21 @@ func (repo *UserKycDataRepositoryImpl) GetAll(userID string) ([]models.KYCData,
 		if err := rows.Scan(
 			&kycData.ID,
 			&kycData.UserID,
-			&kycData.SubmissionData,
+			&kycData.Submission,
 			&kycData.RequirementID,
 			&kycData.CreatedAt,
 			&kycData.Verified,
+			&kycData.Status,
+			&kycData.SubmittedAt,
+			&kycData.ReviewReason,
+			&kycData.ReviewedAt,
+			&kycData.DocumentKey,
+			&kycData.DocumentName,
+			&kycData.DocumentContentType,
+			&kycData.DocumentSize,
 			&kycData.Requirement,
+			&kycData.Schema,
+			&kycData.RequiresDocument,
 		); err != nil {
 			return nil, err
 		}
@@ -92,6 +150,59 @@ func (repo *UserKycDataRepositoryImpl) GetAll(userID string) ([]models.KYCData,
 	return kycDataList, nil
 }
 
+func (repo *UserKycDataRepositoryImpl) GetOne(id string) (*models.KYCData, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		SELECT 
+			ukd.id, 
+			ukd.user_id, 
+			ukd.submission, 
+			ukd.kyc_requirement_id,
+			ukd.created_at, 
+			ukd.verified, 
+			ukd.status,
+			ukd.submitted_at,
+			ukd.reviewed_by,
+			ukd.review_reason,
+			ukd.reviewed_at,
+			ukd.document_key,
+			ukd.document_name,
+			ukd.document_content_type,
+			ukd.document_size,
+			ukd.identity_provider,
+			ukd.identity_score,
+			ukd.identity_outcome,
+			ukd.identity_response,
+			ukd.identity_checked_at,
+			ukd.duplicate_identity,
+			kr.requirement,
+			kr.schema,
+			kr.requires_document
+		FROM 
+			user_kyc_data ukd
+		JOIN 
+			kyc_requirements kr 
+		ON 
+			ukd.kyc_requirement_id = kr.id
+		WHERE 
+			ukd.id = $1
+	`
+
+	var kycData models.KYCData
+	err := repo.db.GetContext(ctx, &kycData, query, id)
+
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &kycData, true, nil
+}
+
 func (repo *UserKycDataRepositoryImpl) GetByRequirementId(userID, kycRequirementID string) (*models.KYCData, bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
@@ -99,7 +210,7 @@ func (repo *UserKycDataRepositoryImpl) GetByRequirementId(userID, kycRequirement
 	query := `
 		SELECT 
 			id, 
-			submission_data
+			status
 		FROM 
 			user_kyc_data
 		WHERE 
@@ -119,6 +230,196 @@ func (repo *UserKycDataRepositoryImpl) GetByRequirementId(userID, kycRequirement
 	return &kycData, true, nil
 }
 
+// GetPending is the review queue: the KYC data waiting for a review, the longest waiting first
+func (repo *UserKycDataRepositoryImpl) GetPending(limit, offset int) ([]models.KYCData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		SELECT 
+			ukd.id, 
+			ukd.user_id, 
+			ukd.submission, 
+			ukd.kyc_requirement_id,
+			ukd.created_at, 
+			ukd.verified, 
+			ukd.status,
+			ukd.submitted_at,
+			ukd.reviewed_by,
+			ukd.review_reason,
+			ukd.reviewed_at,
+			ukd.document_key,
+			ukd.document_name,
+			ukd.document_content_type,
+			ukd.document_size,
+			ukd.identity_provider,
+			ukd.identity_score,
+			ukd.identity_outcome,
+			ukd.identity_response,
+			ukd.identity_checked_at,
+			ukd.duplicate_identity,
+			kr.requirement,
+			kr.schema,
+			kr.requires_document
+		FROM 
+			user_kyc_data ukd
+		JOIN 
+			kyc_requirements kr 
+		ON 
+			ukd.kyc_requirement_id = kr.id
+		WHERE 
+			ukd.status = $1
+		ORDER BY ukd.submitted_at ASC
+		LIMIT $2 OFFSET $3
+	`
+
+	kycDataList := []models.KYCData{}
+	err := repo.db.SelectContext(ctx, &kycDataList, query, KYCDataPendingStatus, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return kycDataList, nil
+}
+
+// Resubmit replaces rejected KYC data and sends it back to the review queue, the fingerprint is checked like Insert does.
+// It returns false when the data is not rejected (anymore).
+func (repo *UserKycDataRepositoryImpl) Resubmit(id string, submission models.KYCSubmission, document *models.KYCDocument, fingerprint *models.KYCFingerprint) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE user_kyc_data
+		SET submission = $1, 
+			document_key = $2,
+			document_name = $3,
+			document_content_type = $4,
+			document_size = $5,
+			status = $6, 
+			submitted_at = NOW(),
+			reviewed_by = NULL, 
+			review_reason = NULL, 
+			reviewed_at = NULL,
+			identity_provider = NULL,
+			identity_score = NULL,
+			identity_outcome = NULL,
+			identity_response = NULL,
+			identity_checked_at = NULL,
+			identity_fingerprint = $7,
+			duplicate_identity = $8
+		WHERE id = $9 AND status = $10
+	`
+
+	var resubmitted bool
+	err := repo.db.withTx(ctx, nil, func(tx *sql.Tx) error {
+		var userID string
+		err := tx.QueryRowContext(ctx, `SELECT user_id FROM user_kyc_data WHERE id = $1`, id).Scan(&userID)
+		if err != nil {
+			return err
+		}
+
+		err = checkFingerprint(ctx, tx, fingerprint, userID)
+		if err != nil {
+			return err
+		}
+
+		documentKey, documentName, documentContentType, documentSize := documentColumns(document)
+		fingerprintValue, duplicate := fingerprintColumns(fingerprint)
+		result, err := tx.ExecContext(ctx, query, submission, documentKey, documentName, documentContentType, documentSize, KYCDataPendingStatus, fingerprintValue, duplicate, id, KYCDataRejectedStatus)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		resubmitted = rowsAffected > 0
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return resubmitted, nil
+}
+
+// Review approves or rejects pending KYC data, status is KYCDataApprovedStatus or KYCDataRejectedStatus.
+// It returns false when the data is not pending (anymore), when someone else reviewed it first for example.
+func (repo *UserKycDataRepositoryImpl) Review(id, status, reviewerID, reason string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE user_kyc_data
+		SET status = $1::VARCHAR, 
+			verified = ($1::VARCHAR = $2::VARCHAR),
+			verified_at = CASE WHEN $1::VARCHAR = $2::VARCHAR THEN NOW() END,
+			reviewed_by = $3, 
+			review_reason = $4, 
+			reviewed_at = NOW()
+		WHERE id = $5 AND status = $6
+	`
+
+	result, err := repo.db.ExecContext(ctx, query, status, KYCDataApprovedStatus, reviewerID, reason, id, KYCDataPendingStatus)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// RecordIdentityCheck keeps the check of the identity number of pending KYC data with it.
+// When approve is true the check approves the data too, without a reviewer: the provider vouched for it.
+// It returns false when the data is not pending (anymore).
+func (repo *UserKycDataRepositoryImpl) RecordIdentityCheck(id string, check *models.IdentityCheck, approve bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE user_kyc_data
+		SET identity_provider = $1,
+			identity_score = $2,
+			identity_outcome = $3,
+			identity_response = $4,
+			identity_checked_at = NOW(),
+			status = CASE WHEN $5::BOOLEAN THEN $6 ELSE status END,
+			verified = $5::BOOLEAN,
+			verified_at = CASE WHEN $5::BOOLEAN THEN NOW() END,
+			review_reason = CASE WHEN $5::BOOLEAN THEN $7 END,
+			reviewed_at = CASE WHEN $5::BOOLEAN THEN NOW() END
+		WHERE id = $8 AND status = $9
+	`
+
+	// a provider that sent no response still gets its check recorded
+	var response any
+	if len(check.Response) != 0 {
+		response = string(check.Response)
+	}
+
+	reason := "Verified with " + check.Provider
+	result, err := repo.db.ExecContext(ctx, query, check.Provider, check.Score, check.Outcome, response, approve, KYCDataApprovedStatus, reason, id, KYCDataPendingStatus)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// UpgradeLevel moves the user up to the next KYC level when every requirement of that level is verified,
+// then again to the one after, as far as the user's verified data allows.
+// It returns whether the user moved up at all.
 func (repo *UserKycDataRepositoryImpl) UpgradeLevel(userID string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
@@ -130,56 +431,229 @@ func (repo *UserKycDataRepositoryImpl) UpgradeLevel(userID string) (bool, error)
 		return false, err
 	}
 
-	// Step 2: Check if all requirements are fulfilled for the current level
+	nextLevelQuery := `SELECT id FROM kyc_levels WHERE id > $1 ORDER BY id ASC LIMIT 1`
+
+	// Step 2: Check if all requirements of the next level that are in effect are verified,
+	// data that is pending review or was rejected doesn't count.
+	// A level with no requirement in effect can't be reached, a level admins just added for example.
+	unfulfilledQuery := `
+		SELECT 
+			NOT EXISTS (
+				SELECT 1 
+				FROM kyc_requirements 
+				WHERE kyc_level_id = $2 
+					AND effective_from <= NOW() 
+					AND (retired_at IS NULL OR retired_at > NOW())
+			)
+			OR EXISTS (
+				SELECT 
+					klr.id
+				FROM 
+					kyc_requirements klr
+				LEFT JOIN 
+					user_kyc_data ukd 
+				ON 
+					klr.id = ukd.kyc_requirement_id 
+					AND ukd.user_id = $1
+					AND ukd.verified
+				WHERE 
+					klr.kyc_level_id = $2
+					AND klr.effective_from <= NOW()
+					AND (klr.retired_at IS NULL OR klr.retired_at > NOW())
+					AND ukd.id IS NULL
+			)
+	`
+
+	// Step 3: If all requirements are met, upgrade to the next level
+	upgradeQuery := `UPDATE users SET kyc_level_id = $1 WHERE id = $2`
+
+	upgraded := false
+	for {
+		var nextLevelID int
+		err = repo.db.QueryRowContext(ctx, nextLevelQuery, currentLevelID).Scan(&nextLevelID)
+		if err == sql.ErrNoRows {
+			// the user is at the highest level
+			return upgraded, nil
+		}
+		if err != nil {
+			return upgraded, err
+		}
+
+		var unfulfilled bool
+		err = repo.db.QueryRowContext(ctx, unfulfilledQuery, userID, nextLevelID).Scan(&unfulfilled)
+		if err != nil {
+			return upgraded, err
+		}
+
+		// If there are unfulfilled requirements, return without upgrading
+		if unfulfilled {
+			return upgraded, nil
+		}
+
+		_, err = repo.db.ExecContext(ctx, upgradeQuery, nextLevelID, userID)
+		if err != nil {
+			return upgraded, err
+		}
+
+		currentLevelID = nextLevelID
+		upgraded = true
+	}
+}
+
+// checkFingerprint sets whether an account other than the user's saved the identity number with fingerprint,
+// and is ErrIdentityLinked when it did and the fingerprint blocks duplicates.
+// Rejected data counts too, a number turned down for one account is no less linked to it.
+// The fingerprint stays locked until tx ends, so two accounts saving the same number are checked one after the other.
+func checkFingerprint(ctx context.Context, tx *sql.Tx, fingerprint *models.KYCFingerprint, userID string) error {
+	if fingerprint == nil {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, fingerprint.Value)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM user_kyc_data WHERE identity_fingerprint = $1 AND user_id <> $2
+		)
+	`
+
+	err = tx.QueryRowContext(ctx, query, fingerprint.Value, userID).Scan(&fingerprint.Duplicate)
+	if err != nil {
+		return err
+	}
+
+	if fingerprint.Duplicate && fingerprint.Block {
+		return ErrIdentityLinked
+	}
+
+	return nil
+}
+
+// GetLinkedAccounts reports the identity numbers submitted from more than one account, with the accounts that submitted them.
+// limit and offset page through the numbers, not their accounts, the most recently submitted first:
+// every account of a number is returned with it, however many there are.
+func (repo *UserKycDataRepositoryImpl) GetLinkedAccounts(limit, offset int) ([]models.LinkedIdentity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
 	query := `
+		WITH linked AS (
+			SELECT 
+				identity_fingerprint,
+				MAX(submitted_at) AS last_submitted_at
+			FROM 
+				user_kyc_data
+			WHERE 
+				identity_fingerprint IS NOT NULL
+			GROUP BY identity_fingerprint
+			HAVING COUNT(DISTINCT user_id) > 1
+			ORDER BY last_submitted_at DESC, identity_fingerprint
+			LIMIT $1 OFFSET $2
+		)
 		SELECT 
-			klr.id
+			ukd.identity_fingerprint,
+			kr.requirement,
+			ukd.id AS kyc_data_id,
+			ukd.status,
+			ukd.submitted_at,
+			u.id AS user_id,
+			u.first_name,
+			u.last_name,
+			u.email
 		FROM 
-			kyc_requirements klr
-		LEFT JOIN 
-			user_kyc_data ukd 
-		ON 
-			klr.id = ukd.kyc_requirement_id 
-			AND ukd.user_id = $1
-		WHERE 
-			klr.kyc_level_id = $2
-			AND ukd.id IS NULL;
+			linked l
+		JOIN 
+			user_kyc_data ukd ON ukd.identity_fingerprint = l.identity_fingerprint
+		JOIN 
+			kyc_requirements kr ON kr.id = ukd.kyc_requirement_id
+		JOIN 
+			users u ON u.id = ukd.user_id
+		ORDER BY l.last_submitted_at DESC, l.identity_fingerprint, ukd.submitted_at ASC
 	`
 
-	rows, err := repo.db.QueryContext(ctx, query, userID, currentLevelID)
+	accounts := []models.LinkedKYCAccount{}
+	err := repo.db.SelectContext(ctx, &accounts, query, limit, offset)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	defer rows.Close()
 
-	// If there are unfulfilled requirements, return without upgrading
-	if rows.Next() {
-		return false, nil // User is not eligible for upgrade
+	// accounts come ordered by their number
+	identities := []models.LinkedIdentity{}
+	for _, account := range accounts {
+		if len(identities) == 0 || identities[len(identities)-1].Fingerprint != account.Fingerprint {
+			identities = append(identities, models.LinkedIdentity{Fingerprint: account.Fingerprint})
+		}
+
+		identity := &identities[len(identities)-1]
+		identity.Accounts = append(identity.Accounts, account)
 	}
 
-	// Step 3: If all requirements are met, upgrade to the next level
-	upgradeQuery := `
-		UPDATE 
-			users 
-		SET 
-			kyc_level_id = (
-				SELECT 
-					id 
-				FROM 
-					kyc_levels 
-				WHERE 
-					id > $1 
-				ORDER BY id ASC 
-				LIMIT 1
-			)
+	return identities, nil
+}
+
+// GetFingerprintable is the KYC data of requirements with schemas, which carry an identity number,
+// that has no fingerprint yet, or all of it with all
+func (repo *UserKycDataRepositoryImpl) GetFingerprintable(schemas []string, all bool) ([]models.KYCData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		SELECT 
+			ukd.id, 
+			ukd.user_id, 
+			ukd.submission, 
+			kr.schema
+		FROM 
+			user_kyc_data ukd
+		JOIN 
+			kyc_requirements kr 
+		ON 
+			ukd.kyc_requirement_id = kr.id
 		WHERE 
-			id = $2;
+			kr.schema = ANY($1)
+			AND ($2 OR ukd.identity_fingerprint IS NULL)
+		ORDER BY ukd.created_at ASC
 	`
 
-	_, err = repo.db.ExecContext(ctx, upgradeQuery, currentLevelID, userID)
+	kycDataList := []models.KYCData{}
+	err := repo.db.SelectContext(ctx, &kycDataList, query, pq.Array(schemas), all)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	return kycDataList, nil
+}
+
+// SetFingerprint sets the fingerprint of the identity number of KYC data
+func (repo *UserKycDataRepositoryImpl) SetFingerprint(id, fingerprint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := repo.db.ExecContext(ctx, `UPDATE user_kyc_data SET identity_fingerprint = $1 WHERE id = $2`, fingerprint, id)
+	return err
+}
+
+// documentColumns are the values of the document columns of user_kyc_data, which are NULL without a document
+func documentColumns(document *models.KYCDocument) (key, name, contentType sql.NullString, size sql.NullInt64) {
+	if document == nil {
+		return
+	}
+
+	key = sql.NullString{String: document.Key, Valid: true}
+	name = sql.NullString{String: document.FileName, Valid: true}
+	contentType = sql.NullString{String: document.ContentType, Valid: true}
+	size = sql.NullInt64{Int64: document.Size, Valid: true}
+	return
+}
+
+// fingerprintColumns are the values of the fingerprint columns of user_kyc_data, the fingerprint is NULL without an identity number
+func fingerprintColumns(fingerprint *models.KYCFingerprint) (value sql.NullString, duplicate bool) {
+	if fingerprint == nil {
+		return
 	}
 
-	return true, nil
+	return sql.NullString{String: fingerprint.Value, Valid: true}, fingerprint.Duplicate
 }
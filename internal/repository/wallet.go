@@ -13,15 +13,40 @@ const (
 	WalletOnHoldStatus = "on-hold"
 )
 
+// Every wallet on hold has a reason, see internal/walletstatus
+const (
+	// WalletLimitExceededReason holds a wallet whose balance went over the balance limit of its owner's KYC level,
+	// the hold is lifted automatically once the balance is back within the limit
+	WalletLimitExceededReason = "limit-exceeded"
+
+	// WalletSecurityReason holds a wallet that may be compromised, only staff lift it
+	WalletSecurityReason = "security"
+
+	// WalletComplianceReason holds a wallet for compliance, an investigation for example, only staff lift it
+	WalletComplianceReason = "compliance"
+
+	// WalletUserRequestedReason holds a wallet its owner asked to freeze, only staff lift it
+	WalletUserRequestedReason = "user-requested"
+)
+
+// WalletStaffReasons are the reasons staff can put a wallet on hold for, limit-exceeded holds are automatic
+func WalletStaffReasons() []string {
+	return []string{WalletSecurityReason, WalletComplianceReason, WalletUserRequestedReason}
+}
+
 type WalletRepository interface {
 	Insert(wallet *models.Wallet, tx *sql.Tx) (string, error)
 	Balance(id string) (*models.Wallet, error)
 	GetAllByUserId(userID string) ([]models.Wallet, bool, error)
 	GetOne(id string) (*models.Wallet, bool, error)
 	FindByAccountNumber(account_number string) (*models.Wallet, bool, error)
-	Debit(walletID string, amount float64) (bool, error)
-	Credit(walletID string, amount float64) (bool, error)
-	Lock(id string) error
+	Debit(walletID string, amount models.Money, transactionID string, tx *sql.Tx) (bool, error)
+	Credit(walletID string, amount models.Money, transactionID string, tx *sql.Tx) (bool, error)
+	Reverse(walletID string, amount models.Money, transactionID string, tx *sql.Tx) (bool, error)
+	Lock(id string, reason string) (bool, error)
+	Unlock(id string, reason string) (bool, error)
+	GetOnHold(reason string, afterID string, limit int) ([]models.Wallet, error)
+	GetWithSuspendedBalance(afterID string, limit int) ([]models.Wallet, error)
 }
 
 type WalletRepositoryImpl struct {
@@ -38,10 +63,16 @@ func (repo *WalletRepositoryImpl) Insert(wallet *models.Wallet, tx *sql.Tx) (str
 
 	var id string
 
+	// every wallet gets its own ledger account at creation
 	query := `
-		INSERT INTO wallets (user_id, account_number)
-		VALUES ($1, $2)
-		RETURNING id`
+		WITH wallet AS (
+			INSERT INTO wallets (user_id, account_number)
+			VALUES ($1, $2)
+			RETURNING id, account_number
+		)
+		INSERT INTO ledger_accounts (wallet_id, name)
+		SELECT id, 'Wallet ' || account_number FROM wallet
+		RETURNING wallet_id`
 	if tx != nil {
 		err := tx.QueryRowContext(ctx, query,
 			wallet.UserID,
@@ -71,7 +102,7 @@ func (repo *WalletRepositoryImpl) Balance(id string) (*models.Wallet, error) {
 	var wallet models.Wallet
 
 	query := `
-        SELECT user_id, balance, currency FROM wallets WHERE id=$1 AND deleted_at IS NULL`
+        SELECT user_id, balance, held_balance, suspended_balance, currency FROM wallets WHERE id=$1 AND deleted_at IS NULL`
 
 	err := repo.db.GetContext(ctx, &wallet, query, id)
 
@@ -92,7 +123,7 @@ func (repo *WalletRepositoryImpl) GetAllByUserId(userID string) ([]models.Wallet
 	var wallets []models.Wallet
 
 	query := `
-        SELECT id, balance, currency, account_number, status, created_at FROM wallets WHERE user_id=$1 AND deleted_at IS NULL`
+        SELECT id, user_id, balance, held_balance, currency, account_number, status, status_reason, status_changed_at, suspended_balance, created_at FROM wallets WHERE user_id=$1 AND deleted_at IS NULL`
 
 	err := repo.db.SelectContext(ctx, &wallets, query, userID)
 
@@ -113,7 +144,7 @@ func (repo *WalletRepositoryImpl) GetOne(id string) (*models.Wallet, bool, error
 	var wallet models.Wallet
 
 	query := `
-        SELECT id, user_id, balance, currency, account_number, status, created_at FROM wallets WHERE id=$1 AND deleted_at IS NULL`
+        SELECT id, user_id, balance, held_balance, currency, account_number, status, status_reason, status_changed_at, suspended_balance, created_at FROM wallets WHERE id=$1 AND deleted_at IS NULL`
 
 	err := repo.db.GetContext(ctx, &wallet, query, id)
 
@@ -134,7 +165,7 @@ func (repo *WalletRepositoryImpl) FindByAccountNumber(account_number string) (*m
 	var wallet models.Wallet
 
 	query := `
-        SELECT id, user_id, balance, currency, account_number, status, created_at FROM wallets WHERE account_number=$1 AND deleted_at IS NULL`
+        SELECT id, user_id, balance, held_balance, currency, account_number, status, status_reason, status_changed_at, suspended_balance, created_at FROM wallets WHERE account_number=$1 AND deleted_at IS NULL`
 
 	err := repo.db.GetContext(ctx, &wallet, query, account_number)
 
@@ -148,103 +179,159 @@ func (repo *WalletRepositoryImpl) FindByAccountNumber(account_number string) (*m
 	return &wallet, true, nil
 }
 
-func (repo *WalletRepositoryImpl) Debit(walletID string, amount float64) (bool, error) {
-	// we need to first check if the wallet has enough balance to process the transaction
-	// if not, we return an error
-	// if the wallet has enough balance, we proceed to debit the wallet
-	// we'll use pessimistic lock to hold the account for the duration of the operation
-
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
-
-	tx, err := repo.db.BeginTxx(ctx, nil)
-	if err != nil {
-		return false, err
-	}
+// Debit moves money out of the wallet into the transfer clearing account.
+// It reports false (without an error) when the wallet does not have enough balance.
+// The wallet row is locked (pessimistic lock) by the ledger for the duration of the operation.
+func (repo *WalletRepositoryImpl) Debit(walletID string, amount models.Money, transactionID string, tx *sql.Tx) (bool, error) {
+	return repo.post(&models.Journal{
+		TransactionID: sql.NullString{String: transactionID, Valid: transactionID != ""},
+		Kind:          JournalKindTransferDebit,
+		Description:   "Transfer debit",
+		Postings: []models.Posting{
+			{WalletID: walletID, Direction: LedgerDebit, Amount: amount},
+			{AccountCode: LedgerTransferClearingAccount, Direction: LedgerCredit, Amount: amount},
+		},
+	}, tx)
+}
 
-	defer tx.Rollback()
+// Credit moves money held in the transfer clearing account into the wallet
+func (repo *WalletRepositoryImpl) Credit(walletID string, amount models.Money, transactionID string, tx *sql.Tx) (bool, error) {
+	return repo.post(&models.Journal{
+		TransactionID: sql.NullString{String: transactionID, Valid: transactionID != ""},
+		Kind:          JournalKindTransferCredit,
+		Description:   "Transfer credit",
+		Postings: []models.Posting{
+			{AccountCode: LedgerTransferClearingAccount, Direction: LedgerDebit, Amount: amount},
+			{WalletID: walletID, Direction: LedgerCredit, Amount: amount},
+		},
+	}, tx)
+}
 
-	var wallet models.Wallet
+// Reverse returns money held in the transfer clearing account to the wallet it was debited from
+func (repo *WalletRepositoryImpl) Reverse(walletID string, amount models.Money, transactionID string, tx *sql.Tx) (bool, error) {
+	return repo.post(&models.Journal{
+		TransactionID: sql.NullString{String: transactionID, Valid: transactionID != ""},
+		Kind:          JournalKindReversal,
+		Description:   "Transfer reversal",
+		Postings: []models.Posting{
+			{AccountCode: LedgerTransferClearingAccount, Direction: LedgerDebit, Amount: amount},
+			{WalletID: walletID, Direction: LedgerCredit, Amount: amount},
+		},
+	}, tx)
+}
 
-	query := `
-		SELECT balance FROM wallets WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`
+func (repo *WalletRepositoryImpl) post(journal *models.Journal, tx *sql.Tx) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
 
-	err = tx.GetContext(ctx, &wallet, query, walletID)
+	err := repo.db.withTx(ctx, tx, func(tx *sql.Tx) error {
+		_, err := postJournal(ctx, tx, journal)
+		return err
+	})
 
+	if errors.Is(err, ErrInsufficientFunds) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
 
-	if wallet.Balance < amount {
-		return false, nil
-	}
-
-	query = `
-		UPDATE wallets SET balance=balance-$1 WHERE id=$2 AND deleted_at IS NULL`
+	return true, nil
+}
 
-	_, err = tx.ExecContext(ctx, query, amount, walletID)
+// Lock puts a wallet on hold for reason. An active wallet can be put on hold for any reason,
+// a wallet on hold for going over its balance limit can also be held for any other reason, which staff then have to lift.
+// It reports false when the wallet is already on hold, or could not change reason.
+func (repo *WalletRepositoryImpl) Lock(id string, reason string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
 
+	query := `
+		UPDATE wallets 
+		SET status = $1, status_reason = $2::VARCHAR, status_changed_at = NOW() 
+		WHERE id = $3 
+			AND (
+				status = $4 
+				OR (status = $1 AND status_reason = $5 AND $2::VARCHAR <> $5)
+			)`
+
+	result, err := repo.db.ExecContext(ctx, query, WalletOnHoldStatus, reason, id, WalletActiveStatus, WalletLimitExceededReason)
 	if err != nil {
 		return false, err
 	}
 
-	err = tx.Commit()
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return false, err
 	}
 
-	return true, nil
-
+	return rowsAffected > 0, nil
 }
 
-func (repo *WalletRepositoryImpl) Credit(walletID string, amount float64) (bool, error) {
-	// we'll use pessimistic lock to lock the account for the duration of the operation
-
+// Unlock puts a wallet that is on hold for reason back to active, whatever the reason when reason is empty.
+// It reports false when the wallet was not on hold for the reason.
+func (repo *WalletRepositoryImpl) Unlock(id string, reason string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	tx, err := repo.db.BeginTxx(ctx, nil)
+	query := `
+		UPDATE wallets 
+		SET status = $1, status_reason = NULL, status_changed_at = NOW() 
+		WHERE id = $2 AND status = $3 AND ($4 = '' OR status_reason = $4)`
+
+	result, err := repo.db.ExecContext(ctx, query, WalletActiveStatus, id, WalletOnHoldStatus, reason)
 	if err != nil {
 		return false, err
 	}
 
-	defer tx.Rollback()
-
-	var wallet models.Wallet
-
-	query := `
-		SELECT balance FROM wallets WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`
-
-	err = tx.GetContext(ctx, &wallet, query, walletID)
-
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return false, err
 	}
 
-	query = `
-		UPDATE wallets SET balance=balance+$1 WHERE id=$2 AND deleted_at IS NULL`
+	return rowsAffected > 0, nil
+}
 
-	_, err = tx.ExecContext(ctx, query, amount, walletID)
+// GetOnHold lists the wallets on hold for reason, by ID, from the one after afterID (from the first when it is empty)
+func (repo *WalletRepositoryImpl) GetOnHold(reason string, afterID string, limit int) ([]models.Wallet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
 
-	if err != nil {
-		return false, err
-	}
+	wallets := []models.Wallet{}
+
+	query := `
+		SELECT id, user_id, balance, held_balance, currency, account_number, status, status_reason, status_changed_at, suspended_balance, created_at 
+		FROM wallets 
+		WHERE status = $1 AND status_reason = $2 AND ($3 = '' OR id::TEXT > $3) AND deleted_at IS NULL
+		ORDER BY id::TEXT
+		LIMIT $4`
 
-	err = tx.Commit()
+	err := repo.db.SelectContext(ctx, &wallets, query, WalletOnHoldStatus, reason, afterID, limit)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return true, nil
-
+	return wallets, nil
 }
 
-func (repo *WalletRepositoryImpl) Lock(id string) error {
+// GetWithSuspendedBalance lists the wallets with credits in suspense, by ID, from the one after afterID (from the first when it is empty)
+func (repo *WalletRepositoryImpl) GetWithSuspendedBalance(afterID string, limit int) ([]models.Wallet, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	query := `UPDATE wallets SET status = $1 WHERE id = $2`
+	wallets := []models.Wallet{}
+
+	query := `
+		SELECT id, user_id, balance, held_balance, currency, account_number, status, status_reason, status_changed_at, suspended_balance, created_at 
+		FROM wallets 
+		WHERE suspended_balance > 0 AND ($1 = '' OR id::TEXT > $1) AND deleted_at IS NULL
+		ORDER BY id::TEXT
+		LIMIT $2`
+
+	err := repo.db.SelectContext(ctx, &wallets, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := repo.db.ExecContext(ctx, query, WalletOnHoldStatus, id)
-	return err
+	return wallets, nil
 }
@@ -0,0 +1,202 @@
+// A hold reserves part of a wallet's balance for a transfer that has been initiated but not yet debited.
+// Without it, the same funds could be promised to several concurrent transfers,
+// since the balance is only checked when the transfer is initiated and only moved later by the debit worker.
+// ...
+// A wallet has two balances:
+//   - the ledger balance, which is what the postings say the wallet holds
+//   - the available balance, which is the ledger balance minus all active holds
+//
+// Holds are placed when a transfer is initiated, captured when the debit goes through,
+// released when the transfer fails, and expired when nothing happened before expires_at,
+// so abandoned transfers don't freeze money.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cradoe/morenee/internal/models"
+)
+
+const (
+	// WalletHoldActiveStatus is used for holds that are still reserving funds
+	WalletHoldActiveStatus = "active"
+
+	// WalletHoldCapturedStatus is used for holds whose funds have been debited from the wallet
+	WalletHoldCapturedStatus = "captured"
+
+	// WalletHoldReleasedStatus is used for holds that were given back because the transfer failed
+	WalletHoldReleasedStatus = "released"
+
+	// WalletHoldExpiredStatus is used for holds that were given back because they were not captured in time
+	WalletHoldExpiredStatus = "expired"
+)
+
+type WalletHoldRepository interface {
+	Place(hold *models.WalletHold, tx *sql.Tx) (bool, error)
+	Capture(transactionID string, tx *sql.Tx) (bool, error)
+	Release(transactionID string, tx *sql.Tx) (bool, error)
+	ExpireDue(limit int) (int64, error)
+}
+
+type WalletHoldRepositoryImpl struct {
+	db *DB
+}
+
+func NewWalletHoldRepository(db *DB) WalletHoldRepository {
+	return &WalletHoldRepositoryImpl{db: db}
+}
+
+// Place reserves the hold amount on the wallet.
+// The wallet row is locked while the available balance is checked, so two transfers can't reserve the same funds.
+// It reports false (without an error) when the available balance is not enough.
+func (repo *WalletHoldRepositoryImpl) Place(hold *models.WalletHold, tx *sql.Tx) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	if !hold.Amount.IsPositive() {
+		return false, ErrInvalidPosting
+	}
+
+	err := repo.db.withTx(ctx, tx, func(tx *sql.Tx) error {
+		var wallet models.Wallet
+		err := tx.QueryRowContext(ctx,
+			`SELECT balance, held_balance FROM wallets WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+			hold.WalletID,
+		).Scan(&wallet.Balance, &wallet.HeldBalance)
+		if err != nil {
+			return err
+		}
+
+		available, err := wallet.AvailableBalance()
+		if err != nil {
+			return err
+		}
+
+		insufficient, err := available.LessThan(hold.Amount.WithCurrency(available.Currency))
+		if err != nil {
+			return err
+		}
+		if insufficient {
+			return ErrInsufficientFunds
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO wallet_holds (wallet_id, transaction_id, amount, expires_at)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id`,
+			hold.WalletID, hold.TransactionID, hold.Amount, hold.ExpiresAt,
+		).Scan(&hold.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE wallets SET held_balance = held_balance + $1, updated_at = NOW() WHERE id = $2`,
+			hold.Amount, hold.WalletID,
+		)
+		return err
+	})
+
+	if errors.Is(err, ErrInsufficientFunds) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Capture marks the transaction's hold as captured and gives the held amount back to the available balance,
+// it must run in the same database transaction as the debit that actually moves the funds.
+// It reports false when there is no active hold, for example because it has expired.
+func (repo *WalletHoldRepositoryImpl) Capture(transactionID string, tx *sql.Tx) (bool, error) {
+	return repo.finish(transactionID, WalletHoldCapturedStatus, tx)
+}
+
+// Release gives the funds reserved for a failed transfer back to the available balance.
+// It reports false when there is no active hold.
+func (repo *WalletHoldRepositoryImpl) Release(transactionID string, tx *sql.Tx) (bool, error) {
+	return repo.finish(transactionID, WalletHoldReleasedStatus, tx)
+}
+
+func (repo *WalletHoldRepositoryImpl) finish(transactionID string, status string, tx *sql.Tx) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	found := false
+	err := repo.db.withTx(ctx, tx, func(tx *sql.Tx) error {
+		var walletID string
+		var amount models.Money
+
+		err := tx.QueryRowContext(ctx, `
+			UPDATE wallet_holds SET status = $1, updated_at = NOW()
+			WHERE transaction_id = $2 AND status = $3
+			RETURNING wallet_id, amount`,
+			status, transactionID, WalletHoldActiveStatus,
+		).Scan(&walletID, &amount)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE wallets SET held_balance = held_balance - $1, updated_at = NOW() WHERE id = $2`,
+			amount, walletID,
+		)
+		if err != nil {
+			return err
+		}
+
+		found = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// ExpireDue expires up to limit active holds whose expiry time has passed
+// and returns how many were expired.
+// SKIP LOCKED lets it run next to transfers that are capturing or releasing holds.
+func (repo *WalletHoldRepositoryImpl) ExpireDue(limit int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var expired int64
+
+	query := `
+		WITH expired AS (
+			UPDATE wallet_holds SET status = $1, updated_at = NOW()
+			WHERE id IN (
+				SELECT id FROM wallet_holds
+				WHERE status = $2 AND expires_at <= $3
+				ORDER BY expires_at ASC
+				LIMIT $4
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING wallet_id, amount
+		), totals AS (
+			SELECT wallet_id, SUM(amount) AS amount, COUNT(*) AS holds FROM expired GROUP BY wallet_id
+		), released AS (
+			UPDATE wallets w SET held_balance = w.held_balance - totals.amount, updated_at = NOW()
+			FROM totals
+			WHERE w.id = totals.wallet_id
+			RETURNING totals.holds
+		)
+		SELECT COALESCE(SUM(holds), 0) FROM released`
+
+	err := repo.db.GetContext(ctx, &expired, query, WalletHoldExpiredStatus, WalletHoldActiveStatus, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
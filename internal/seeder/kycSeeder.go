@@ -4,38 +4,61 @@ import (
 	"context"
 	"database/sql"
 	"log"
+
+	"github.com/cradoe/morenee/internal/kyc"
 )
 
-// seedKycData seeds KYC levels and their associated requirements
+// seedKycData seeds the KYC levels, their limits and their requirements into an empty database.
+// Once there is a level, admins manage them (see handler.KycAdminHandler) and the seeder leaves them alone.
 func (seeder *Seeder) seedKycData() {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
+	var seeded bool
+	err := seeder.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM kyc_levels)`).Scan(&seeded)
+	if err != nil {
+		log.Fatalf("Failed to check for KYC levels: %v", err)
+	}
+	if seeded {
+		log.Println("KYC levels already exist, they are managed by admins, skipping")
+		return
+	}
+
 	tx, err := seeder.DB.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		log.Fatalf("Failed to start transaction: %v", err)
 	}
 
+	// nil weekly/monthly limits and counts mean there is no such limit
 	kycLevels := []struct {
-		LevelName           string
-		DailyTransferLimit  int
-		WalletBalanceLimit  int
-		SingleTransferLimit int
-		Requirements        []string
+		LevelName            string
+		DailyTransferLimit   int
+		WalletBalanceLimit   int
+		SingleTransferLimit  int
+		WeeklyTransferLimit  *int
+		MonthlyTransferLimit *int
+		DailyTransferCount   *int
+		Requirements         []string
 	}{
 		{
-			LevelName:           "Tier 1",
-			DailyTransferLimit:  50000,
-			WalletBalanceLimit:  300000,
-			SingleTransferLimit: 10000,
-			Requirements:        []string{"Address", "BVN"},
+			LevelName:            "Tier 1",
+			DailyTransferLimit:   50000,
+			WalletBalanceLimit:   300000,
+			SingleTransferLimit:  10000,
+			WeeklyTransferLimit:  intPointer(200000),
+			MonthlyTransferLimit: intPointer(500000),
+			DailyTransferCount:   intPointer(10),
+			Requirements:         []string{"Address", "BVN"},
 		},
 		{
-			LevelName:           "Tier 2",
-			DailyTransferLimit:  200000,
-			WalletBalanceLimit:  500000,
-			SingleTransferLimit: 100000,
-			Requirements:        []string{"Government-issued ID"},
+			LevelName:            "Tier 2",
+			DailyTransferLimit:   200000,
+			WalletBalanceLimit:   500000,
+			SingleTransferLimit:  100000,
+			WeeklyTransferLimit:  intPointer(1000000),
+			MonthlyTransferLimit: intPointer(3000000),
+			DailyTransferCount:   intPointer(50),
+			Requirements:         []string{"Government-issued ID"},
 		},
 		{
 			LevelName:           "Tier 3",
@@ -46,35 +69,47 @@ func (seeder *Seeder) seedKycData() {
 		},
 	}
 
-	// Insert KYC levels and their requirements
+	// requirements met with a document rather than a value
+	documentRequirements := map[string]bool{
+		"Government-issued ID": true,
+		"Proof of Address":     true,
+	}
+
+	// the schema of the data submitted for each requirement
+	requirementSchemas := map[string]string{
+		"Address":                         kyc.SchemaAddress,
+		"BVN":                             kyc.SchemaBVN,
+		"Government-issued ID":            kyc.SchemaIDDocument,
+		"Proof of Address":                kyc.SchemaDocument,
+		"Occupation/Employer Information": kyc.SchemaEmployment,
+	}
+
+	// Insert KYC levels, the first version of their limits, and their requirements
 	for _, level := range kycLevels {
 		var kycLevelID string
-		err := tx.QueryRowContext(ctx, `
-			INSERT INTO kyc_levels (level_name, daily_transfer_limit, wallet_balance_limit, single_transfer_limit) 
-			VALUES ($1, $2, $3, $4) 
-			ON CONFLICT (level_name) DO NOTHING
-			RETURNING id;`,
-			level.LevelName, level.DailyTransferLimit, level.WalletBalanceLimit, level.SingleTransferLimit,
-		).Scan(&kycLevelID)
-
-		// Check if the insert failed due to conflict (no ID returned)
-		if err == sql.ErrNoRows {
-			// Get the existing ID for the level to ensure requirements are still seeded
-			err = tx.QueryRowContext(ctx, `SELECT id FROM kyc_levels WHERE level_name = $1`, level.LevelName).Scan(&kycLevelID)
+		err := tx.QueryRowContext(ctx, `INSERT INTO kyc_levels (level_name) VALUES ($1) RETURNING id;`, level.LevelName).Scan(&kycLevelID)
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("Failed to insert KYC level '%s': %v", level.LevelName, err)
 		}
 
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO kyc_level_versions (kyc_level_id, version, daily_transfer_limit, wallet_balance_limit, single_transfer_limit, weekly_transfer_limit, monthly_transfer_limit, daily_transfer_count, effective_from, reason) 
+			VALUES ($1, 1, $2, $3, $4, $5, $6, $7, NOW(), 'Seeded');`,
+			kycLevelID, level.DailyTransferLimit, level.WalletBalanceLimit, level.SingleTransferLimit,
+			level.WeeklyTransferLimit, level.MonthlyTransferLimit, level.DailyTransferCount,
+		)
 		if err != nil {
 			tx.Rollback()
-			log.Fatalf("Failed to insert or retrieve KYC level '%s': %v", level.LevelName, err)
+			log.Fatalf("Failed to insert the limits of KYC level '%s': %v", level.LevelName, err)
 		}
 
 		// Insert the KYC requirements for the level
 		for _, requirement := range level.Requirements {
 			_, err = tx.ExecContext(ctx, `
-				INSERT INTO kyc_requirements (kyc_level_id, requirement) 
-				VALUES ($1, $2) 
-				ON CONFLICT DO NOTHING;`,
-				kycLevelID, requirement,
+				INSERT INTO kyc_requirements (kyc_level_id, requirement, schema, requires_document) 
+				VALUES ($1, $2, $3, $4);`,
+				kycLevelID, requirement, requirementSchemas[requirement], documentRequirements[requirement],
 			)
 			if err != nil {
 				tx.Rollback()
@@ -89,3 +124,7 @@ func (seeder *Seeder) seedKycData() {
 	}
 
 }
+
+func intPointer(value int) *int {
+	return &value
+}
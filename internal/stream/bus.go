@@ -0,0 +1,134 @@
+// The event bus carries events between the parts of the application, for example the steps of a transfer.
+// Two implementations are available, chosen with the EVENT_BUS setting:
+//   - KafkaStream, which is what runs in production
+//   - MemoryBus, which keeps everything in the process, so the whole transfer flow can run on one machine without a broker
+//
+// Both deliver messages at least once: a message is delivered again to its group until it is acknowledged,
+// if the subscription that received it goes away first. Messages of a partition are delivered in order,
+// and an acknowledgement only moves the group forward once every message before it was acknowledged too.
+package stream
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// EventBusKafka selects the Kafka event bus
+	EventBusKafka = "kafka"
+
+	// EventBusMemory selects the in-process event bus
+	EventBusMemory = "memory"
+)
+
+var ErrBusClosed = errors.New("event bus is closed")
+
+type EventBus interface {
+	// EnsureTopicsExist creates the topics that don't exist yet
+	EnsureTopicsExist(topics []Topic) error
+
+	// Produce queues a message for publishing, and sends its delivery report on the returned channel:
+	// nil once the bus has the message, or the reason it could not be delivered.
+	// Messages with the same key keep their order.
+	Produce(msg *Message) <-chan error
+
+	// Subscribe joins a consumer group on a topic
+	Subscribe(consumer *StreamConsumer) (Subscription, error)
+
+	// Close delivers the messages that were produced but not yet delivered, and releases the bus
+	Close()
+}
+
+type Subscription interface {
+	// Poll waits up to timeout for the next message, it returns nil when there is none
+	Poll(timeout time.Duration) (*Message, error)
+
+	// Ack marks a message as processed, so it is never delivered to the group again
+	Ack(msg *Message) error
+
+	// Close leaves the group, messages that were not acknowledged are delivered again to the rest of the group
+	Close() error
+}
+
+type Topic struct {
+	Name       string
+	Partitions int
+}
+
+type StreamConsumer struct {
+	GroupId string
+	Topic   string
+}
+
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// offsetTracker works out which offset can be committed for each partition.
+// Messages of a partition can be acknowledged out of order when they are processed concurrently,
+// an offset is only committable once every message before it has been acknowledged too.
+// It is not safe for concurrent use.
+type offsetTracker struct {
+	partitions map[partitionKey]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	// inFlight holds the offsets in the order they were delivered, which is their order in the partition
+	inFlight []int64
+	acked    map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: map[partitionKey]*partitionOffsets{}}
+}
+
+func (t *offsetTracker) delivered(topic string, partition int32, offset int64) {
+	key := partitionKey{topic: topic, partition: partition}
+
+	p, ok := t.partitions[key]
+	if !ok {
+		p = &partitionOffsets{acked: map[int64]bool{}}
+		t.partitions[key] = p
+	}
+
+	p.inFlight = append(p.inFlight, offset)
+}
+
+// ack records an acknowledged message and returns the offset to commit (the next message to read), if it moved
+func (t *offsetTracker) ack(topic string, partition int32, offset int64) (int64, bool) {
+	p, ok := t.partitions[partitionKey{topic: topic, partition: partition}]
+	if !ok {
+		// the partition was revoked while the message was processed
+		return 0, false
+	}
+
+	p.acked[offset] = true
+
+	moved := false
+	var last int64
+	for len(p.inFlight) > 0 && p.acked[p.inFlight[0]] {
+		last = p.inFlight[0]
+		delete(p.acked, last)
+		p.inFlight = p.inFlight[1:]
+		moved = true
+	}
+	if !moved {
+		return 0, false
+	}
+
+	return last + 1, true
+}
+
+func (t *offsetTracker) forget(topic string, partition int32) {
+	delete(t.partitions, partitionKey{topic: topic, partition: partition})
+}
@@ -4,28 +4,54 @@ import (
 	"context"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 )
 
 type KafkaStream struct {
 	kafkaServers string
+
+	// producer is shared by the whole process, its delivery reports are read by handleDeliveryReports
+	producer  *kafka.Producer
+	reports   chan struct{}
+	closeOnce sync.Once
 }
 
-func New(kafkaServers string) *KafkaStream {
-	return &KafkaStream{
+// producerFlushTimeout is how long Close waits for queued messages to be delivered
+const producerFlushTimeout = 15 * time.Second
+
+func New(kafkaServers string) (*KafkaStream, error) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":      kafkaServers,
+		"queue.buffering.max.ms": "5", // Messages are sent in small batches, with little delay
+		// retries never write a message twice, and keep the order of messages on a partition
+		"enable.idempotence": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	st := &KafkaStream{
 		kafkaServers: kafkaServers,
+		producer:     producer,
+		reports:      make(chan struct{}),
 	}
+	go st.handleDeliveryReports()
+
+	return st, nil
 }
 
 // EnsureTopicsExist ensures that all the topics in the provided list exist in Kafka.
-// If a topic does not exist, it will be created with the specified number of partitions and replication factor.
+// If a topic does not exist, it will be created with its number of partitions (at least 1), and as many replicas as there are brokers.
+// The partitions of existing topics are left as they are.
 // The function will be called on startup, when New constructor is called.
 // This approach minimizes unnecessary topic creation requests, improving performance and reducing potential Kafka errors.
 //
 // Why: Kafka does not automatically create topics by default for production environments. This function ensures
 // that missing topics are created ahead of time to avoid runtime errors when producing or consuming messages.
-func (st *KafkaStream) EnsureTopicsExist(topics []string) error {
+func (st *KafkaStream) EnsureTopicsExist(topics []Topic) error {
 	// Create an admin client to interact with Kafka for administrative tasks (e.g., topic creation).
 	adminClient, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": st.kafkaServers})
 	if err != nil {
@@ -48,11 +74,11 @@ func (st *KafkaStream) EnsureTopicsExist(topics []string) error {
 	// Iterate through the list of requested topics.
 	for _, topic := range topics {
 		// Check if the topic already exists in the metadata.
-		if _, exists := metadata.Topics[topic]; !exists {
+		if _, exists := metadata.Topics[topic.Name]; !exists {
 			// If the topic is not found in metadata, add it to the list of topics to be created.
 			missingTopics = append(missingTopics, kafka.TopicSpecification{
-				Topic:             topic,
-				NumPartitions:     1,
+				Topic:             topic.Name,
+				NumPartitions:     max(topic.Partitions, 1),
 				ReplicationFactor: int(numBrokers), // Use the number of brokers as the replication factor.
 			})
 		}
@@ -69,84 +95,160 @@ func (st *KafkaStream) EnsureTopicsExist(topics []string) error {
 	return nil
 }
 
-func (st *KafkaStream) ProduceMessage(topic, message string) error {
-	producer, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers":      st.kafkaServers,
-		"queue.buffering.max.ms": "100", // Reduce buffering delay (optional)
-	})
-	if err != nil {
-		return err
-	}
-	defer func() {
-		producer.Flush(15000)
-		producer.Close()
-	}()
+// Produce queues a message on the shared producer. Messages with the same key go to the same partition.
+// Its delivery report is sent on the returned channel once Kafka has acknowledged (or refused) the message.
+func (st *KafkaStream) Produce(msg *Message) <-chan error {
+	report := make(chan error, 1)
 
-	// Delivery report channel
-	deliveryChan := make(chan kafka.Event, 1)
-
-	// Produce message
-	err = producer.Produce(&kafka.Message{
+	topic := msg.Topic
+	kafkaMessage := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-		Value:          []byte(message),
-	}, deliveryChan)
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Opaque:         report,
+	}
+	for key, value := range msg.Headers {
+		kafkaMessage.Headers = append(kafkaMessage.Headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
 
+	err := st.producer.Produce(kafkaMessage, nil)
 	if err != nil {
 		log.Printf("Failed to produce message: %v", err)
-		return err
+		report <- err
 	}
 
-	// Wait for delivery confirmation
-	e := <-deliveryChan
-	m := e.(*kafka.Message)
-	if m.TopicPartition.Error != nil {
-		log.Printf("Delivery failed: %v", m.TopicPartition.Error)
-	} else {
-		log.Printf("Message delivered to %v", m.TopicPartition)
-	}
-
-	close(deliveryChan)
-	return nil
+	return report
 }
 
-type StreamConsumer struct {
-	GroupId string
-	Topic   string
+// handleDeliveryReports passes each delivery report to the channel of its message,
+// it returns when the producer is closed
+func (st *KafkaStream) handleDeliveryReports() {
+	defer close(st.reports)
+
+	for event := range st.producer.Events() {
+		switch e := event.(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				log.Printf("Delivery failed: %v", e.TopicPartition.Error)
+			}
+
+			if report, ok := e.Opaque.(chan error); ok {
+				report <- e.TopicPartition.Error
+			}
+		case kafka.Error:
+			log.Printf("Kafka producer error: %v", e)
+		}
+	}
 }
 
-func (st *KafkaStream) CreateConsumer(consumerStruct *StreamConsumer) (*kafka.Consumer, error) {
+func (st *KafkaStream) Subscribe(consumerStruct *StreamConsumer) (Subscription, error) {
 	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
 		"bootstrap.servers": st.kafkaServers,
 		"group.id":          consumerStruct.GroupId,
 		"auto.offset.reset": "earliest",
+		// offsets are committed when messages are acknowledged,
+		// and rebalances are reported so we can stop tracking partitions we no longer own
+		"enable.auto.commit":              false,
+		"go.application.rebalance.enable": true,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	if err := consumer.Subscribe(consumerStruct.Topic, nil); err != nil {
+		consumer.Close()
 		return nil, err
 	}
 
-	return consumer, nil
+	return &kafkaSubscription{
+		consumer: consumer,
+		offsets:  newOffsetTracker(),
+	}, nil
 }
 
-func (st *KafkaStream) EnsureTopicExists(topic string) error {
-	adminClient, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": st.kafkaServers})
-	if err != nil {
-		return err
+// Close delivers the messages that are still queued, within producerFlushTimeout, and closes the producer
+func (st *KafkaStream) Close() {
+	st.closeOnce.Do(func() {
+		remaining := st.producer.Flush(int(producerFlushTimeout.Milliseconds()))
+		if remaining > 0 {
+			log.Printf("%d messages were not delivered before the producer was closed", remaining)
+		}
+
+		st.producer.Close()
+		<-st.reports
+	})
+}
+
+// kafkaSubscription commits offsets as messages are acknowledged.
+// Poll must be called from a single goroutine, Ack can be called from any.
+type kafkaSubscription struct {
+	consumer *kafka.Consumer
+
+	mu      sync.Mutex
+	offsets *offsetTracker
+}
+
+func (sub *kafkaSubscription) Poll(timeout time.Duration) (*Message, error) {
+	event := sub.consumer.Poll(int(timeout.Milliseconds()))
+
+	switch e := event.(type) {
+	case *kafka.Message:
+		if e.TopicPartition.Error != nil {
+			return nil, e.TopicPartition.Error
+		}
+
+		msg := &Message{
+			Topic:     *e.TopicPartition.Topic,
+			Partition: e.TopicPartition.Partition,
+			Offset:    int64(e.TopicPartition.Offset),
+			Key:       e.Key,
+			Value:     e.Value,
+			Headers:   map[string]string{},
+		}
+		for _, header := range e.Headers {
+			msg.Headers[header.Key] = string(header.Value)
+		}
+
+		sub.mu.Lock()
+		sub.offsets.delivered(msg.Topic, msg.Partition, msg.Offset)
+		sub.mu.Unlock()
+
+		return msg, nil
+	case kafka.Error:
+		return nil, e
+	case kafka.AssignedPartitions:
+		return nil, sub.consumer.Assign(e.Partitions)
+	case kafka.RevokedPartitions:
+		// messages still in flight for these partitions will be delivered again to their new owner
+		sub.mu.Lock()
+		for _, tp := range e.Partitions {
+			sub.offsets.forget(*tp.Topic, tp.Partition)
+		}
+		sub.mu.Unlock()
+
+		return nil, sub.consumer.Unassign()
 	}
-	defer adminClient.Close()
 
-	_, err = adminClient.CreateTopics(
-		context.Background(),
-		[]kafka.TopicSpecification{
-			{Topic: topic, NumPartitions: 1, ReplicationFactor: 1},
-		},
-	)
-	if err != nil {
-		return err
+	return nil, nil
+}
+
+func (sub *kafkaSubscription) Ack(msg *Message) error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	next, ok := sub.offsets.ack(msg.Topic, msg.Partition, msg.Offset)
+	if !ok {
+		return nil
 	}
 
-	return nil
+	topic := msg.Topic
+	_, err := sub.consumer.CommitOffsets([]kafka.TopicPartition{
+		{Topic: &topic, Partition: msg.Partition, Offset: kafka.Offset(next)},
+	})
+
+	return err
+}
+
+func (sub *kafkaSubscription) Close() error {
+	return sub.consumer.Close()
 }
@@ -0,0 +1,214 @@
+package stream
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errSubscriptionClosed = errors.New("subscription is closed")
+
+// MemoryBus is an event bus that lives in the process, it is meant for development and tests.
+// Every topic has a single partition, and messages are kept for as long as the process runs.
+// Like Kafka, each consumer group gets every message at least once, from the earliest one.
+type MemoryBus struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	closed bool
+}
+
+type memoryTopic struct {
+	// a message's offset is its index
+	messages []*Message
+	groups   map[string]*memoryGroup
+
+	// published is closed and replaced whenever a message is published, it wakes up waiting subscriptions
+	published chan struct{}
+}
+
+type memoryGroup struct {
+	// next is the offset of the next message to deliver to the group
+	next int64
+
+	// committed is the offset of the first message the group has not acknowledged
+	committed int64
+	offsets   *offsetTracker
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		topics: map[string]*memoryTopic{},
+	}
+}
+
+// topic returns the topic, creating it if needed. The bus must be locked.
+func (bus *MemoryBus) topic(name string) *memoryTopic {
+	topic, ok := bus.topics[name]
+	if !ok {
+		topic = &memoryTopic{
+			groups:    map[string]*memoryGroup{},
+			published: make(chan struct{}),
+		}
+		bus.topics[name] = topic
+	}
+
+	return topic
+}
+
+// EnsureTopicsExist creates the topics, with a single partition whatever they ask for
+func (bus *MemoryBus) EnsureTopicsExist(topics []Topic) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	for _, topic := range topics {
+		bus.topic(topic.Name)
+	}
+
+	return nil
+}
+
+// Produce delivers the message straight away, its report is ready when Produce returns
+func (bus *MemoryBus) Produce(msg *Message) <-chan error {
+	report := make(chan error, 1)
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.closed {
+		report <- ErrBusClosed
+		return report
+	}
+
+	headers := make(map[string]string, len(msg.Headers))
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+
+	t := bus.topic(msg.Topic)
+	t.messages = append(t.messages, &Message{
+		Topic:   msg.Topic,
+		Offset:  int64(len(t.messages)),
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	})
+
+	close(t.published)
+	t.published = make(chan struct{})
+
+	report <- nil
+	return report
+}
+
+func (bus *MemoryBus) Subscribe(consumer *StreamConsumer) (Subscription, error) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.closed {
+		return nil, ErrBusClosed
+	}
+
+	topic := bus.topic(consumer.Topic)
+	if _, ok := topic.groups[consumer.GroupId]; !ok {
+		topic.groups[consumer.GroupId] = &memoryGroup{offsets: newOffsetTracker()}
+	}
+
+	return &memorySubscription{
+		bus:     bus,
+		topic:   consumer.Topic,
+		groupID: consumer.GroupId,
+	}, nil
+}
+
+// Close wakes up every waiting subscription, they return ErrBusClosed from then on
+func (bus *MemoryBus) Close() {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.closed {
+		return
+	}
+	bus.closed = true
+
+	for _, topic := range bus.topics {
+		close(topic.published)
+		topic.published = make(chan struct{})
+	}
+}
+
+type memorySubscription struct {
+	bus     *MemoryBus
+	topic   string
+	groupID string
+	closed  bool
+}
+
+func (sub *memorySubscription) Poll(timeout time.Duration) (*Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		sub.bus.mu.Lock()
+		if sub.bus.closed {
+			sub.bus.mu.Unlock()
+			return nil, ErrBusClosed
+		}
+		if sub.closed {
+			sub.bus.mu.Unlock()
+			return nil, errSubscriptionClosed
+		}
+
+		topic := sub.bus.topics[sub.topic]
+		group := topic.groups[sub.groupID]
+
+		if group.next < int64(len(topic.messages)) {
+			msg := *topic.messages[group.next]
+			group.offsets.delivered(msg.Topic, msg.Partition, msg.Offset)
+			group.next++
+			sub.bus.mu.Unlock()
+
+			return &msg, nil
+		}
+
+		published := topic.published
+		sub.bus.mu.Unlock()
+
+		select {
+		case <-published:
+		case <-timer.C:
+			return nil, nil
+		}
+	}
+}
+
+func (sub *memorySubscription) Ack(msg *Message) error {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+
+	group := sub.bus.topics[sub.topic].groups[sub.groupID]
+
+	next, ok := group.offsets.ack(msg.Topic, msg.Partition, msg.Offset)
+	if ok {
+		group.committed = next
+	}
+
+	return nil
+}
+
+// Close rewinds the group to its first unacknowledged message,
+// which is what happens in Kafka when a consumer leaves its group before committing
+func (sub *memorySubscription) Close() error {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+
+	if sub.closed {
+		return nil
+	}
+	sub.closed = true
+
+	group := sub.bus.topics[sub.topic].groups[sub.groupID]
+	group.next = group.committed
+	group.offsets = newOffsetTracker()
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testTopic = "transfers"
+
+func produce(t *testing.T, bus *MemoryBus, key, value string) {
+	t.Helper()
+
+	err := <-bus.Produce(&Message{Topic: testTopic, Key: []byte(key), Value: []byte(value)})
+	if err != nil {
+		t.Fatalf("Produce(%s) error = %v", value, err)
+	}
+}
+
+func subscribe(t *testing.T, bus *MemoryBus, groupID string) Subscription {
+	t.Helper()
+
+	sub, err := bus.Subscribe(&StreamConsumer{GroupId: groupID, Topic: testTopic})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	return sub
+}
+
+// poll returns the next message and its value, or nil and "" when there is none
+func poll(t *testing.T, sub Subscription) (*Message, string) {
+	t.Helper()
+
+	msg, err := sub.Poll(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if msg == nil {
+		return nil, ""
+	}
+
+	return msg, string(msg.Value)
+}
+
+func TestMemoryBusDeliversInOrder(t *testing.T) {
+	bus := NewMemoryBus()
+	defer bus.Close()
+
+	want := []string{}
+	for i := 0; i < 6; i++ {
+		value := fmt.Sprintf("%d", i)
+		produce(t, bus, []string{"wallet-a", "wallet-b"}[i%2], value)
+		want = append(want, value)
+	}
+
+	// every group reads every message, from the earliest one
+	for _, groupID := range []string{"debit", "audit"} {
+		sub := subscribe(t, bus, groupID)
+
+		for i, value := range want {
+			msg, got := poll(t, sub)
+			if got != value {
+				t.Fatalf("group %s message %d = %q, want %q", groupID, i, got, value)
+			}
+			if msg.Offset != int64(i) {
+				t.Errorf("group %s message %d offset = %d, want %d", groupID, i, msg.Offset, i)
+			}
+			if wantKey := []string{"wallet-a", "wallet-b"}[i%2]; string(msg.Key) != wantKey {
+				t.Errorf("group %s message %d key = %q, want %q", groupID, i, msg.Key, wantKey)
+			}
+		}
+
+		if _, got := poll(t, sub); got != "" {
+			t.Errorf("group %s got %q after the last message", groupID, got)
+		}
+	}
+}
+
+func TestMemoryBusRedeliversUnacknowledgedMessages(t *testing.T) {
+	bus := NewMemoryBus()
+	defer bus.Close()
+
+	for _, value := range []string{"first", "second", "third"} {
+		produce(t, bus, "wallet", value)
+	}
+
+	sub := subscribe(t, bus, "credit")
+	first, _ := poll(t, sub)
+	_, _ = poll(t, sub)
+	third, _ := poll(t, sub)
+
+	// the second message is not acknowledged, so the third can't be committed either
+	for _, msg := range []*Message{first, third} {
+		if err := sub.Ack(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sub.Poll(time.Millisecond); !errors.Is(err, errSubscriptionClosed) {
+		t.Errorf("Poll() after Close() error = %v, want %v", err, errSubscriptionClosed)
+	}
+
+	sub = subscribe(t, bus, "credit")
+	for _, want := range []string{"second", "third"} {
+		msg, got := poll(t, sub)
+		if got != want {
+			t.Fatalf("redelivered %q, want %q", got, want)
+		}
+		if err := sub.Ack(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything was acknowledged, nothing is delivered again
+	sub = subscribe(t, bus, "credit")
+	if _, got := poll(t, sub); got != "" {
+		t.Errorf("got %q again after it was acknowledged", got)
+	}
+}
+
+func TestMemoryBusWakesUpPollOnProduce(t *testing.T) {
+	bus := NewMemoryBus()
+	defer bus.Close()
+
+	sub := subscribe(t, bus, "success")
+
+	got := make(chan string, 1)
+	go func() {
+		msg, err := sub.Poll(5 * time.Second)
+		if err != nil || msg == nil {
+			got <- fmt.Sprintf("error %v", err)
+			return
+		}
+		got <- string(msg.Value)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	produce(t, bus, "wallet", "late")
+
+	select {
+	case value := <-got:
+		if value != "late" {
+			t.Errorf("Poll() = %q, want %q", value, "late")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Poll() did not return after a message was produced")
+	}
+}
+
+func TestMemoryBusCloseUnblocksConsumers(t *testing.T) {
+	bus := NewMemoryBus()
+	sub := subscribe(t, bus, "failure")
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := sub.Poll(5 * time.Second)
+		errs <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	bus.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrBusClosed) {
+			t.Errorf("Poll() error = %v, want %v", err, ErrBusClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Poll() did not return after the bus was closed")
+	}
+
+	if err := <-bus.Produce(&Message{Topic: testTopic}); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Produce() after Close() error = %v, want %v", err, ErrBusClosed)
+	}
+	if _, err := bus.Subscribe(&StreamConsumer{GroupId: "failure", Topic: testTopic}); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Subscribe() after Close() error = %v, want %v", err, ErrBusClosed)
+	}
+
+	// closing twice is harmless
+	bus.Close()
+}
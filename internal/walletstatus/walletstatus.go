@@ -0,0 +1,346 @@
+// A wallet is either active or on hold, and a wallet on hold always has a reason:
+//   - limit-exceeded: its balance went over the balance limit of its owner's KYC level.
+//     The hold is set and lifted automatically, it is lifted as soon as the balance is back within the limit:
+//     the owner moved up a level, spent some of it, or admins raised the limit.
+//     The wallet can still send money meanwhile, to get back within the limit, but can't receive any.
+//   - security, compliance and user-requested: staff put the wallet on hold, and only staff lift it.
+//     Staff can hold a wallet already held for its limit for one of these reasons, which then stops it from being lifted automatically.
+//
+// The owner is emailed every time their wallet is put on hold or released.
+//
+// Credits don't go over the limit in the first place: what would is suspended, and released into the wallet
+// as far as the limit allows whenever the limit is enforced (see repository.SuspendedCreditRepository).
+// Holds for going over the limit are left for limits lowered after the money came in.
+package walletstatus
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/cradoe/morenee/internal/helper"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/repository"
+	"github.com/cradoe/morenee/internal/smtp"
+)
+
+// what the owner is told about each reason in the email
+var reasonTexts = map[string]string{
+	repository.WalletLimitExceededReason: "its balance went over the balance limit of your KYC level",
+	repository.WalletSecurityReason:      "for your security",
+	repository.WalletComplianceReason:    "for a compliance review",
+	repository.WalletUserRequestedReason: "as you asked",
+}
+
+var ErrWalletNotFound = errors.New("wallet not found")
+
+type Manager struct {
+	WalletRepo   repository.WalletRepository
+	UserRepo     repository.UserRepository
+	KycRepo      repository.KycRepository
+	ActivityRepo repository.ActivityRepository
+
+	SuspendedCreditRepo repository.SuspendedCreditRepository
+
+	Mailer *smtp.Mailer
+	Helper *helper.Helper
+}
+
+func New(manager *Manager) *Manager {
+	return &Manager{
+		WalletRepo:   manager.WalletRepo,
+		UserRepo:     manager.UserRepo,
+		KycRepo:      manager.KycRepo,
+		ActivityRepo: manager.ActivityRepo,
+
+		SuspendedCreditRepo: manager.SuspendedCreditRepo,
+
+		Mailer: manager.Mailer,
+		Helper: manager.Helper,
+	}
+}
+
+// CanSend reports whether the wallet can send money: active wallets can,
+// and wallets on hold for going over their limit can too, spending is how they get back within it
+func CanSend(wallet *models.Wallet) bool {
+	return wallet.Status == repository.WalletActiveStatus ||
+		(wallet.Status == repository.WalletOnHoldStatus && wallet.StatusReason.String == repository.WalletLimitExceededReason)
+}
+
+// Lock puts the wallet on hold for reason and emails its owner.
+// It reports false when the wallet is already on hold (see WalletRepository.Lock).
+func (m *Manager) Lock(wallet *models.Wallet, reason string) (bool, error) {
+	locked, err := m.WalletRepo.Lock(wallet.ID, reason)
+	if err != nil || !locked {
+		return false, err
+	}
+
+	m.notify(wallet, true, reason)
+	return true, nil
+}
+
+// Unlock puts the wallet on hold for reason back to active, whatever the reason when reason is empty, and emails its owner.
+// It reports false when the wallet was not on hold for the reason.
+func (m *Manager) Unlock(wallet *models.Wallet, reason string) (bool, error) {
+	unlocked, err := m.WalletRepo.Unlock(wallet.ID, reason)
+	if err != nil || !unlocked {
+		return false, err
+	}
+
+	m.notify(wallet, false, wallet.StatusReason.String)
+	return true, nil
+}
+
+// Credit credits the amount held for a transfer to the wallet of the user, up to the balance limit of their KYC level,
+// and suspends the rest. It runs in tx, the credit of the transfer. It returns the amount suspended.
+func (m *Manager) Credit(walletID string, userID string, amount models.Money, transactionID string, tx *sql.Tx) (models.Money, error) {
+	limit, err := m.BalanceLimit(userID)
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	return m.SuspendedCreditRepo.Credit(walletID, amount, limit, transactionID, tx)
+}
+
+// Room is how much more the wallet can take before going over the balance limit of its owner's KYC level,
+// credits waiting in suspense count as taken
+func (m *Manager) Room(wallet *models.Wallet) (models.Money, error) {
+	limit, err := m.BalanceLimit(wallet.UserID)
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	taken, err := wallet.Balance.Add(wallet.SuspendedBalance.WithCurrency(wallet.Balance.Currency))
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	room, err := limit.WithCurrency(wallet.Balance.Currency).Sub(taken)
+	if err != nil {
+		return models.Money{}, err
+	}
+	if room.IsNegative() {
+		return models.NewMoney(0, wallet.Balance.Currency), nil
+	}
+
+	return room, nil
+}
+
+// EnforceLimit checks the wallet against the balance limit of its owner's KYC level:
+// its suspended credits are released as far as the limit allows,
+// an active wallet over the limit is put on hold, a wallet on hold for going over it is released once back within it.
+// Holds for other reasons are left alone. It returns the status the wallet moved to, empty when it didn't move.
+func (m *Manager) EnforceLimit(walletID string) (string, error) {
+	status, _, err := m.enforceLimit(walletID)
+	return status, err
+}
+
+// enforceLimit is EnforceLimit, it also returns the amount of suspended credits released
+func (m *Manager) enforceLimit(walletID string) (string, models.Money, error) {
+	wallet, found, err := m.WalletRepo.GetOne(walletID)
+	if err != nil {
+		return "", models.Money{}, err
+	}
+	if !found {
+		return "", models.Money{}, ErrWalletNotFound
+	}
+
+	limit, err := m.BalanceLimit(wallet.UserID)
+	if err != nil {
+		return "", models.Money{}, err
+	}
+
+	released := models.NewMoney(0, wallet.Balance.Currency)
+	if wallet.SuspendedBalance.IsPositive() {
+		released, err = m.SuspendedCreditRepo.Release(wallet.ID, limit)
+		if err != nil {
+			return "", models.Money{}, err
+		}
+
+		if released.IsPositive() {
+			m.log(wallet, repository.WalletActivityLogSuspenseReleasedDescription)
+
+			wallet.Balance, err = wallet.Balance.Add(released.WithCurrency(wallet.Balance.Currency))
+			if err != nil {
+				return "", models.Money{}, err
+			}
+		}
+	}
+
+	exceeded, err := wallet.Balance.GreaterThan(limit.WithCurrency(wallet.Balance.Currency))
+	if err != nil {
+		return "", models.Money{}, err
+	}
+
+	switch {
+	case exceeded && wallet.Status == repository.WalletActiveStatus:
+		locked, err := m.Lock(wallet, repository.WalletLimitExceededReason)
+		if err != nil || !locked {
+			return "", released, err
+		}
+
+		m.log(wallet, repository.WalletActivityLogLimitLockedDescription)
+		return repository.WalletOnHoldStatus, released, nil
+
+	case !exceeded && wallet.Status == repository.WalletOnHoldStatus && wallet.StatusReason.String == repository.WalletLimitExceededReason:
+		unlocked, err := m.Unlock(wallet, repository.WalletLimitExceededReason)
+		if err != nil || !unlocked {
+			return "", released, err
+		}
+
+		m.log(wallet, repository.WalletActivityLogLimitReleasedDescription)
+		return repository.WalletActiveStatus, released, nil
+	}
+
+	return "", released, nil
+}
+
+// EnforceUserLimits enforces the balance limit on every wallet of the user, once they moved up a KYC level for example
+func (m *Manager) EnforceUserLimits(userID string) error {
+	wallets, _, err := m.WalletRepo.GetAllByUserId(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, wallet := range wallets {
+		_, err := m.EnforceLimit(wallet.ID)
+		if err != nil {
+			return fmt.Errorf("enforcing the balance limit of wallet %s: %w", wallet.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// ReleaseLimitHolds goes through the wallets on hold for going over their limit, and releases those back within it,
+// which limits that changed since, admins raised a limit for example, call for. It returns how many it released.
+func (m *Manager) ReleaseLimitHolds(batchSize int) (int, error) {
+	released := 0
+	afterID := ""
+
+	for {
+		wallets, err := m.WalletRepo.GetOnHold(repository.WalletLimitExceededReason, afterID, batchSize)
+		if err != nil {
+			return released, err
+		}
+
+		for _, wallet := range wallets {
+			status, err := m.EnforceLimit(wallet.ID)
+			if err != nil {
+				log.Printf("Error enforcing the balance limit of wallet %s: %v", wallet.ID, err)
+				continue
+			}
+
+			if status == repository.WalletActiveStatus {
+				released++
+			}
+		}
+
+		if len(wallets) < batchSize {
+			return released, nil
+		}
+
+		afterID = wallets[len(wallets)-1].ID
+	}
+}
+
+// ReleaseSuspendedCredits goes through the wallets with credits in suspense, and releases them as far as the limits allow,
+// which limits that changed since call for. It returns how many wallets it released credits into.
+func (m *Manager) ReleaseSuspendedCredits(batchSize int) (int, error) {
+	released := 0
+	afterID := ""
+
+	for {
+		wallets, err := m.WalletRepo.GetWithSuspendedBalance(afterID, batchSize)
+		if err != nil {
+			return released, err
+		}
+
+		for _, wallet := range wallets {
+			_, credited, err := m.enforceLimit(wallet.ID)
+			if err != nil {
+				log.Printf("Error enforcing the balance limit of wallet %s: %v", wallet.ID, err)
+				continue
+			}
+
+			if credited.IsPositive() {
+				released++
+			}
+		}
+
+		if len(wallets) < batchSize {
+			return released, nil
+		}
+
+		afterID = wallets[len(wallets)-1].ID
+	}
+}
+
+// BalanceLimit is the balance limit of the KYC level of the user,
+// users who haven't reached the first level yet can't keep any money
+func (m *Manager) BalanceLimit(userID string) (models.Money, error) {
+	user, found, err := m.UserRepo.GetOne(userID)
+	if err != nil {
+		return models.Money{}, err
+	}
+	if !found {
+		return models.Money{}, fmt.Errorf("user %s not found", userID)
+	}
+
+	if !user.KYCLevelID.Valid {
+		return models.NewMoney(0, ""), nil
+	}
+
+	level, found, err := m.KycRepo.GetOne(fmt.Sprintf("%d", user.KYCLevelID.Int16))
+	if err != nil {
+		return models.Money{}, err
+	}
+	if !found {
+		return models.Money{}, fmt.Errorf("kyc level %d not found", user.KYCLevelID.Int16)
+	}
+
+	return level.WalletBalanceLimit, nil
+}
+
+// log writes a change the system made on its own to the activity log
+func (m *Manager) log(wallet *models.Wallet, description string) {
+	_, err := m.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      wallet.UserID,
+		Entity:      repository.ActivityLogWalletEntity,
+		EntityId:    wallet.ID,
+		Description: description,
+	})
+	if err != nil {
+		log.Printf("Error logging wallet status change: %v", err)
+	}
+}
+
+// notify emails the owner of the wallet that it was put on hold (locked) or released, reason is the reason of the hold
+func (m *Manager) notify(wallet *models.Wallet, locked bool, reason string) {
+	m.Helper.BackgroundTask(nil, func() error {
+		user, found, err := m.UserRepo.GetOne(wallet.UserID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return nil
+		}
+
+		emailData := m.Helper.NewEmailData()
+		emailData["Name"] = user.FirstName + " " + user.LastName
+		emailData["BankName"] = models.BankName
+		emailData["AccountNumber"] = wallet.AccountNumber
+		emailData["Locked"] = locked
+		emailData["LimitExceeded"] = reason == repository.WalletLimitExceededReason
+		emailData["Reason"] = reasonTexts[reason]
+
+		err = m.Mailer.Send(user.Email, emailData, "wallet-status.tmpl")
+		if err != nil {
+			log.Printf("Error sending wallet status email: %v", err)
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,79 @@
+// Staff can undo a transfer stuck as pending, rather than waiting for the recovery sweeper to give up on it.
+// How a transfer is undone depends on how far it went, which the ledger tells:
+//   - a transfer that was never debited is failed, and its funds hold is released
+//   - a transfer that was debited but not credited is reversed, the money goes back to the sender
+//
+// A transfer that reached the recipient can't be undone this way.
+package worker
+
+import (
+	"errors"
+
+	"github.com/cradoe/morenee/internal/events"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/repository"
+)
+
+const (
+	// CompensationFail marks a transfer that was never debited as failed
+	CompensationFail = "fail"
+
+	// CompensationReverse gives the money of a debited transfer back to the sender
+	CompensationReverse = "reverse"
+)
+
+var (
+	ErrTransferNotPending = errors.New("transfer is not pending")
+	ErrTransferCredited   = errors.New("transfer has been credited to the recipient")
+	ErrTransferReversed   = errors.New("transfer has already been reversed, the recovery sweeper will update its status")
+)
+
+// PlanCompensation returns how a transfer would be undone, without changing anything
+func (wk *Worker) PlanCompensation(transaction *models.TransactionDetails) (string, error) {
+	if transaction.Status != repository.TransactionStatusPending {
+		return "", ErrTransferNotPending
+	}
+
+	journals, err := wk.LedgerRepo.GetByTransactionId(transaction.ID)
+	if err != nil {
+		return "", err
+	}
+
+	debited := false
+	for _, journal := range journals {
+		switch journal.Kind {
+		case repository.JournalKindReversal:
+			return "", ErrTransferReversed
+		case repository.JournalKindTransferCredit:
+			return "", ErrTransferCredited
+		case repository.JournalKindTransferDebit:
+			debited = true
+		}
+	}
+
+	if debited {
+		return CompensationReverse, nil
+	}
+
+	return CompensationFail, nil
+}
+
+// CompensateTransfer undoes a stuck transfer the way PlanCompensation says, and returns how.
+// It reports false when the transfer moved on in the meantime, in which case nothing was done.
+func (wk *Worker) CompensateTransfer(transaction *models.TransactionDetails, reason string) (string, bool, error) {
+	plan, err := wk.PlanCompensation(transaction)
+	if err != nil {
+		return "", false, err
+	}
+
+	var compensated bool
+
+	switch plan {
+	case CompensationReverse:
+		compensated, err = wk.reverseTransfer(events.TransferFromDetails(transaction))
+	default:
+		compensated, err = wk.failTransaction(transaction.ID, reason)
+	}
+
+	return plan, compensated, err
+}
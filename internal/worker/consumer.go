@@ -0,0 +1,231 @@
+// Every worker that consumes a topic runs through the same consumer runner,
+// so each worker only has to provide a handler for a single message.
+// ...
+// The runner:
+//   - processes messages on a fixed number of lanes (the concurrency), instead of a goroutine per message
+//   - sends every message with the same key to the same lane, so messages for one key are handled in order
+//   - retries failed messages with exponential backoff and jitter, and gives up after maxAttempts
+//   - acknowledges a message only once it was processed or given up on, the event bus commits it
+//     once every message before it on its partition is acknowledged too
+//   - drains on shutdown: it stops polling, and lets the lanes finish what they hold
+//
+// A message that was neither processed nor given up on is never acknowledged, so it is delivered again after a restart.
+// Handlers must therefore be idempotent, which every transfer step is.
+package worker
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cradoe/morenee/internal/stream"
+)
+
+const (
+	// defaultConsumerConcurrency is the number of lanes a consumer uses when neither it nor the worker sets one
+	defaultConsumerConcurrency = 4
+
+	// consumerLaneBuffer is how many messages can wait on a lane, it bounds the messages held in memory
+	consumerLaneBuffer = 16
+
+	consumerPollTimeout = 100 * time.Millisecond
+)
+
+// MessageHandler processes a single message. Returning an error retries the message,
+// unless the error is permanent (see isRetryable), in which case the consumer gives up on it straight away.
+type MessageHandler func(msg *stream.Message) error
+
+// GiveUpHandler is called with a message the consumer gave up on, and the last error.
+// The message is acknowledged once it returns nil; an error makes the consumer call it again after a delay.
+type GiveUpHandler func(msg *stream.Message, cause error, attempts int) error
+
+type consumerConfig struct {
+	Name    string
+	GroupID string
+	Topic   string
+
+	// Concurrency is the number of lanes; it defaults to the worker's concurrency
+	Concurrency int
+
+	MaxAttempts    int
+	BaseRetryDelay time.Duration
+	MaxRetryDelay  time.Duration
+
+	Handle MessageHandler
+	GiveUp GiveUpHandler
+}
+
+// isRetryable reports whether processing a message again could succeed
+func isRetryable(err error) bool {
+	return !errors.Is(err, errMalformed)
+}
+
+// runConsumer consumes the configured topic until the worker's context is cancelled
+func (wk *Worker) runConsumer(cfg *consumerConfig) {
+	subscription, err := wk.EventBus.Subscribe(&stream.StreamConsumer{
+		GroupId: cfg.GroupID,
+		Topic:   cfg.Topic,
+	})
+	if err != nil {
+		log.Fatalf("Error creating consumer: %v", err)
+	}
+	defer subscription.Close()
+
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = wk.Concurrency
+	}
+	if concurrency <= 0 {
+		concurrency = defaultConsumerConcurrency
+	}
+
+	var wg sync.WaitGroup
+	lanes := make([]chan *stream.Message, concurrency)
+	for i := range lanes {
+		lanes[i] = make(chan *stream.Message, consumerLaneBuffer)
+
+		wg.Add(1)
+		go func(lane <-chan *stream.Message) {
+			defer wg.Done()
+			for msg := range lane {
+				if !wk.processMessage(cfg, msg) {
+					continue
+				}
+
+				err := subscription.Ack(msg)
+				if err != nil {
+					log.Printf("%s: error acknowledging message %d: %v", cfg.Name, msg.Offset, err)
+				}
+			}
+		}(lanes[i])
+	}
+
+	drain := func() {
+		for _, lane := range lanes {
+			close(lane)
+		}
+		wg.Wait()
+	}
+
+	for {
+		select {
+		case <-wk.Ctx.Done():
+			log.Printf("%s received cancellation signal, draining...", cfg.Name)
+			drain()
+			log.Printf("%s shut down", cfg.Name)
+			return
+		default:
+		}
+
+		msg, err := subscription.Poll(consumerPollTimeout)
+		if errors.Is(err, stream.ErrBusClosed) {
+			log.Printf("%s: event bus closed, draining...", cfg.Name)
+			drain()
+			return
+		}
+		if err != nil {
+			log.Printf("%s: error: %v\n", cfg.Name, err)
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+
+		// a full lane holds up polling, which bounds the messages held in memory
+		select {
+		case lanes[laneFor(msg, concurrency)] <- msg:
+		case <-wk.Ctx.Done():
+			// the message was not processed, it is not acknowledged and will be delivered again
+		}
+	}
+}
+
+// processMessage handles a message until it succeeds or is given up on, and reports whether it can be acknowledged.
+// It returns false when the worker is shutting down before either happened.
+func (wk *Worker) processMessage(cfg *consumerConfig, msg *stream.Message) bool {
+	var err error
+	attempts := 0
+
+	for attempts < cfg.MaxAttempts {
+		attempts++
+
+		err = cfg.Handle(msg)
+		if err == nil {
+			return true
+		}
+
+		if !isRetryable(err) || attempts == cfg.MaxAttempts {
+			break
+		}
+
+		delay := retryDelay(attempts, cfg.BaseRetryDelay, cfg.MaxRetryDelay)
+		log.Printf("%s: attempt failed: %v. Retrying in %v... (attempt %d/%d)\n", cfg.Name, err, delay, attempts, cfg.MaxAttempts)
+		if !sleepContext(wk.Ctx, delay) {
+			return false
+		}
+	}
+
+	log.Printf("%s: giving up after %d attempts: %v. Message: %s\n", cfg.Name, attempts, err, msg.Value)
+
+	// the message is only acknowledged once it has been given up on properly, dead-lettered for example
+	for retry := 1; ; retry++ {
+		giveUpErr := cfg.GiveUp(msg, err, attempts)
+		if giveUpErr == nil {
+			return true
+		}
+
+		log.Printf("%s: error giving up on message: %v", cfg.Name, giveUpErr)
+		if !sleepContext(wk.Ctx, retryDelay(retry, cfg.BaseRetryDelay, cfg.MaxRetryDelay)) {
+			return false
+		}
+	}
+}
+
+// retryDelay doubles the base delay for each attempt, up to max,
+// and picks a random delay in the upper half of it, so failed messages don't all retry at the same time
+func retryDelay(attempts int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, max)
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+
+	return half + rand.N(half)
+}
+
+// sleepContext waits for d, it reports false if ctx was cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// laneFor picks the lane of a message from its key,
+// messages without a key are spread by offset since they have nothing to be ordered with
+func laneFor(msg *stream.Message, lanes int) int {
+	key := msg.Key
+	if len(key) == 0 {
+		key = []byte(strconv.FormatInt(msg.Offset, 10))
+	}
+
+	hash := fnv.New32a()
+	hash.Write(key)
+
+	return int(hash.Sum32() % uint32(lanes))
+}
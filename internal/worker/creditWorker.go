@@ -1,7 +1,7 @@
 // Crediting is done when there's a transfer request and debit has been done from the sender's account
-// Creditting locks the wallet if the received amount exceeds the wallet limit of the user,
-// ... which is controlled by the user's KYC level
-// Our listeners checks (polling) every 100ms for new event
+// Creditting never takes the wallet over the wallet limit of the user, which is controlled by the user's KYC level,
+// ... what would is suspended until the limit allows it, see internal/walletstatus
+// Messages are consumed through the consumer runner (see consumer.go), which polls every 100ms for new events
 // We need to make sure the creditting is done with pessimistic lock, to avoid race condition
 // A log of this action is submitted in another go routine
 // and we then produce a new asynchronous event to mark the transaction as success
@@ -9,103 +9,100 @@ package worker
 
 import (
 	"database/sql"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/cradoe/morenee/internal/handler"
+	"github.com/cradoe/morenee/internal/events"
 	"github.com/cradoe/morenee/internal/models"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/stream"
 )
 
 func (wk *Worker) CreditWorker() {
-	consumer, err := wk.KafkaStream.CreateConsumer(&stream.StreamConsumer{
-		GroupId: transferCreditGroupID,
+	wk.runConsumer(&consumerConfig{
+		Name:    "CreditWorker",
+		GroupID: transferCreditGroupID,
 		Topic:   TransferCreditTopic,
+
+		MaxAttempts:    5,
+		BaseRetryDelay: 2 * time.Second,
+		MaxRetryDelay:  30 * time.Second,
+
+		Handle: wk.handleCreditMessage,
+		// the failure worker reverses the money to the sender and notifies them
+		GiveUp: wk.deadLetter,
 	})
+}
 
+func (wk *Worker) handleCreditMessage(msg *stream.Message) error {
+	transfer, err := decodeTransferEvent(msg)
 	if err != nil {
-		log.Fatalf("Error creating consumer: %v", err)
-	}
-	defer consumer.Close()
-
-	maxRetries := 5
-	baseRetryDelay := time.Second * 2
-
-	for {
-		select {
-		case <-wk.Ctx.Done():
-			log.Println("CreditWorker received cancellation signal, shutting down...")
-			return
-		default:
-			// Poll for events
-			event := consumer.Poll(100)
-			switch e := event.(type) {
-			case *kafka.Message:
-				// We use a goroutine to process each message independently
-				// This is to ensure that the worker remains non-blocking and can continue
-				// processing new messages.
-				// Delay could be caused by network or retry policy,
-				// using goroutine here is an option to make sure our worker can
-				// attend to other requests while retries are handled in the background.
-				go func(msg *kafka.Message) {
-					message := msg.Value
-					var transferReq *handler.TransactionResponseData
-					json.Unmarshal(message, &transferReq)
-
-					retryCount := 0
-					for retryCount < maxRetries {
-						success := wk.creditAccount(transferReq)
-						if success {
-							// Produce message so the success worker can mark the transaction as successful
-							wk.KafkaStream.ProduceMessage(TransferSuccessTopic, string(e.Value))
-							return
-						}
-
-						// we can implement retry mechanism when credit fails
-						// this will be done with exponential backoff
-						// we must have also confirmed that the `DB.CreditWallet` function uses database transaction mechanism
-						// with a rollback strategy for when something happen.
-						retryCount++
-						delay := time.Duration(retryCount) * baseRetryDelay
-						log.Printf("Credit attempt failed. Retrying in %v... (attempt %d/%d)\n", delay, retryCount, maxRetries)
-						time.Sleep(delay)
-					}
-
-					// Final failure handling
-					log.Printf("Failed to credit account after %d retries. Message: %s\n", maxRetries, message)
-
-					wk.processFailedCredit(transferReq)
-				}(e)
-			case kafka.Error:
-				log.Printf("Error: %v\n", e)
-			case *kafka.AssignedPartitions:
-				consumer.Assign(e.Partitions)
-			case *kafka.RevokedPartitions:
-				consumer.Unassign()
-			}
-		}
+		return err
 	}
+
+	// the credited event is written to the outbox together with the credit
+	return wk.creditAccount(transfer)
 }
 
-func (wk *Worker) creditAccount(transferReq *handler.TransactionResponseData) bool {
-	_, err := wk.WalletRepo.Credit(transferReq.Recipient.Wallet.ID, transferReq.Amount)
+func (wk *Worker) creditAccount(transfer *events.Transfer) error {
+	tx, err := wk.DB.Begin()
 	if err != nil {
-		log.Printf("Error crediting wallet: %v", err)
-		return false
+		return fmt.Errorf("starting credit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the transfer may have been reversed in the meantime
+	pending, err := wk.isStillPending(transfer.TransactionID, tx)
+	if err != nil {
+		return fmt.Errorf("checking transaction status: %w", err)
+	}
+	if !pending {
+		log.Printf("Transaction %s is no longer pending, skipping credit", transfer.TransactionID)
+		return nil
+	}
+
+	// a redelivered message must not credit the recipient twice
+	firstDelivery, err := wk.ProcessedRepo.MarkProcessed(transfer.TransactionID, repository.ProcessedStepCredit, tx)
+	if err != nil {
+		return fmt.Errorf("recording credit step: %w", err)
+	}
+
+	// the success worker takes it from there
+	event, err := newTransferEvent(events.TypeTransferCredited, transfer.TransactionID, &events.TransferCredited{
+		Transfer:   *transfer,
+		CreditedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if !firstDelivery {
+		log.Printf("Credit for transaction %s was already applied, skipping", transfer.TransactionID)
+
+		// the credited event is emitted again, in case it was lost; the success worker ignores duplicates
+		return wk.commitWithEvent(tx, TransferSuccessTopic, transferEventKey(TransferSuccessTopic, transfer), event)
+	}
+
+	// the wallet row stays locked until the credit is committed, so the limit is checked against the balance that is actually credited
+	suspended, err := wk.WalletStatus.Credit(transfer.Recipient.WalletID, transfer.Recipient.UserID, transfer.Amount, transfer.TransactionID, tx)
+	if err != nil {
+		return fmt.Errorf("crediting wallet: %w", err)
+	}
+
+	// Produce message (through the outbox) so the success worker can mark the transaction as successful
+	err = wk.commitWithEvent(tx, TransferSuccessTopic, transferEventKey(TransferSuccessTopic, transfer), event)
+	if err != nil {
+		return err
 	}
 
 	// log operation
 	wk.Helper.BackgroundTask(nil, func() error {
-		_, err = wk.ActivityRepo.Insert(&models.ActivityLog{
-			UserID:      transferReq.Recipient.ID,
+		_, err := wk.ActivityRepo.Insert(&models.ActivityLog{
+			UserID:      transfer.Recipient.UserID,
 			Entity:      repository.ActivityLogTransactionEntity,
-			EntityId:    transferReq.ID,
-			Description: handler.TransactionActivityLogCreditDescription,
+			EntityId:    transfer.TransactionID,
+			Description: repository.TransactionActivityLogCreditDescription,
 		})
 
 		if err != nil {
@@ -116,58 +113,37 @@ func (wk *Worker) creditAccount(transferReq *handler.TransactionResponseData) bo
 		return nil
 	})
 
-	// check and lock account if balance has exceeded balance limit
-	wk.Helper.BackgroundTask(nil, func() error {
-		// Step 1: Get the recipient wallet
-		wallet, found, err := wk.WalletRepo.GetOne(transferReq.Recipient.Wallet.ID)
-		if err != nil {
-			log.Printf("Error getting wallet: %v", err)
-			return err
-		}
-		if !found {
-			return errors.New("could not check account limit")
-		}
-
-		// Step 2: Get the recipient's KYC level
-		recipient, found, err := wk.UserRepo.GetOne(transferReq.Recipient.ID)
-		if err != nil {
-			log.Printf("Error getting recipient user: %v", err)
-			return err
-		}
-		if !found {
-			return errors.New("could not check account limit")
-		}
-
-		// Step 3: Determine recipient's KYC level
+	// what would have taken the recipient over their balance limit waits in suspense until the limit allows it
+	if suspended.IsPositive() {
+		wk.Helper.BackgroundTask(nil, func() error {
+			_, err := wk.ActivityRepo.Insert(&models.ActivityLog{
+				UserID:      transfer.Recipient.UserID,
+				Entity:      repository.ActivityLogTransactionEntity,
+				EntityId:    transfer.TransactionID,
+				Description: repository.TransactionActivityLogCreditSuspendedDescription,
+			})
 
-		if !recipient.KYCLevelID.Valid {
-			// user is still in tier 0
-			err := wk.WalletRepo.Lock(transferReq.Recipient.Wallet.ID)
 			if err != nil {
-				return errors.New("error locking recipient account due to missing KYC level 1")
+				log.Printf("Error logging suspended credit: %v", err)
+				return err
 			}
 
 			return nil
-		}
-		kycLevelIDStr := fmt.Sprintf("%d", recipient.KYCLevelID.Int16)
-		recipientKYCLevel, _, err := wk.KycRepo.GetOne(kycLevelIDStr)
-		if err != nil {
-			return errors.New("could not get user kyc")
-		}
+		})
+	}
 
-		// Step 4: Check balance limit and lock wallet if exceeded
-		if wallet.Balance > recipientKYCLevel.WalletBalanceLimit {
-			err = wk.WalletRepo.Lock(transferReq.Recipient.Wallet.ID)
-			if err != nil {
-				log.Printf("Error locking recipient account over max balance limit: %v", err)
-				return err
-			}
+	// put the wallet on hold if the balance has gone over the balance limit of the recipient's KYC level
+	wk.Helper.BackgroundTask(nil, func() error {
+		_, err := wk.WalletStatus.EnforceLimit(transfer.Recipient.WalletID)
+		if err != nil {
+			log.Printf("Error enforcing the balance limit of the recipient's wallet: %v", err)
+			return err
 		}
 
 		return nil
 	})
 
-	return true
+	return nil
 }
 
 // processFailedCredit handles the reversal of a failed credit transaction.
@@ -175,72 +151,134 @@ func (wk *Worker) creditAccount(transferReq *handler.TransactionResponseData) bo
 // When a credit transaction fails after multiple retry attempts, this function performs the following steps:
 // 1. Logs the failed credit attempt to create a record of the failure.
 // 2. Credits the money back to the sender’s wallet to ensure no loss of funds.
-// 3. Logs the successful reversal for transparency and tracking purposes.
-// 4. Marks the original transaction as "Reversed" to indicate its failure and refund status.
-// 5. Creates a new transaction record for the reversal to ensure proper audit trails.
-// 6. Logs the new reversal transaction to document the entire process.
-func (wk *Worker) processFailedCredit(transferReq *handler.TransactionResponseData) bool {
+// 3. Marks the original transaction as "Reversed" to indicate its failure and refund status.
+// 4. Creates a new transaction record for the reversal to ensure proper audit trails.
+// 5. Logs the successful reversal and the new reversal transaction to document the entire process.
+// Steps 2 to 4 happen in one database transaction, together with the reversal step record,
+// so a transfer can never be reversed twice.
+// It reports whether the transfer was reversed by this call; false without an error means there was nothing to reverse.
+func (wk *Worker) processFailedCredit(transfer *events.Transfer) (bool, error) {
 	// Log the failed credit attempt synchronously
 	_, err := wk.ActivityRepo.Insert(&models.ActivityLog{
-		UserID:      transferReq.Sender.ID,
+		UserID:      transfer.Sender.UserID,
 		Entity:      repository.ActivityLogTransactionEntity,
-		EntityId:    transferReq.ID,
-		Description: handler.TransactionActivityLogFailedCreditDescription,
+		EntityId:    transfer.TransactionID,
+		Description: repository.TransactionActivityLogFailedCreditDescription,
 	})
 	if err != nil {
 		log.Printf("Error logging failed credit action: %v", err)
 	}
 
-	// Reverse the money to the sender
-	_, err = wk.WalletRepo.Credit(transferReq.Sender.Wallet.ID, transferReq.Amount)
+	return wk.reverseTransfer(transfer)
+}
+
+// reverseTransfer gives the money of a debited transfer back to the sender, see processFailedCredit
+func (wk *Worker) reverseTransfer(transfer *events.Transfer) (bool, error) {
+	tx, err := wk.DB.Begin()
 	if err != nil {
-		log.Printf("Error reversing money from failed credit: %v", err)
-		return false
+		return false, fmt.Errorf("starting reversal transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	// Log the successful credit reversal
-	_, err = wk.ActivityRepo.Insert(&models.ActivityLog{
-		UserID:      transferReq.Sender.ID,
-		Entity:      repository.ActivityLogTransactionEntity,
-		EntityId:    transferReq.ID,
-		Description: handler.TransactionActivityLogCreditDescription,
-	})
+	pending, err := wk.isStillPending(transfer.TransactionID, tx)
 	if err != nil {
-		log.Printf("Error logging credit reversal action: %v", err)
+		return false, fmt.Errorf("checking transaction status: %w", err)
+	}
+	if !pending {
+		log.Printf("Transaction %s is no longer pending, skipping reversal", transfer.TransactionID)
+		return false, nil
+	}
+
+	firstDelivery, err := wk.ProcessedRepo.MarkProcessed(transfer.TransactionID, repository.ProcessedStepReversal, tx)
+	if err != nil {
+		return false, fmt.Errorf("recording reversal step: %w", err)
+	}
+
+	if !firstDelivery {
+		log.Printf("Transaction %s was already reversed, skipping", transfer.TransactionID)
+		return false, nil
+	}
+
+	// recording the credit step makes sure the recipient can't be credited after the money went back to the sender,
+	// and tells us if a credit went through after all, in which case there is nothing to reverse
+	notCredited, err := wk.ProcessedRepo.MarkProcessed(transfer.TransactionID, repository.ProcessedStepCredit, tx)
+	if err != nil {
+		return false, fmt.Errorf("recording credit step: %w", err)
+	}
+
+	if !notCredited {
+		log.Printf("Transaction %s was credited, skipping reversal", transfer.TransactionID)
+		return false, nil
+	}
+
+	// Reverse the money to the sender, out of the transfer clearing account
+	_, err = wk.WalletRepo.Reverse(transfer.Sender.WalletID, transfer.Amount, transfer.TransactionID, tx)
+	if err != nil {
+		return false, fmt.Errorf("reversing money from failed credit: %w", err)
 	}
 
 	// Mark the original transaction as reversed
-	_, err = wk.TransactionRepo.UpdateStatus(transferReq.ID, repository.TransactionStatusReversed)
+	_, err = wk.TransactionRepo.UpdateStatus(transfer.TransactionID, repository.TransactionStatusReversed, tx)
 	if err != nil {
-		log.Printf("Error marking transaction as reversed: %v", err)
-		return false
+		return false, fmt.Errorf("marking transaction as reversed: %w", err)
 	}
 
 	// Create a new transaction for the reversal
-	desc := fmt.Sprintf("Reversal of %f", transferReq.Amount)
+	// reference numbers are unique, so the reversal gets one derived from the original transfer
+	desc := fmt.Sprintf("Reversal of %s", transfer.Amount)
 	newTrans := &models.Transaction{
-		SenderWalletID:    transferReq.Sender.Wallet.ID,
-		RecipientWalletID: transferReq.Sender.Wallet.ID, // sender is the recipient in a reversal
-		Amount:            transferReq.Amount,
-		ReferenceNumber:   transferReq.ReferenceNumber,
+		SenderWalletID:    transfer.Sender.WalletID,
+		RecipientWalletID: transfer.Sender.WalletID, // sender is the recipient in a reversal
+		Amount:            transfer.Amount,
+		ReferenceNumber:   transfer.ReferenceNumber + "-REV",
 		Description:       sql.NullString{String: desc, Valid: true},
 	}
-	transactionID, err := wk.TransactionRepo.Insert(newTrans, nil)
+	transactionID, err := wk.TransactionRepo.Insert(newTrans, tx)
+	if err != nil {
+		return false, fmt.Errorf("creating reversal transaction: %w", err)
+	}
+
+	// the reversal is complete as soon as it is recorded, it must not be left pending
+	_, err = wk.TransactionRepo.UpdateStatus(transactionID, repository.TransactionStatusCompleted, tx)
+	if err != nil {
+		return false, fmt.Errorf("completing reversal transaction: %w", err)
+	}
+
+	event, err := newTransferEvent(events.TypeTransferReversed, transfer.TransactionID, &events.TransferReversed{
+		TransactionID:         transfer.TransactionID,
+		ReversalTransactionID: transactionID,
+		ReversedAt:            time.Now(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = wk.commitWithEvent(tx, TransferEventsTopic, transfer.TransactionID, event)
 	if err != nil {
-		log.Printf("Error creating reversal transaction: %v", err)
-		return false
+		return false, fmt.Errorf("committing reversal: %w", err)
+	}
+
+	// Log the successful credit reversal
+	_, err = wk.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      transfer.Sender.UserID,
+		Entity:      repository.ActivityLogTransactionEntity,
+		EntityId:    transfer.TransactionID,
+		Description: repository.TransactionActivityLogCreditDescription,
+	})
+	if err != nil {
+		log.Printf("Error logging credit reversal action: %v", err)
 	}
 
 	// Log the reversal transaction
 	_, err = wk.ActivityRepo.Insert(&models.ActivityLog{
-		UserID:      transferReq.Sender.ID,
+		UserID:      transfer.Sender.UserID,
 		Entity:      repository.ActivityLogTransactionEntity,
 		EntityId:    transactionID,
-		Description: handler.TransactionActivityLogRevertedDescription,
+		Description: repository.TransactionActivityLogRevertedDescription,
 	})
 	if err != nil {
 		log.Printf("Error logging reversal transaction action: %v", err)
 	}
 
-	return true
+	return true, nil
 }
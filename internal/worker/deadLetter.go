@@ -0,0 +1,226 @@
+// A message lands in the dead-letter topic (transfer.failed) when a worker gives up on it:
+// either it could not be read at all (a poison message), or processing it kept failing after all retries.
+// The message is stored in the dead_letters table, with the error, the number of attempts and its original key and headers,
+// and an envelope describing it is published to the dead-letter topic in the same database transaction.
+// ...
+// The failure worker consumes that topic and compensates for the transfer the message belonged to:
+//   - a debit that was given up on marks the transaction as failed and releases its funds hold
+//   - a credit that was given up on reverses the money to the sender
+//
+// The sender is notified once the compensation went through, and the dead letter is marked as handled.
+// Dead letters the failure worker can't compensate for stay pending, admins can inspect and replay them.
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cradoe/morenee/internal/events"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/stream"
+	"github.com/google/uuid"
+)
+
+const (
+	// Outcomes the sender is told about when their transfer could not go through
+	transferFailedOutcomeFailed   = "The transfer could not be completed and your account was not debited."
+	transferFailedOutcomeReversed = "The transfer could not be completed and the amount has been returned to your account."
+)
+
+var errMalformed = errors.New("malformed message")
+
+// errMalformedMessage wraps the decoding error of a message that can't be processed at all
+func errMalformedMessage(err error) error {
+	if err == nil {
+		return errMalformed
+	}
+
+	return fmt.Errorf("%w: %v", errMalformed, err)
+}
+
+// DeadLetterMessage is what is published to the dead-letter topic for every message a worker gave up on
+type DeadLetterMessage struct {
+	ID            string            `json:"id"`
+	Topic         string            `json:"topic"`
+	Key           string            `json:"key,omitempty"`
+	Payload       string            `json:"payload"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Error         string            `json:"error"`
+	Attempts      int               `json:"attempts"`
+	TransactionID string            `json:"transaction_id,omitempty"`
+	FailedAt      time.Time         `json:"failed_at"`
+}
+
+// deadLetter stores a message the worker gave up on and publishes it to the dead-letter topic (through the outbox)
+func (wk *Worker) deadLetter(msg *stream.Message, cause error, attempts int) error {
+	deadLetter := &models.DeadLetter{
+		Topic:    msg.Topic,
+		Payload:  string(msg.Value),
+		Headers:  models.MessageHeaders{},
+		Error:    cause.Error(),
+		Attempts: attempts,
+	}
+
+	if len(msg.Key) > 0 {
+		deadLetter.MessageKey.String = string(msg.Key)
+		deadLetter.MessageKey.Valid = true
+	}
+
+	for key, value := range msg.Headers {
+		deadLetter.Headers[key] = value
+	}
+
+	// the transaction is found from the event whenever it can be read, even partly
+	if event, err := events.Decode(msg.Value, transferStepEvents[msg.Topic]); err == nil {
+		var payload struct {
+			TransactionID string `json:"transaction_id"`
+		}
+		if event.DecodePayload(&payload) == nil {
+			if _, err := uuid.Parse(payload.TransactionID); err == nil {
+				deadLetter.TransactionID.String = payload.TransactionID
+				deadLetter.TransactionID.Valid = true
+			}
+		}
+	}
+
+	err := wk.storeDeadLetter(deadLetter)
+	if err != nil {
+		return fmt.Errorf("dead-lettering message from %s: %w", deadLetter.Topic, err)
+	}
+
+	return nil
+}
+
+func (wk *Worker) storeDeadLetter(deadLetter *models.DeadLetter) error {
+	tx, err := wk.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := wk.DeadLetterRepo.Insert(deadLetter, tx)
+	if err != nil {
+		return err
+	}
+
+	message := &DeadLetterMessage{
+		ID:            id,
+		Topic:         deadLetter.Topic,
+		Key:           deadLetter.MessageKey.String,
+		Payload:       deadLetter.Payload,
+		Headers:       deadLetter.Headers,
+		Error:         deadLetter.Error,
+		Attempts:      deadLetter.Attempts,
+		TransactionID: deadLetter.TransactionID.String,
+		FailedAt:      time.Now(),
+	}
+
+	// dead letters of the same transfer keep their order
+	return wk.commitWithEvent(tx, TransferFailureTopic, deadLetter.TransactionID.String, message)
+}
+
+func (wk *Worker) FailureWorker() {
+	wk.runConsumer(&consumerConfig{
+		Name:    "FailureWorker",
+		GroupID: transferFailureGroupID,
+		Topic:   TransferFailureTopic,
+
+		MaxAttempts:    5,
+		BaseRetryDelay: 2 * time.Second,
+		MaxRetryDelay:  time.Minute,
+
+		Handle: wk.handleFailureMessage,
+		GiveUp: func(msg *stream.Message, cause error, attempts int) error {
+			// the dead letter stays pending, the recovery sweeper and admins can still act on the transfer
+			log.Printf("Error handling dead letter after %d attempts: %v", attempts, cause)
+			return nil
+		},
+	})
+}
+
+func (wk *Worker) handleFailureMessage(msg *stream.Message) error {
+	var deadLetter DeadLetterMessage
+	err := json.Unmarshal(msg.Value, &deadLetter)
+	if err != nil {
+		// a dead letter can't be dead-lettered again
+		log.Printf("Malformed dead letter, skipping: %v. Message: %s", err, msg.Value)
+		return nil
+	}
+
+	return wk.handleDeadLetter(&deadLetter)
+}
+
+// handleDeadLetter compensates for the transfer a dead letter belongs to
+func (wk *Worker) handleDeadLetter(deadLetter *DeadLetterMessage) error {
+	if deadLetter.TransactionID == "" {
+		log.Printf("Dead letter %s has no transaction, leaving it for an admin", deadLetter.ID)
+		return nil
+	}
+
+	// the stored transaction is used rather than the payload, which may be the reason the message failed
+	transaction, found, err := wk.TransactionRepo.GetOne(deadLetter.TransactionID, nil)
+	if err != nil {
+		return err
+	}
+	if !found {
+		log.Printf("Transaction %s of dead letter %s does not exist, leaving it for an admin", deadLetter.TransactionID, deadLetter.ID)
+		return nil
+	}
+
+	transfer := events.TransferFromDetails(transaction)
+
+	var compensated bool
+	var outcome string
+
+	switch deadLetter.Topic {
+	case TransferDebitTopic:
+		compensated, err = wk.processFailedDebit(transfer)
+		outcome = transferFailedOutcomeFailed
+	case TransferCreditTopic:
+		compensated, err = wk.processFailedCredit(transfer)
+		outcome = transferFailedOutcomeReversed
+	default:
+		// the money has already moved, replaying the message is the way forward
+		log.Printf("Dead letter %s from %s has nothing to compensate, leaving it for an admin", deadLetter.ID, deadLetter.Topic)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the transfer may already have been failed or reversed, the sender has been told about it then
+	if compensated {
+		wk.sendTransferFailedAlert(transfer, outcome)
+	}
+
+	return wk.DeadLetterRepo.MarkHandled(deadLetter.ID)
+}
+
+func (wk *Worker) sendTransferFailedAlert(transfer *events.Transfer, outcome string) {
+	sender, found, err := wk.UserRepo.GetOne(transfer.Sender.UserID)
+	if err != nil || !found {
+		log.Printf("Error finding sender's account for failed transfer alert: %v", err)
+		return
+	}
+
+	wk.Helper.BackgroundTask(nil, func() error {
+		emailData := wk.Helper.NewEmailData()
+		emailData["Name"] = sender.FirstName + " " + sender.LastName
+		emailData["BankName"] = transfer.Sender.BankName
+		emailData["Amount"] = transfer.Amount
+		emailData["RecipientName"] = transfer.Recipient.FirstName + " " + transfer.Recipient.LastName
+		emailData["TransactionID"] = transfer.ReferenceNumber
+		emailData["Outcome"] = outcome
+
+		err := wk.Mailer.Send(sender.Email, emailData, "transfer-failed.tmpl")
+		if err != nil {
+			log.Printf("Error sending failed transfer email alert: %v", err)
+			return err
+		}
+
+		return nil
+	})
+}
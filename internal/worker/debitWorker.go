@@ -1,108 +1,121 @@
 // The first event after a transfer request has been initiated synchronousely is to debit the sender
 // We do this by getting event to this effect.
-// Our listeners checks (polling) every 100ms for new event
+// Messages are consumed through the consumer runner (see consumer.go), which polls every 100ms for new events
 // We need to make sure the debitting is done with pessimistic lock, to avoid race condition
 // A log of this action is submitted in another go routine
 // and we then produce a new asynchronous event to credit the recipient
-// We retry failed debit 5 times with exponential delays,
-// failure after the 5 trial will result in marking the transaction status as "failed"
+// We retry failed debit 5 times with exponential delays and jitter,
+// failure after the 5 trial sends the message to the dead-letter topic,
+// where the failure worker marks the transaction status as "failed"
 
 package worker
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
 	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/cradoe/morenee/internal/handler"
+	"github.com/cradoe/morenee/internal/events"
 	"github.com/cradoe/morenee/internal/models"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/stream"
 )
 
 func (wk *Worker) DebitWorker() {
-	consumer, err := wk.KafkaStream.CreateConsumer(&stream.StreamConsumer{
-		GroupId: transferDebitGroupID,
+	wk.runConsumer(&consumerConfig{
+		Name:    "DebitWorker",
+		GroupID: transferDebitGroupID,
 		Topic:   TransferDebitTopic,
+
+		MaxAttempts:    5,
+		BaseRetryDelay: 2 * time.Second,
+		MaxRetryDelay:  30 * time.Second,
+
+		Handle: wk.handleDebitMessage,
+		// the failure worker marks the transaction as failed and notifies the sender
+		GiveUp: wk.deadLetter,
 	})
+}
 
+func (wk *Worker) handleDebitMessage(msg *stream.Message) error {
+	transfer, err := decodeTransferEvent(msg)
 	if err != nil {
-		log.Fatalf("Error creating consumer: %v", err)
-	}
-	defer consumer.Close()
-
-	maxRetries := 5
-	baseRetryDelay := time.Second * 2
-
-	for {
-		select {
-		case <-wk.Ctx.Done():
-			log.Println("DebitWorker received cancellation signal, shutting down...")
-			return
-		default:
-			event := consumer.Poll(100)
-			switch e := event.(type) {
-			case *kafka.Message:
-				// We use a goroutine to process each message independently
-				// This is to ensure that the worker remains non-blocking and can continue
-				// processing new messages.
-				// Delay could be caused by network or retry policy,
-				// using goroutine here is an option to make sure our worker can
-				// attend to other requests while retries are handled in the background.
-				go func(msg *kafka.Message) {
-					message := msg.Value
-					var transferReq *handler.TransactionResponseData
-					json.Unmarshal(message, &transferReq)
-
-					retryCount := 0
-					for retryCount < maxRetries {
-						success := wk.debitAccount(transferReq)
-						if success {
-							wk.KafkaStream.ProduceMessage(TransferCreditTopic, string(msg.Value))
-							return
-						}
-
-						// we can implement retry mechanism when debit fails
-						// this will be done with exponential backoff
-						// we must have also confirmed that the `wk.debitAccount` function uses database transaction mechanism
-						// with a rollback strategy for when something happen.
-						retryCount++
-						delay := time.Duration(retryCount) * baseRetryDelay
-						log.Printf("Debit attempt failed. Retrying in %v... (attempt %d/%d)\n", delay, retryCount, maxRetries)
-						time.Sleep(delay)
-					}
-
-					// Final failure handling
-					log.Printf("Failed to debit account after %d retries. Message: %s\n", maxRetries, message)
-
-					wk.processFailedDebit(transferReq)
-				}(e)
-
-			case kafka.Error:
-				log.Printf("Error: %v\n", e)
-			case *kafka.AssignedPartitions:
-				consumer.Assign(e.Partitions)
-			case *kafka.RevokedPartitions:
-				consumer.Unassign()
-			}
-		}
+		return err
 	}
+
+	// the debited event is written to the outbox together with the debit
+	return wk.debitAccount(transfer)
 }
 
-func (wk *Worker) debitAccount(transferReq *handler.TransactionResponseData) bool {
-	_, err := wk.WalletRepo.Debit(transferReq.Sender.Wallet.ID, transferReq.Amount)
+func (wk *Worker) debitAccount(transfer *events.Transfer) error {
+	tx, err := wk.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("starting debit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the transfer may have been failed in the meantime, by the recovery sweeper for example
+	pending, err := wk.isStillPending(transfer.TransactionID, tx)
+	if err != nil {
+		return fmt.Errorf("checking transaction status: %w", err)
+	}
+	if !pending {
+		log.Printf("Transaction %s is no longer pending, skipping debit", transfer.TransactionID)
+		return nil
+	}
+
+	// a redelivered message must not debit the sender twice
+	firstDelivery, err := wk.ProcessedRepo.MarkProcessed(transfer.TransactionID, repository.ProcessedStepDebit, tx)
+	if err != nil {
+		return fmt.Errorf("recording debit step: %w", err)
+	}
+
+	// the credit worker takes it from there
+	event, err := newTransferEvent(events.TypeTransferDebited, transfer.TransactionID, &events.TransferDebited{
+		Transfer:  *transfer,
+		DebitedAt: time.Now(),
+	})
 	if err != nil {
-		return false
+		return err
+	}
+
+	if !firstDelivery {
+		log.Printf("Debit for transaction %s was already applied, skipping", transfer.TransactionID)
+
+		// the debited event is emitted again, in case it was lost; the credit worker ignores duplicates
+		return wk.commitWithEvent(tx, TransferCreditTopic, transferEventKey(TransferCreditTopic, transfer), event)
+	}
+
+	// the funds reserved when the transfer was initiated are released into the debit,
+	// if the hold has already expired the debit simply has to fit in the available balance
+	_, err = wk.HoldRepo.Capture(transfer.TransactionID, tx)
+	if err != nil {
+		return fmt.Errorf("capturing funds hold: %w", err)
+	}
+
+	debited, err := wk.WalletRepo.Debit(transfer.Sender.WalletID, transfer.Amount, transfer.TransactionID, tx)
+	if err != nil {
+		return fmt.Errorf("debiting wallet: %w", err)
+	}
+
+	// the ledger refuses debits that would take the wallet below zero
+	if !debited {
+		return repository.ErrInsufficientFunds
+	}
+
+	// Produce message (through the outbox) so the credit worker can credit the recipient
+	err = wk.commitWithEvent(tx, TransferCreditTopic, transferEventKey(TransferCreditTopic, transfer), event)
+	if err != nil {
+		return err
 	}
 
 	// log operation
 	wk.Helper.BackgroundTask(nil, func() error {
-		_, err = wk.ActivityRepo.Insert(&models.ActivityLog{
-			UserID:      transferReq.Sender.ID,
+		_, err := wk.ActivityRepo.Insert(&models.ActivityLog{
+			UserID:      transfer.Sender.UserID,
 			Entity:      repository.ActivityLogTransactionEntity,
-			EntityId:    transferReq.ID,
-			Description: handler.TransactionActivityLogDebitDescription,
+			EntityId:    transfer.TransactionID,
+			Description: repository.TransactionActivityLogDebitDescription,
 		})
 
 		if err != nil {
@@ -112,28 +125,90 @@ func (wk *Worker) debitAccount(transferReq *handler.TransactionResponseData) boo
 		return nil
 	})
 
-	return true
+	// a wallet on hold for going over its balance limit may be back within it now
+	wk.Helper.BackgroundTask(nil, func() error {
+		_, err := wk.WalletStatus.EnforceLimit(transfer.Sender.WalletID)
+		if err != nil {
+			log.Printf("Error enforcing the balance limit of the sender's wallet: %v", err)
+			return err
+		}
+		return nil
+	})
+
+	return nil
 }
 
-func (wk *Worker) processFailedDebit(transferReq *handler.TransactionResponseData) bool {
+func (wk *Worker) processFailedDebit(transfer *events.Transfer) (bool, error) {
 	// When debit fails, we would mark the transaction status as failed
+	// and give the funds held for it back to the sender's available balance
 
-	_, err := wk.TransactionRepo.UpdateStatus(transferReq.ID, repository.TransactionStatusFailed)
+	// create an activity log to this effect first,
+	// the recovery sweeper relies on it if the transaction can't be marked as failed now
+	_, err := wk.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      transfer.Sender.UserID,
+		Entity:      repository.ActivityLogTransactionEntity,
+		EntityId:    transfer.TransactionID,
+		Description: repository.TransactionActivityLogFailedDebitDescription,
+	})
 	if err != nil {
-		log.Printf("Error marking transaction as failed: %v", err)
-		return false
+		log.Printf("Error logging failed transaction action: %v", err)
 	}
-	// create an activity log to this effect
-	_, err = wk.ActivityRepo.Insert(&models.ActivityLog{
-		UserID:      transferReq.Sender.ID,
-		Entity:      repository.ActivityLogTransactionEntity,
-		EntityId:    transferReq.ID,
-		Description: handler.TransactionActivityLogFailedDebitDescription,
+
+	failed, err := wk.failTransaction(transfer.TransactionID, "the sender could not be debited")
+	if err != nil {
+		return false, fmt.Errorf("marking transaction as failed: %w", err)
+	}
+
+	return failed, nil
+}
+
+// failTransaction marks a transfer that was never debited as failed and releases its funds hold.
+// Recording the debit step in the same database transaction makes sure a late debit message can't debit it anymore.
+// It reports false when the transfer is not pending or has already been debited, in which case it is left alone.
+// The failed event, with reason, is published with it.
+func (wk *Worker) failTransaction(transactionID string, reason string) (bool, error) {
+	tx, err := wk.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	pending, err := wk.isStillPending(transactionID, tx)
+	if err != nil || !pending {
+		return false, err
+	}
+
+	notDebited, err := wk.ProcessedRepo.MarkProcessed(transactionID, repository.ProcessedStepDebit, tx)
+	if err != nil {
+		return false, err
+	}
+	if !notDebited {
+		return false, nil
+	}
+
+	_, err = wk.TransactionRepo.UpdateStatus(transactionID, repository.TransactionStatusFailed, tx)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = wk.HoldRepo.Release(transactionID, tx)
+	if err != nil {
+		return false, err
+	}
+
+	event, err := newTransferEvent(events.TypeTransferFailed, transactionID, &events.TransferFailed{
+		TransactionID: transactionID,
+		Reason:        reason,
+		FailedAt:      time.Now(),
 	})
+	if err != nil {
+		return false, err
+	}
 
+	err = wk.commitWithEvent(tx, TransferEventsTopic, transactionID, event)
 	if err != nil {
-		log.Printf("Error logging failed transaction action: %v", err)
+		return false, err
 	}
 
-	return true
+	return true, nil
 }
@@ -0,0 +1,45 @@
+// Funds are held on the sender's wallet when a transfer is initiated, and captured by the debit worker.
+// If a transfer is abandoned (its debit event is never processed), the hold would freeze that money forever.
+// This worker periodically expires holds that have passed their expiry time,
+// which gives the funds back to the wallet's available balance.
+package worker
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	holdExpiryInterval  = time.Minute
+	holdExpiryBatchSize = 100
+)
+
+func (wk *Worker) HoldExpiryWorker() {
+	ticker := time.NewTicker(holdExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wk.Ctx.Done():
+			log.Println("HoldExpiryWorker received cancellation signal, shutting down...")
+			return
+		case <-ticker.C:
+			// keep going until there is nothing left to expire
+			for {
+				expired, err := wk.HoldRepo.ExpireDue(holdExpiryBatchSize)
+				if err != nil {
+					log.Printf("Error expiring funds holds: %v", err)
+					break
+				}
+
+				if expired > 0 {
+					log.Printf("Expired %d funds holds", expired)
+				}
+
+				if expired < holdExpiryBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package worker
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cradoe/morenee/internal/response"
+)
+
+// MetricsHandler serves the counters of the pending-transfer sweeper of this process.
+// Only the sweeper's expvar map is served, never the whole expvar dump, which also holds the command line and memory stats.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]any{
+			"pending_transfer_sweeper": json.RawMessage(sweeperMetrics.String()),
+		}
+
+		err := response.JSONOkResponse(w, data, "Metrics fetched successfully", nil)
+		if err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+// The outbox relay publishes events that were written to the outbox_messages table
+// in the same database transaction as the change they describe (see repository/outbox.go).
+// It polls for due messages, produces them to the event bus (with their key and headers) and marks them as sent once delivered.
+// Failed deliveries are retried with exponential backoff,
+// and a message that keeps failing is parked as failed after outboxMaxAttempts.
+// Messages with the same key keep their order: they are produced one at a time,
+// and the ones after a failed message are given back until it is sent.
+package worker
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/stream"
+)
+
+const (
+	outboxPollInterval = 500 * time.Millisecond
+	outboxBatchSize    = 50
+	outboxMaxAttempts  = 10
+
+	// outboxLease is how long a claimed message stays hidden from other relays,
+	// it must comfortably cover the time it takes to produce a batch
+	outboxLease = 30 * time.Second
+
+	outboxBaseRetryDelay = 2 * time.Second
+	outboxMaxRetryDelay  = 5 * time.Minute
+
+	// outboxMessageIDHeader carries the outbox message ID, to trace a published message back to the outbox
+	outboxMessageIDHeader = "outbox-message-id"
+)
+
+func (wk *Worker) OutboxRelay() {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wk.Ctx.Done():
+			log.Println("OutboxRelay received cancellation signal, shutting down...")
+			return
+		case <-ticker.C:
+			wk.relayOutboxBatch()
+		}
+	}
+}
+
+func (wk *Worker) relayOutboxBatch() {
+	messages, err := wk.OutboxRepo.ClaimPending(outboxBatchSize, outboxLease)
+	if err != nil {
+		log.Printf("Error claiming outbox messages: %v", err)
+		return
+	}
+
+	// messages with the same key are produced one after the other, in the order they were written,
+	// messages of different keys (and messages without a key) are produced side by side
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+
+	queues := [][]models.OutboxMessage{}
+	index := make(map[string]int)
+	for _, message := range messages {
+		if !message.Key.Valid {
+			queues = append(queues, []models.OutboxMessage{message})
+			continue
+		}
+
+		i, ok := index[message.Key.String]
+		if !ok {
+			i = len(queues)
+			index[message.Key.String] = i
+			queues = append(queues, nil)
+		}
+		queues[i] = append(queues[i], message)
+	}
+
+	// each round produces the next message of every key and waits for the delivery reports
+	for round := 0; ; round++ {
+		batch := []models.OutboxMessage{}
+		for _, queue := range queues {
+			if round < len(queue) {
+				batch = append(batch, queue[round])
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		reports := make([]<-chan error, len(batch))
+		for i, message := range batch {
+			reports[i] = wk.EventBus.Produce(outboxStreamMessage(&message))
+		}
+
+		for i, message := range batch {
+			err := <-reports[i]
+			if err == nil {
+				err = wk.OutboxRepo.MarkSent(message.ID)
+				if err != nil {
+					// the lease will expire and the message will be published again,
+					// which is safe because consumers are expected to be idempotent
+					log.Printf("Error marking outbox message %s as sent: %v", message.ID, err)
+				}
+				continue
+			}
+
+			retryAt := time.Now().Add(outboxRetryDelay(message.Attempts))
+			log.Printf("Error publishing outbox message %s (attempt %d/%d): %v", message.ID, message.Attempts, outboxMaxAttempts, err)
+
+			err = wk.OutboxRepo.MarkFailed(message.ID, err.Error(), retryAt, outboxMaxAttempts)
+			if err != nil {
+				log.Printf("Error recording failed outbox message %s: %v", message.ID, err)
+			}
+
+			// the later messages of the key must wait for this one, they are claimed again after it is sent
+			if message.Key.Valid {
+				queue := queues[index[message.Key.String]]
+				for _, later := range queue[round+1:] {
+					err = wk.OutboxRepo.Release(later.ID)
+					if err != nil {
+						log.Printf("Error releasing outbox message %s: %v", later.ID, err)
+					}
+				}
+				queues[index[message.Key.String]] = queue[:round+1]
+			}
+		}
+	}
+}
+
+// outboxStreamMessage is the message to produce for an outbox message, its headers tell which outbox message it is
+func outboxStreamMessage(message *models.OutboxMessage) *stream.Message {
+	headers := map[string]string{outboxMessageIDHeader: message.ID}
+	for key, value := range message.Headers {
+		headers[key] = value
+	}
+
+	return &stream.Message{
+		Topic:   message.Topic,
+		Key:     []byte(message.Key.String),
+		Value:   []byte(message.Payload),
+		Headers: headers,
+	}
+}
+
+// outboxRetryDelay doubles the delay for each attempt, up to outboxMaxRetryDelay
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := outboxBaseRetryDelay
+	for i := 1; i < attempts && delay < outboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, outboxMaxRetryDelay)
+}
@@ -0,0 +1,239 @@
+// A transfer moves through debit, credit and success steps, each handled by its own worker.
+// If a worker crashes between steps, or an event is lost, the transaction stays pending forever
+// and keeps counting towards the sender's transfer limits.
+// ...
+// The sweeper periodically picks transfers that have been pending for longer than sweeperStuckAfter,
+// works out which step they reached from the ledger (and the activity log), and then:
+//   - resumes them, by emitting the event for the next step again (every step is idempotent)
+//   - reverses them, when the credit was given up on but the money never went back to the sender
+//   - fails them, when the sender was never debited and the transfer is too old to go on
+//
+// Every decision is written to the activity log and counted in the "pending_transfer_sweeper" expvar map,
+// which cmd/worker serves on GET /metrics of its internal address.
+package worker
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/cradoe/morenee/internal/events"
+	"github.com/cradoe/morenee/internal/models"
+	"github.com/cradoe/morenee/internal/repository"
+)
+
+const (
+	sweeperInterval  = 5 * time.Minute
+	sweeperBatchSize = 50
+
+	// sweeperStuckAfter is how long a transfer can be pending before the sweeper looks at it,
+	// it leaves enough time for the workers' own retries
+	sweeperStuckAfter = 15 * time.Minute
+
+	// sweeperFailAfter is how long a transfer that was never debited is resumed for, before it is failed
+	sweeperFailAfter = 2 * time.Hour
+)
+
+const (
+	// The decisions the sweeper can make about a stuck transfer
+	sweeperDecisionResumedDebit   = "resumed_debit"
+	sweeperDecisionResumedCredit  = "resumed_credit"
+	sweeperDecisionResumedSuccess = "resumed_success"
+	sweeperDecisionReversed       = "reversed"
+	sweeperDecisionMarkedReversed = "marked_reversed"
+	sweeperDecisionFailed         = "failed"
+	sweeperDecisionSkipped        = "skipped"
+	sweeperDecisionError          = "error"
+)
+
+var (
+	sweeperMetrics = expvar.NewMap("pending_transfer_sweeper")
+
+	errTransferRecoveryFailed = errors.New("transfer could not be recovered")
+)
+
+func (wk *Worker) PendingTransferSweeper() {
+	ticker := time.NewTicker(sweeperInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wk.Ctx.Done():
+			log.Println("PendingTransferSweeper received cancellation signal, shutting down...")
+			return
+		case <-ticker.C:
+			wk.sweepPendingTransfers()
+		}
+	}
+}
+
+// sweepPendingTransfers looks at every stuck transfer once, a batch at a time,
+// so transfers that keep being skipped or failing to recover never hide newer ones
+func (wk *Worker) sweepPendingTransfers() {
+	stuckBefore := time.Now().Add(-sweeperStuckAfter)
+	sweeperMetrics.Add("runs", 1)
+
+	var after *models.TransactionDetails
+	for {
+		transactions, err := wk.TransactionRepo.FindStuckPending(stuckBefore, after, sweeperBatchSize)
+		if err != nil {
+			log.Printf("Error finding stuck transfers: %v", err)
+			sweeperMetrics.Add(sweeperDecisionError, 1)
+			return
+		}
+
+		sweeperMetrics.Add("stuck_found", int64(len(transactions)))
+
+		for _, transaction := range transactions {
+			wk.sweepPendingTransfer(transaction)
+		}
+
+		if len(transactions) < sweeperBatchSize {
+			return
+		}
+		after = transactions[len(transactions)-1]
+	}
+}
+
+// sweepPendingTransfer recovers a stuck transfer, the decision is counted and written to the activity log
+func (wk *Worker) sweepPendingTransfer(transaction *models.TransactionDetails) {
+	decision, err := wk.recoverTransfer(transaction)
+	if err != nil {
+		log.Printf("Error recovering transfer %s: %v", transaction.ID, err)
+		sweeperMetrics.Add(sweeperDecisionError, 1)
+		return
+	}
+
+	sweeperMetrics.Add(decision, 1)
+	log.Printf("Swept stuck transfer %s: %s", transaction.ID, decision)
+
+	_, err = wk.ActivityRepo.Insert(&models.ActivityLog{
+		UserID:      transaction.SenderID,
+		Entity:      repository.ActivityLogTransactionEntity,
+		EntityId:    transaction.ID,
+		Description: repository.TransactionActivityLogRecoveryDescription + decision,
+	})
+	if err != nil {
+		log.Printf("Error logging transfer recovery action: %v", err)
+	}
+}
+
+// recoverTransfer decides what to do with a stuck transfer, does it, and returns the decision
+func (wk *Worker) recoverTransfer(transaction *models.TransactionDetails) (string, error) {
+	journals, err := wk.LedgerRepo.GetByTransactionId(transaction.ID)
+	if err != nil {
+		return "", err
+	}
+
+	// the time each step was posted to the ledger
+	reached := map[string]time.Time{}
+	for _, journal := range journals {
+		reached[journal.Kind] = journal.CreatedAt
+	}
+
+	transfer := events.TransferFromDetails(transaction)
+
+	debitedAt, debited := reached[repository.JournalKindTransferDebit]
+	creditedAt, credited := reached[repository.JournalKindTransferCredit]
+	_, reversed := reached[repository.JournalKindReversal]
+
+	switch {
+	case reversed:
+		// the money went back to the sender, only the status update was lost
+		updated, err := wk.markReversed(transaction.ID)
+		if err != nil || !updated {
+			return sweeperDecisionSkipped, err
+		}
+		return sweeperDecisionMarkedReversed, nil
+
+	case credited:
+		// both legs are done, the transfer only needs to be marked as completed
+		return sweeperDecisionResumedSuccess, wk.emitTransferEvent(TransferSuccessTopic, transfer, events.TypeTransferCredited, &events.TransferCredited{
+			Transfer:   *transfer,
+			CreditedAt: creditedAt,
+		})
+
+	case debited:
+		// the credit worker gave up, but the reversal did not go through
+		creditFailed, err := wk.ActivityRepo.Exists(repository.ActivityLogTransactionEntity, transaction.ID, repository.TransactionActivityLogFailedCreditDescription)
+		if err != nil {
+			return "", err
+		}
+
+		if creditFailed {
+			reversed, err := wk.processFailedCredit(transfer)
+			if err != nil {
+				return "", errors.Join(errTransferRecoveryFailed, err)
+			}
+			if !reversed {
+				return sweeperDecisionSkipped, nil
+			}
+			return sweeperDecisionReversed, nil
+		}
+
+		return sweeperDecisionResumedCredit, wk.emitTransferEvent(TransferCreditTopic, transfer, events.TypeTransferDebited, &events.TransferDebited{
+			Transfer:  *transfer,
+			DebitedAt: debitedAt,
+		})
+
+	default:
+		// the sender was never debited
+		debitFailed, err := wk.ActivityRepo.Exists(repository.ActivityLogTransactionEntity, transaction.ID, repository.TransactionActivityLogFailedDebitDescription)
+		if err != nil {
+			return "", err
+		}
+
+		if debitFailed || time.Since(transaction.CreatedAt.Time) > sweeperFailAfter {
+			reason := "the transfer was never debited"
+			if debitFailed {
+				reason = "the sender could not be debited"
+			}
+
+			failed, err := wk.failTransaction(transaction.ID, reason)
+			if err != nil || !failed {
+				return sweeperDecisionSkipped, err
+			}
+			return sweeperDecisionFailed, nil
+		}
+
+		return sweeperDecisionResumedDebit, wk.emitTransferEvent(TransferDebitTopic, transfer, events.TypeTransferInitiated, &events.TransferInitiated{
+			Transfer: *transfer,
+		})
+	}
+}
+
+// markReversed sets the status of a pending transfer whose reversal has been posted
+func (wk *Worker) markReversed(transactionID string) (bool, error) {
+	tx, err := wk.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	pending, err := wk.isStillPending(transactionID, tx)
+	if err != nil || !pending {
+		return false, err
+	}
+
+	_, err = wk.TransactionRepo.UpdateStatus(transactionID, repository.TransactionStatusReversed, tx)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// emitTransferEvent writes an event to the outbox on its own
+func (wk *Worker) emitTransferEvent(topic string, transfer *events.Transfer, eventType string, payload any) error {
+	event, err := newTransferEvent(eventType, transfer.TransactionID, payload)
+	if err != nil {
+		return err
+	}
+
+	return wk.queueEvent(nil, topic, transferEventKey(topic, transfer), event)
+}
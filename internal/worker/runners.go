@@ -0,0 +1,76 @@
+// Every long-running process of this package has a name, so a worker command can be told which ones to run.
+// Replicas can then be scaled per consumer, e.g. more debit and credit consumers than sweepers.
+// All of them stop once the worker's context is cancelled, consumers after draining the messages they hold.
+package worker
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	RunnerOutboxRelay     = "outbox-relay"
+	RunnerDebit           = "debit"
+	RunnerCredit          = "credit"
+	RunnerSuccess         = "success"
+	RunnerFailure         = "failure"
+	RunnerHoldExpiry      = "hold-expiry"
+	RunnerTransferSweeper = "transfer-sweeper"
+	RunnerWalletLimits    = "wallet-limits"
+)
+
+// Runners returns every process of the worker, by name
+func (wk *Worker) Runners() map[string]func() {
+	return map[string]func(){
+		// Events are written to the outbox in the same database transaction as the change they describe,
+		// the relay is what actually publishes them to the event bus.
+		RunnerOutboxRelay: wk.OutboxRelay,
+
+		// The `HandleTransferMoney` handler function initiates the transaction and produces an event
+		// ... that would be received by our first worker `DebitWorker`.
+		// Messages the debit, credit and success workers give up on are dead-lettered, the failure worker compensates for them.
+		RunnerDebit:   wk.DebitWorker,
+		RunnerCredit:  wk.CreditWorker,
+		RunnerSuccess: wk.SuccessTransferWorker,
+		RunnerFailure: wk.FailureWorker,
+
+		RunnerHoldExpiry:      wk.HoldExpiryWorker,
+		RunnerTransferSweeper: wk.PendingTransferSweeper,
+		RunnerWalletLimits:    wk.WalletLimitWorker,
+	}
+}
+
+// RunnerNames lists the names of every process of the worker
+func RunnerNames() []string {
+	names := []string{}
+	for name := range (&Worker{}).Runners() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SelectRunners returns the processes with the given names, or all of them when no name is given
+func (wk *Worker) SelectRunners(names []string) (map[string]func(), error) {
+	runners := wk.Runners()
+	if len(names) == 0 {
+		return runners, nil
+	}
+
+	selected := map[string]func(){}
+	for _, name := range names {
+		run, ok := runners[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown worker %q, expected one of %v", name, RunnerNames())
+		}
+		selected[name] = run
+	}
+
+	return selected, nil
+}
+
+// Topics lists the topics the workers publish to and consume from
+func Topics() []string {
+	return []string{TransferDebitTopic, TransferCreditTopic, TransferSuccessTopic, TransferFailureTopic, TransferEventsTopic}
+}
@@ -5,71 +5,105 @@
 package worker
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
+	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/cradoe/morenee/internal/handler"
+	"github.com/cradoe/morenee/internal/events"
 	"github.com/cradoe/morenee/internal/models"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/stream"
 )
 
 func (wk *Worker) SuccessTransferWorker() {
-	consumer, err := wk.KafkaStream.CreateConsumer(&stream.StreamConsumer{
-		GroupId: transferSuccessGroupID,
+	wk.runConsumer(&consumerConfig{
+		Name:    "SuccessTransferWorker",
+		GroupID: transferSuccessGroupID,
 		Topic:   TransferSuccessTopic,
+
+		MaxAttempts:    5,
+		BaseRetryDelay: 2 * time.Second,
+		MaxRetryDelay:  30 * time.Second,
+
+		Handle: wk.handleSuccessMessage,
+		// both legs are done by now, the recovery sweeper completes the transfer if this keeps failing
+		GiveUp: wk.deadLetter,
 	})
+}
 
+func (wk *Worker) handleSuccessMessage(msg *stream.Message) error {
+	transfer, err := decodeTransferEvent(msg)
 	if err != nil {
-		log.Fatalf("Error creating consumer: %v", err)
+		return err
 	}
-	defer consumer.Close() // Ensure cleanup
-
-	for {
-		select {
-		case <-wk.Ctx.Done():
-			log.Println("SuccessTransferWorker received cancellation signal, shutting down...")
-			return
-		default:
-			// Poll for Kafka events
-			event := consumer.Poll(100)
-			switch e := event.(type) {
-			case *kafka.Message:
-				message := e.Value
-				var transferReq *handler.TransactionResponseData
-				json.Unmarshal(message, &transferReq)
-
-				success := wk.completeTransferOperation(transferReq)
-				if success {
-					// Send notifications to the sender and receiver
-					log.Printf("Transfer completed successfully: %v", transferReq)
-					wk.sendTransactionAlerts(transferReq)
-				}
-			case kafka.Error:
-				log.Printf("Error: %v\n", e)
-			case *kafka.AssignedPartitions:
-				consumer.Assign(e.Partitions)
-			case *kafka.RevokedPartitions:
-				consumer.Unassign()
-			}
-		}
+
+	completed, err := wk.completeTransferOperation(transfer)
+	if err != nil {
+		return err
+	}
+
+	if completed {
+		// Send notifications to the sender and receiver
+		log.Printf("Transfer completed successfully: %v", transfer.TransactionID)
+		wk.sendTransactionAlerts(transfer)
 	}
+
+	return nil
 }
 
-func (wk *Worker) completeTransferOperation(transferReq *handler.TransactionResponseData) bool {
-	_, err := wk.TransactionRepo.UpdateStatus(transferReq.ID, repository.TransactionStatusCompleted)
+// completeTransferOperation marks the transaction as completed.
+// It returns false for a redelivered message, so alerts are only sent once per transfer.
+func (wk *Worker) completeTransferOperation(transfer *events.Transfer) (bool, error) {
+	tx, err := wk.DB.Begin()
 	if err != nil {
-		log.Printf("Error updating transaction status: %v", err)
-		return false
+		return false, fmt.Errorf("starting success transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// a reversed transfer must never be marked as completed
+	pending, err := wk.isStillPending(transfer.TransactionID, tx)
+	if err != nil {
+		return false, fmt.Errorf("checking transaction status: %w", err)
+	}
+	if !pending {
+		log.Printf("Transaction %s is no longer pending, skipping completion", transfer.TransactionID)
+		return false, nil
+	}
+
+	firstDelivery, err := wk.ProcessedRepo.MarkProcessed(transfer.TransactionID, repository.ProcessedStepSuccess, tx)
+	if err != nil {
+		return false, fmt.Errorf("recording success step: %w", err)
+	}
+
+	if !firstDelivery {
+		log.Printf("Transaction %s was already completed, skipping", transfer.TransactionID)
+		return false, nil
+	}
+
+	_, err = wk.TransactionRepo.UpdateStatus(transfer.TransactionID, repository.TransactionStatusCompleted, tx)
+	if err != nil {
+		return false, fmt.Errorf("updating transaction status: %w", err)
+	}
+
+	event, err := newTransferEvent(events.TypeTransferCompleted, transfer.TransactionID, &events.TransferCompleted{
+		TransactionID: transfer.TransactionID,
+		CompletedAt:   time.Now(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = wk.commitWithEvent(tx, TransferEventsTopic, transfer.TransactionID, event)
+	if err != nil {
+		return false, fmt.Errorf("committing transaction status: %w", err)
 	}
 
 	wk.Helper.BackgroundTask(nil, func() error {
-		_, err = wk.ActivityRepo.Insert(&models.ActivityLog{
-			UserID:      transferReq.Sender.ID,
+		_, err := wk.ActivityRepo.Insert(&models.ActivityLog{
+			UserID:      transfer.Sender.UserID,
 			Entity:      repository.ActivityLogTransactionEntity,
-			EntityId:    transferReq.ID,
-			Description: handler.TransactionActivityLogSuccessDescription,
+			EntityId:    transfer.TransactionID,
+			Description: repository.TransactionActivityLogSuccessDescription,
 		})
 
 		if err != nil {
@@ -79,30 +113,30 @@ func (wk *Worker) completeTransferOperation(transferReq *handler.TransactionResp
 		return nil
 	})
 
-	return true
+	return true, nil
 }
 
-func (wk *Worker) sendTransactionAlerts(transferReq *handler.TransactionResponseData) bool {
+func (wk *Worker) sendTransactionAlerts(transfer *events.Transfer) bool {
 
-	sender, _, err := wk.UserRepo.GetOne(transferReq.Sender.ID)
+	sender, _, err := wk.UserRepo.GetOne(transfer.Sender.UserID)
 	if err != nil {
 		log.Printf("Error finding sender's account for debit alert: %v", err)
 		return false
 	}
 
-	recipient, _, err := wk.UserRepo.GetOne(transferReq.Recipient.ID)
+	recipient, _, err := wk.UserRepo.GetOne(transfer.Recipient.UserID)
 	if err != nil {
 		log.Printf("Error finding recipient's account for debit alert: %v", err)
 		return false
 	}
 
-	senderWallet, _, err := wk.WalletRepo.GetOne(transferReq.Sender.Wallet.ID)
+	senderWallet, _, err := wk.WalletRepo.GetOne(transfer.Sender.WalletID)
 	if err != nil {
 		log.Printf("Error finding sender's wallet for debit alert: %v", err)
 		return false
 	}
 
-	recipientWallet, _, err := wk.WalletRepo.GetOne(transferReq.Recipient.Wallet.ID)
+	recipientWallet, _, err := wk.WalletRepo.GetOne(transfer.Recipient.WalletID)
 	if err != nil {
 		log.Printf("Error finding sender's wallet for debit alert: %v", err)
 		return false
@@ -112,11 +146,11 @@ func (wk *Worker) sendTransactionAlerts(transferReq *handler.TransactionResponse
 	wk.Helper.BackgroundTask(nil, func() error {
 		emailData := wk.Helper.NewEmailData()
 		emailData["Name"] = sender.FirstName + " " + sender.LastName
-		emailData["BankName"] = transferReq.Sender.Wallet.BankName
-		emailData["Amount"] = transferReq.Amount
+		emailData["BankName"] = transfer.Sender.BankName
+		emailData["Amount"] = transfer.Amount
 		emailData["RecipientName"] = recipient.FirstName + " " + recipient.LastName
 		emailData["RecipientAccountNumber"] = recipientWallet.AccountNumber
-		emailData["TransactionID"] = transferReq.ReferenceNumber
+		emailData["TransactionID"] = transfer.ReferenceNumber
 		emailData["NewBalance"] = senderWallet.Balance
 
 		err = wk.Mailer.Send(sender.Email, emailData, "debit-alert.tmpl")
@@ -132,11 +166,11 @@ func (wk *Worker) sendTransactionAlerts(transferReq *handler.TransactionResponse
 	wk.Helper.BackgroundTask(nil, func() error {
 		emailData := wk.Helper.NewEmailData()
 		emailData["Name"] = recipient.FirstName + " " + recipient.LastName
-		emailData["BankName"] = transferReq.Recipient.Wallet.BankName
-		emailData["Amount"] = transferReq.Amount
+		emailData["BankName"] = transfer.Recipient.BankName
+		emailData["Amount"] = transfer.Amount
 		emailData["SenderName"] = sender.FirstName + " " + sender.LastName
 		emailData["SenderAccountNumber"] = senderWallet.AccountNumber
-		emailData["TransactionID"] = transferReq.ReferenceNumber
+		emailData["TransactionID"] = transfer.ReferenceNumber
 		emailData["NewBalance"] = recipientWallet.Balance
 
 		err = wk.Mailer.Send(recipient.Email, emailData, "credit-alert.tmpl")
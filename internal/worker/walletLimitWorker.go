@@ -0,0 +1,45 @@
+// Wallets that go over the balance limit of their owner's KYC level are put on hold, and credits that would take them over it are suspended,
+// see internal/walletstatus. Both are released as the owner moves up a level or spends, but limits can also change without either,
+// admins can raise the limit of a level for example. This worker periodically releases the holds and suspended credits whose limits now allow it.
+package worker
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	walletLimitInterval  = 5 * time.Minute
+	walletLimitBatchSize = 100
+)
+
+func (wk *Worker) WalletLimitWorker() {
+	ticker := time.NewTicker(walletLimitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wk.Ctx.Done():
+			log.Println("WalletLimitWorker received cancellation signal, shutting down...")
+			return
+		case <-ticker.C:
+			released, err := wk.WalletStatus.ReleaseLimitHolds(walletLimitBatchSize)
+			if err != nil {
+				log.Printf("Error releasing wallets held for their balance limit: %v", err)
+			}
+
+			if released > 0 {
+				log.Printf("Released %d wallets held for their balance limit", released)
+			}
+
+			credited, err := wk.WalletStatus.ReleaseSuspendedCredits(walletLimitBatchSize)
+			if err != nil {
+				log.Printf("Error releasing suspended credits: %v", err)
+			}
+
+			if credited > 0 {
+				log.Printf("Released suspended credits into %d wallets", credited)
+			}
+		}
+	}
+}
@@ -2,24 +2,42 @@ package worker
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 
+	"github.com/cradoe/morenee/internal/events"
 	"github.com/cradoe/morenee/internal/helper"
+	"github.com/cradoe/morenee/internal/models"
 	"github.com/cradoe/morenee/internal/repository"
 	"github.com/cradoe/morenee/internal/smtp"
 	"github.com/cradoe/morenee/internal/stream"
+	"github.com/cradoe/morenee/internal/walletstatus"
 )
 
 type Worker struct {
+	DB              *repository.DB
 	UserRepo        repository.UserRepository
 	TransactionRepo repository.TransactionRepository
 	WalletRepo      repository.WalletRepository
 	KycRepo         repository.KycRepository
 	ActivityRepo    repository.ActivityRepository
+	OutboxRepo      repository.OutboxRepository
+	ProcessedRepo   repository.ProcessedMessageRepository
+	HoldRepo        repository.WalletHoldRepository
+	LedgerRepo      repository.LedgerRepository
+	DeadLetterRepo  repository.DeadLetterRepository
 
-	KafkaStream *stream.KafkaStream
-	Ctx         context.Context
-	Helper      *helper.Helper
-	Mailer      *smtp.Mailer
+	EventBus stream.EventBus
+	Ctx      context.Context
+	Helper   *helper.Helper
+	Mailer   *smtp.Mailer
+
+	// WalletStatus puts wallets on hold and releases them, with the balance limit of their owner's KYC level
+	WalletStatus *walletstatus.Manager
+
+	// Concurrency is the number of messages each consumer processes at the same time
+	Concurrency int
 }
 
 const (
@@ -32,6 +50,9 @@ const (
 	// transferSuccessGroupID is used for workers that needs to take action when a transfer recquest has been completed
 	transferSuccessGroupID = "transfer-success-group"
 
+	// transferFailureGroupID is used for workers that needs to compensate for transfer messages that were given up on
+	transferFailureGroupID = "transfer-failure-group"
+
 	// Topics
 	// TransferDebitTopic is used to create request to debit the sender's wallet, when they initiate a transfer request to another user.
 	TransferDebitTopic = "transfer.debit"
@@ -39,26 +60,146 @@ const (
 	// TransferCreditTopic is used to create request that credits the recipient's wallet during wallet-wallet transaction
 	TransferCreditTopic = "transfer.credit"
 
-	// TransferFailureTopic is used to create request to mark transaction as failed and revert all actions, to avoid inconsistent data
+	// TransferFailureTopic is the dead-letter topic, it receives transfer messages that could not be processed,
+	// so the transaction can be marked as failed and all actions reverted, to avoid inconsistent data
 	TransferFailureTopic = "transfer.failed"
 
 	// TransferSuccessTopic is used to create request to mark transaction as successful after debit and credit has been completed
 	TransferSuccessTopic = "transfer.success"
+
+	// TransferEventsTopic tells the rest of the system how transfers ended: completed, failed or reversed.
+	// Nothing in the transfer flow consumes it, it is there for notifications, reporting and the like.
+	TransferEventsTopic = "transfer.events"
 )
 
-// Our workers typically needs access to database and kafka event stream
+// Our workers typically needs access to database and the event bus
 // worker-specific dependency can be passed as argument to the worker
 func New(wk *Worker) *Worker {
 	return &Worker{
+		DB:              wk.DB,
 		UserRepo:        wk.UserRepo,
 		TransactionRepo: wk.TransactionRepo,
 		WalletRepo:      wk.WalletRepo,
 		KycRepo:         wk.KycRepo,
 		ActivityRepo:    wk.ActivityRepo,
+		OutboxRepo:      wk.OutboxRepo,
+		ProcessedRepo:   wk.ProcessedRepo,
+		HoldRepo:        wk.HoldRepo,
+		LedgerRepo:      wk.LedgerRepo,
+		DeadLetterRepo:  wk.DeadLetterRepo,
+
+		EventBus: wk.EventBus,
+		Ctx:      wk.Ctx,
+		Helper:   wk.Helper,
+		Mailer:   wk.Mailer,
+
+		WalletStatus: wk.WalletStatus,
+
+		Concurrency: wk.Concurrency,
+	}
+}
+
+// queueEvent writes an event to the outbox as part of tx, so it is only published if tx is committed
+func (wk *Worker) queueEvent(tx *sql.Tx, topic string, key string, event any) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding %s event: %w", topic, err)
+	}
+
+	_, err = wk.OutboxRepo.Insert(&models.OutboxMessage{
+		Topic:   topic,
+		Key:     sql.NullString{String: key, Valid: key != ""},
+		Payload: string(message),
+	}, tx)
+	if err != nil {
+		return fmt.Errorf("queueing %s event: %w", topic, err)
+	}
+
+	return nil
+}
+
+// commitWithEvent writes the next transfer event to the outbox and commits tx,
+// so the event is only published if the step that produced it was saved.
+func (wk *Worker) commitWithEvent(tx *sql.Tx, topic string, key string, event any) error {
+	err := wk.queueEvent(tx, topic, key, event)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing before %s event: %w", topic, err)
+	}
+
+	return nil
+}
+
+// isStillPending locks the transaction row for the rest of tx and reports whether the transfer is still in progress.
+// Every step of a transfer checks it first, so steps never run for transfers that were completed, failed or reversed.
+func (wk *Worker) isStillPending(transactionID string, tx *sql.Tx) (bool, error) {
+	status, found, err := wk.TransactionRepo.LockStatus(transactionID, tx)
+	if err != nil {
+		return false, err
+	}
+
+	return found && status == repository.TransactionStatusPending, nil
+}
+
+// transferStepEvents is the event each step of a transfer consumes, by the topic it comes from
+var transferStepEvents = map[string]string{
+	TransferDebitTopic:   events.TypeTransferInitiated,
+	TransferCreditTopic:  events.TypeTransferDebited,
+	TransferSuccessTopic: events.TypeTransferCredited,
+}
+
+// decodeTransferEvent reads the transfer carried by the event of a transfer step's message.
+// Messages published before events had envelopes are upcast, so they are read like any other.
+// A message that can't be read, or isn't the event its topic carries, is malformed and is not retried.
+func decodeTransferEvent(msg *stream.Message) (*events.Transfer, error) {
+	eventType, ok := transferStepEvents[msg.Topic]
+	if !ok {
+		return nil, errMalformedMessage(fmt.Errorf("no transfer event is published to %s", msg.Topic))
+	}
+
+	event, err := events.Decode(msg.Value, eventType)
+	if err != nil {
+		return nil, errMalformedMessage(err)
+	}
+	if event.Type != eventType {
+		return nil, errMalformedMessage(fmt.Errorf("unexpected %s event on %s", event.Type, msg.Topic))
+	}
+
+	// every step event embeds the transfer
+	var transfer events.Transfer
+	err = event.DecodePayload(&transfer)
+	if err != nil || transfer.TransactionID == "" {
+		return nil, errMalformedMessage(err)
+	}
+
+	return &transfer, nil
+}
+
+// newTransferEvent wraps the payload of an event about a transfer,
+// every event of a transfer is correlated by its transaction
+func newTransferEvent(eventType string, transactionID string, payload any) (*events.Envelope, error) {
+	event, err := events.New(eventType, transactionID, payload)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s event: %w", eventType, err)
+	}
+
+	return event, nil
+}
 
-		KafkaStream: wk.KafkaStream,
-		Ctx:         wk.Ctx,
-		Helper:      wk.Helper,
-		Mailer:      wk.Mailer,
+// transferEventKey is the key of a transfer event, events with the same key are consumed in order.
+// Debits are keyed by the sender's wallet and credits by the recipient's wallet,
+// so the transfers of a wallet are applied in the order they were made,
+// other events are keyed by the transaction.
+func transferEventKey(topic string, transfer *events.Transfer) string {
+	switch topic {
+	case TransferDebitTopic:
+		return transfer.Sender.WalletID
+	case TransferCreditTopic:
+		return transfer.Recipient.WalletID
+	default:
+		return transfer.TransactionID
 	}
 }